@@ -20,6 +20,16 @@ type activateCmd struct {
 	Dir         string `arg:"" help:"Directory of environment to activate (${default})" default:"${env}"`
 	Prompt      string `enum:"env,short,none" default:"env" help:"Include hermit environment, just icon or nothing in shell prompt"`
 	ShortPrompt bool   `help:"Use a minimal prompt in active environments." hidden:""`
+	Print       string `placeholder:"SHELL" help:"Print only the shell code to activate the environment in SHELL, for use with direnv and similar tools."`
+}
+
+func (a *activateCmd) Help() string {
+	return `
+Passing "--print <shell>" will print the shell code to activate the environment, including the state needed to
+deactivate it, without requiring the activate-hermit script. For example, in a direnv .envrc:
+
+    eval "$(./bin/hermit activate --print bash)"
+	`
 }
 
 func (a *activateCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, globalState GlobalState, config Config, defaultClient *http.Client) error {
@@ -53,11 +63,27 @@ func (a *activateCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, global
 	for _, pkg := range pkgs {
 		pkg.LogWarnings(l)
 	}
+	env.WarnEnvarCollisions(l, pkgs)
+	if a.Print != "" {
+		sh, err := shell.Resolve(a.Print)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		environ, err := activationEnvars(l, env, envars.Parse(os.Environ()), ops)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(sh.ApplyEnvars(os.Stdout, environ))
+	}
 	sh, err := shell.Detect()
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	environ := envars.Parse(os.Environ()).Apply(env.Root(), ops).Changed(true)
+	transform := envars.Parse(os.Environ()).Apply(env.Root(), ops)
+	if err := env.CheckRequiredEnv(l, transform.Combined()); err != nil {
+		return errors.WithStack(err)
+	}
+	environ := transform.Changed(true)
 	prompt := a.Prompt
 	if a.ShortPrompt {
 		prompt = "short"
@@ -69,6 +95,28 @@ func (a *activateCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, global
 	})
 }
 
+// activationEnvars returns the changes to environ that activate env,
+// including the undo state and operations needed to deactivate it again, as
+// the activation script would set them.
+func activationEnvars(l *ui.UI, env *hermit.Env, environ envars.Envars, ops envars.Ops) (envars.Envars, error) {
+	transform := environ.Apply(env.Root(), ops)
+	if err := env.CheckRequiredEnv(l, transform.Combined()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	encoded, err := envars.MarshalOps(ops)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode envar operations")
+	}
+	changed := transform.Changed(true)
+	changed["HERMIT_ENV"] = env.Root()
+	changed["ACTIVE_HERMIT"] = env.Root()
+	changed["HERMIT_ENV_OPS"] = string(encoded)
+	if _, ok := environ["DEACTIVATED_HERMIT"]; ok {
+		changed["DEACTIVATED_HERMIT"] = ""
+	}
+	return changed, nil
+}
+
 // resolveActivationDir converts the directory used at activation to an absolute path
 // with all symlinks resolved
 func resolveActivationDir(from string) (string, error) {
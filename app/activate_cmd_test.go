@@ -0,0 +1,36 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/envars"
+	"github.com/cashapp/hermit/hermittest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestActivationEnvars(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, nil)
+	defer f.Clean()
+
+	l, _ := ui.NewForTesting()
+	ops, err := f.Env.EnvOps(l)
+	assert.NoError(t, err)
+	environ := envars.Envars{"PATH": "/usr/bin", "DEACTIVATED_HERMIT": f.Env.Root()}
+	changed, err := activationEnvars(l, f.Env, environ, ops)
+	assert.NoError(t, err)
+	assert.Equal(t, f.Env.Root(), changed["HERMIT_ENV"])
+	assert.Equal(t, f.Env.Root(), changed["ACTIVE_HERMIT"])
+	assert.Equal(t, "", changed["DEACTIVATED_HERMIT"])
+	assert.Equal(t, f.Env.BinDir()+":/usr/bin", changed["PATH"])
+
+	// The recorded operations are sufficient to deactivate the environment.
+	decoded, err := envars.UnmarshalOps([]byte(changed["HERMIT_ENV_OPS"]))
+	assert.NoError(t, err)
+	activated := environ.Clone()
+	environ.Apply(f.Env.Root(), ops).To(activated)
+	reverted := activated.Clone()
+	activated.Revert(f.Env.Root(), decoded).To(reverted)
+	assert.Equal(t, "/usr/bin", reverted["PATH"])
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+)
+
+// auditRecord is a line in the audit log.
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Env     string    `json:"env"`
+	Package string    `json:"package"`
+	Binary  string    `json:"binary"`
+	Args    []string  `json:"args"`
+	Dir     string    `json:"dir,omitempty"`
+}
+
+// redactedArg replaces arguments matching an audit-redact pattern.
+const redactedArg = "[REDACTED]"
+
+// writeAuditRecord appends a record of a binary about to be executed to the
+// audit log at path, with any args matching a redact pattern replaced.
+//
+// The record is written with a single append so that concurrent executions
+// do not interleave.
+func writeAuditRecord(path string, redact []string, envDir string, pkg *manifest.Package, binary string, args []string) error {
+	patterns := make([]*regexp.Regexp, 0, len(redact))
+	for _, pattern := range redact {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid audit-redact pattern %q", pattern)
+		}
+		patterns = append(patterns, re)
+	}
+	record := auditRecord{
+		Time:    time.Now().UTC(),
+		Env:     envDir,
+		Package: pkg.Reference.String(),
+		Binary:  binary,
+		Args:    make([]string, 0, len(args)),
+	}
+	record.Dir, _ = os.Getwd()
+next:
+	for _, arg := range args {
+		for _, re := range patterns {
+			if re.MatchString(arg) {
+				record.Args = append(record.Args, redactedArg)
+				continue next
+			}
+		}
+		record.Args = append(record.Args, arg)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w, err := os.OpenFile(kong.ExpandPath(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return errors.WithStack(err)
+}
@@ -14,12 +14,13 @@ import (
 
 type autoVersionCmd struct {
 	ContinueOnError bool     `help:"Continue on errors."`
+	Concurrency     int      `help:"Number of manifests to process in parallel." default:"1"`
 	UpdateDigests   bool     `help:"Update digests when auto-versioning."`
 	Manifest        []string `arg:"" type:"existingfile" required:"" help:"Manifests to upgrade." predictor:"hclfile"`
 }
 
 func (a *autoVersionCmd) Run(l *ui.UI, hclient *http.Client, state *state.State, client *github.Client) error {
-	for _, path := range a.Manifest {
+	return forEachManifest(a.Concurrency, a.Manifest, a.ContinueOnError, func(path string) error {
 		l.Debugf("Auto-versioning %s", path)
 		info, err := os.Stat(path)
 		if err != nil {
@@ -40,8 +41,8 @@ func (a *autoVersionCmd) Run(l *ui.UI, hclient *http.Client, state *state.State,
 				return errors.Wrapf(err, "could not restore original manifest: %s", path)
 			}
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func autoVersionManifest(l *ui.UI, hclient *http.Client, state *state.State, client *github.Client, path string) error {
@@ -53,7 +54,7 @@ func autoVersionManifest(l *ui.UI, hclient *http.Client, state *state.State, cli
 		return nil
 	}
 	l.Infof("Auto-versioned %s to %s", path, version)
-	err = digest.UpdateDigests(l, hclient, state, path)
+	err = digest.UpdateDigests(l, hclient, state, path, false, digest.FormatHex, digest.DefaultConcurrency)
 	if err != nil {
 		return errors.WithStack(err)
 	}
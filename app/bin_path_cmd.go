@@ -0,0 +1,61 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
+)
+
+type binPathCmd struct {
+	Binary string `arg:"" help:"Name of, or path to, a binary in the environment's bin directory."`
+}
+
+func (b *binPathCmd) Help() string {
+	return `
+Show how a binary in the environment resolves, including the full symlink chain, the package that owns the binary,
+and the path to the real executable.
+`
+}
+
+func (b *binPathCmd) Run(l *ui.UI, env *hermit.Env) error {
+	links, pkg, executable, err := resolveBinPath(l, env, b.Binary)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Println(strings.Join(links, " -> "))
+	fmt.Printf("Package: %s\n", pkg.Reference)
+	fmt.Printf("Executable: %s\n", executable)
+	return nil
+}
+
+// resolveBinPath returns the symlink chain of a binary in the environment,
+// the package that owns it, and the path to its real executable.
+func resolveBinPath(l *ui.UI, env *hermit.Env, binary string) (links []string, pkg *manifest.Package, executable string, err error) {
+	if !strings.ContainsRune(binary, filepath.Separator) {
+		binary = filepath.Join(env.BinDir(), binary)
+	}
+	links, err = util.ResolveSymlinks(binary)
+	if err != nil {
+		return nil, nil, "", errors.WithStack(err)
+	}
+	pkg, _, err = env.ResolveLink(l, binary)
+	if err != nil {
+		return nil, nil, "", errors.WithStack(err)
+	}
+	bins, err := pkg.ResolveBinaries()
+	if err != nil {
+		return nil, nil, "", errors.WithStack(err)
+	}
+	for _, bin := range bins {
+		if filepath.Base(bin) == filepath.Base(binary) {
+			return links, pkg, bin, nil
+		}
+	}
+	return nil, nil, "", errors.Errorf("%s: package %s does not provide %s", binary, pkg.Reference, filepath.Base(binary))
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/hermittest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestResolveBinPath(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, staticFileHTTPHandler(t, "../archive/testdata"))
+	f.WithManifests(map[string]string{
+		"tpkg.hcl": `
+			description = ""
+			binaries = ["darwin_exe"]
+			version "0.9.0" {
+			  source = "` + f.Server.URL + `/archive.tar.gz"
+			}
+		`,
+	})
+	defer f.Clean()
+	l, _ := ui.NewForTesting()
+	_, _, err := f.Env.InstallByName(l, "tpkg-0.9.0")
+	assert.NoError(t, err)
+
+	links, pkg, executable, err := resolveBinPath(l, f.Env, "darwin_exe")
+	assert.NoError(t, err)
+	assert.Equal(t, "tpkg-0.9.0", pkg.Reference.String())
+	assert.Equal(t, filepath.Join(pkg.Root, "darwin_exe"), executable)
+	assert.Equal(t, []string{
+		filepath.Join(f.Env.BinDir(), "darwin_exe"),
+		filepath.Join(f.Env.BinDir(), ".tpkg-0.9.0.pkg"),
+		filepath.Join(f.Env.BinDir(), "hermit"),
+	}, links)
+
+	// A path to the binary works too.
+	_, pkg, _, err = resolveBinPath(l, f.Env, filepath.Join(f.Env.BinDir(), "darwin_exe"))
+	assert.NoError(t, err)
+	assert.Equal(t, "tpkg-0.9.0", pkg.Reference.String())
+
+	// Binaries not provided by the package they link to are reported.
+	err = os.Symlink(".tpkg-0.9.0.pkg", filepath.Join(f.Env.BinDir(), "other"))
+	assert.NoError(t, err)
+	_, _, _, err = resolveBinPath(l, f.Env, "other")
+	assert.EqualError(t, err, filepath.Join(f.Env.BinDir(), "other")+": package tpkg-0.9.0 does not provide other")
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/bundle"
+	"github.com/cashapp/hermit/cache"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type exportBundleCmd struct {
+	Out       string                  `short:"o" required:"" placeholder:"FILE" help:"Bundle file to write."`
+	Platforms []platform.Platform     `name:"platform" placeholder:"OS-ARCH" help:"Platforms to export artefacts for. Defaults to all core platforms."`
+	Packages  []manifest.GlobSelector `arg:"" required:"" name:"package" help:"Packages to export (<name>[-<version>])." predictor:"package"`
+}
+
+func (e *exportBundleCmd) Help() string {
+	return `
+Download the artefacts of the given packages and their runtime dependencies for each target platform, and write
+them to a bundle along with an index of their sources and checksums. The bundle can be imported into the cache on
+another machine with "hermit import-bundle".
+`
+}
+
+func (e *exportBundleCmd) Run(l *ui.UI, env *hermit.Env, state *state.State, c *cache.Cache) error {
+	platforms := e.Platforms
+	if len(platforms) == 0 {
+		platforms = platform.Core
+	}
+	var entries []bundle.Entry
+	for _, selector := range e.Packages {
+		for _, target := range platforms {
+			pkgs, err := env.ResolveForPlatform(l, target, selector)
+			if errors.Is(err, manifest.ErrNoSource) {
+				l.Warnf("%s is not available for %s", selector, target)
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, selector.String())
+			}
+			for _, pkg := range pkgs {
+				if strings.Contains(pkg.Source, ".git#") || strings.HasSuffix(pkg.Source, ".git") || pkg.Source == "/" {
+					l.Warnf("Skipping %s for %s, only archive sources can be bundled", pkg, target)
+					continue
+				}
+				task := l.Task(pkg.Reference.String())
+				digest, err := state.CacheAndDigest(task, pkg)
+				task.Done()
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				entries = append(entries, bundle.Entry{
+					Reference: pkg.Reference.String(),
+					Platform:  target.String(),
+					Source:    pkg.Source,
+					SHA256:    pkg.SHA256,
+					Digest:    digest,
+					Path:      bundle.EntryPath(pkg.SHA256, pkg.Source),
+				})
+			}
+		}
+	}
+	w, err := os.CreateTemp(filepath.Dir(e.Out), filepath.Base(e.Out)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(w.Name()) // nolint
+	err = bundle.Write(w, c, entries)
+	_ = w.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.Infof("Exported %d artefacts to %s", len(entries), e.Out)
+	return errors.WithStack(os.Rename(w.Name(), e.Out))
+}
+
+type importBundleCmd struct {
+	Bundle string `arg:"" type:"existingfile" help:"Bundle file to import." predictor:"file"`
+}
+
+func (i *importBundleCmd) Run(l *ui.UI, c *cache.Cache) error {
+	r, err := os.Open(i.Bundle)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer r.Close() // nolint
+	task := l.Task(filepath.Base(i.Bundle))
+	defer task.Done()
+	index, err := bundle.Read(task, r, c)
+	if err != nil {
+		return errors.Wrap(err, i.Bundle)
+	}
+	l.Infof("Imported %d artefacts from %s", len(index.Entries), i.Bundle)
+	return nil
+}
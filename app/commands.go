@@ -17,23 +17,35 @@ type GlobalState struct {
 type cliInterface interface {
 	getCPUProfile() string
 	getMemProfile() string
+	getProfile() string
 	getDebug() bool
 	getTrace() bool
 	getQuiet() bool
 	getLevel() ui.Level
 	getGlobalState() GlobalState
 	getLockTimeout() time.Duration
+	getDownloadConcurrency() int
+	getStateIndex() bool
+	getTraceHTTP() bool
+	getConfig() string
+	getNoVerifyScripts() bool
 }
 
 type cliBase struct {
-	VersionFlag kong.VersionFlag `help:"Show version." name:"version"`
-	CPUProfile  string           `placeholder:"PATH" name:"cpu-profile" help:"Enable CPU profiling to PATH." hidden:""`
-	MemProfile  string           `placeholder:"PATH" name:"mem-profile" help:"Enable memory profiling to PATH." hidden:""`
-	Debug       bool             `help:"Enable debug logging." short:"d"`
-	Trace       bool             `help:"Enable trace logging." short:"t"`
-	Quiet       bool             `help:"Disable logging and progress UI, except fatal errors." env:"HERMIT_QUIET" short:"q"`
-	Level       ui.Level         `help:"Set minimum log level (${enum})." env:"HERMIT_LOG" default:"auto" enum:"auto,trace,debug,info,warn,error,fatal"`
-	LockTimeout time.Duration    `help:"Timeout for waiting on the lock" default:"30s" env:"HERMIT_LOCK_TIMEOUT"`
+	VersionFlag     kong.VersionFlag `help:"Show version." name:"version"`
+	CPUProfile      string           `placeholder:"PATH" name:"cpu-profile" help:"Enable CPU profiling to PATH." hidden:""`
+	MemProfile      string           `placeholder:"PATH" name:"mem-profile" help:"Enable memory profiling to PATH." hidden:""`
+	Profile         string           `placeholder:"PATH" help:"Write a timeline of operations such as syncing, resolving, downloading, extracting and linking to PATH, in Chrome trace JSON format."`
+	Debug           bool             `help:"Enable debug logging." short:"d"`
+	Trace           bool             `help:"Enable trace logging." short:"t"`
+	Quiet           bool             `help:"Disable logging and progress UI, except fatal errors." env:"HERMIT_QUIET" short:"q"`
+	Level           ui.Level         `help:"Set minimum log level (${enum})." env:"HERMIT_LOG" default:"auto" enum:"auto,trace,debug,info,warn,error,fatal"`
+	LockTimeout     time.Duration    `help:"Timeout for waiting on the lock" default:"30s" env:"HERMIT_LOCK_TIMEOUT"`
+	Downloads       int              `help:"Number of package sources to download at once when installing." default:"4" env:"HERMIT_DOWNLOADS"`
+	StateIndex      bool             `help:"Use an on-disk index of extracted packages to avoid filesystem checks, eg. for network backed state." env:"HERMIT_STATE_INDEX"`
+	TraceHTTP       bool             `help:"Log HTTP request and response headers, status and timing." name:"trace-http" env:"HERMIT_TRACE_HTTP"`
+	Config          string           `placeholder:"PATH" type:"existingfile" help:"Read the environment configuration from PATH rather than bin/hermit.hcl." env:"HERMIT_CONFIG"`
+	NoVerifyScripts bool             `help:"Warn rather than fail if the environment's scripts have unknown SHA256 sums. Only use this if you have reviewed the scripts." env:"HERMIT_NO_VERIFY_SCRIPTS"`
 	GlobalState
 
 	Init       initCmd       `cmd:"" help:"Initialise an environment (idempotent)." group:"env"`
@@ -47,9 +59,14 @@ type cliBase struct {
 	Exec                 execCmd              `cmd:"" help:"Directly execute a binary in a package." hidden:""`
 	Update               updateCmd            `cmd:"" aliases:"sync" help:"Update manifest sources." group:"global"`
 	Search               searchCmd            `cmd:"" help:"Search for packages to install." group:"global"`
+	WhichProvides        whichProvidesCmd     `cmd:"" help:"List packages providing a command." group:"global"`
 	DumpUserConfigSchema dumpUserConfigSchema `cmd:"" help:"Dump user configuration schema." hidden:""`
+	JSONSchema           jsonSchemaCmd        `cmd:"" name:"json-schema" help:"Print a JSON Schema for hermit.hcl, for editor completion and validation." group:"global"`
 	ScriptSHA            scriptSHACmd         `cmd:"" help:"Print known sha256 sums of activate-hermit and hermit scripts." hidden:""`
 	GenInstaller         genInstallerCmd      `cmd:"" help:"Generate Hermit installer script." group:"global"`
+	ImportBundle         importBundleCmd      `cmd:"" help:"Import package artefacts from a bundle into the cache." group:"global"`
+	State                stateCmd             `cmd:"" help:"Manage the Hermit state directory." group:"global"`
+	SelfUpdate           selfUpdateCmd        `cmd:"" help:"Update Hermit itself, or roll back the last update." group:"global"`
 	kong.Plugins
 }
 
@@ -57,12 +74,18 @@ var _ cliInterface = &cliBase{}
 
 func (u *cliBase) getCPUProfile() string         { return u.CPUProfile }
 func (u *cliBase) getMemProfile() string         { return u.MemProfile }
+func (u *cliBase) getProfile() string            { return u.Profile }
 func (u *cliBase) getTrace() bool                { return u.Trace }
 func (u *cliBase) getDebug() bool                { return u.Debug }
 func (u *cliBase) getQuiet() bool                { return u.Quiet }
 func (u *cliBase) getLevel() ui.Level            { return ui.AutoLevel(u.Level) }
 func (u *cliBase) getGlobalState() GlobalState   { return u.GlobalState }
 func (u *cliBase) getLockTimeout() time.Duration { return u.LockTimeout }
+func (u *cliBase) getDownloadConcurrency() int   { return u.Downloads }
+func (u *cliBase) getStateIndex() bool           { return u.StateIndex }
+func (u *cliBase) getTraceHTTP() bool            { return u.TraceHTTP }
+func (u *cliBase) getConfig() string             { return u.Config }
+func (u *cliBase) getNoVerifyScripts() bool      { return u.NoVerifyScripts }
 
 // CLI structure.
 type unactivated struct {
@@ -80,6 +103,10 @@ type activated struct {
 	List       listCmd              `cmd:"" help:"List local packages." group:"env"`
 	Exec       execCmd              `cmd:"" help:"Directly execute a binary in a package." group:"env"`
 	Env        envCmd               `cmd:"" help:"Manage environment variables." group:"env"`
+	BinPath    binPathCmd           `cmd:"" help:"Show the symlink chain, owning package and executable for a binary." group:"env"`
+	Why        whyCmd               `cmd:"" help:"Explain why a package is installed." group:"env"`
+	Pin        pinCmd               `cmd:"" help:"Pin channel packages to the version they resolve to." group:"env"`
+	Unpin      unpinCmd             `cmd:"" help:"Unpin channel packages pinned with 'hermit pin'." group:"env"`
 	Validate   activatedValidateCmd `cmd:"" help:"Hermit validation." group:"global"`
 	AddDigests addDigestsCmd        `cmd:"" help:"Add digests for all versions/platforms to the input manifest files." group:"global"`
 
@@ -87,6 +114,8 @@ type activated struct {
 	GC    gcCmd    `cmd:"" hidden:"" group:"global"`
 	Test  testCmd  `cmd:"" help:"Run package sanity tests." group:"global"`
 
+	ExportBundle exportBundleCmd `cmd:"" help:"Export package artefacts to a bundle for air-gapped environments." group:"env"`
+
 	// TODO: Remove this after we can assume that all active hermit sessions have been recreated with the latest scripts
 	Deactivate deactivateCmd `cmd:"" help:"Deprecated" hidden:""`
 }
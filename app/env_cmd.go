@@ -2,32 +2,56 @@ package app
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/cashapp/hermit"
 	"github.com/cashapp/hermit/envars"
 	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
 	"github.com/cashapp/hermit/shell"
+	"github.com/cashapp/hermit/state"
 	"github.com/cashapp/hermit/ui"
 )
 
 type envCmd struct {
+	Rename     envRenameCmd     `cmd:"" help:"Rename an environment variable, keeping its value."`
+	Doctor     envDoctorCmd     `cmd:"" help:"Check the environment for problems, offering to fix them."`
+	ExportPath envExportPathCmd `cmd:"" help:"Print only the PATH the environment sets, without activating it."`
+	Lock       envLockCmd       `cmd:"" help:"Lock the installed packages, so that install, upgrade and uninstall fail without --force."`
+	Unlock     envUnlockCmd     `cmd:"" help:"Unlock the installed packages of a locked environment."`
+	CopyFrom   envCopyFromCmd   `cmd:"" help:"Install the packages installed in another Hermit environment."`
+	PruneBin   envPruneBinCmd   `cmd:"" help:"Remove links from the bin directory whose package is not installed or no longer provides the binary."`
+	Relink     envRelinkCmd     `cmd:"" help:"Rebuild the links in the bin directory for all installed packages."`
+	Vars       envVarsCmd       `cmd:"" default:"withargs" help:"Display, set and unset environment variables (the default)."`
+}
+
+type envVarsCmd struct {
 	Raw               bool   `short:"r" help:"Output raw values without shell quoting."`
 	Ops               bool   `xor:"action" help:"Print the operations needed to manipulate the environment."`
 	Activate          bool   `xor:"action" help:"Print the commands needed to set the environment to the activated state."`
 	Deactivate        bool   `xor:"action" help:"Print the commands needed to reset the environment to the deactivated state."`
 	DeactivateFromOps string `xor:"action" placeholder:"OPS" help:"Decodes the operations, and prints the shell commands to reset the environment to the deactivated state."`
+	ActivatedIn       string `xor:"action" placeholder:"DIR" type:"existingdir" help:"Exit with an error unless DIR is the environment currently activated in the calling shell, with valid scripts."`
 	Shell             string `short:"s" help:"Shell type."`
 	Inherit           bool   `short:"i" help:"Inherit variables from parent environment."`
 	Names             bool   `short:"n" help:"Show only names."`
 	Unset             bool   `xor:"action" short:"u" help:"Unset the specified environment variable."`
+	Packages          bool   `xor:"action" help:"Print the references of installed packages, one per line, without resolving them."`
 	Name              string `arg:"" optional:"" help:"Name of the environment variable."`
 	Value             string `arg:"" optional:"" help:"Value to set the variable to."`
 }
 
 func (e *envCmd) Help() string {
 	return `
+Without a command, "env" runs "env vars", so "hermit env [<name>] [<value>]" displays and sets environment variables.
+See "hermit env vars --help" for its flags.
+	`
+}
+
+func (e *envVarsCmd) Help() string {
+	return `
 Without arguments the "env" command will display environment variables for the active Hermit environment.
 
 Passing "<name>" will print the value for that environment variable.
@@ -36,7 +60,7 @@ Passing "<name> <value>" will set the value for an environment variable in the a
 	`
 }
 
-func (e *envCmd) Run(l *ui.UI, env *hermit.Env) error {
+func (e *envVarsCmd) Run(l *ui.UI, cli cliInterface, env *hermit.Env) error {
 	// Special case for backwards compatibility.
 	// TODO: Remove this at some point.
 	if e.Name == "get" {
@@ -44,6 +68,10 @@ func (e *envCmd) Run(l *ui.UI, env *hermit.Env) error {
 		e.Value = ""
 	}
 
+	if e.Packages {
+		return errors.WithStack(printInstalledReferences(os.Stdout, env))
+	}
+
 	// Setting envar
 	if e.Value != "" {
 		return env.SetEnv(e.Name, e.Value)
@@ -53,6 +81,10 @@ func (e *envCmd) Run(l *ui.UI, env *hermit.Env) error {
 		return env.DelEnv(e.Name)
 	}
 
+	if e.ActivatedIn != "" {
+		return errors.WithStack(allowUnverifiedScripts(l, cli, env.VerifyActivation(e.ActivatedIn, envars.Parse(os.Environ()))))
+	}
+
 	if e.Activate || e.Deactivate || e.Ops || e.DeactivateFromOps != "" {
 		sh, err := e.resolveShell()
 		if err != nil {
@@ -117,7 +149,243 @@ func (e *envCmd) Run(l *ui.UI, env *hermit.Env) error {
 	return nil
 }
 
-func (e *envCmd) resolveShell() (shell.Shell, error) {
+type envRenameCmd struct {
+	PrintChanges string `enum:"none,json" default:"none" help:"Print the environment variable changes made by the rename to stdout in the given format (${enum}), for scripts that apply them to their own environment."`
+	From         string `arg:"" help:"Name of the environment variable to rename."`
+	To           string `arg:"" help:"New name of the environment variable."`
+}
+
+func (r *envRenameCmd) Run(env *hermit.Env) error {
+	changes, err := env.RenameEnv(r.From, r.To)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if r.PrintChanges == "json" {
+		return errors.WithStack(printChanges(os.Stdout, changes))
+	}
+	return nil
+}
+
+type envDoctorCmd struct {
+	Scripts bool `help:"Check the environment's scripts."`
+	Yes     bool `short:"y" help:"Fix problems without asking for confirmation."`
+}
+
+func (d *envDoctorCmd) Help() string {
+	return `
+Checks the environment for problems, offering to fix them. Without any check flags, all checks are run.
+
+--scripts checks that the environment's scripts are known to this version of Hermit, such as after a release fixing a
+bug in them, and offers to rewrite any that are not with the bundled versions.
+	`
+}
+
+func (d *envDoctorCmd) Run(l *ui.UI, env *hermit.Env, config Config) error {
+	all := !d.Scripts
+	if d.Scripts || all {
+		if err := upgradeScripts(l, env, config, d.Yes); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+type envExportPathCmd struct{}
+
+func (e *envExportPathCmd) Help() string {
+	return `
+Prints the PATH the activated environment would have, for running the environment's binaries from subprocesses and
+container entrypoints without activating it. For example:
+
+    PATH="$(./bin/hermit env export-path)" make build
+	`
+}
+
+func (e *envExportPathCmd) Run(l *ui.UI, env *hermit.Env) error {
+	path, err := env.Path(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Println(path)
+	return nil
+}
+
+type envLockCmd struct{}
+
+func (e *envLockCmd) Help() string {
+	return `
+Locks the installed packages of the environment, such as in a shared or golden image, so that "hermit install",
+"upgrade" and "uninstall" fail rather than change them unless passed --force.
+	`
+}
+
+func (e *envLockCmd) Run(l *ui.UI, env *hermit.Env) error {
+	if err := env.Lock(l); err != nil {
+		return errors.WithStack(err)
+	}
+	l.Infof("Locked %s, run \"hermit env unlock\" to allow its packages to be changed", env.Root())
+	return nil
+}
+
+type envUnlockCmd struct{}
+
+func (e *envUnlockCmd) Run(l *ui.UI, env *hermit.Env) error {
+	if err := env.Unlock(l); err != nil {
+		return errors.WithStack(err)
+	}
+	l.Infof("Unlocked %s", env.Root())
+	return nil
+}
+
+// printInstalledReferences writes the references of the packages installed
+// in env to w, one per line, without resolving them.
+func printInstalledReferences(w io.Writer, env *hermit.Env) error {
+	refs, err := env.ListInstalledReferences()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, ref := range refs {
+		fmt.Fprintln(w, ref)
+	}
+	return nil
+}
+
+// upgradeScripts rewrites the scripts in env with unknown SHA256 sums to
+// the versions bundled with this Hermit, after confirmation unless "yes".
+func upgradeScripts(l *ui.UI, env *hermit.Env, config Config, yes bool) error {
+	unknown, err := env.UnknownScripts()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(unknown) == 0 {
+		l.Infof("All scripts in %s are up to date", env.BinDir())
+		return nil
+	}
+	if !yes {
+		ok, err := l.Confirmation("%s have unknown SHA256 sums, upgrade them to the versions bundled with this Hermit? [y/N]", strings.Join(unknown, ", "))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !ok {
+			return errors.Errorf("not upgrading scripts")
+		}
+	}
+	_, sum, err := GenInstaller(config)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := env.UpgradeScripts(l, unknown, config.BaseDistURL, sum); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.Wrap(env.Verify(), "scripts are still not valid after upgrading")
+}
+
+type envPruneBinCmd struct{}
+
+func (p *envPruneBinCmd) Help() string {
+	return `
+Removes links from the bin directory whose package is no longer installed, or no longer provides a binary of that
+name, such as after packages have been removed by hand.
+	`
+}
+
+func (p *envPruneBinCmd) Run(l *ui.UI, env *hermit.Env) error {
+	pruned, err := env.PruneBinaries(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(pruned) == 0 {
+		l.Infof("No orphaned binaries found")
+	}
+	return nil
+}
+
+type envRelinkCmd struct{}
+
+func (r *envRelinkCmd) Help() string {
+	return `
+Removes and recreates the links in the bin directory for every installed package, such as after they have been
+damaged by another tool or a bad merge, without reinstalling the packages.
+	`
+}
+
+func (r *envRelinkCmd) Run(l *ui.UI, env *hermit.Env) error {
+	pkgs, err := env.Relink(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.Infof("Relinked %d package(s)", len(pkgs))
+	return nil
+}
+
+type envCopyFromCmd struct {
+	Dir string `arg:"" type:"existingdir" help:"Hermit environment to copy the installed packages of."`
+}
+
+func (c *envCopyFromCmd) Help() string {
+	return `
+Installs the packages installed in the Hermit environment at <dir>, such as when bootstrapping a new project. If a
+version is not available from the sources of the active environment, the latest available version is installed instead.
+	`
+}
+
+func (c *envCopyFromCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State) error {
+	return errors.WithStack(copyPackagesFrom(l, env, sta, c.Dir))
+}
+
+// copyPackagesFrom installs the packages installed in the environment at dir
+// into env, substituting the latest version of any that are not available.
+func copyPackagesFrom(l *ui.UI, env *hermit.Env, sta *state.State, dir string) error {
+	info, err := hermit.LoadEnvInfo(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	refs, err := info.ListInstalledReferences()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := env.Update(l, false); err != nil {
+		return errors.WithStack(err)
+	}
+	var (
+		selectors   []manifest.GlobSelector
+		unavailable []string
+	)
+	for _, ref := range refs {
+		pkg, err := env.Resolve(l, manifest.ExactSelector(ref), true)
+		if errors.Is(err, manifest.ErrUnknownPackage) {
+			pkg, err = env.Resolve(l, manifest.NameSelector(ref.Name), true)
+			if errors.Is(err, manifest.ErrUnknownPackage) {
+				l.Warnf("%s is not available", ref)
+				unavailable = append(unavailable, ref.String())
+				continue
+			}
+			if err == nil {
+				l.Warnf("%s is not available, installing %s instead", ref, pkg.Reference)
+			}
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		selector, err := manifest.ParseGlobSelector(pkg.Reference.String())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		selectors = append(selectors, selector)
+	}
+	if len(selectors) > 0 {
+		install := &installCmd{Packages: selectors, FailFast: true}
+		if err := install.Run(l, env, sta); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if len(unavailable) > 0 {
+		return errors.Errorf("%d package(s) are not available: %s", len(unavailable), strings.Join(unavailable, ", "))
+	}
+	return nil
+}
+
+func (e *envVarsCmd) resolveShell() (shell.Shell, error) {
 	if e.Shell != "" {
 		return shell.Resolve(e.Shell)
 	}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/hermittest"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestCopyPackagesFrom(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, staticFileHTTPHandler(t, "../archive/testdata"))
+	f.WithManifests(map[string]string{
+		"tpkg.hcl": `
+			description = ""
+			binaries = ["darwin_exe"]
+			version "0.9.0" {
+			  source = "` + f.Server.URL + `/archive.tar.gz"
+			}
+		`,
+		"upkg.hcl": `
+			description = ""
+			binaries = ["linux_exe"]
+			version "1.0.0" "1.1.0" {
+			  source = "` + f.Server.URL + `/archive.tar.gz"
+			}
+		`,
+	})
+	defer f.Clean()
+
+	// Only the package links are needed to list another environment's packages.
+	other := f.NewEnv()
+	for _, ref := range []string{"tpkg-0.9.0", "upkg-0.1.0", "gone-1.0.0"} {
+		err := os.Symlink("hermit", filepath.Join(other.BinDir(), "."+ref+".pkg"))
+		assert.NoError(t, err)
+	}
+
+	l, _ := ui.NewForTesting()
+	err := copyPackagesFrom(l, f.Env, f.State, other.Root())
+	assert.EqualError(t, err, "1 package(s) are not available: gone-1.0.0")
+
+	installed, err := f.Env.ListInstalledReferences()
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{
+		manifest.ParseReference("tpkg-0.9.0"),
+		manifest.ParseReference("upkg-1.1.0"),
+	}, installed)
+}
+
+func TestAllowUnverifiedScripts(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, nil)
+	defer f.Clean()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(f.Env.BinDir(), "hermit"), []byte("#!/bin/sh\necho custom\n"), 0700))
+	err := f.Env.Verify()
+	assert.True(t, errors.Is(err, hermit.ErrUnknownScript))
+
+	l, out := ui.NewForTesting()
+	assert.Error(t, allowUnverifiedScripts(l, &cliBase{}, err))
+	assert.NoError(t, allowUnverifiedScripts(l, &cliBase{NoVerifyScripts: true}, err))
+	assert.Contains(t, out.String(), "SCRIPT VERIFICATION IS DISABLED")
+
+	// Missing scripts are still an error.
+	assert.NoError(t, os.Remove(filepath.Join(f.Env.BinDir(), "hermit")))
+	err = f.Env.Verify()
+	assert.Error(t, allowUnverifiedScripts(l, &cliBase{NoVerifyScripts: true}, err))
+}
+
+func TestPrintInstalledReferences(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, nil)
+	defer f.Clean()
+	// Packages are listed from their links, even without a manifest to resolve them from.
+	for _, ref := range []string{"tpkg-0.9.0", "upkg@stable"} {
+		err := os.Symlink("hermit", filepath.Join(f.Env.BinDir(), "."+ref+".pkg"))
+		assert.NoError(t, err)
+	}
+	out := &strings.Builder{}
+	err := printInstalledReferences(out, f.Env)
+	assert.NoError(t, err)
+	assert.Equal(t, "tpkg-0.9.0\nupkg@stable\n", out.String())
+}
@@ -1,7 +1,7 @@
 package app
 
 import (
-	"fmt"
+	"cmp"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,11 +19,13 @@ import (
 )
 
 type execCmd struct {
+	Cwd    string   `type:"existingdir" placeholder:"DIR" help:"Directory to run the binary in, rather than the current directory."`
+	Audit  string   `placeholder:"PATH" env:"HERMIT_AUDIT_LOG" help:"Append a JSON record of the execution to PATH, overriding audit-log in the user configuration."`
 	Binary string   `arg:"" help:"Binary symlink to execute."`
 	Args   []string `arg:"" help:"Arguments to pass to executable (use -- to separate)." optional:""`
 }
 
-func (e *execCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, globalState GlobalState, config Config, defaultHTTPClient *http.Client) error {
+func (e *execCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, globalState GlobalState, config Config, userConfig UserConfig, defaultHTTPClient *http.Client) error {
 	envDir, err := hermit.FindEnvDir(e.Binary)
 	if err != nil {
 		return errors.WithStack(err)
@@ -60,6 +62,11 @@ func (e *execCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, globalStat
 	if filepath.Base(e.Binary) == "hermit" {
 		env := os.Environ()
 		env = append(env, "HERMIT_ENV="+envDir)
+		restore, err := e.chdir()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer restore()
 		return syscall.Exec(self, args, env)
 	}
 
@@ -71,15 +78,6 @@ func (e *execCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, globalStat
 		return errors.Wrapf(err, "execution failed")
 	}
 
-	// Run any pre-execution triggers.
-	messages, err := env.TriggerForPackage(l, manifest.EventExec, pkg)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	w := l.WriterAt(ui.LevelInfo)
-	for _, message := range messages {
-		fmt.Fprintln(w, message)
-	}
 	installed, err := env.ListInstalledReferences()
 	if err != nil {
 		return errors.WithStack(err)
@@ -93,9 +91,38 @@ func (e *execCmd) Run(l *ui.UI, cache *cache.Cache, sta *state.State, globalStat
 		return errors.WithStack(err)
 	}
 
+	// The executed binary inherits the working directory, so change it last,
+	// after the binary has been resolved relative to the original directory.
+	restore, err := e.chdir()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer restore()
+	if audit := cmp.Or(e.Audit, userConfig.AuditLog); audit != "" {
+		// Auditing fails open, so it can never prevent a binary from running.
+		if err := writeAuditRecord(audit, userConfig.AuditRedact, envDir, pkg, binary, e.Args); err != nil {
+			l.Warnf("Could not write to audit log: %s", err)
+		}
+	}
 	return env.Exec(l, pkg, binary, args, deps)
 }
 
+// chdir changes to the --cwd directory, if any, returning a function that
+// restores the original working directory if the exec fails.
+func (e *execCmd) chdir() (restore func(), err error) {
+	if e.Cwd == "" {
+		return func() {}, nil
+	}
+	original, err := os.Getwd()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := os.Chdir(e.Cwd); err != nil {
+		return nil, errors.Wrap(err, "invalid --cwd")
+	}
+	return func() { _ = os.Chdir(original) }, nil
+}
+
 func updateHermit(l *ui.UI, env *hermit.Env, pkgRef string, force bool) error {
 	l.Tracef("Checking if %s needs to be updated", pkgRef)
 	pkg, err := env.Resolve(l, manifest.ExactSelector(manifest.ParseReference(pkgRef)), false)
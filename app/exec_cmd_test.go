@@ -0,0 +1,63 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/manifest"
+)
+
+func TestExecChdir(t *testing.T) {
+	original, err := os.Getwd()
+	assert.NoError(t, err)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+
+	cmd := execCmd{Cwd: dir}
+	restore, err := cmd.chdir()
+	assert.NoError(t, err)
+	cwd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.Equal(t, dir, cwd)
+
+	// Restored if the exec fails.
+	restore()
+	cwd, err = os.Getwd()
+	assert.NoError(t, err)
+	assert.Equal(t, original, cwd)
+
+	cmd = execCmd{Cwd: filepath.Join(dir, "missing")}
+	_, err = cmd.chdir()
+	assert.Error(t, err)
+	cwd, err = os.Getwd()
+	assert.NoError(t, err)
+	assert.Equal(t, original, cwd)
+}
+
+func TestWriteAuditRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	pkg := &manifest.Package{Reference: manifest.ParseReference("tool-1.0.0")}
+	redact := []string{"^--token=", "^hunter2$"}
+
+	assert.NoError(t, writeAuditRecord(path, redact, "/project", pkg, "tool", []string{"run", "--token=secret", "hunter2"}))
+	assert.NoError(t, writeAuditRecord(path, redact, "/project", pkg, "tool", nil))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, 2, len(lines))
+	record := auditRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "tool-1.0.0", record.Package)
+	assert.Equal(t, "tool", record.Binary)
+	assert.Equal(t, "/project", record.Env)
+	assert.Equal(t, []string{"run", redactedArg, redactedArg}, record.Args)
+	assert.False(t, record.Time.IsZero())
+
+	assert.Error(t, writeAuditRecord(path, []string{"("}, "/project", pkg, "tool", nil))
+}
@@ -1,42 +1,109 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/cashapp/hermit"
 	"github.com/cashapp/hermit/envars"
 	"github.com/cashapp/hermit/errors"
 	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/platform"
 	"github.com/cashapp/hermit/shell"
 	"github.com/cashapp/hermit/state"
 	"github.com/cashapp/hermit/ui"
 )
 
 type installCmd struct {
-	Packages []manifest.GlobSelector `arg:"" optional:"" name:"package" help:"Packages to install (<name>[-<version>]). Version can be a glob to find the latest version with." predictor:"package"`
+	Platform          platform.Platform       `placeholder:"OS-ARCH" help:"Resolve packages for the given platform rather than the host. Requires --only-download."`
+	OnlyDownload      bool                    `help:"Only download packages to the cache, do not extract or link them."`
+	Save              bool                    `xor:"save" help:"Record installed packages in the environment configuration, so they are installed by 'hermit install' with no arguments."`
+	NoSave            bool                    `xor:"save" help:"Do not record installed packages in the environment configuration, removing them if already present."`
+	ByBinary          bool                    `help:"If no package matches a bare name, install the package that provides a binary of that name instead."`
+	FailFast          bool                    `default:"true" negatable:"" help:"Stop at the first package that fails to install, rather than installing the rest and summarising failures."`
+	Requirements      []string                `short:"r" type:"existingfile" placeholder:"FILE" help:"Also install the packages listed in FILE, one reference per line. Blank lines and # comments are ignored."`
+	PrintChanges      string                  `enum:"none,json" default:"none" help:"Print the environment variable changes made by the install to stdout in the given format (${enum}), for scripts that apply them to their own environment."`
+	Force             bool                    `help:"Change packages even if the environment is locked with 'hermit env lock'."`
+	PreferBinaryCache bool                    `help:"Skip syncing sources and updating channels if every package resolves locally and is already extracted in the shared state, so it only needs to be linked into the environment."`
+	Packages          []manifest.GlobSelector `arg:"" optional:"" name:"package" help:"Packages to install (<name>[-<version>] or a manifest URL). Version can be a glob to find the latest version with." predictor:"package"`
 }
 
 func (i *installCmd) Help() string {
 	return `
 Add the specified set of packages to the environment. If no packages are specified, all existing packages linked
-into the environment will be downloaded and installed. Packages will be pinned to the version resolved at install time.
+into the environment, and all packages saved in the environment configuration, will be downloaded and installed.
+Packages will be pinned to the version resolved at install time.
+
+A package can also be installed directly from a manifest, by passing an http(s) URL or path to a .hcl file rather
+than a package name. The manifest is only used for that install, and is not added to the environment's sources.
+
+With --save, installed packages are also recorded in bin/hermit.hcl. With --no-save, any saved version of the
+installed packages is removed from bin/hermit.hcl, so the installation only exists as symlinks. Otherwise, packages
+that are already saved have their saved version updated.
+
+If a binary (with --by-binary) or a required dependency is provided by several packages, Hermit prompts for which one
+to install when run interactively, and otherwise fails listing the candidates.
+
+With -r, package references are also read from a file, one per line, as with "pip install -r". Every listed package
+is installed, and any failures are summarised at the end, as with --no-fail-fast.
+
+Packages given without a version use the version from a project file such as .nvmrc, if the package is mapped to
+one by "version-files" in bin/hermit.hcl.
 `
 }
 
 func (i *installCmd) Run(l *ui.UI, env *hermit.Env, state *state.State) error {
+	if i.Force {
+		env.OverrideLock()
+	}
 	installed, err := env.ListInstalledReferences()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	pkgs := map[string]*manifest.Package{}
+	failFast := i.FailFast
+	if len(i.Requirements) > 0 {
+		failFast = false
+		listed, err := readRequirements(i.Requirements)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(listed) == 0 && len(i.Packages) == 0 {
+			l.Infof("No packages listed in %s", strings.Join(i.Requirements, ", "))
+			return i.printChanges(nil)
+		}
+		i.Packages = append(i.Packages, listed...)
+	}
+	i.Packages, err = manifestSelectors(l, env, i.Packages)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	i.Packages, err = env.ApplyVersionFiles(l, i.Packages)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	selectors := i.Packages
 
-	err = env.Update(l, false)
-	if err != nil {
+	if i.PreferBinaryCache && !i.OnlyDownload && i.allPrepared(l, env, state, installed) {
+		l.Debugf("All packages are prepared, skipping update")
+	} else if err = env.Update(l, false); err != nil {
 		return errors.WithStack(err)
 	}
 
+	if i.OnlyDownload {
+		if err := i.download(l, env, state, installed); err != nil {
+			return errors.WithStack(err)
+		}
+		return i.printChanges(nil)
+	}
+	if i.Platform != (platform.Platform{}) && i.Platform != platform.Host {
+		return errors.Errorf("--platform %s can only be used with --only-download", i.Platform)
+	}
+
 	if len(selectors) == 0 {
 		// Checking that all the packages are downloaded and unarchived
 		for _, ref := range installed {
@@ -52,7 +119,13 @@ func (i *installCmd) Run(l *ui.UI, env *hermit.Env, state *state.State) error {
 				return errors.WithStack(err)
 			}
 		}
-		return nil
+		selectors, err = savedSelectors(env, installed)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(selectors) == 0 {
+			return i.printChanges(nil)
+		}
 	}
 
 	var toBeInstalledSelectors []manifest.GlobSelector
@@ -70,15 +143,33 @@ func (i *installCmd) Run(l *ui.UI, env *hermit.Env, state *state.State) error {
 		toBeInstalledSelectors = append(toBeInstalledSelectors, selector)
 	}
 
-	for i, search := range toBeInstalledSelectors {
-		err := env.ResolveWithDeps(l, installed, toBeInstalledSelectors[i], pkgs)
+	if i.ByBinary {
+		toBeInstalledSelectors, err = resolveBinarySelectors(l, env, toBeInstalledSelectors)
 		if err != nil {
-			return errors.Wrap(err, search.String())
+			return errors.WithStack(err)
 		}
 	}
+
+	// Failures keyed by package, only collected if not failing fast.
+	failed := map[string]error{}
+
+	timer := ui.LogElapsed(l, "Resolve packages")
+	for _, search := range toBeInstalledSelectors {
+		err := env.ResolveWithDeps(l, installed, search, pkgs)
+		if err != nil {
+			if failFast {
+				return errors.Wrap(err, search.String())
+			}
+			failed[search.String()] = err
+		}
+	}
+	timer()
 	changes := shell.NewChanges(envars.Parse(os.Environ()))
 	w := l.WriterAt(ui.LevelInfo)
 	defer w.Sync() // nolint
+	// When failing fast, packages installed before a failure are rolled back
+	// so the environment is not left partially modified.
+	tx := env.BeginInstall()
 	for _, pkg := range pkgs {
 		// Skip possible dependencies that have already been installed
 		exists := false
@@ -92,19 +183,284 @@ func (i *installCmd) Run(l *ui.UI, env *hermit.Env, state *state.State) error {
 			continue
 		}
 
-		c, err := env.Install(l, pkg)
+		c, err := tx.Install(l, pkg)
+		if err == nil {
+			var messages []string
+			messages, err = env.TriggerForPackage(l, manifest.EventInstall, pkg)
+			for _, message := range messages {
+				fmt.Fprintln(w, message)
+			}
+		}
 		if err != nil {
+			if failFast {
+				if rerr := tx.Rollback(l); rerr != nil {
+					l.Warnf("Failed to roll back install: %s", rerr)
+				}
+				return errors.WithStack(err)
+			}
+			failed[pkg.Reference.String()] = err
+			delete(pkgs, pkg.Reference.String())
+			continue
+		}
+		changes = changes.Merge(c)
+		pkg.LogWarnings(l)
+	}
+	if err := i.updateSaved(env, toBeInstalledSelectors, pkgs); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(failed) > 0 {
+		names := make([]string, 0, len(failed))
+		for name := range failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			l.Errorf("%s: %s", name, failed[name])
+		}
+		// Report the changes made by the packages that did install.
+		if err := i.printChanges(changes); err != nil {
 			return errors.WithStack(err)
 		}
-		messages, err := env.TriggerForPackage(l, manifest.EventInstall, pkg)
+		return errors.Errorf("%d package(s) failed to install: %s", len(failed), strings.Join(names, ", "))
+	}
+	return i.printChanges(changes)
+}
+
+// printChanges writes changes to stdout if requested with --print-changes,
+// where nil means nothing changed.
+func (i *installCmd) printChanges(changes *shell.Changes) error {
+	if i.PrintChanges != "json" {
+		return nil
+	}
+	return errors.WithStack(printChanges(os.Stdout, changes))
+}
+
+// allPrepared returns true if every package to be installed resolves without
+// syncing sources, and is already extracted with its binaries linked.
+func (i *installCmd) allPrepared(l *ui.UI, env *hermit.Env, state *state.State, installed []manifest.Reference) bool {
+	var selectors []manifest.Selector
+	for _, selector := range i.Packages {
+		selectors = append(selectors, selector)
+	}
+	if len(selectors) == 0 {
+		for _, ref := range installed {
+			selectors = append(selectors, manifest.ExactSelector(ref))
+		}
+		for _, ref := range env.SavedPackages() {
+			selectors = append(selectors, manifest.ExactSelector(ref))
+		}
+	}
+	for _, selector := range selectors {
+		pkg, err := env.Resolve(l, selector, false)
+		if err != nil || !state.IsPrepared(pkg) {
+			return false
+		}
+	}
+	return true
+}
+
+// printChanges writes the environment variable operations added and removed by
+// changes to w as JSON, or "{}" if changes is nil or empty.
+func printChanges(w io.Writer, changes *shell.Changes) error {
+	if changes == nil || (len(changes.Add) == 0 && len(changes.Remove) == 0) {
+		_, err := fmt.Fprintln(w, "{}")
+		return errors.WithStack(err)
+	}
+	add, err := envars.MarshalOps(changes.Add)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	remove, err := envars.MarshalOps(changes.Remove)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data, err := json.Marshal(map[string]json.RawMessage{"add": add, "remove": remove})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return errors.WithStack(err)
+}
+
+// readRequirements reads package selectors from requirements files, one per
+// line. Blank lines and "#" comments are ignored.
+func readRequirements(paths []string) ([]manifest.GlobSelector, error) {
+	var out []manifest.GlobSelector
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for n, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			selector, err := manifest.ParseGlobSelector(line)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s:%d", path, n+1)
+			}
+			out = append(out, selector)
+		}
+	}
+	return out, nil
+}
+
+// manifestSelectors replaces selectors that are manifest URLs or files with
+// the name of the package, adding the manifest as a source.
+func manifestSelectors(l *ui.UI, env *hermit.Env, selectors []manifest.GlobSelector) ([]manifest.GlobSelector, error) {
+	out := make([]manifest.GlobSelector, 0, len(selectors))
+	for _, selector := range selectors {
+		uri := selector.String()
+		if !isManifestURI(uri) {
+			out = append(out, selector)
+			continue
+		}
+		name, err := env.AddManifestSource(l, uri)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		resolved, err := manifest.ParseGlobSelector(name)
+		if err != nil {
+			return nil, errors.Wrap(err, uri)
+		}
+		out = append(out, resolved)
+	}
+	return out, nil
+}
+
+// isManifestURI returns true if the package argument is an http(s) URL or an
+// existing file ending in .hcl.
+func isManifestURI(arg string) bool {
+	if !strings.HasSuffix(strings.SplitN(arg, "?", 2)[0], ".hcl") {
+		return false
+	}
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") || strings.HasPrefix(arg, "file://") {
+		return true
+	}
+	info, err := os.Stat(arg)
+	return err == nil && !info.IsDir()
+}
+
+// resolveBinarySelectors replaces bare package names that do not match a
+// package with the package providing a binary of that name.
+func resolveBinarySelectors(l *ui.UI, env *hermit.Env, selectors []manifest.GlobSelector) ([]manifest.GlobSelector, error) {
+	out := make([]manifest.GlobSelector, 0, len(selectors))
+	for _, selector := range selectors {
+		if selector.IsFullyQualified() {
+			out = append(out, selector)
+			continue
+		}
+		_, err := env.Resolve(l, selector, true)
+		if !errors.Is(err, manifest.ErrUnknownPackage) {
+			out = append(out, selector)
+			continue
+		}
+		pkg, err := env.ResolveBinary(l, selector.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, selector.String())
+		}
+		l.Infof("%s is provided by %s", selector, pkg.Reference.Name)
+		resolved, err := manifest.ParseGlobSelector(pkg.Reference.Name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		out = append(out, resolved)
+	}
+	return out, nil
+}
+
+// savedSelectors returns selectors for packages saved in the environment
+// configuration that are not already installed.
+func savedSelectors(env *hermit.Env, installed []manifest.Reference) ([]manifest.GlobSelector, error) {
+	var selectors []manifest.GlobSelector
+next:
+	for _, ref := range env.SavedPackages() {
+		for _, iref := range installed {
+			if iref.String() == ref.String() {
+				continue next
+			}
+		}
+		selector, err := manifest.ParseGlobSelector(ref.String())
+		if err != nil {
+			return nil, errors.Wrap(err, ref.String())
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+// updateSaved records, updates or removes the explicitly selected packages in
+// the environment configuration, according to --save/--no-save.
+func (i *installCmd) updateSaved(env *hermit.Env, selectors []manifest.GlobSelector, pkgs map[string]*manifest.Package) error {
+	if len(i.Packages) == 0 {
+		return nil
+	}
+	for _, pkg := range pkgs {
+		selected := false
+		for _, selector := range selectors {
+			if selector.Matches(pkg.Reference) {
+				selected = true
+				break
+			}
+		}
+		if !selected {
+			continue
+		}
+		var err error
+		switch {
+		case i.Save || (!i.NoSave && isSaved(env, pkg.Reference.Name)):
+			err = env.SavePackage(pkg.Reference)
+		case i.NoSave:
+			err = env.UnsavePackage(pkg.Reference.Name)
+		}
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		for _, message := range messages {
-			fmt.Fprintln(w, message)
+	}
+	return nil
+}
+
+// download packages and their runtime dependencies to the cache, without
+// extracting or linking them.
+func (i *installCmd) download(l *ui.UI, env *hermit.Env, state *state.State, installed []manifest.Reference) error {
+	target := i.Platform
+	if target == (platform.Platform{}) {
+		target = platform.Host
+	}
+	var selectors []manifest.Selector
+	for _, selector := range i.Packages {
+		selectors = append(selectors, selector)
+	}
+	if len(selectors) == 0 {
+		for _, ref := range installed {
+			selectors = append(selectors, manifest.ExactSelector(ref))
+		}
+	}
+	for _, selector := range selectors {
+		pkgs, err := env.ResolveForPlatform(l, target, selector)
+		if err != nil {
+			return errors.Wrap(err, selector.String())
+		}
+		for _, pkg := range pkgs {
+			task := l.Task(pkg.Reference.String())
+			task.Infof("Downloading %s for %s", pkg, target)
+			err = state.Cache(task, pkg)
+			task.Done()
+			if err != nil {
+				return errors.WithStack(err)
+			}
 		}
-		changes = changes.Merge(c)
-		pkg.LogWarnings(l)
 	}
 	return nil
 }
+
+func isSaved(env *hermit.Env, name string) bool {
+	for _, ref := range env.SavedPackages() {
+		if ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
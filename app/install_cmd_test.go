@@ -0,0 +1,218 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/envars"
+	"github.com/cashapp/hermit/hermittest"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/shell"
+	"github.com/cashapp/hermit/sources"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestInstallContinuesPastFailuresWithoutFailFast(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, staticFileHTTPHandler(t, "../archive/testdata"))
+	f.WithManifests(map[string]string{
+		"broken.hcl": `
+			description = ""
+			binaries = ["broken"]
+			version "1.0.0" {
+			  source = "` + f.Server.URL + `/missing.tar.gz"
+			}
+		`,
+		"tpkg.hcl": `
+			description = ""
+			binaries = ["darwin_exe"]
+			version "0.9.0" {
+			  source = "` + f.Server.URL + `/archive.tar.gz"
+			}
+		`,
+	})
+	defer f.Clean()
+
+	l, _ := ui.NewForTesting()
+	cmd := installCmd{FailFast: false, Packages: []manifest.GlobSelector{
+		manifest.MustParseGlobSelector("broken-1.0.0"),
+		manifest.MustParseGlobSelector("tpkg-0.9.0"),
+	}}
+	err := cmd.Run(l, f.Env, f.State)
+	assert.EqualError(t, err, "1 package(s) failed to install: broken-1.0.0")
+
+	installed, err := f.Env.ListInstalledReferences()
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("tpkg-0.9.0")}, installed)
+}
+
+func TestInstallPreferBinaryCache(t *testing.T) {
+	manifests := map[string]string{}
+	f := hermittest.NewEnvTestFixture(t, staticFileHTTPHandler(t, "../archive/testdata"))
+	manifests["tpkg.hcl"] = `
+		description = ""
+		binaries = ["darwin_exe"]
+		version "0.9.0" {
+		  source = "` + f.Server.URL + `/archive.tar.gz"
+		}
+	`
+	f.WithManifests(manifests)
+	defer f.Clean()
+	other := f.NewEnv()
+	for name, content := range manifests {
+		assert.NoError(t, other.AddSource(f.P, sources.NewMemSource(name, content)))
+	}
+
+	l, _ := ui.NewForTesting()
+	cmd := installCmd{FailFast: true, PreferBinaryCache: true, Packages: []manifest.GlobSelector{
+		manifest.MustParseGlobSelector("tpkg-0.9.0"),
+	}}
+	assert.False(t, cmd.allPrepared(l, f.Env, f.State, nil))
+	assert.NoError(t, cmd.Run(l, f.Env, f.State))
+
+	// Once prepared by one environment, the package only needs to be linked into others.
+	assert.True(t, cmd.allPrepared(l, other, f.State, nil))
+	assert.NoError(t, cmd.Run(l, other, f.State))
+	installed, err := other.ListInstalledReferences()
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("tpkg-0.9.0")}, installed)
+}
+
+func TestInstallFromManifestURL(t *testing.T) {
+	files := staticFileHTTPHandler(t, "../archive/testdata")
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mytool.hcl" {
+			files(w, r)
+			return
+		}
+		_, err := w.Write([]byte(`
+			description = ""
+			binaries = ["darwin_exe"]
+			version "1.0.0" {
+			  source = "http://` + r.Host + `/archive.tar.gz"
+			}
+		`))
+		assert.NoError(t, err)
+	}))
+	defer f.Clean()
+
+	l, _ := ui.NewForTesting()
+	cmd := installCmd{FailFast: true, Packages: []manifest.GlobSelector{
+		manifest.MustParseGlobSelector(f.Server.URL + "/mytool.hcl"),
+	}}
+	assert.NoError(t, cmd.Run(l, f.Env, f.State))
+	installed, err := f.Env.ListInstalledReferences()
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("mytool-1.0.0")}, installed)
+
+	// The manifest is saved in the environment, so the package still resolves
+	// once it is reopened.
+	_, err = os.Stat(filepath.Join(f.Env.Root(), ".hermit", "manifests", "mytool.hcl"))
+	assert.NoError(t, err)
+	pkgs, err := f.ReopenEnv().ListInstalled(l)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pkgs))
+	assert.Equal(t, "mytool-1.0.0", pkgs[0].Reference.String())
+
+	cmd = installCmd{FailFast: true, Packages: []manifest.GlobSelector{
+		manifest.MustParseGlobSelector(f.Server.URL + "/missing.hcl"),
+	}}
+	assert.Error(t, cmd.Run(l, f.Env, f.State))
+}
+
+func TestInstallFromManifestURLDoesNotShadowConfiguredSources(t *testing.T) {
+	files := staticFileHTTPHandler(t, "../archive/testdata")
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mytool.hcl" {
+			files(w, r)
+			return
+		}
+		_, err := w.Write([]byte(`
+			description = ""
+			binaries = ["darwin_exe"]
+			version "1.0.0" {
+			  source = "http://` + r.Host + `/archive.tar.gz"
+			}
+		`))
+		assert.NoError(t, err)
+	}))
+	defer f.Clean()
+
+	configured := t.TempDir()
+	err := os.WriteFile(filepath.Join(configured, "mytool.hcl"), []byte(`
+		description = ""
+		binaries = ["darwin_exe"]
+		version "2.0.0" {
+		  source = "`+f.Server.URL+`/archive.tar.gz"
+		}
+	`), 0600)
+	assert.NoError(t, err)
+	f.WithEnvConfig(`sources = ["file://` + configured + `"]`)
+
+	// The manifest is used for this install, ahead of the configured sources.
+	l, _ := ui.NewForTesting()
+	cmd := installCmd{FailFast: true, Packages: []manifest.GlobSelector{
+		manifest.MustParseGlobSelector(f.Server.URL + "/mytool.hcl"),
+	}}
+	assert.NoError(t, cmd.Run(l, f.Env, f.State))
+	installed, err := f.Env.ListInstalledReferences()
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("mytool-1.0.0")}, installed)
+
+	// Once saved, it is only searched after the configured sources.
+	pkg, err := f.ReopenEnv().Resolve(l, manifest.NameSelector("mytool"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "mytool-2.0.0", pkg.Reference.String())
+}
+
+func TestInstallFromRequirementsFile(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, staticFileHTTPHandler(t, "../archive/testdata"))
+	f.WithManifests(map[string]string{
+		"broken.hcl": `
+			description = ""
+			binaries = ["broken"]
+			version "1.0.0" {
+			  source = "` + f.Server.URL + `/missing.tar.gz"
+			}
+		`,
+		"tpkg.hcl": `
+			description = ""
+			binaries = ["darwin_exe"]
+			version "0.9.0" {
+			  source = "` + f.Server.URL + `/archive.tar.gz"
+			}
+		`,
+	})
+	defer f.Clean()
+
+	requirements := filepath.Join(t.TempDir(), "tools.txt")
+	err := os.WriteFile(requirements, []byte("# Tools\nbroken-1.0.0\n\n  tpkg-0.9.0 # pinned\n"), 0600)
+	assert.NoError(t, err)
+
+	l, _ := ui.NewForTesting()
+	cmd := installCmd{FailFast: true, Requirements: []string{requirements}}
+	err = cmd.Run(l, f.Env, f.State)
+	assert.EqualError(t, err, "1 package(s) failed to install: broken-1.0.0")
+
+	installed, err := f.Env.ListInstalledReferences()
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("tpkg-0.9.0")}, installed)
+}
+
+func TestPrintChanges(t *testing.T) {
+	changes := shell.NewChanges(envars.Envars{})
+	changes.Add = envars.Ops{&envars.Set{Name: "TOOL_HOME", Value: "/tool"}, &envars.Prepend{Name: "PATH", Value: "/tool/bin"}}
+	changes.Remove = envars.Ops{&envars.Set{Name: "OLD_HOME", Value: "/old"}}
+	buf := &bytes.Buffer{}
+	err := printChanges(buf, changes)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"add":[{"s":{"n":"TOOL_HOME","v":"/tool"}},{"p":{"n":"PATH","v":"/tool/bin"}}],"remove":[{"s":{"n":"OLD_HOME","v":"/old"}}]}`+"\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, printChanges(buf, shell.NewChanges(envars.Envars{})))
+	assert.Equal(t, "{}\n", buf.String())
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/util/jsonschema"
+)
+
+type jsonSchemaCmd struct{}
+
+func (jsonSchemaCmd) Run() error {
+	ast, err := hcl.Schema(&hermit.Config{})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	schema, err := jsonschema.FromHCL("hermit.hcl", ast)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -6,9 +6,13 @@ import (
 	"go/doc"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/alecthomas/colour"
+	"github.com/alecthomas/kong"
 	"golang.org/x/term"
 
 	"github.com/cashapp/hermit"
@@ -23,10 +27,50 @@ type JSONFormattable struct {
 }
 
 type listCmd struct {
-	Short bool `short:"s" help:"Short listing."`
+	Short    bool      `short:"s" help:"Short listing."`
+	Since    ageWindow `placeholder:"AGE" help:"Only list packages installed or updated within AGE, eg. 30d or 12h."`
+	Unused   bool      `help:"Invert --since, listing packages not installed or updated within AGE."`
+	Outdated bool      `help:"Only list packages with a newer version available, or whose channel has changed, without upgrading them."`
 	JSONFormattable
 }
 
+// ageWindow is a duration that additionally accepts whole days, eg. "30d".
+type ageWindow time.Duration
+
+func (a *ageWindow) Decode(ctx *kong.DecodeContext) error { // nolint: golint
+	var value string
+	if err := ctx.Scan.PopValueInto("age", &value); err != nil {
+		return errors.WithStack(err)
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return errors.Errorf("invalid age %q", value)
+		}
+		*a = ageWindow(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.Errorf("invalid age %q", value)
+	}
+	*a = ageWindow(d)
+	return nil
+}
+
+// filterByAge returns the packages installed or updated within window of now,
+// or those that were not if unused is true.
+func filterByAge(pkgs manifest.Packages, window time.Duration, unused bool, now time.Time) manifest.Packages {
+	out := manifest.Packages{}
+	for _, pkg := range pkgs {
+		recent := !pkg.UpdatedAt.IsZero() && now.Sub(pkg.UpdatedAt) <= window
+		if recent != unused {
+			out = append(out, pkg)
+		}
+	}
+	return out
+}
+
 func buildListJSONResult(byName map[string][]*manifest.Package, names []string) interface{} {
 	packages := make([]*manifest.Package, 0)
 
@@ -44,10 +88,19 @@ func buildListJSONResult(byName map[string][]*manifest.Package, names []string)
 }
 
 func (cmd *listCmd) Run(l *ui.UI, env *hermit.Env) error {
+	if cmd.Outdated {
+		return cmd.listOutdated(l, env)
+	}
 	pkgs, err := env.ListInstalled(l)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if cmd.Unused && cmd.Since == 0 {
+		return errors.Errorf("--unused requires --since")
+	}
+	if cmd.Since != 0 {
+		pkgs = filterByAge(pkgs, time.Duration(cmd.Since), cmd.Unused, time.Now())
+	}
 	if cmd.Short {
 		for _, pkg := range pkgs {
 			fmt.Println(pkg)
@@ -67,6 +120,71 @@ func (cmd *listCmd) Run(l *ui.UI, env *hermit.Env) error {
 	return nil
 }
 
+// outdatedJSON is the JSON representation of an outdated package.
+type outdatedJSON struct {
+	Name           string `json:"name"`
+	Current        string `json:"current"`
+	Upgrade        string `json:"upgrade,omitempty"`
+	Latest         string `json:"latest,omitempty"`
+	ChannelUpdated bool   `json:"channel_updated,omitempty"`
+}
+
+func (cmd *listCmd) listOutdated(l *ui.UI, env *hermit.Env) error {
+	outdated, err := env.Outdated(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	sort.Slice(outdated, func(i, j int) bool {
+		return outdated[i].Package.Reference.Name < outdated[j].Package.Reference.Name
+	})
+	rows := make([]outdatedJSON, 0, len(outdated))
+	for _, pkg := range outdated {
+		row := outdatedJSON{
+			Name:           pkg.Package.Reference.Name,
+			Current:        pkg.Package.Reference.StringNoName(),
+			ChannelUpdated: pkg.ChannelUpdated,
+		}
+		if pkg.Upgrade.IsSet() {
+			row.Upgrade = pkg.Upgrade.StringNoName()
+		}
+		if pkg.Latest.IsSet() {
+			row.Latest = pkg.Latest.StringNoName()
+		}
+		rows = append(rows, row)
+	}
+	if cmd.JSON {
+		data, err := json.Marshal(rows)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if cmd.Short {
+		for _, pkg := range outdated {
+			fmt.Println(pkg.Package)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tCURRENT\tUPGRADE\tLATEST")
+	for _, row := range rows {
+		upgrade, latest := orDash(row.Upgrade), orDash(row.Latest)
+		if row.ChannelUpdated {
+			upgrade, latest = "changed", "changed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.Name, row.Current, upgrade, latest)
+	}
+	return errors.WithStack(w.Flush())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func groupPackages(pkgs []*manifest.Package) (map[string][]*manifest.Package, []string) {
 	byName := map[string][]*manifest.Package{}
 	for _, pkg := range pkgs {
@@ -159,6 +277,9 @@ func printPackage(pkgs []*manifest.Package, option *listPackageOption, name stri
 				clr = "^2"
 			}
 		}
+		if pkg.Pinned {
+			suffix += " (pinned)"
+		}
 		versions = append(versions, fmt.Sprintf("%s%s%s^R", clr, pkg.Reference.StringNoName(), suffix))
 	}
 	colour.Println("^B^2" + name + "^R (" + strings.Join(versions, ", ") + ")")
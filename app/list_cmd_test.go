@@ -2,8 +2,12 @@ package app
 
 import (
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/kong"
+
+	"github.com/cashapp/hermit/manifest"
 )
 
 func TestNameListWithoutPrefix(t *testing.T) {
@@ -46,3 +50,33 @@ func TestNameListWithPrefixAndTieBreak(t *testing.T) {
 	assert.Equal(t, names[2], "attest")
 	assert.Equal(t, names[3], "untested")
 }
+
+func TestListSinceParsesAge(t *testing.T) {
+	for input, expected := range map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"90m": 90 * time.Minute,
+	} {
+		cmd := &listCmd{}
+		parser, err := kong.New(cmd)
+		assert.NoError(t, err)
+		_, err = parser.Parse([]string{"--since", input})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, time.Duration(cmd.Since), input)
+	}
+	parser, err := kong.New(&listCmd{})
+	assert.NoError(t, err)
+	_, err = parser.Parse([]string{"--since", "xd"})
+	assert.Error(t, err)
+}
+
+func TestFilterByAge(t *testing.T) {
+	now := time.Now()
+	recent := &manifest.Package{Reference: manifest.ParseReference("recent-1.0.0"), UpdatedAt: now.Add(-time.Hour)}
+	old := &manifest.Package{Reference: manifest.ParseReference("old-1.0.0"), UpdatedAt: now.Add(-48 * time.Hour)}
+	unknown := &manifest.Package{Reference: manifest.ParseReference("unknown-1.0.0")}
+	pkgs := manifest.Packages{recent, old, unknown}
+
+	assert.Equal(t, manifest.Packages{recent}, filterByAge(pkgs, 24*time.Hour, false, now))
+	assert.Equal(t, manifest.Packages{old, unknown}, filterByAge(pkgs, 24*time.Hour, true, now))
+}
@@ -125,7 +125,7 @@ func testLogsFor(
 	err = expected.Execute(&tbuf, state{
 		Source: uri,
 		State:  f.State.Root(),
-		Cache:  filepath.Join(f.State.Root(), "cache", cache.BasePath("", uri)),
+		Cache:  filepath.Join(f.State.CacheDir(), cache.BasePath("", uri)),
 		Env:    f.Env.EnvDir(),
 		Bin:    f.Env.BinDir(),
 	})
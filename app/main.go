@@ -2,12 +2,17 @@ package app
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -17,9 +22,11 @@ import (
 
 	"github.com/cashapp/hermit"
 	"github.com/cashapp/hermit/cache"
+	"github.com/cashapp/hermit/errors"
 	"github.com/cashapp/hermit/github"
 	"github.com/cashapp/hermit/state"
 	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
 	"github.com/cashapp/hermit/util/debug"
 )
 
@@ -30,45 +37,120 @@ type HTTPTransportConfig struct {
 	ResponseHeaderTimeout time.Duration
 	DialTimeout           time.Duration
 	KeepAlive             time.Duration
+	// RootCAs to trust, or nil to use the system roots.
+	RootCAs *x509.CertPool
+	// ProxyFunc selects the proxy for each request, or nil to use http.ProxyFromEnvironment.
+	ProxyFunc func(*http.Request) (*url.URL, error)
 }
 
 // Config for the main Hermit application.
 type Config struct {
 	Version     string
+	Channel     string // Release channel Hermit was built for, eg. "stable".
+	BuildDate   string // Time Hermit was built at in RFC 3339 format, if known.
 	LogLevel    ui.Level
 	BaseDistURL string
 	// Possible system-wide installation paths
 	InstallPaths []string
 	// SHA256 checksums for all known versions of per-environment scripts.
 	// If empty shell.ScriptSHAs will be used.
-	SHA256Sums  []string
+	SHA256Sums []string
+	// Creates HTTP clients. Defaults to a client with a plain http.Transport.
+	//
+	// Custom clients must honour the RootCAs and ProxyFunc of the
+	// HTTPTransportConfig, which carry the user's ca-bundle and Config.Proxy.
+	// RootCAs are applied automatically to a returned *http.Transport that
+	// does not set its own.
 	HTTP        func(HTTPTransportConfig) *http.Client
 	State       state.Config
 	KongOptions []kong.Option
 	KongPlugins kong.Plugins
 	// Defaults to cache.GetSource if nil.
 	PackageSourceSelector cache.PackageSourceSelector
+	// Selects the proxy for each HTTP request, eg. to route downloads from
+	// different hosts through different proxies. Defaults to
+	// http.ProxyFromEnvironment if nil.
+	Proxy func(*http.Request) (*url.URL, error)
 	// True if we're running in CI - disables progress bar.
 	CI bool
+
+	rootCAs *x509.CertPool
+	// Whether HTTP clients log the metadata of each request and response, from
+	// --trace-http. Set once flags are parsed, before any clients are created.
+	traceHTTP *bool
 }
 
 type loggingHTTPTransport struct {
 	logger ui.Logger
 	next   http.RoundTripper
+	// If true, log headers, status and timing of each request.
+	verbose *bool
 }
 
 func (l *loggingHTTPTransport) RoundTrip(r *http.Request) (*http.Response, error) {
-	l.logger.Tracef("%s %s", r.Method, r.URL)
-	return l.next.RoundTrip(r)
+	url := util.RedactURL(r.URL.String())
+	l.logger.Tracef("%s %s", r.Method, url)
+	if l.verbose == nil || !*l.verbose {
+		return l.next.RoundTrip(r)
+	}
+	l.logger.Infof("> %s %s", r.Method, url)
+	logHTTPHeaders(l.logger, ">", r.Header)
+	start := time.Now()
+	resp, err := l.next.RoundTrip(r)
+	elapsed := time.Since(start)
+	if err != nil {
+		l.logger.Infof("< %s %s failed after %s: %s", r.Method, url, elapsed, err)
+		return resp, err
+	}
+	l.logger.Infof("< %s %s (%s)", resp.Proto, resp.Status, elapsed)
+	logHTTPHeaders(l.logger, "<", resp.Header)
+	return resp, nil
+}
+
+// Headers that carry credentials, and are never logged.
+var sensitiveHTTPHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+func logHTTPHeaders(logger ui.Logger, prefix string, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			if sensitiveHTTPHeaders[key] || strings.Contains(strings.ToLower(key), "token") {
+				value = "REDACTED"
+			} else if key == "Location" {
+				value = util.RedactURL(value)
+			}
+			logger.Infof("%s %s: %s", prefix, key, value)
+		}
+	}
 }
 
 // Make a HTTP client.
 func (c Config) makeHTTPClient(logger ui.Logger, config HTTPTransportConfig) *http.Client {
+	config.RootCAs = c.rootCAs
+	config.ProxyFunc = c.Proxy
 	client := c.HTTP(config)
+	if transport, ok := client.Transport.(*http.Transport); ok && config.RootCAs != nil &&
+		(transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil) {
+		transport = transport.Clone()
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		transport.TLSClientConfig.RootCAs = config.RootCAs
+		client.Transport = transport
+	}
 	if debug.Flags.FailHTTP {
 		client.Timeout = time.Millisecond
 	}
-	client.Transport = &loggingHTTPTransport{logger, client.Transport}
+	client.Transport = &loggingHTTPTransport{logger, client.Transport, c.traceHTTP}
 	return client
 }
 
@@ -85,6 +167,53 @@ func (c Config) defaultHTTPClient(logger ui.Logger) *http.Client {
 	return c.makeHTTPClient(logger, HTTPTransportConfig{})
 }
 
+// newHTTPClient is the default Config.HTTP.
+func newHTTPClient(config HTTPTransportConfig) *http.Client {
+	proxy := config.ProxyFunc
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	transport := &http.Transport{
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		Proxy:                 proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: config.KeepAlive,
+		}).DialContext,
+	}
+	if config.RootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: config.RootCAs, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// loadCABundle returns the system roots augmented with the PEM encoded
+// certificates in "path".
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	path = kong.ExpandPath(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("%s: no PEM encoded certificates found", path)
+	}
+	return pool, nil
+}
+
+// openCache opens the download cache at dir, or within the Hermit state
+// directory if dir is empty.
+func openCache(dir string, selector cache.PackageSourceSelector, client, fastFailClient *http.Client) (*cache.Cache, error) {
+	if dir == "" {
+		return cache.Open(hermit.UserStateDir, selector, client, fastFailClient)
+	}
+	return cache.OpenDir(dir, selector, client, fastFailClient)
+}
+
 // Main runs the Hermit command-line application with the given config.
 func Main(config Config) {
 	if len(config.InstallPaths) == 0 {
@@ -96,17 +225,7 @@ func Main(config Config) {
 	}
 	config.LogLevel = ui.AutoLevel(config.LogLevel)
 	if config.HTTP == nil {
-		config.HTTP = func(config HTTPTransportConfig) *http.Client {
-			transport := &http.Transport{
-				ResponseHeaderTimeout: config.ResponseHeaderTimeout,
-				Proxy:                 http.ProxyFromEnvironment,
-				DialContext: (&net.Dialer{
-					Timeout:   config.DialTimeout,
-					KeepAlive: config.KeepAlive,
-				}).DialContext,
-			}
-			return &http.Client{Transport: transport}
-		}
+		config.HTTP = newHTTPClient
 	}
 
 	if len(config.SHA256Sums) == 0 {
@@ -137,6 +256,9 @@ func Main(config Config) {
 		p = ui.New(config.LogLevel, os.Stdout, os.Stderr, stdoutIsTTY, stderrIsTTY)
 	}
 	p.SetProgressBarEnabled(!config.CI)
+	if stdoutIsTTY && isatty.IsTerminal(os.Stdin.Fd()) && !config.CI {
+		p.SetStdin(os.Stdin)
+	}
 	defer func() {
 		err := recover()
 		p.Clear()
@@ -172,6 +294,25 @@ func Main(config Config) {
 		log.Printf("%s: %s", userConfigPath, err)
 	}
 
+	caBundle := userConfig.CABundle
+	if envCABundle := os.Getenv("HERMIT_CA_BUNDLE"); envCABundle != "" {
+		caBundle = envCABundle
+	}
+	if caBundle != "" {
+		config.rootCAs, err = loadCABundle(caBundle)
+		if err != nil {
+			log.Fatalf("failed to load CA bundle: %s", err)
+		}
+	}
+
+	cacheDir := ""
+	if userConfig.CacheDir != "" {
+		cacheDir = kong.ExpandPath(userConfig.CacheDir)
+	}
+	if envCacheDir := os.Getenv("HERMIT_CACHE_DIR"); envCacheDir != "" {
+		cacheDir = kong.ExpandPath(envCacheDir)
+	}
+
 	githubToken := os.Getenv("HERMIT_GITHUB_TOKEN")
 	if githubToken == "" {
 		githubToken = os.Getenv("GITHUB_TOKEN")
@@ -223,10 +364,26 @@ func Main(config Config) {
 		}
 	}
 
+	ctx, err := parser.Parse(os.Args[1:])
+	parser.FatalIfErrorf(err)
+	configureLogging(cli, ctx.Command(), p)
+
+	if configFile := cli.getConfig(); configFile != "" {
+		if !isActivated {
+			log.Fatalf("--config can only be used in an environment")
+		}
+		envInfo, err = hermit.LoadEnvInfoWithConfig(envPath, configFile)
+		if err != nil {
+			log.Fatalf("failed to load environment info: %s", err)
+		}
+	}
+
 	getSource := config.PackageSourceSelector
 	if config.PackageSourceSelector == nil {
 		getSource = cache.GetSource
 	}
+	config.traceHTTP = new(bool)
+	*config.traceHTTP = cli.getTraceHTTP()
 	defaultHTTPClient := config.defaultHTTPClient(p)
 
 	ghClient := github.New(defaultHTTPClient, githubToken)
@@ -246,20 +403,21 @@ func Main(config Config) {
 		}
 	}
 
-	cache, err := cache.Open(hermit.UserStateDir, getSource, defaultHTTPClient, config.fastHTTPClient(p))
+	cache, err := openCache(cacheDir, getSource, defaultHTTPClient, config.fastHTTPClient(p))
 	if err != nil {
 		log.Fatalf("failed to open cache: %s", err)
 	}
 
-	ctx, err := parser.Parse(os.Args[1:])
-	parser.FatalIfErrorf(err)
-	configureLogging(cli, ctx.Command(), p)
-
 	config.State.LockTimeout = cli.getLockTimeout()
+	config.State.DownloadConcurrency = cli.getDownloadConcurrency()
+	config.State.Index = config.State.Index || cli.getStateIndex()
 	sta, err = state.Open(hermit.UserStateDir, config.State, cache)
 	if err != nil {
 		log.Fatalf("failed to open state: %s", err)
 	}
+	for _, warning := range sta.Warnings() {
+		p.Warnf("%s", warning)
+	}
 
 	if isActivated {
 		// envInfo is guaranteed to be non-nil here
@@ -296,7 +454,17 @@ func Main(config Config) {
 		err = pprof.WriteHeapProfile(f)
 		fatalIfError(p, err)
 	}
+	var timeline *ui.Timeline
+	if cli.getProfile() != "" {
+		timeline = ui.NewTimeline()
+		p.SetTimeline(timeline)
+	}
 	err = ctx.Run(env, p, sta, config, cli.getGlobalState(), ghClient, defaultHTTPClient, cache)
+	if timeline != nil {
+		if werr := writeTimeline(cli.getProfile(), timeline); werr != nil {
+			p.Warnf("Failed to write profile: %s", werr)
+		}
+	}
 	if err != nil && p.WillLog(ui.LevelDebug) {
 		p.Fatalf("%+v", err)
 	} else {
@@ -304,6 +472,19 @@ func Main(config Config) {
 	}
 }
 
+// writeTimeline writes timeline to path as a Chrome trace.
+func writeTimeline(path string, timeline *ui.Timeline) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := timeline.WriteChromeTrace(f); err != nil {
+		_ = f.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(f.Close())
+}
+
 func configureLogging(cli cliInterface, cmd string, p *ui.UI) {
 	// This is set to avoid logging in environments where quiet flag is not used
 	// in the "hermit" script. This is fragile, and should be removed when we know that all the
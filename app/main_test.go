@@ -0,0 +1,110 @@
+package app
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acarl005/stripansi"
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/cache"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestTraceHTTPRedactsCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Cache", "HIT")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	l, buf := ui.NewForTesting()
+	l.SetProgressBarEnabled(false)
+	verbose := true
+	client := &http.Client{Transport: &loggingHTTPTransport{l, http.DefaultTransport, &verbose}}
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/pkg.tar.gz?token=secret", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Auth-Token", "secret")
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	log := stripansi.Strip(buf.String())
+	assert.NotContains(t, log, "secret")
+	assert.Contains(t, log, "> GET "+server.URL+"/pkg.tar.gz?token=REDACTED")
+	assert.Contains(t, log, "> Authorization: REDACTED")
+	assert.Contains(t, log, "< Set-Cookie: REDACTED")
+	assert.Contains(t, log, "< X-Cache: HIT")
+	assert.Contains(t, log, "403 Forbidden")
+}
+
+func TestProxyUsedForDownloads(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	assert.NoError(t, err)
+	var requested []string
+	config := Config{
+		HTTP: newHTTPClient,
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			requested = append(requested, r.URL.Host)
+			return proxyURL, nil
+		},
+	}
+	l, _ := ui.NewForTesting()
+	client := config.defaultHTTPClient(l)
+	c, err := cache.Open(t.TempDir(), nil, client, client)
+	assert.NoError(t, err)
+
+	_, _, _, err = c.Download(l.Task("test"), "", "http://artifacts.example.internal/pkg.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"artifacts.example.internal"}, requested)
+	assert.Equal(t, []string{"http://artifacts.example.internal/pkg.tar.gz"}, proxied)
+}
+
+func TestCABundleUsedForDownloads(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	assert.NoError(t, err)
+	rootCAs, err := loadCABundle(bundle)
+	assert.NoError(t, err)
+
+	for name, factory := range map[string]func(HTTPTransportConfig) *http.Client{
+		"Default": newHTTPClient,
+		// Custom clients with a plain transport have the roots applied for them.
+		"Custom": func(HTTPTransportConfig) *http.Client {
+			return &http.Client{Transport: &http.Transport{}}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			l, _ := ui.NewForTesting()
+			client := Config{HTTP: factory, rootCAs: rootCAs}.defaultHTTPClient(l)
+			c, err := cache.Open(t.TempDir(), nil, client, client)
+			assert.NoError(t, err)
+			_, _, _, err = c.Download(l.Task("test"), "", server.URL+"/pkg.tar.gz")
+			assert.NoError(t, err)
+
+			// Without the bundle the server is not trusted.
+			client = Config{HTTP: factory}.defaultHTTPClient(l)
+			c, err = cache.Open(t.TempDir(), nil, client, client)
+			assert.NoError(t, err)
+			_, _, _, err = c.Download(l.Task("test"), "", server.URL+"/pkg.tar.gz")
+			assert.Error(t, err)
+		})
+	}
+}
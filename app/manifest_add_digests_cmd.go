@@ -10,23 +10,63 @@ import (
 )
 
 type addDigestsCmd struct {
-	Manifest []string `arg:"" help:"List of files that need to be updated with digests"`
+	Concurrency int           `help:"Number of manifests to process in parallel." default:"1"`
+	Digests     int           `help:"Number of missing digests to compute in parallel within each manifest." default:"4"`
+	KeepGoing   bool          `help:"Attempt every source and manifest, reporting all failures at the end rather than stopping at the first."`
+	Format      digest.Format `help:"Format of digests to write, hex or Subresource Integrity (sri)." enum:"hex,sri" default:"hex"`
+	Manifest    []string      `arg:"" help:"List of files that need to be updated with digests"`
 }
 
 func (*addDigestsCmd) Help() string {
 	return `
 	This command will go through each manifest file in input and add missing digest values to the "sha256" map.
 
-	Note: It might download packages that are not in the local cache. So it might take some time.
+	With --format=sri, digests are written in Subresource Integrity format, eg. "sha256-<base64>".
+
+	Note: It might download packages that are not in the local cache. So it might take some time. Up to --digests
+	sources are downloaded at once, and digests are written to the manifest as they are computed.
 	`
 }
 
 func (a *addDigestsCmd) Run(l *ui.UI, client *http.Client, state *state.State) error {
-	for _, f := range a.Manifest {
-		err := digest.UpdateDigests(l, client, state, f)
+	return forEachManifest(a.Concurrency, a.Manifest, a.KeepGoing, func(f string) error {
+		return errors.Wrap(digest.UpdateDigests(l, client, state, f, a.KeepGoing, a.Format, a.Digests), f)
+	})
+}
+
+type pinDigestsCmd struct {
+	addDigestsCmd
+	Verify bool `help:"Verify declared digests against freshly downloaded artefacts, reporting any that differ without modifying the manifests."`
+}
+
+func (*pinDigestsCmd) Help() string {
+	return `
+	Without --verify this command pins missing digests in the same way as "add-digests".
+
+	With --verify, manifests are not modified. Instead every source with a declared digest is downloaded again, and
+	any digest that no longer matches the upstream artefact is reported, as it may indicate a compromised release.
+	`
+}
+
+func (p *pinDigestsCmd) Run(l *ui.UI, client *http.Client, state *state.State) error {
+	if p.Verify {
+		return p.verify(l, state)
+	}
+	return p.addDigestsCmd.Run(l, client, state)
+}
+
+func (a *addDigestsCmd) verify(l *ui.UI, state *state.State) error {
+	return forEachManifest(a.Concurrency, a.Manifest, a.KeepGoing, func(f string) error {
+		drift, err := digest.VerifyDigests(l, state, f, a.KeepGoing)
+		for _, d := range drift {
+			l.Errorf("%s: %s", f, d)
+		}
 		if err != nil {
 			return errors.Wrap(err, f)
 		}
-	}
-	return nil
+		if len(drift) > 0 {
+			return errors.Errorf("%s: %d digests do not match their upstream artefacts", f, len(drift))
+		}
+		return nil
+	})
 }
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/github"
+	"github.com/cashapp/hermit/manifest/autoversion"
+	"github.com/cashapp/hermit/manifest/digest"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type addVersionCmd struct {
+	Auto     bool   `help:"Determine the new version from the manifest's auto-version block(s)."`
+	Manifest string `arg:"" type:"existingfile" help:"Manifest to add the version to." predictor:"hclfile"`
+}
+
+func (*addVersionCmd) Help() string {
+	return `
+	With --auto, this command auto-versions the manifest and, if a new version
+	was found, adds digests for all core platforms before writing the manifest
+	back in a single atomic step. The new version, if any, is printed to stdout.
+	`
+}
+
+func (a *addVersionCmd) Run(l *ui.UI, hclient *http.Client, state *state.State, client *github.Client) error {
+	if !a.Auto {
+		return errors.Errorf("no version to add, use --auto to determine it from the manifest's auto-version block(s)")
+	}
+	version, err := addVersion(l, hclient, state, client, a.Manifest)
+	if err != nil {
+		return errors.Wrap(err, a.Manifest)
+	}
+	if version != "" {
+		fmt.Println(version)
+	}
+	return nil
+}
+
+// addVersion auto-versions and digests a copy of the manifest, then atomically
+// replaces the original only if a new version was added.
+func addVersion(l *ui.UI, hclient *http.Client, state *state.State, client *github.Client, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	// The working copy keeps the manifest's file name, as the package name is
+	// derived from it when computing digests.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".add-version-*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.RemoveAll(dir)
+	work := filepath.Join(dir, filepath.Base(path))
+	err = os.WriteFile(work, original, info.Mode())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	version, err := autoversion.AutoVersion(hclient, client, work)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if version == "" {
+		l.Debugf("No new version found for %s", path)
+		return "", nil
+	}
+	l.Infof("Auto-versioned %s to %s", path, version)
+	err = digest.UpdateDigests(l, hclient, state, work, false, digest.FormatHex, digest.DefaultConcurrency)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return version, errors.WithStack(os.Rename(work, path))
+}
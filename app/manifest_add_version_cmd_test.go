@@ -0,0 +1,88 @@
+package app
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/hermittest"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestAddVersion(t *testing.T) {
+	latest := "1.1.0"
+	serveArchives := true
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/releases":
+			_, _ = w.Write([]byte("<html><body><h3>tool-1.0.0</h3><h3>tool-" + latest + "</h3></body></html>"))
+		case serveArchives && strings.HasSuffix(r.URL.Path, ".tar.gz"):
+			http.ServeFile(w, r, "../archive/testdata/archive.tar.gz")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer f.Clean()
+
+	path := filepath.Join(t.TempDir(), "tool.hcl")
+	err := os.WriteFile(path, []byte(`
+description = "A tool"
+binaries = ["darwin_exe"]
+source = "`+f.Server.URL+`/tool-${version}.tar.gz"
+
+version "1.0.0" {
+  auto-version {
+    version-pattern = "tool-(.*)"
+    html {
+      url = "`+f.Server.URL+`/releases"
+      xpath = "//h3/text()"
+    }
+  }
+}
+`), 0600)
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+
+	version, err := addVersion(l, f.Server.Client(), f.State, nil, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.0", version)
+	mani, err := manifest.LoadManifestFile(os.DirFS(filepath.Dir(path)), "tool.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0", "1.1.0"}, mani.Versions[0].Version)
+	_, ok := mani.SHA256Sums[f.Server.URL+"/tool-1.1.0.tar.gz"]
+	assert.True(t, ok)
+
+	// Without a new version the manifest is left untouched.
+	before, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	version, err = addVersion(l, f.Server.Client(), f.State, nil, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "", version)
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+
+	// A failure to compute digests leaves the original manifest in place.
+	latest = "2.0.0"
+	serveArchives = false
+	_, err = addVersion(l, f.Server.Client(), f.State, nil, path)
+	assert.Error(t, err)
+	after, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestAddVersionRequiresAuto(t *testing.T) {
+	l, _ := ui.NewForTesting()
+	cmd := &addVersionCmd{Manifest: "tool.hcl"}
+	err := cmd.Run(l, nil, nil, nil)
+	assert.EqualError(t, err, "no version to add, use --auto to determine it from the manifest's auto-version block(s)")
+}
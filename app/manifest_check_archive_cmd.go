@@ -0,0 +1,114 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestCheckArchiveCmd struct {
+	Manifest string `arg:"" type:"existingfile" help:"Manifest to check." predictor:"hclfile"`
+	Version  string `arg:"" help:"Version or channel of the package to check, eg. 1.2.3, @stable or <name>-1.2.3."`
+}
+
+func (*manifestCheckArchiveCmd) Help() string {
+	return `
+	Download and extract a package from a manifest into a temporary directory,
+	then report whether its "binaries" and "apps" are found. If they are not,
+	the top-level entries of the package are listed to help choose the correct
+	"strip" level. Nothing is installed, and the extracted package is removed.
+	`
+}
+
+func (m *manifestCheckArchiveCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State) error {
+	mani, err := manifest.LoadManifestFile(os.DirFS(filepath.Dir(m.Manifest)), filepath.Base(m.Manifest))
+	if err != nil {
+		return errors.Wrap(err, m.Manifest)
+	}
+	ref := m.Version
+	if !strings.HasPrefix(ref, mani.Name+"-") && !strings.HasPrefix(ref, mani.Name+"@") {
+		if !strings.HasPrefix(ref, "@") {
+			ref = "-" + ref
+		}
+		ref = mani.Name + ref
+	}
+	tmpDir, err := os.MkdirTemp("", "hermit-check-archive-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	config := manifest.Config{Env: tmpDir, State: tmpDir, Platform: platform.Host}
+	if env != nil {
+		config.Env = env.Root()
+	}
+	pkg, err := manifest.Resolve(mani, config, manifest.ParseReference(ref))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	task := l.Task(pkg.Reference.String())
+	defer task.Done()
+	cleanup, err := sta.ExtractDetached(task, pkg)
+	defer cleanup()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	problems := checkExtractedPackage(l, pkg)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			l.Errorf("%s", problem)
+		}
+		return errors.Errorf("%s: %d problem(s) found", pkg, len(problems))
+	}
+	l.Infof("%s extracts cleanly", pkg)
+	return nil
+}
+
+// checkExtractedPackage returns problems finding the binaries and apps of an
+// extracted package.
+func checkExtractedPackage(l *ui.UI, pkg *manifest.Package) []string {
+	var problems []string
+	if _, err := os.Stat(pkg.Root); err != nil {
+		problems = append(problems, "root "+pkg.Root+" does not exist, is strip too high?")
+	} else if binaries, err := pkg.ResolveBinaries(); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		for _, binary := range binaries {
+			rel, _ := filepath.Rel(pkg.Root, binary)
+			l.Infof("Found binary %s", rel)
+		}
+	}
+	for _, app := range pkg.Apps {
+		if _, err := os.Stat(filepath.Join(pkg.Dest, filepath.Base(app))); err != nil {
+			problems = append(problems, "app "+app+" not found")
+		} else {
+			l.Infof("Found app %s", app)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(pkg.Dest)
+	if err != nil {
+		return problems
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	l.Infof("Package contains: %s", strings.Join(names, " "))
+	if len(entries) == 1 && entries[0].IsDir() {
+		l.Infof("The package has a single top-level directory, try increasing strip to %d", pkg.Strip+1)
+	}
+	return problems
+}
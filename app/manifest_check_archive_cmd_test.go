@@ -0,0 +1,51 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/hermittest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestManifestCheckArchive(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, staticFileHTTPHandler(t, "../archive/testdata"))
+	defer f.Clean()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool.hcl")
+	err := os.WriteFile(path, []byte(`
+description = "A tool."
+binaries = ["darwin_exe", "bin/*"]
+version "1.0.0" {
+  source = "`+f.Server.URL+`/archive.tar.gz"
+  on "unpack" {
+    mkdir { dir = "${root}/bin" }
+    rename { from = "${root}/linux_exe" to = "${root}/bin/linux_exe" }
+  }
+}
+version "2.0.0" {
+  source = "`+f.Server.URL+`/archive.tar.gz"
+  strip = 1
+}
+`), 0600)
+	assert.NoError(t, err)
+
+	l, _ := ui.NewForTesting()
+	cmd := &manifestCheckArchiveCmd{Manifest: path, Version: "1.0.0"}
+	err = cmd.Run(l, nil, f.State)
+	assert.NoError(t, err)
+
+	cmd = &manifestCheckArchiveCmd{Manifest: path, Version: "tool-2.0.0"}
+	err = cmd.Run(l, nil, f.State)
+	assert.EqualError(t, err, "tool-2.0.0: 1 problem(s) found")
+
+	entries, err := os.ReadDir(f.State.PkgDir())
+	if !os.IsNotExist(err) {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 0, len(entries), "nothing should be extracted into the state")
+}
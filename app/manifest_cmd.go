@@ -1,8 +1,72 @@
 package app
 
+import (
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cashapp/hermit/errors"
+)
+
 type manifestCmd struct {
-	Validate    validateSourceCmd `cmd:"" help:"Check a package manifest source for errors." group:"global"`
-	AutoVersion autoVersionCmd    `cmd:"" help:"Upgrade manifest versions automatically where possible." group:"global"`
-	Create      manifestCreateCmd `cmd:"" help:"Create a new manifest from an existing package artefact URL." group:"global"`
-	AddDigests  addDigestsCmd     `cmd:"" help:"Add digests for all versions/platforms to the input manifest files." group:"global"`
+	Validate       validateSourceCmd         `cmd:"" help:"Check a package manifest source for errors." group:"global"`
+	ValidateSchema manifestValidateSchemaCmd `cmd:"" help:"Strictly check manifests for structural errors, without resolving them." group:"global"`
+	AutoVersion    autoVersionCmd            `cmd:"" help:"Upgrade manifest versions automatically where possible." group:"global"`
+	Create         manifestCreateCmd         `cmd:"" help:"Create a new manifest from an existing package artefact URL." group:"global"`
+	New            manifestNewCmd            `cmd:"" help:"Write a new manifest for a package source, ready for review." group:"global"`
+	AddDigests     addDigestsCmd             `cmd:"" help:"Add digests for all versions/platforms to the input manifest files." group:"global"`
+	PinDigests     pinDigestsCmd             `cmd:"" help:"Pin digests for all versions/platforms in manifest files, or verify them against upstream with --verify." group:"global"`
+	AddVersion     addVersionCmd             `cmd:"" help:"Add a new version to a manifest along with its digests." group:"global"`
+	MergeDigests   manifestMergeDigestsCmd   `cmd:"" help:"Merge the sha256sums of other copies of a manifest into it." group:"global"`
+	Resolve        manifestResolveCmd        `cmd:"" help:"Resolve a package reference, optionally explaining how its manifest layers merge." group:"global"`
+	Deprecate      manifestDeprecateCmd      `cmd:"" help:"Mark a version in a manifest as deprecated." group:"global"`
+	Sort           manifestSortCmd           `cmd:"" help:"Reorder manifests into a canonical order for cleaner diffs." group:"global"`
+	Coverage       manifestCoverageCmd       `cmd:"" help:"Report which platforms are supported by the packages in manifests." group:"global"`
+	UnusedVars     manifestUnusedVarsCmd     `cmd:"" help:"Report vars and files entries in manifests that are never used." group:"global"`
+	Lint           manifestLintCmd           `cmd:"" help:"Check manifests for dead homepage and repository links." group:"global"`
+	CheckArchive   manifestCheckArchiveCmd   `cmd:"" help:"Check that a package extracts cleanly and its binaries are found, without installing it." group:"global"`
+}
+
+// forEachManifest calls fn for each unique manifest path, with up to
+// "concurrency" calls in flight at once.
+//
+// All errors are aggregated. If "keepGoing" is false no further manifests are
+// started after the first failure.
+func forEachManifest(concurrency int, paths []string, keepGoing bool, fn func(path string) error) error {
+	var (
+		lock sync.Mutex
+		errs []error
+		seen = map[string]bool{}
+	)
+	wg := errgroup.Group{}
+	wg.SetLimit(max(1, concurrency))
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		lock.Lock()
+		failed := len(errs) > 0
+		lock.Unlock()
+		if failed && !keepGoing {
+			break
+		}
+		wg.Go(func() error {
+			// Go blocks until a slot is free, so check again once one is.
+			lock.Lock()
+			failed := len(errs) > 0
+			lock.Unlock()
+			if failed && !keepGoing {
+				return nil
+			}
+			if err := fn(path); err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = wg.Wait()
+	return errors.Join(errs...)
 }
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+func TestForEachManifest(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		visited  []string
+		inFlight atomic.Int32
+		peak     atomic.Int32
+	)
+	err := forEachManifest(2, []string{"a.hcl", "b.hcl", "a.hcl", "c.hcl", "d.hcl"}, false, func(path string) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		visited = append(visited, path)
+		lock.Unlock()
+		return nil
+	})
+	assert.NoError(t, err)
+	sort.Strings(visited)
+	assert.Equal(t, []string{"a.hcl", "b.hcl", "c.hcl", "d.hcl"}, visited)
+	assert.True(t, peak.Load() <= 2, "at most 2 manifests should be processed concurrently, got %d", peak.Load())
+}
+
+func TestForEachManifestErrors(t *testing.T) {
+	paths := []string{"a.hcl", "b.hcl", "c.hcl"}
+	fail := func(path string) error {
+		if path == "c.hcl" {
+			return nil
+		}
+		return errors.Errorf("%s failed", path)
+	}
+
+	// All failures are reported when keeping going.
+	err := forEachManifest(1, paths, true, fail)
+	assert.EqualError(t, err, "a.hcl failed\nb.hcl failed")
+
+	// Otherwise nothing is started after the first failure.
+	var visited []string
+	err = forEachManifest(1, paths, false, func(path string) error {
+		visited = append(visited, path)
+		return fail(path)
+	})
+	assert.EqualError(t, err, "a.hcl failed")
+	assert.Equal(t, []string{"a.hcl"}, visited)
+}
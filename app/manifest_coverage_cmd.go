@@ -0,0 +1,81 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest/coverage"
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestCoverageCmd struct {
+	Platforms []platform.Platform `name:"platform" placeholder:"OS-ARCH" help:"Platforms to report on. Defaults to all core and optional platforms."`
+	JSONFormattable
+	Manifest []string `arg:"" type:"existingfile" help:"Manifests to report on." predictor:"hclfile"`
+}
+
+func (*manifestCoverageCmd) Help() string {
+	return `
+	Report which platforms each package supports, where a platform is supported
+	by "all" versions and channels of a package, "some" of them, or "-" none.
+	Packages missing support for a core platform are listed after the table.
+	`
+}
+
+func (m *manifestCoverageCmd) Run(l *ui.UI) error {
+	platforms := m.Platforms
+	if len(platforms) == 0 {
+		platforms = append(append(platforms, platform.Core...), platform.Optional...)
+	}
+	report, err := coverage.Report(m.Manifest, platforms)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if m.JSON {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{"PACKAGE"}
+	for _, p := range platforms {
+		header = append(header, p.String())
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, pkg := range report {
+		row := []string{pkg.Name}
+		for _, p := range platforms {
+			row = append(row, supportLabel(pkg.Platforms[p.String()]))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, pkg := range report {
+		if len(pkg.MissingCore) > 0 {
+			l.Warnf("%s has no support for %s", pkg.Name, strings.Join(pkg.MissingCore, ", "))
+		}
+	}
+	return nil
+}
+
+func supportLabel(support coverage.Support) string {
+	switch support {
+	case coverage.SupportFull:
+		return "all"
+	case coverage.SupportPartial:
+		return "some"
+	default:
+		return "-"
+	}
+}
@@ -18,6 +18,14 @@ type manifestCreateCmd struct {
 	URL        string `arg:"" required:"" help:"URL of a package artefact."`
 }
 
+func (m *manifestCreateCmd) Help() string {
+	return `
+	This command infers a manifest from a GitHub release artefact URL, checking that artefacts exist for every
+	supported platform, and prints it. Binaries must be added before the manifest can be used. To write a manifest for
+	a source that is not a GitHub release, use "new".
+	`
+}
+
 func (m *manifestCreateCmd) Run(p *ui.UI, cache *cache.Cache, defaultHTTPClient *http.Client, ghClient *github.Client) error {
 	pkg, err := manifest.InferFromArtefact(p, cache.GetSource, defaultHTTPClient, ghClient, m.URL, m.PkgVersion)
 	if err != nil {
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest/deprecate"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestDeprecateCmd struct {
+	Message  string `required:"" help:"Deprecation warning shown when the version is installed."`
+	Manifest string `arg:"" type:"existingfile" help:"Manifest containing the version." predictor:"hclfile"`
+	Version  string `arg:"" help:"Version to deprecate."`
+}
+
+func (m *manifestDeprecateCmd) Run(l *ui.UI) error {
+	if err := deprecate.Deprecate(m.Manifest, m.Version, m.Message); err != nil {
+		return errors.Wrap(err, m.Manifest)
+	}
+	l.Infof("Deprecated %s in %s", m.Version, m.Manifest)
+	return nil
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestLintCmd struct {
+	Offline  bool          `env:"HERMIT_LINT_OFFLINE" help:"Skip rules that require network access."`
+	Strict   bool          `help:"Fail if any link is not found."`
+	Interval time.Duration `default:"250ms" help:"Minimum interval between network requests."`
+	Manifest []string      `arg:"" type:"existingfile" help:"Manifests to lint." predictor:"hclfile"`
+}
+
+func (*manifestLintCmd) Help() string {
+	return `
+	Check that the "homepage" and "repository" of each manifest can be reached,
+	warning about links that are not found or unreachable. Each link is checked
+	with a HEAD request, at most once per --interval. This requires network
+	access, and is skipped with --offline.
+
+	With --strict, links that are not found fail the lint. Unreachable links
+	are never fatal, as they are usually transient.
+	`
+}
+
+func (m *manifestLintCmd) Run(l *ui.UI, config Config) error {
+	if m.Offline {
+		l.Infof("Offline, skipping link checks")
+		return nil
+	}
+	dead, unreachable, err := lintLinks(config.fastHTTPClient(l), m.Interval, m.Manifest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, problem := range dead {
+		l.Warnf("%s", problem)
+	}
+	for _, problem := range unreachable {
+		l.Warnf("%s", problem)
+	}
+	if m.Strict && len(dead) > 0 {
+		return errors.Errorf("%d dead link(s) in %d manifests", len(dead), len(m.Manifest))
+	}
+	return nil
+}
+
+type linkCheck struct {
+	dead bool
+	err  error
+}
+
+// lintLinks returns a problem for each "homepage" or "repository" in the
+// manifests at paths, split into links that are not found and links that
+// could not be reached.
+//
+// Requests are issued no more often than once per interval, and each URL is
+// only checked once.
+func lintLinks(client *http.Client, interval time.Duration, paths []string) (dead, unreachable []string, err error) {
+	var next time.Time
+	checked := map[string]linkCheck{}
+	for _, path := range paths {
+		mani, err := manifest.LoadManifestFile(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, path)
+		}
+		links := []struct{ attr, url string }{
+			{"homepage", mani.Manifest.Homepage},
+			{"repository", mani.Manifest.Repository},
+		}
+		for _, link := range links {
+			if link.url == "" {
+				continue
+			}
+			check, ok := checked[link.url]
+			if !ok {
+				time.Sleep(time.Until(next))
+				check.dead, check.err = checkLink(client, link.url)
+				next = time.Now().Add(interval)
+				checked[link.url] = check
+			}
+			if check.err == nil {
+				continue
+			}
+			problem := fmt.Sprintf("%s: %s %s: %s", path, link.attr, link.url, check.err)
+			if check.dead {
+				dead = append(dead, problem)
+			} else {
+				unreachable = append(unreachable, problem)
+			}
+		}
+	}
+	return dead, unreachable, nil
+}
+
+// checkLink returns an error if url does not exist or cannot be reached, and
+// whether the link is definitely dead rather than unreachable.
+//
+// Only "not found" responses are dead, as sites commonly refuse requests
+// from non-browsers with other client errors.
+func checkLink(client *http.Client, url string) (dead bool, err error) {
+	resp, err := client.Head(url)
+	if err != nil {
+		return false, errors.Errorf("unreachable: %s", err)
+	}
+	_ = resp.Body.Close()
+	// Not every server supports HEAD.
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		resp, err = client.Get(url)
+		if err != nil {
+			return false, errors.Errorf("unreachable: %s", err)
+		}
+		_ = resp.Body.Close()
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return true, errors.Errorf("%s", resp.Status)
+	}
+	return false, nil
+}
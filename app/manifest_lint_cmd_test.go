@@ -0,0 +1,62 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestLintLinks(t *testing.T) {
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method+" "+r.URL.Path]++
+		switch r.URL.Path {
+		case "/home":
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	dir := t.TempDir()
+	write := func(name, homepage, repository string) string {
+		path := filepath.Join(dir, name+".hcl")
+		content := `description = "` + name + `"
+homepage = "` + homepage + `"
+repository = "` + repository + `"
+`
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	alpha := write("alpha", server.URL+"/home", server.URL+"/missing")
+	beta := write("beta", server.URL+"/no-head", server.URL+"/forbidden")
+	gamma := write("gamma", server.URL+"/home", "")
+	delta := write("delta", closed.URL+"/home", "")
+
+	dead, unreachable, err := lintLinks(server.Client(), time.Millisecond, []string{alpha, beta, gamma, delta})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{alpha + ": repository " + server.URL + "/missing: 404 Not Found"}, dead)
+	assert.Equal(t, 1, len(unreachable))
+	assert.True(t, strings.HasPrefix(unreachable[0], delta+": homepage "+closed.URL+"/home: unreachable: "), unreachable[0])
+	assert.Equal(t, map[string]int{
+		"HEAD /home":      1,
+		"HEAD /missing":   1,
+		"HEAD /no-head":   1,
+		"GET /no-head":    1,
+		"HEAD /forbidden": 1,
+	}, requests)
+}
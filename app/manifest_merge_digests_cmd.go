@@ -0,0 +1,31 @@
+package app
+
+import (
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest/digest"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestMergeDigestsCmd struct {
+	Manifest string   `arg:"" type:"existingfile" help:"Manifest to merge digests into." predictor:"hclfile"`
+	From     []string `arg:"" type:"existingfile" help:"Other versions of the manifest, or files containing only a sha256sums attribute, to merge digests from." predictor:"hclfile"`
+}
+
+func (*manifestMergeDigestsCmd) Help() string {
+	return `
+	Merge the "sha256sums" of other copies of a manifest into it, such as those
+	produced by running "hermit manifest add-digests" on different platforms.
+	Digests are deduplicated by source URL, preferring non-empty digests. If a
+	source has different digests the conflicts are reported and the manifest is
+	not modified.
+	`
+}
+
+func (m *manifestMergeDigestsCmd) Run(l *ui.UI) error {
+	added, err := digest.MergeDigests(m.Manifest, m.From...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.Infof("Merged %d digests into %s", added, m.Manifest)
+	return nil
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/manifest/digest"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestNewCmd struct {
+	Source     string   `required:"" help:"URL of the package source, which may contain $${version}, $${os}, $${arch} or $${xarch}."`
+	Binary     []string `required:"" help:"Path to a binary relative to the package root, may be a glob."`
+	PkgVersion string   `help:"Version of the package, inferred from the source URL if not provided."`
+	Dir        string   `type:"existingdir" default:"." help:"Directory to write the manifest to."`
+	Name       string   `arg:"" help:"Name of the package."`
+}
+
+func (*manifestNewCmd) Help() string {
+	return `
+	This command writes a new manifest "<name>.hcl" with placeholder description and homepage, and a single version
+	block. The version, OS and CPU architecture in the source URL are replaced with ${version}, ${os} and ${arch} or
+	${xarch}, so that later versions and other platforms can reuse it.
+
+	Unlike "create", which infers a manifest for every platform from a GitHub release artefact and prints it, this
+	works with any source URL, sets the binaries, and writes the manifest to a file.
+
+	If the source is reachable, digests are then added as with "add-digests". Review the manifest before committing it.
+	`
+}
+
+func (m *manifestNewCmd) Run(l *ui.UI, hclient *http.Client, state *state.State) error {
+	path := filepath.Join(m.Dir, m.Name+".hcl")
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("%s already exists", path)
+	}
+	mani, err := scaffoldManifest(m.Name, m.Source, m.PkgVersion, m.Binary)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ast, err := hcl.MarshalToAST(mani)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// Lead with the placeholders, as hand-written manifests do.
+	sort.SliceStable(ast.Entries, func(i, j int) bool {
+		return isPlaceholderEntry(ast.Entries[i]) && !isPlaceholderEntry(ast.Entries[j])
+	})
+	data, err := hcl.MarshalAST(ast)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	// Check the manifest loads before adding digests to it.
+	if _, err := manifest.LoadManifestFile(os.DirFS(m.Dir), filepath.Base(path)); err != nil {
+		return errors.Wrapf(err, "%s is invalid", path)
+	}
+	if err := digest.UpdateDigests(l, hclient, state, path, true, digest.FormatHex, digest.DefaultConcurrency); err != nil {
+		l.Warnf("Could not add digests to %s, run \"hermit manifest add-digests %s\" once the source is reachable: %s", path, path, err)
+	}
+	l.Infof("Wrote %s", path)
+	return nil
+}
+
+func isPlaceholderEntry(entry *hcl.Entry) bool {
+	return entry.Attribute != nil && (entry.Attribute.Key == "description" || entry.Attribute.Key == "homepage")
+}
+
+// scaffoldManifest returns a manifest for a single version of a package.
+//
+// If version is empty, it is inferred from source.
+func scaffoldManifest(name, source, version string, binaries []string) (*manifest.Manifest, error) {
+	if version == "" && strings.Contains(source, "${version}") {
+		return nil, errors.Errorf("--pkg-version is required when the source contains ${version}")
+	}
+	source, version, err := manifest.InsertVariables(source, version)
+	if err != nil {
+		return nil, errors.Wrap(err, "use --pkg-version to set it explicitly")
+	}
+	return &manifest.Manifest{
+		Description: "TODO: describe " + name,
+		Homepage:    "TODO: https://",
+		Layer: manifest.Layer{
+			Binaries: binaries,
+			Source:   source,
+		},
+		Versions: []manifest.VersionBlock{{Version: []string{version}}},
+	}, nil
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/hermittest"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestManifestNew(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/archive.tar.gz") {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "../archive/testdata/archive.tar.gz")
+	}))
+	defer f.Clean()
+
+	dir := t.TempDir()
+	l, _ := ui.NewForTesting()
+	cmd := &manifestNewCmd{
+		Name:       "tool",
+		Source:     f.Server.URL + "/1.2.3/archive.tar.gz",
+		Binary:     []string{"darwin_exe"},
+		PkgVersion: "1.2.3",
+		Dir:        dir,
+	}
+	assert.NoError(t, cmd.Run(l, f.Server.Client(), f.State))
+
+	mani, err := manifest.LoadManifestFile(os.DirFS(dir), "tool.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, f.Server.URL+"/${version}/archive.tar.gz", mani.Source)
+	assert.Equal(t, []string{"darwin_exe"}, mani.Binaries)
+	assert.Equal(t, []string{"1.2.3"}, mani.Versions[0].Version)
+	assert.Equal(t, 1, len(mani.SHA256Sums))
+
+	// Existing manifests are not overwritten.
+	assert.Error(t, cmd.Run(l, f.Server.Client(), f.State))
+
+	// Unreachable sources still produce a manifest, without digests.
+	cmd = &manifestNewCmd{
+		Name:   "missing",
+		Source: f.Server.URL + "/releases/download/v${version}/missing-linux.tar.gz",
+		Binary: []string{"missing"},
+		Dir:    dir,
+	}
+	assert.EqualError(t, cmd.Run(l, f.Server.Client(), f.State), "--pkg-version is required when the source contains ${version}")
+	cmd.Source = strings.ReplaceAll(cmd.Source, "${version}", "2.0.0")
+	assert.NoError(t, cmd.Run(l, f.Server.Client(), f.State))
+	mani, err = manifest.LoadManifestFile(os.DirFS(dir), "missing.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, f.Server.URL+"/releases/download/v${version}/missing-${os}.tar.gz", mani.Source)
+	assert.Equal(t, 0, len(mani.SHA256Sums))
+	_, err = os.Stat(filepath.Join(dir, "missing.hcl"))
+	assert.NoError(t, err)
+}
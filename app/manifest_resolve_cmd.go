@@ -0,0 +1,53 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestResolveCmd struct {
+	Explain bool                  `help:"Show which manifest layers contributed to each resolved field."`
+	Package manifest.GlobSelector `arg:"" help:"Package reference to resolve." predictor:"package"`
+}
+
+func (m *manifestResolveCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State) error {
+	var (
+		pkg        *manifest.Package
+		provenance []manifest.Provenance
+		err        error
+	)
+	if env != nil {
+		pkg, provenance, err = env.Explain(l, m.Package)
+	} else {
+		pkg, provenance, err = sta.Explain(l, m.Package)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Printf("%s %s\n", pkg.Reference, pkg.Source)
+	if !m.Explain {
+		return nil
+	}
+	width := 0
+	for _, p := range provenance {
+		width = max(width, len(p.Field))
+	}
+	for _, p := range provenance {
+		layers := p.Layers
+		how := "from"
+		if !p.Merged && len(layers) > 1 {
+			how = "overridden by"
+			layers = layers[len(layers)-1:]
+		} else if p.Merged && len(layers) > 1 {
+			how = "merged from"
+		}
+		fmt.Printf("  %-*s  %s %s\n", width, p.Field, how, strings.Join(layers, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest/canonical"
+	"github.com/cashapp/hermit/ui"
+)
+
+type manifestSortCmd struct {
+	Manifests []string `arg:"" type:"existingfile" help:"Manifests to sort." predictor:"hclfile"`
+}
+
+func (*manifestSortCmd) Help() string {
+	return `
+	Reorders manifests so that top-level attributes are in schema order, followed by platform blocks and triggers,
+	version blocks from the highest version down, and channel blocks by name. Comments move with the entries they are
+	attached to. Manifests that are already in order are not rewritten.
+	`
+}
+
+func (m *manifestSortCmd) Run(l *ui.UI) error {
+	for _, path := range m.Manifests {
+		changed, err := canonical.Sort(path)
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		if changed {
+			l.Infof("Sorted %s", path)
+		}
+	}
+	return nil
+}
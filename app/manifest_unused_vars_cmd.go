@@ -0,0 +1,53 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/platform"
+)
+
+type manifestUnusedVarsCmd struct {
+	Platforms []platform.Platform `name:"platform" placeholder:"OS-ARCH" help:"Platforms to resolve manifests on. Defaults to all core and optional platforms."`
+	Manifest  []string            `arg:"" type:"existingfile" help:"Manifests to check." predictor:"hclfile"`
+}
+
+func (*manifestUnusedVarsCmd) Help() string {
+	return `
+	Resolve every version and channel of each manifest, and report "vars" entries
+	that are never expanded and "files" entries that are never included in a
+	package, eg. because they are always overridden or only defined for platforms
+	that are never selected.
+	`
+}
+
+func (m *manifestUnusedVarsCmd) Run() error {
+	platforms := m.Platforms
+	if len(platforms) == 0 {
+		platforms = append(append(platforms, platform.Core...), platform.Optional...)
+	}
+	failed := 0
+	for _, path := range m.Manifest {
+		mani, err := manifest.LoadManifestFile(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		unused, err := manifest.FindUnused(mani, platforms)
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		for _, entry := range unused {
+			fmt.Fprintf(os.Stderr, "%s: %s is never used\n", path, entry)
+		}
+		if len(unused) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d manifests have unused entries", failed, len(m.Manifest))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+)
+
+type manifestValidateSchemaCmd struct {
+	Manifest []string `arg:"" type:"existingfile" help:"Manifests to validate." predictor:"hclfile"`
+}
+
+func (*manifestValidateSchemaCmd) Help() string {
+	return `
+	Check manifests for unknown attributes and blocks, invalid trigger events and
+	invalid durations, without resolving them against a platform. Every error is
+	reported with its position.
+	`
+}
+
+func (m *manifestValidateSchemaCmd) Run() error {
+	failed := 0
+	for _, path := range m.Manifest {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		errs := manifest.ValidateSchema(data)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", path, err)
+		}
+		if len(errs) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d manifests are invalid", failed, len(m.Manifest))
+	}
+	return nil
+}
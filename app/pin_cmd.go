@@ -0,0 +1,48 @@
+package app
+
+import (
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+type pinCmd struct {
+	Packages []string `arg:"" help:"Channel packages to pin, eg. go or go@stable." predictor:"installed-package"`
+}
+
+func (p *pinCmd) Help() string {
+	return `
+Pin installed channel packages by replacing each with the version the channel currently resolves to, which is not
+upgraded by "hermit upgrade" until unpinned with "hermit unpin". The pins are recorded in bin/hermit.hcl, so only
+channels with a version glob, such as go@stable, can be pinned.
+`
+}
+
+func (p *pinCmd) Run(l *ui.UI, env *hermit.Env) error {
+	for _, pkg := range p.Packages {
+		if _, err := env.Pin(l, manifest.ParseReference(pkg)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+type unpinCmd struct {
+	Packages []string `arg:"" help:"Channel packages to unpin." predictor:"installed-package"`
+}
+
+func (u *unpinCmd) Help() string {
+	return `
+Unpin channel packages pinned with "hermit pin", replacing the pinned version with the channel again.
+`
+}
+
+func (u *unpinCmd) Run(l *ui.UI, env *hermit.Env) error {
+	for _, pkg := range u.Packages {
+		if _, err := env.Unpin(l, manifest.ParseReference(pkg)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
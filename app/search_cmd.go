@@ -16,6 +16,7 @@ type searchCmd struct {
 	Pattern string `arg:"" help:"Either a search term or regex to match a package name." optional:""`
 	Exact   bool   `short:"e" long:"exact" help:"Exact name matches only. Not compatible with regex patterns."`
 	JSONFormattable
+	Detailed bool `help:"Format information as a JSON array of each package's name, description, homepage, repository, versions and channels, in a stable schema."`
 }
 
 // searchResult resolved from a manifest.
@@ -70,6 +71,14 @@ func buildSearchJSONResults(byName map[string][]*manifest.Package, names []strin
 	return packages
 }
 
+func buildDetailedSearchJSONResults(byName map[string][]*manifest.Package, names []string) interface{} {
+	pkgs := manifest.Packages{}
+	for _, name := range names {
+		pkgs = append(pkgs, byName[name]...)
+	}
+	return pkgs.SearchResults()
+}
+
 func (s *searchCmd) Run(l *ui.UI, env *hermit.Env, state *state.State) error {
 	var (
 		pkgs manifest.Packages
@@ -111,11 +120,15 @@ func (s *searchCmd) Run(l *ui.UI, env *hermit.Env, state *state.State) error {
 		return nil
 	}
 
+	transform := buildSearchJSONResults
+	if s.Detailed {
+		transform = buildDetailedSearchJSONResults
+	}
 	err = listPackages(pkgs, &listPackageOption{
 		AllVersions:   true,
-		TransformJSON: buildSearchJSONResults,
+		TransformJSON: transform,
 		UI:            l,
-		JSON:          s.JSON,
+		JSON:          s.JSON || s.Detailed,
 		Prefix:        s.Pattern,
 	})
 	if err != nil {
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type selfUpdateCmd struct {
+	Channel  string `help:"Hermit channel to update (eg. stable, canary). Defaults to the channel of the running Hermit."`
+	Rollback bool   `help:"Restore the version of Hermit that was replaced by the last self-update."`
+}
+
+func (s *selfUpdateCmd) Help() string {
+	return `
+Update Hermit itself to the latest version in its channel. The new version is verified before being used, and the
+previous version is restored if verification fails. The previous version is kept, and can be restored with --rollback.
+`
+}
+
+func (s *selfUpdateCmd) Run(l *ui.UI, state *state.State) error {
+	channel := s.Channel
+	if channel == "" {
+		self, err := os.Executable()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		current := filepath.Base(filepath.Dir(self))
+		if !strings.HasPrefix(current, "hermit@") {
+			return errors.Errorf("Hermit is not running from a channel package, use --channel to select one")
+		}
+		channel = strings.TrimPrefix(current, "hermit@")
+	}
+	ref := manifest.Reference{Name: "hermit", Channel: channel}
+	task := l.Task(ref.String())
+
+	if !s.Rollback {
+		srcs, err := state.Sources(l)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := srcs.Sync(l, true); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	pkg, err := state.Resolve(l, manifest.ExactSelector(ref))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if s.Rollback {
+		if err := state.RollbackSelf(task, pkg); err != nil {
+			return errors.WithStack(err)
+		}
+		task.Infof("Restored previous version of %s", ref)
+		return nil
+	}
+	upgraded, err := state.UpgradeSelf(task, pkg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if upgraded {
+		task.Infof("Updated %s, use --rollback to restore the previous version", ref)
+	} else {
+		task.Infof("%s is up to date", ref)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"path/filepath"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type stateCmd struct {
+	Migrate stateMigrateCmd `cmd:"" help:"Move the Hermit state directory to a new location." group:"global"`
+}
+
+type stateMigrateCmd struct {
+	Dir string `arg:"" type:"path" help:"New state directory, which must not exist or be empty."`
+}
+
+func (s *stateMigrateCmd) Help() string {
+	return `
+Move downloaded and extracted packages, manifest sources, linked binaries and metadata from the current state
+directory to a new one, rewriting absolute symlinks that point into the old state directory. The download cache is
+only moved if it is inside the state directory.
+
+Once migrated, set HERMIT_STATE_DIR to the new directory.
+`
+}
+
+func (s *stateMigrateCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State) error {
+	oldRoot, err := filepath.Abs(sta.Root())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := sta.Migrate(l, s.Dir); err != nil {
+		return errors.Wrapf(err, "failed to migrate state to %s", s.Dir)
+	}
+	if env != nil {
+		// Links in the environment are usually relative, but may point
+		// directly into the state if they were created by hand.
+		if _, err := state.RewriteSymlinks(env.BinDir(), oldRoot, s.Dir); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	l.Infof("Migrated state from %s to %s, set HERMIT_STATE_DIR=%s to use it", oldRoot, s.Dir, s.Dir)
+	return nil
+}
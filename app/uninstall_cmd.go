@@ -13,10 +13,15 @@ import (
 )
 
 type uninstallCmd struct {
-	Packages []manifest.GlobSelector `arg:"" help:"Packages to uninstall from this environment." predictor:"installed-package"`
+	Force        bool                    `help:"Change packages even if the environment is locked with 'hermit env lock'."`
+	PrintChanges string                  `enum:"none,json" default:"none" help:"Print the environment variable changes made by the uninstall to stdout in the given format (${enum}), for scripts that apply them to their own environment."`
+	Packages     []manifest.GlobSelector `arg:"" help:"Packages to uninstall from this environment." predictor:"installed-package"`
 }
 
 func (u *uninstallCmd) Run(l *ui.UI, env *hermit.Env) error {
+	if u.Force {
+		env.OverrideLock()
+	}
 	installed, err := env.ListInstalled(l)
 	if err != nil {
 		return errors.WithStack(err)
@@ -33,6 +38,9 @@ next:
 					return errors.WithStack(err)
 				}
 				changes = changes.Merge(c)
+				if err := env.UnsavePackage(pkg.Reference.Name); err != nil {
+					return errors.WithStack(err)
+				}
 				messages, err := env.TriggerForPackage(l, manifest.EventUninstall, pkg)
 				if err != nil {
 					return errors.WithStack(err)
@@ -45,6 +53,8 @@ next:
 		}
 		return errors.Errorf("package %s is not installed", selector)
 	}
-
+	if u.PrintChanges == "json" {
+		return errors.WithStack(printChanges(os.Stdout, changes))
+	}
 	return nil
 }
@@ -13,10 +13,21 @@ import (
 )
 
 type upgradeCmd struct {
+	Force    bool     `help:"Change packages even if the environment is locked with 'hermit env lock'."`
+	All      bool     `help:"Upgrade all installed packages, continuing past failures and printing a summary."`
 	Packages []string `arg:"" optional:"" name:"package" help:"Packages to upgrade. If omitted, upgrades all installed packages."  predictor:"installed-package"`
 }
 
 func (g *upgradeCmd) Run(l *ui.UI, env *hermit.Env) error {
+	if g.Force {
+		env.OverrideLock()
+	}
+	if g.All {
+		if len(g.Packages) > 0 {
+			return errors.Errorf("--all can not be used with explicit packages")
+		}
+		return g.upgradeAll(l, env)
+	}
 	err := env.Update(l, true)
 	if err != nil {
 		return errors.WithStack(err)
@@ -65,3 +76,56 @@ func (g *upgradeCmd) Run(l *ui.UI, env *hermit.Env) error {
 
 	return nil
 }
+
+// upgradeAll upgrades every installed package, then prints a summary of the
+// outcome for each.
+func (g *upgradeCmd) upgradeAll(l *ui.UI, env *hermit.Env) error {
+	if err := env.Sync(l, true); err != nil {
+		return errors.WithStack(err)
+	}
+	installed, err := env.ListInstalled(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	results := env.UpgradeAll(l, installed)
+	w := l.WriterAt(ui.LevelInfo)
+	failed := 0
+	for i, result := range results {
+		if result.Upgraded == nil {
+			continue
+		}
+		messages, err := env.TriggerForPackage(l, manifest.EventInstall, result.Upgraded)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		for _, message := range messages {
+			fmt.Fprintln(w, message)
+		}
+		result.Upgraded.LogWarnings(l)
+	}
+	_ = w.Sync()
+
+	width := 0
+	for _, result := range results {
+		width = max(width, len(result.Package.Reference.String()))
+	}
+	for _, result := range results {
+		name := result.Package.Reference.String()
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Printf("%-*s  failed     %s\n", width, name, result.Err)
+		case result.Upgraded == nil:
+			fmt.Printf("%-*s  unchanged\n", width, name)
+		case result.Upgraded.Reference.IsChannel():
+			fmt.Printf("%-*s  upgraded   new ETag %s\n", width, name, result.Upgraded.ETag)
+		default:
+			fmt.Printf("%-*s  upgraded   to %s\n", width, name, result.Upgraded.Reference)
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d packages failed to upgrade", failed, len(results))
+	}
+	return nil
+}
@@ -25,10 +25,14 @@ var userConfigSchema = func() string {
 
 // UserConfig is stored in ~/.hermit.hcl
 type UserConfig struct {
-	Prompt      string `hcl:"prompt,optional" default:"env" enum:"env,short,none" help:"Modify prompt to include hermit environment (env), just an icon (short) or nothing (none)"`
-	ShortPrompt bool   `hcl:"short-prompt,optional" help:"If true use a short prompt when an environment is activated."`
-	NoGit       bool   `hcl:"no-git,optional" help:"If true Hermit will never add/remove files from Git automatically."`
-	Idea        bool   `hcl:"idea,optional" help:"If true Hermit will try to add the IntelliJ IDEA plugin automatically."`
+	Prompt      string   `hcl:"prompt,optional" default:"env" enum:"env,short,none" help:"Modify prompt to include hermit environment (env), just an icon (short) or nothing (none)"`
+	ShortPrompt bool     `hcl:"short-prompt,optional" help:"If true use a short prompt when an environment is activated."`
+	NoGit       bool     `hcl:"no-git,optional" help:"If true Hermit will never add/remove files from Git automatically."`
+	Idea        bool     `hcl:"idea,optional" help:"If true Hermit will try to add the IntelliJ IDEA plugin automatically."`
+	CABundle    string   `hcl:"ca-bundle,optional" help:"Path to a PEM encoded CA bundle to trust, in addition to the system roots, for downloads."`
+	CacheDir    string   `hcl:"cache-dir,optional" help:"Directory for downloaded package archives, if not within the Hermit state directory."`
+	AuditLog    string   `hcl:"audit-log,optional" help:"File to append a JSON record to for every package binary executed through Hermit."`
+	AuditRedact []string `hcl:"audit-redact,optional" help:"Regular expressions matching arguments to replace with [REDACTED] in the audit log, eg. \"^--password=\"."`
 }
 
 // LoadUserConfig from disk.
@@ -14,7 +14,7 @@ type validateEnvCmd struct {
 	Env string `arg:"" type:"existingdir" help:"Path to environment root."`
 }
 
-func (v *validateEnvCmd) Run(l *ui.UI, state *state.State, cache *cache.Cache, config Config, httpClient *http.Client) error {
+func (v *validateEnvCmd) Run(l *ui.UI, cli cliInterface, state *state.State, cache *cache.Cache, config Config, httpClient *http.Client) error {
 	envInfo, err := hermit.LoadEnvInfo(v.Env)
 	if err != nil {
 		return errors.WithStack(err)
@@ -23,5 +23,16 @@ func (v *validateEnvCmd) Run(l *ui.UI, state *state.State, cache *cache.Cache, c
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return errors.WithStack(env.Verify())
+	return errors.WithStack(allowUnverifiedScripts(l, cli, env.Verify()))
+}
+
+// allowUnverifiedScripts downgrades an error from verifying an environment's
+// scripts to a warning, if they have unknown SHA256 sums and verification has
+// been disabled with --no-verify-scripts.
+func allowUnverifiedScripts(l *ui.UI, cli cliInterface, err error) error {
+	if err == nil || !cli.getNoVerifyScripts() || !errors.Is(err, hermit.ErrUnknownScript) {
+		return err
+	}
+	l.Warnf("!!! SCRIPT VERIFICATION IS DISABLED, continuing with untrusted scripts: %s", err)
+	return nil
 }
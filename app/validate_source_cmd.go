@@ -1,6 +1,7 @@
 package app
 
 import (
+	"net/http"
 	"runtime"
 	"strconv"
 
@@ -17,7 +18,7 @@ type validateSourceCmd struct {
 	Source string `arg:"" optional:"" name:"source" help:"The manifest source to validate."`
 }
 
-func (g *validateSourceCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State) error {
+func (g *validateSourceCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State, client *http.Client) error {
 	var (
 		srcs    *sources.Sources
 		err     error
@@ -29,7 +30,7 @@ func (g *validateSourceCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State) err
 			return errors.WithStack(err)
 		}
 	} else {
-		srcs, err = sources.ForURIs(l, sta.SourcesDir(), "", []string{g.Source})
+		srcs, err = sources.ForURIs(l, sta.SourcesDir(), "", []string{g.Source}, client)
 		if err != nil {
 			return errors.WithStack(err)
 		}
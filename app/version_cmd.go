@@ -1,14 +1,67 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
 
 	"github.com/alecthomas/kong"
+
+	"github.com/cashapp/hermit/errors"
 )
 
-type versionCmd struct{}
+type versionCmd struct {
+	JSON bool `help:"Print the version and build metadata as JSON."`
+}
+
+// versionInfo is the build metadata printed by "hermit version --json".
+type versionInfo struct {
+	Version     string `json:"version"`
+	Channel     string `json:"channel,omitempty"`
+	Commit      string `json:"commit,omitempty"`
+	BuildDate   string `json:"build_date,omitempty"`
+	Modified    bool   `json:"modified,omitempty"`
+	GoVersion   string `json:"go_version"`
+	BaseDistURL string `json:"base_dist_url"`
+}
+
+func (v *versionCmd) Run(kctx kong.Vars, config Config) error {
+	if !v.JSON {
+		fmt.Println(kctx["version"])
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return errors.WithStack(enc.Encode(buildVersionInfo(config)))
+}
 
-func (v *versionCmd) Run(kctx kong.Vars) error {
-	fmt.Println(kctx["version"])
-	return nil
+// buildVersionInfo returns the version and build date of Hermit from config,
+// along with the VCS metadata embedded in the binary by the Go toolchain.
+func buildVersionInfo(config Config) versionInfo {
+	info := versionInfo{
+		Version:     config.Version,
+		Channel:     config.Channel,
+		BuildDate:   config.BuildDate,
+		GoVersion:   runtime.Version(),
+		BaseDistURL: config.BaseDistURL,
+	}
+	if config.Channel != "" {
+		info.Version = strings.TrimSuffix(info.Version, " ("+config.Channel+")")
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
 }
@@ -0,0 +1,22 @@
+package app
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestBuildVersionInfo(t *testing.T) {
+	info := buildVersionInfo(Config{
+		Version:     "1.2.3 (stable)",
+		Channel:     "stable",
+		BuildDate:   "2024-01-02T03:04:05Z",
+		BaseDistURL: "https://example.com/stable",
+	})
+	assert.Equal(t, "1.2.3", info.Version)
+	assert.Equal(t, "stable", info.Channel)
+	assert.Equal(t, "2024-01-02T03:04:05Z", info.BuildDate)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Equal(t, "https://example.com/stable", info.BaseDistURL)
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+)
+
+type whichProvidesCmd struct {
+	Suggest bool          `help:"Print a suggestion to install a providing package to stderr, succeeding even if there is none. Used by shell command-not-found hooks." hidden:""`
+	Timeout time.Duration `help:"With --suggest, give up without a suggestion if the packages have not been searched within this time." default:"1s" hidden:""`
+	Binary  string        `arg:"" help:"Name of the command to find packages for."`
+}
+
+func (w *whichProvidesCmd) Help() string {
+	return `
+List the packages providing a command, either as one of their binaries or by declaring it in "provides".
+
+Only the manifest sources already synced are searched, so run "hermit update" first for up to date results.
+`
+}
+
+func (w *whichProvidesCmd) Run(l *ui.UI, env *hermit.Env, sta *state.State) error {
+	if w.Suggest {
+		// This runs on every mistyped command, so never keep the shell waiting.
+		type result struct {
+			pkgs manifest.Packages
+			err  error
+		}
+		found := make(chan result, 1)
+		go func() {
+			pkgs, err := w.find(l, env, sta)
+			found <- result{pkgs, err}
+		}()
+		select {
+		case r := <-found:
+			if r.err == nil {
+				fmt.Fprintln(os.Stderr, installSuggestion(w.Binary, r.pkgs))
+			}
+		case <-time.After(w.Timeout):
+		}
+		return nil
+	}
+	pkgs, err := w.find(l, env, sta)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, pkg := range pkgs {
+		fmt.Printf("%s: %s\n", pkg.Reference.Name, pkg.Description)
+	}
+	return nil
+}
+
+func (w *whichProvidesCmd) find(l *ui.UI, env *hermit.Env, sta *state.State) (manifest.Packages, error) {
+	if env != nil {
+		return env.WhichProvides(l, w.Binary)
+	}
+	return sta.WhichProvides(l, w.Binary)
+}
+
+func installSuggestion(binary string, pkgs manifest.Packages) string {
+	if len(pkgs) == 1 {
+		return fmt.Sprintf("%s is provided by the Hermit package %s, run \"hermit install %s\" to install it", binary, pkgs[0].Reference.Name, pkgs[0].Reference.Name)
+	}
+	names := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Reference.Name)
+	}
+	return fmt.Sprintf("%s is provided by the Hermit packages %s, run \"hermit install <package>\" to install one", binary, strings.Join(names, ", "))
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cashapp/hermit"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+type whyCmd struct {
+	Package string `arg:"" help:"Package to explain, eg. libfoo or libfoo-1.2.3." predictor:"installed-package"`
+}
+
+func (w *whyCmd) Help() string {
+	return `
+Explain why a package is in the environment: because it was installed directly, saved to the
+environment with "hermit install --save", or is required by, or a runtime dependency of, other
+packages. Every chain of packages that pulls it in is listed.
+`
+}
+
+func (w *whyCmd) Run(l *ui.UI, env *hermit.Env) error {
+	explanation, err := env.ExplainInstall(l, manifest.ParseReference(w.Package))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	pkg := explanation.Package
+	switch {
+	case explanation.Saved:
+		fmt.Printf("%s was installed directly and saved to the environment\n", pkg)
+	case explanation.Direct():
+		fmt.Printf("%s was installed directly\n", pkg)
+	}
+	for _, chain := range explanation.Chains {
+		parts := make([]string, 0, len(chain)+1)
+		for _, dep := range chain {
+			parts = append(parts, dep.String())
+		}
+		parts = append(parts, pkg.String())
+		fmt.Printf("%s is a dependency of %s: %s\n", pkg, chain[len(chain)-1], strings.Join(parts, " -> "))
+	}
+	return nil
+}
@@ -16,9 +16,11 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/andybalholm/brotli"
 	bufra "github.com/avvmoto/buf-readerat"
 	"github.com/blakesmith/ar"
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/gobwas/glob"
 	"github.com/klauspost/compress/zstd"
 	"github.com/saracen/go7z"
 	"github.com/sassoftware/go-rpmutils"
@@ -28,7 +30,6 @@ import (
 	"github.com/otiai10/copy"
 
 	"github.com/cashapp/hermit/errors"
-	"github.com/cashapp/hermit/internal/system"
 	"github.com/cashapp/hermit/manifest"
 	"github.com/cashapp/hermit/ui"
 	"github.com/cashapp/hermit/util"
@@ -64,6 +65,10 @@ func Extract(b *ui.Task, source string, pkg *manifest.Package) (finalise func()
 		return finalise, installFromDirectory(source, pkg)
 	}
 
+	if pkg.Inner != "" {
+		return extractInner(task, source, pkg)
+	}
+
 	ext := filepath.Ext(source)
 	switch ext {
 	case ".pkg":
@@ -130,36 +135,176 @@ func Extract(b *ui.Task, source string, pkg *manifest.Package) (finalise func()
 	r = io.NopCloser(io.TeeReader(r, task.ProgressWriter()))
 
 	if pkg.DontExtract {
-		return finalise, copyDirect(r, tmpDest, path.Base(pkg.Source))
+		return finalise, copyDirect(r, tmpDest, sourceFilename(pkg))
+	}
+
+	filter, err := newPathFilter(pkg)
+	if err != nil {
+		return finalise, err
 	}
 
 	// Archive is a single executable.
 	switch mime.String() {
-	case "application/zip":
-		return finalise, extractZip(task, f, info, tmpDest, pkg.Strip)
-
-	case "application/x-7z-compressed":
-		return finalise, extract7Zip(f, info.Size(), tmpDest, pkg.Strip)
+	case "application/zip", "application/x-7z-compressed", "application/x-tar", "application/x-rpm":
+		if err := extractEntries(task, f, r, info, mime, tmpDest, filter); err != nil {
+			return finalise, err
+		}
+		if err := restoreSymlinkTargets(task, source, tmpDest, filter); err != nil {
+			return finalise, err
+		}
+		return finalise, filter.checkSymlinks(tmpDest)
 
 	case "application/x-mach-binary", "application/x-elf",
 		"application/x-executable", "application/x-sharedlib",
 		"text/x-shellscript":
-		return finalise, extractExecutable(r, tmpDest, path.Base(pkg.Source))
-
-	case "application/x-tar":
-		return finalise, extractPackageTarball(task, r, tmpDest, pkg.Strip)
+		return finalise, extractExecutable(r, tmpDest, sourceFilename(pkg))
 
 	case "application/vnd.debian.binary-package":
+		// The data member is extracted directly to the package destination,
+		// so the temporary directory only holds the intermediate member.
 		renameResult = false
+		defer os.RemoveAll(tmpDest)
 		return finalise, extractDebianPackage(task, r, tmpDest, pkg)
 
+	default:
+		return finalise, errors.Errorf("don't know how to extract archive %s of type %s", source, mime)
+	}
+
+}
+
+// extractInner extracts the source archive to a temporary directory, then
+// extracts the archive at pkg.Inner within it to the package destination.
+//
+// "strip" and "exclude" apply only to the inner archive.
+func extractInner(b *ui.Task, source string, pkg *manifest.Package) (finalise func() error, err error) {
+	parentDir := filepath.Dir(pkg.Dest)
+	if err := os.MkdirAll(parentDir, 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tmpDir, err := os.MkdirTemp(parentDir, filepath.Base(pkg.Dest)+"-outer-*")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	outer := *pkg
+	outer.Dest = filepath.Join(tmpDir, "outer")
+	outer.Inner = ""
+	outer.Strip = 0
+	outer.Exclude = nil
+	outer.DontExtract = false
+	outer.Mutable = true
+	b.Debugf("Extracting outer archive %s", source)
+	if _, err := Extract(b, source, &outer); err != nil {
+		return nil, errors.Wrapf(err, "outer archive %s", source)
+	}
+	if err := sanitizeExtractPath(pkg.Inner, outer.Dest); err != nil {
+		return nil, err
+	}
+	innerSource := filepath.Join(outer.Dest, filepath.FromSlash(pkg.Inner))
+	if _, err := os.Stat(innerSource); os.IsNotExist(err) {
+		return nil, errors.Errorf("inner archive %q not found in %s", pkg.Inner, source)
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	inner := *pkg
+	inner.Inner = ""
+	b.Debugf("Extracting inner archive %s", pkg.Inner)
+	return Extract(b, innerSource, &inner)
+}
+
+// extractEntries extracts the entries of a zip, 7z, tar or rpm archive
+// selected by filter.
+func extractEntries(b *ui.Task, f *os.File, r io.Reader, info os.FileInfo, mime *mimetype.MIME, dest string, filter pathFilter) error {
+	switch mime.String() {
+	case "application/zip":
+		return extractZip(b, f, info, dest, filter)
+
+	case "application/x-7z-compressed":
+		return extract7Zip(f, info.Size(), dest, filter)
+
+	case "application/x-tar":
+		return extractPackageTarball(b, r, dest, filter)
+
 	case "application/x-rpm":
-		return finalise, extractRpmPackage(r, tmpDest, pkg)
+		return extractRpmPackage(r, dest, filter)
 
 	default:
-		return finalise, errors.Errorf("don't know how to extract archive %s of type %s", source, mime)
+		return errors.Errorf("don't know how to extract entries from archive of type %s", mime)
+	}
+}
+
+// restoreSymlinkTargets extracts excluded entries that symlinks within dest
+// point to, so that excluding files never breaks links within the package.
+func restoreSymlinkTargets(b *ui.Task, source, dest string, filter pathFilter) error {
+	if len(filter.exclude) == 0 {
+		return nil
+	}
+	restored := map[string]bool{}
+	// Restored entries may themselves be symlinks to excluded entries.
+	for {
+		missing, err := danglingSymlinkTargets(dest, filter)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		only := map[string]bool{}
+		for target := range missing {
+			if !restored[target] {
+				only[target] = true
+				restored[target] = true
+			}
+		}
+		if len(only) == 0 {
+			return nil
+		}
+		b.Debugf("Restoring %d excluded symlink targets", len(only))
+		f, r, mime, err := openArchive(source)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			err = extractEntries(b, f, r, info, mime, dest, pathFilter{strip: filter.strip, symlinks: filter.symlinks, only: only})
+		}
+		_ = f.Close()
+		if err != nil {
+			return errors.WithStack(err)
+		}
 	}
+}
 
+// danglingSymlinkTargets returns the excluded paths, relative to dest, that
+// relative symlinks within dest point to.
+func danglingSymlinkTargets(dest string, filter pathFilter) (map[string]bool, error) {
+	missing := map[string]bool{}
+	err := filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if filepath.IsAbs(link) {
+			return nil
+		}
+		target := filepath.Join(filepath.Dir(path), link)
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dest, target)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if filter.excluded(rel) {
+			missing[rel] = true
+		}
+		return nil
+	})
+	return missing, errors.WithStack(err)
 }
 
 type hdiEntry struct {
@@ -199,10 +344,6 @@ func installMacDMG(b *ui.Task, source string, pkg *manifest.Package) error {
 		return errors.WithStack(err)
 	}
 	defer os.RemoveAll(dest)
-	home, err := system.UserHomeDir()
-	if err != nil {
-		return errors.WithStack(err)
-	}
 	output, err := util.Capture(b, "hdiutil", "attach", "-plist", source)
 	if err != nil {
 		return errors.Wrap(err, "could not mount DMG")
@@ -235,10 +376,6 @@ func installMacDMG(b *ui.Task, source string, pkg *manifest.Package) error {
 			if err != nil {
 				return errors.WithStack(err)
 			}
-			err = os.Symlink(appDest, filepath.Join(home, "Applications", base))
-			if err != nil {
-				return errors.WithStack(err)
-			}
 		}
 		return errors.WithStack(os.Rename(dest, pkg.Dest))
 
@@ -251,7 +388,7 @@ func extractExecutable(r io.Reader, dest, executableName string) error {
 	destExe := filepath.Join(dest, executableName)
 	ext := filepath.Ext(destExe)
 	switch ext {
-	case ".gz", ".bz2", ".xz", ".zst":
+	case ".gz", ".bz2", ".xz", ".zst", ".lz":
 		destExe = strings.TrimSuffix(destExe, ext)
 	}
 
@@ -294,7 +431,15 @@ func openArchive(source string) (f *os.File, r io.Reader, mime *mimetype.MIME, e
 		}
 	}()
 	r = f
-	switch mime.String() {
+	mimeType := mime.String()
+	// Brotli streams have no magic number, so can only be identified by extension.
+	if strings.HasSuffix(source, ".br") {
+		mimeType = "application/x-brotli"
+	}
+	switch mimeType {
+	case "application/x-brotli":
+		r = brotli.NewReader(r)
+
 	case "application/gzip":
 		zr, err := gzip.NewReader(r)
 		if err != nil {
@@ -319,14 +464,25 @@ func openArchive(source string) (f *os.File, r io.Reader, mime *mimetype.MIME, e
 		}
 		r = zr
 
+	case "application/lzip", "application/x-lzip":
+		lr, err := newLzipReader(r)
+		if err != nil {
+			return nil, nil, mime, errors.WithStack(err)
+		}
+		r = lr
+
 	default:
 		// Assume it's uncompressed?
 		return f, r, mime, nil
 	}
 
-	// Now detect the underlying file type.
+	// Now detect the underlying file type. Decompressors may return short
+	// reads, so fill the buffer to ensure there is enough to detect from.
 	buf := make([]byte, 4096)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
 	if err != nil && (!errors.Is(err, io.EOF) || n == 0) {
 		return nil, nil, mime, errors.WithStack(err)
 	}
@@ -353,9 +509,6 @@ const extractMacPkgChangesXML = `
 `
 
 func extractMacPKG(b *ui.Task, path, dest string, strip int) error {
-	if strip != 0 {
-		return errors.Errorf("\"strip = %d\" is not supported for Mac installer .pkg files", strip)
-	}
 	err := os.MkdirAll(dest, 0700)
 	if err != nil {
 		return errors.WithStack(err)
@@ -371,30 +524,123 @@ func extractMacPKG(b *ui.Task, path, dest string, strip int) error {
 	fmt.Fprint(changesf, os.Expand(extractMacPkgChangesXML, func(s string) string { return dest }))
 	_ = changesf.Close()
 	task.Add(1)
-	return util.Run(b, "installer", "-verbose",
+	err = util.Run(b, "installer", "-verbose",
 		"-pkg", path,
 		"-target", "CurrentUserHomeDirectory",
 		"-applyChoiceChangesXML", changesf.Name())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// The installer can't strip, so strip the installed files afterwards.
+	return stripDir(dest, strip)
 }
 
-func extractZip(b *ui.Task, f *os.File, info os.FileInfo, dest string, strip int) error {
-	zr, err := zip.NewReader(bufra.NewBufReaderAt(f, int(info.Size())), info.Size())
+// stripDir removes the leading "strip" path components from the files in dir,
+// as "strip" does when extracting an archive.
+//
+// Files with fewer path components are removed. Files are moved rather than
+// copied, preserving symlinks and modes.
+func stripDir(dir string, strip int) error {
+	if strip == 0 {
+		return nil
+	}
+	unstripped := dir + "~unstripped"
+	if err := os.Rename(dir, unstripped); err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(unstripped)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	moved := 0
+	err := filepath.WalkDir(unstripped, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel, err := filepath.Rel(unstripped, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		if rel == "." || len(parts) <= strip {
+			return nil
+		}
+		moved++
+		if err := moveInto(path, filepath.Join(dir, filepath.Join(parts[strip:]...))); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if moved == 0 {
+		return errors.Errorf("\"strip = %d\" would remove all files", strip)
+	}
+	return nil
+}
+
+// moveInto moves src to dest, merging the contents of directories that
+// already exist at dest.
+func moveInto(src, dest string) error {
+	info, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return errors.WithStack(os.Rename(src, dest))
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	srcInfo, err := os.Lstat(src)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if !info.IsDir() || !srcInfo.IsDir() {
+		return errors.Errorf("%s: already exists after stripping", dest)
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		if err := moveInto(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceFilename returns the name for a package source that is not extracted.
+func sourceFilename(pkg *manifest.Package) string {
+	if pkg.Filename != "" {
+		return pkg.Filename
+	}
+	return path.Base(pkg.Source)
+}
+
+func extractZip(b *ui.Task, f *os.File, info os.FileInfo, dest string, filter pathFilter) error {
+	zr, err := zip.NewReader(bufra.NewBufReaderAt(f, int(info.Size())), info.Size())
+	if err != nil {
+		b.Debugf("Falling back to streaming zip extraction: %s", err)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return errors.WithStack(err)
+		}
+		return extractStreamingZip(b, f, dest, filter)
+	}
 	task := b.SubProgress("unpack", len(zr.File))
 	defer task.Done()
 	for _, zf := range zr.File {
 		b.Tracef("  %s", zf.Name)
 		task.Add(1)
-		destFile, err := makeDestPath(dest, zf.Name, strip)
+		destFile, err := makeDestPath(dest, zf.Name, filter)
 		if err != nil {
 			return err
 		}
 		if destFile == "" {
 			continue
 		}
-		err = extractZipFile(zf, destFile)
+		err = extractZipFile(zf, dest, destFile, filter)
 		if err != nil {
 			return errors.Wrap(err, destFile)
 		}
@@ -402,7 +648,7 @@ func extractZip(b *ui.Task, f *os.File, info os.FileInfo, dest string, strip int
 	return nil
 }
 
-func extractZipFile(zf *zip.File, destFile string) error {
+func extractZipFile(zf *zip.File, dest, destFile string, filter pathFilter) error {
 	zfr, err := zf.Open()
 	if err != nil {
 		return errors.WithStack(err)
@@ -418,7 +664,15 @@ func extractZipFile(zf *zip.File, destFile string) error {
 			return errors.WithStack(err)
 		}
 		dir := filepath.Dir(destFile)
-		symlinkPath := filepath.Join(dir, string(symlink))
+		// Parent directories may not have entries of their own, or may be excluded.
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return errors.WithStack(err)
+		}
+		target, err := filter.symlinkTarget(dest, destFile, string(symlink))
+		if err != nil {
+			return err
+		}
+		symlinkPath := filepath.Join(dir, target)
 		symlinkPath, err = filepath.Rel(dir, symlinkPath)
 		if err != nil {
 			return errors.WithStack(err)
@@ -447,7 +701,7 @@ func extractZipFile(zf *zip.File, destFile string) error {
 	return nil
 }
 
-func extractPackageTarball(b *ui.Task, r io.Reader, dest string, strip int) error {
+func extractPackageTarball(b *ui.Task, r io.Reader, dest string, filter pathFilter) error {
 	tr := tar.NewReader(r)
 	for {
 		hdr, err := tr.Next()
@@ -457,7 +711,7 @@ func extractPackageTarball(b *ui.Task, r io.Reader, dest string, strip int) erro
 			return errors.WithStack(err)
 		}
 		mode := hdr.FileInfo().Mode() &^ 0077
-		destFile, err := makeDestPath(dest, hdr.Name, strip)
+		destFile, err := makeDestPath(dest, hdr.Name, filter)
 		if err != nil {
 			return err
 		}
@@ -477,9 +731,13 @@ func extractPackageTarball(b *ui.Task, r io.Reader, dest string, strip int) erro
 			}
 
 		case mode&os.ModeSymlink != 0:
-			err = syscall.Symlink(hdr.Linkname, destFile)
+			target, err := filter.symlinkTarget(dest, destFile, hdr.Linkname)
 			if err != nil {
-				return errors.Wrapf(err, "%s: failed to create symlink to %s", destFile, hdr.Linkname)
+				return err
+			}
+			err = syscall.Symlink(target, destFile)
+			if err != nil {
+				return errors.Wrapf(err, "%s: failed to create symlink to %s", destFile, target)
 			}
 
 		case hdr.Typeflag&(tar.TypeLink|tar.TypeGNULongLink) != 0 && hdr.Linkname != "":
@@ -489,6 +747,10 @@ func extractPackageTarball(b *ui.Task, r io.Reader, dest string, strip int) erro
 			if err != nil {
 				return errors.WithStack(err)
 			}
+			rp, err = filter.symlinkTarget(dest, destFile, rp)
+			if err != nil {
+				return err
+			}
 			err = os.Symlink(rp, destFile)
 			if err != nil {
 				return errors.WithStack(err)
@@ -521,9 +783,11 @@ func extractDebianPackage(b *ui.Task, r io.Reader, dest string, pkg *manifest.Pa
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		if strings.HasPrefix(header.Name, "data.tar") {
+		// GNU ar terminates member names with a "/".
+		name := strings.TrimSuffix(header.Name, "/")
+		if strings.HasPrefix(name, "data.tar") {
 			r := io.LimitReader(reader, header.Size)
-			filename := filepath.Join(dest, header.Name)
+			filename := filepath.Join(dest, name)
 			w, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 			if err != nil {
 				return errors.WithStack(err)
@@ -539,7 +803,7 @@ func extractDebianPackage(b *ui.Task, r io.Reader, dest string, pkg *manifest.Pa
 	}
 }
 
-func extract7Zip(r io.ReaderAt, size int64, dest string, strip int) error {
+func extract7Zip(r io.ReaderAt, size int64, dest string, filter pathFilter) error {
 	sz, err := go7z.NewReader(r, size)
 	if err != nil {
 		return errors.WithStack(err)
@@ -559,7 +823,7 @@ func extract7Zip(r io.ReaderAt, size int64, dest string, strip int) error {
 		if hdr.IsEmptyStream && !hdr.IsEmptyFile {
 			continue
 		}
-		destFile, err := makeDestPath(dest, hdr.Name, strip)
+		destFile, err := makeDestPath(dest, hdr.Name, filter)
 		if err != nil {
 			return err
 		}
@@ -588,7 +852,7 @@ func extract7Zip(r io.ReaderAt, size int64, dest string, strip int) error {
 	return nil
 }
 
-func extractRpmPackage(r io.Reader, dest string, pkg *manifest.Package) error {
+func extractRpmPackage(r io.Reader, dest string, filter pathFilter) error {
 	rpm, err := rpmutils.ReadRpm(r)
 	if err != nil {
 		return errors.WithStack(err)
@@ -611,7 +875,7 @@ func extractRpmPackage(r io.Reader, dest string, pkg *manifest.Package) error {
 			if err != nil {
 				return errors.WithStack(err)
 			}
-			filename, err := makeDestPath(dest, header.Filename(), pkg.Strip)
+			filename, err := makeDestPath(dest, header.Filename(), filter)
 			if err != nil {
 				return err
 			}
@@ -636,17 +900,179 @@ func ensureDirExists(file string) error {
 	return os.MkdirAll(dir, os.ModePerm) //nolint:gosec
 }
 
-// Strip leading path component.
-func makeDestPath(dest, path string, strip int) (string, error) {
-	if err := sanitizeExtractPath(path, dest); err != nil {
+// pathFilter selects archive entries to extract and maps their paths to
+// paths under the destination.
+type pathFilter struct {
+	strip   int
+	exclude []excludePattern
+	// Policy for symlinks that are absolute or escape the destination.
+	symlinks string
+	// If non-nil, only these paths and their contents, relative to the
+	// destination, are extracted.
+	only map[string]bool
+}
+
+type excludePattern struct {
+	glob glob.Glob
+	// Patterns without a "/" match the base name of any path.
+	base bool
+}
+
+func newPathFilter(pkg *manifest.Package) (pathFilter, error) {
+	filter := pathFilter{strip: pkg.Strip, symlinks: pkg.UnsafeSymlinks}
+	switch pkg.UnsafeSymlinks {
+	case "", "keep", "reject", "rewrite":
+	default:
+		return filter, errors.Errorf("invalid unsafe-symlinks %q, must be one of keep, reject or rewrite", pkg.UnsafeSymlinks)
+	}
+	for _, pattern := range pkg.Exclude {
+		g, err := glob.Compile(pattern, '/')
+		if err != nil {
+			return filter, errors.Wrapf(err, "invalid exclude pattern %q", pattern)
+		}
+		filter.exclude = append(filter.exclude, excludePattern{glob: g, base: !strings.Contains(pattern, "/")})
+	}
+	return filter, nil
+}
+
+// excluded returns true if rel, or a directory containing it, matches an
+// exclude pattern.
+func (f pathFilter) excluded(rel string) bool {
+	for p := rel; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		for _, pattern := range f.exclude {
+			if pattern.glob.Match(p) || (pattern.base && pattern.glob.Match(path.Base(p))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f pathFilter) selected(rel string) bool {
+	if f.only == nil {
+		return !f.excluded(rel)
+	}
+	for p := rel; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if f.only[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// symlinkTarget returns the target to create the symlink at destFile with.
+//
+// Relative targets within dest are returned unchanged. Absolute targets, and
+// relative targets that resolve outside dest, either lexically or through
+// symlinks already extracted to dest, are kept, rejected, or rewritten to the
+// same path relative to dest, according to the policy.
+func (f pathFilter) symlinkTarget(dest, destFile, target string) (string, error) {
+	dir := filepath.Dir(destFile)
+	relDir, err := filepath.Rel(dest, dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	inPkg := target
+	if !filepath.IsAbs(target) {
+		if resolvesWithin(dest, relDir, target) {
+			return target, nil
+		}
+		inPkg = filepath.Join(relDir, target)
+	}
+	switch f.symlinks {
+	case "reject":
+		return "", errors.Errorf("%s: illegal symlink to %s (not under %s)", destFile, target, dest)
+	case "rewrite":
+		// Joining to the root discards any leading "..".
+		rewritten, err := filepath.Rel(dir, filepath.Join(dest, filepath.Join("/", inPkg)))
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		if !resolvesWithin(dest, relDir, rewritten) {
+			return "", errors.Errorf("%s: illegal symlink to %s (not under %s)", destFile, target, dest)
+		}
+		return rewritten, nil
+	default:
+		return target, nil
+	}
+}
+
+// checkSymlinks applies the symlink policy to every symlink in dest once
+// extraction is complete, as a symlink that was within dest when it was
+// extracted may escape through symlinks extracted after it.
+func (f pathFilter) checkSymlinks(dest string) error {
+	if f.symlinks != "reject" && f.symlinks != "rewrite" {
+		return nil
+	}
+	return errors.WithStack(filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return err
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		checked, err := f.symlinkTarget(dest, path, target)
+		if err != nil || checked == target {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(os.Symlink(checked, path))
+	}))
+}
+
+// resolvesWithin returns true if the relative target of a symlink in dir,
+// relative to dest, resolves within dest, following any symlinks already
+// extracted to dest.
+func resolvesWithin(dest, dir, target string) bool {
+	// Path components remaining to resolve, and the resolved path so far.
+	parts := strings.Split(filepath.ToSlash(dir)+"/"+filepath.ToSlash(target), "/")
+	resolved := "."
+	for followed := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "." {
+				return false
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		linked, err := os.Readlink(filepath.Join(dest, next))
+		if err != nil {
+			// Not a symlink, or not extracted yet.
+			resolved = next
+			continue
+		}
+		if followed++; followed > 255 || filepath.IsAbs(linked) {
+			return false
+		}
+		parts = append(strings.Split(filepath.ToSlash(linked), "/"), parts...)
+	}
+	return true
+}
+
+// makeDestPath strips leading path components from an archive entry,
+// returning "" if the entry should not be extracted.
+func makeDestPath(dest, name string, filter pathFilter) (string, error) {
+	if err := sanitizeExtractPath(name, dest); err != nil {
 		return "", err
 	}
-	parts := strings.Split(path, "/")
-	if len(parts) <= strip {
+	parts := strings.Split(name, "/")
+	if len(parts) <= filter.strip {
+		return "", nil
+	}
+	rel := strings.Join(parts[filter.strip:], "/")
+	if !filter.selected(path.Clean("/" + rel)[1:]) {
 		return "", nil
 	}
-	destFile := strings.Join(parts[strip:], "/")
-	destFile = filepath.Join(dest, destFile)
+	destFile := filepath.Join(dest, rel)
 	return destFile, nil
 }
 
@@ -1,6 +1,11 @@
 package archive
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -21,12 +26,16 @@ func TestExtract(t *testing.T) {
 		{"archive.tar.bz2", []string{"darwin_exe", "linux_exe"}},
 		{"archive.tar.gz", []string{"darwin_exe", "linux_exe"}},
 		{"archive.tar.xz", []string{"darwin_exe", "linux_exe"}},
+		{"archive.tar.br", []string{"darwin_exe", "linux_exe"}},
 		{"archive.zip", []string{"darwin_exe", "linux_exe"}},
+		{"streamed.zip", []string{"darwin_exe", "linux_exe"}},
 		{"darwin_exe", []string{"darwin_exe"}},
 		{"linux_exe", []string{"linux_exe"}},
 		{"darwin_exe.gz", []string{"darwin_exe"}},
 		{"linux_exe.gz", []string{"linux_exe"}},
 		{"bzip2_1.0.6-9.2_deb10u1_amd64.deb", []string{"/bin/bzip2"}},
+		{"hello_1.0.0_all_zstd.deb", []string{"/bin/hello"}},
+		{"hello_1.0.0_all_xz.deb", []string{"/bin/hello"}},
 		{"bzip2-1.0.6-13.el7.x86_64.rpm", []string{"/usr/bin/bzip2"}},
 		{"directory", []string{"foo"}},
 	}
@@ -54,3 +63,342 @@ func TestExtract(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractLzipStrip(t *testing.T) {
+	p, _ := ui.NewForTesting()
+	dest := filepath.Join(t.TempDir(), "extracted")
+	// The tarball contains archive/darwin_exe and archive/linux_exe.
+	finalise, err := Extract(
+		p.Task("extract"),
+		filepath.Join("testdata", "archive.tar.lz"),
+		&manifest.Package{Dest: dest, Source: "archive.tar.lz", Strip: 1},
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, finalise())
+	for _, expected := range []string{"darwin_exe", "linux_exe"} {
+		info, err := os.Stat(filepath.Join(dest, expected))
+		assert.NoError(t, err)
+		assert.True(t, info.Mode()&unix.S_IXUSR != 0, "is not executable")
+	}
+	_, err = os.Stat(filepath.Join(dest, "archive"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStripDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pkg")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "Applications", "Foo.app", "Contents", "MacOS"), 0700))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "Library", "Foo"), 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "Applications", "Foo.app", "Contents", "MacOS", "foo"), []byte("#!/bin/sh\n"), 0700)) // nolint: gosec
+	assert.NoError(t, os.Symlink("Foo.app/Contents/MacOS/foo", filepath.Join(dir, "Applications", "foo")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "Library", "Foo", "config"), nil, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README"), nil, 0600))
+
+	err := stripDir(dir, 1)
+	assert.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, "Foo.app", "Contents", "MacOS", "foo"))
+	assert.NoError(t, err)
+	assert.True(t, info.Mode()&unix.S_IXUSR != 0, "is not executable")
+	link, err := os.Readlink(filepath.Join(dir, "foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Foo.app/Contents/MacOS/foo", link)
+	_, err = os.Stat(filepath.Join(dir, "Foo", "config"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "README"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dir + "~unstripped")
+	assert.True(t, os.IsNotExist(err))
+
+	err = stripDir(dir, 5)
+	assert.EqualError(t, err, `"strip = 5" would remove all files`)
+}
+
+func TestExtractExclude(t *testing.T) {
+	type entry struct {
+		name string
+		link string
+	}
+	entries := []entry{
+		{name: "pkg/bin/tool", link: "../libexec/tool"},
+		{name: "pkg/bin/other"},
+		{name: "pkg/doc/"},
+		{name: "pkg/doc/index.html"},
+		{name: "pkg/doc/api/ref.html"},
+		{name: "pkg/lib/libfoo.a"},
+		{name: "pkg/lib/sub/libbar.a"},
+		{name: "pkg/lib/libfoo.so"},
+		{name: "pkg/libexec/tool", link: "tool-1.0"},
+		{name: "pkg/libexec/tool-1.0"},
+	}
+	writeTar := func(w io.Writer) {
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		for _, e := range entries {
+			hdr := &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeReg, Size: int64(len(e.name))}
+			switch {
+			case e.link != "":
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+			case e.name[len(e.name)-1] == '/':
+				hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+			}
+			assert.NoError(t, tw.WriteHeader(hdr))
+			if hdr.Size > 0 {
+				_, err := tw.Write([]byte(e.name))
+				assert.NoError(t, err)
+			}
+		}
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gw.Close())
+	}
+	writeZip := func(w io.Writer) {
+		zw := zip.NewWriter(w)
+		for _, e := range entries {
+			hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			content := e.name
+			hdr.SetMode(0755)
+			if e.link != "" {
+				hdr.SetMode(0755 | os.ModeSymlink)
+				content = e.link
+			}
+			fw, err := zw.CreateHeader(hdr)
+			assert.NoError(t, err)
+			if e.name[len(e.name)-1] != '/' {
+				_, err = fw.Write([]byte(content))
+				assert.NoError(t, err)
+			}
+		}
+		assert.NoError(t, zw.Close())
+	}
+	for name, write := range map[string]func(io.Writer){"archive.tar.gz": writeTar, "archive.zip": writeZip} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, name)
+			w, err := os.Create(source)
+			assert.NoError(t, err)
+			write(w)
+			assert.NoError(t, w.Close())
+
+			p, _ := ui.NewForTesting()
+			dest := filepath.Join(dir, "extracted")
+			finalise, err := Extract(p.Task("extract"), source, &manifest.Package{
+				Dest:    dest,
+				Source:  name,
+				Strip:   1,
+				Exclude: []string{"doc/**", "*.a", "libexec"},
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, finalise())
+
+			for _, present := range []string{"bin/other", "lib/libfoo.so", "bin/tool", "libexec/tool", "libexec/tool-1.0"} {
+				_, err := os.Stat(filepath.Join(dest, present))
+				assert.NoError(t, err, present)
+			}
+			for _, absent := range []string{"doc/index.html", "doc/api", "lib/libfoo.a", "lib/sub/libbar.a"} {
+				_, err := os.Lstat(filepath.Join(dest, absent))
+				assert.True(t, os.IsNotExist(err), "%s should be excluded", absent)
+			}
+		})
+	}
+}
+
+func TestExtractUnsafeSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "tool.tar.gz")
+	w, err := os.Create(source)
+	assert.NoError(t, err)
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "pkg/bin/tool", Mode: 0755, Typeflag: tar.TypeReg, Size: 4}))
+	_, err = tw.Write([]byte("tool"))
+	assert.NoError(t, err)
+	for name, link := range map[string]string{
+		"pkg/bin/within":   "tool",
+		"pkg/bin/absolute": "/usr/bin/tool",
+		"pkg/bin/escaping": "../../../etc/passwd",
+	} {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeSymlink, Linkname: link}))
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, w.Close())
+
+	tests := []struct {
+		policy   string
+		expected map[string]string
+		err      string
+	}{
+		{policy: "", expected: map[string]string{"within": "tool", "absolute": "/usr/bin/tool", "escaping": "../../../etc/passwd"}},
+		{policy: "rewrite", expected: map[string]string{"within": "tool", "absolute": "../usr/bin/tool", "escaping": "../etc/passwd"}},
+		{policy: "reject", err: "illegal symlink"},
+		{policy: "invalid", err: `invalid unsafe-symlinks "invalid"`},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			p, _ := ui.NewForTesting()
+			dest := filepath.Join(t.TempDir(), "extracted")
+			_, err := Extract(p.Task("extract"), source, &manifest.Package{
+				Dest:           dest,
+				Source:         "tool.tar.gz",
+				Strip:          1,
+				UnsafeSymlinks: test.policy,
+			})
+			if test.err != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			assert.NoError(t, err)
+			for name, expected := range test.expected {
+				link, err := os.Readlink(filepath.Join(dest, "bin", name))
+				assert.NoError(t, err)
+				assert.Equal(t, expected, link, name)
+			}
+		})
+	}
+}
+
+func TestExtractChainedSymlinks(t *testing.T) {
+	// "early" escapes through "up" once it is extracted, "chained" as soon as
+	// it is extracted.
+	links := [][2]string{
+		{"pkg/bin/early", "up/.."},
+		{"pkg/bin/up", ".."},
+		{"pkg/bin/chained", "up/.."},
+	}
+	writeTar := func(w io.Writer) {
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		for _, link := range links {
+			assert.NoError(t, tw.WriteHeader(&tar.Header{Name: link[0], Mode: 0755, Typeflag: tar.TypeSymlink, Linkname: link[1]}))
+		}
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gw.Close())
+	}
+	writeZip := func(w io.Writer) {
+		zw := zip.NewWriter(w)
+		for _, link := range links {
+			hdr := &zip.FileHeader{Name: link[0], Method: zip.Deflate}
+			hdr.SetMode(0755 | os.ModeSymlink)
+			fw, err := zw.CreateHeader(hdr)
+			assert.NoError(t, err)
+			_, err = fw.Write([]byte(link[1]))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, zw.Close())
+	}
+	for name, write := range map[string]func(io.Writer){"archive.tar.gz": writeTar, "archive.zip": writeZip} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, name)
+			w, err := os.Create(source)
+			assert.NoError(t, err)
+			write(w)
+			assert.NoError(t, w.Close())
+
+			p, _ := ui.NewForTesting()
+			_, err = Extract(p.Task("extract"), source, &manifest.Package{
+				Dest:           filepath.Join(dir, "rejected"),
+				Source:         name,
+				Strip:          1,
+				UnsafeSymlinks: "reject",
+			})
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "illegal symlink")
+
+			dest := filepath.Join(dir, "rewritten")
+			_, err = Extract(p.Task("extract"), source, &manifest.Package{
+				Dest:           dest,
+				Source:         name,
+				Strip:          1,
+				UnsafeSymlinks: "rewrite",
+			})
+			assert.NoError(t, err)
+			for name, expected := range map[string]string{"early": ".", "up": "..", "chained": "."} {
+				link, err := os.Readlink(filepath.Join(dest, "bin", name))
+				assert.NoError(t, err)
+				assert.Equal(t, expected, link, name)
+			}
+		})
+	}
+}
+
+func TestExtractInner(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "tool.zip")
+	w, err := os.Create(source)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(w)
+	fw, err := zw.Create("tool.tar.gz")
+	assert.NoError(t, err)
+	gw := gzip.NewWriter(fw)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"tool-1.0/bin/tool", "tool-1.0/doc/README"} {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeReg, Size: int64(len(name))}))
+		_, err = tw.Write([]byte(name))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, w.Close())
+
+	p, _ := ui.NewForTesting()
+	dest := filepath.Join(dir, "extracted")
+	finalise, err := Extract(p.Task("extract"), source, &manifest.Package{
+		Dest:    dest,
+		Source:  "tool.zip",
+		Inner:   "tool.tar.gz",
+		Strip:   1,
+		Exclude: []string{"doc"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, finalise())
+	content, err := os.ReadFile(filepath.Join(dest, "bin", "tool"))
+	assert.NoError(t, err)
+	assert.Equal(t, "tool-1.0/bin/tool", string(content))
+	_, err = os.Stat(filepath.Join(dest, "doc"))
+	assert.True(t, os.IsNotExist(err), "doc should be excluded")
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries), "outer archive should be cleaned up")
+
+	_, err = Extract(p.Task("extract"), source, &manifest.Package{
+		Dest:   filepath.Join(dir, "missing"),
+		Source: "tool.zip",
+		Inner:  "missing.tar.gz",
+	})
+	assert.EqualError(t, err, `inner archive "missing.tar.gz" not found in `+source)
+}
+
+func TestExtractStreamingZipEnd(t *testing.T) {
+	data, err := os.ReadFile("testdata/streamed.zip")
+	assert.NoError(t, err)
+	// The archive has no central directory.
+	entries := data[:len(data):len(data)]
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{name: "MissingCentralDirectory", data: entries},
+		{name: "CentralDirectory", data: append(entries, "PK\x01\x02 ignored"...)},
+		{name: "TrailingGarbage", data: append(entries, "garbage!"...), err: "invalid zip header signature 0x62726167"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, _ := ui.NewForTesting()
+			dest := t.TempDir()
+			// Existing files are truncated.
+			err := os.WriteFile(filepath.Join(dest, "darwin_exe"), bytes.Repeat([]byte("x"), 1<<20), 0600)
+			assert.NoError(t, err)
+			err = extractStreamingZip(p.Task("extract"), bytes.NewReader(test.data), dest, pathFilter{})
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			info, err := os.Stat(filepath.Join(dest, "darwin_exe"))
+			assert.NoError(t, err)
+			assert.True(t, info.Size() < 1<<20, "%d bytes", info.Size())
+		})
+	}
+}
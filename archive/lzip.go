@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// lzipMagic starts each member of an lzip file.
+var lzipMagic = []byte("LZIP")
+
+// lzipReader decompresses an lzip file.
+//
+// An lzip file is a sequence of members, each consisting of a header, an LZMA
+// stream terminated by an end of stream marker, and a trailer with the CRC32
+// and size of the decompressed data.
+type lzipReader struct {
+	r      *bufio.Reader
+	member *lzma.Reader // Nil between members.
+	crc    hash.Hash32
+	size   uint64
+}
+
+func newLzipReader(r io.Reader) (io.Reader, error) {
+	lr := &lzipReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	if err := lr.openMember(); err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+func (l *lzipReader) Read(p []byte) (int, error) {
+	for {
+		if l.member == nil {
+			// Anything other than another member, such as trailing padding, ends the file.
+			if magic, _ := l.r.Peek(len(lzipMagic)); !bytes.Equal(magic, lzipMagic) {
+				return 0, io.EOF
+			}
+			if err := l.openMember(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := l.member.Read(p)
+		_, _ = l.crc.Write(p[:n])
+		l.size += uint64(n)
+		if errors.Is(err, io.EOF) {
+			if err := l.closeMember(); err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, errors.WithStack(err)
+	}
+}
+
+// openMember reads the header of the next member.
+func (l *lzipReader) openMember() error {
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(l.r, header); err != nil {
+		return errors.Wrap(err, "invalid lzip header")
+	}
+	if !bytes.Equal(header[:4], lzipMagic) {
+		return errors.New("invalid lzip header")
+	}
+	if header[4] != 1 {
+		return errors.Errorf("unsupported lzip version %d", header[4])
+	}
+	// The dictionary size is a power of two, less a number of sixteenths of it.
+	dictSize := uint32(1) << (header[5] & 0x1f)
+	dictSize -= (dictSize / 16) * uint32(header[5]>>5)
+	if dictSize < lzma.MinDictCap || dictSize > 1<<29 {
+		return errors.Errorf("invalid lzip dictionary size %d", dictSize)
+	}
+	// Members are LZMA streams with fixed properties and an end of stream
+	// marker, so synthesise an LZMA header for them.
+	lzmaHeader := make([]byte, 13)
+	lzmaHeader[0] = 0x5d // lc=3, lp=0, pb=2
+	binary.LittleEndian.PutUint32(lzmaHeader[1:], dictSize)
+	binary.LittleEndian.PutUint64(lzmaHeader[5:], ^uint64(0))
+	// The LZMA reader reads a byte at a time, so the trailer is left in l.r.
+	member, err := lzma.NewReader(io.MultiReader(bytes.NewReader(lzmaHeader), l.r))
+	if err != nil {
+		return errors.Wrap(err, "invalid lzip member")
+	}
+	l.member = member
+	l.crc.Reset()
+	l.size = 0
+	return nil
+}
+
+// closeMember reads and verifies the trailer of the current member.
+func (l *lzipReader) closeMember() error {
+	l.member = nil
+	trailer := make([]byte, 20)
+	if _, err := io.ReadFull(l.r, trailer); err != nil {
+		return errors.Wrap(err, "invalid lzip trailer")
+	}
+	if crc := binary.LittleEndian.Uint32(trailer); crc != l.crc.Sum32() {
+		return errors.Errorf("lzip CRC %08x should have been %08x", l.crc.Sum32(), crc)
+	}
+	if size := binary.LittleEndian.Uint64(trailer[4:]); size != l.size {
+		return errors.Errorf("lzip data size %d should have been %d", l.size, size)
+	}
+	return nil
+}
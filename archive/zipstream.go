@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/ui"
+)
+
+const (
+	zipLocalHeaderSignature    = 0x04034b50
+	zipCentralHeaderSignature  = 0x02014b50
+	zipDataDescriptorSignature = 0x08074b50
+	zipFlagDataDescriptor      = 0x8
+	zipZip64ExtraID            = 0x0001
+)
+
+// extractStreamingZip extracts a zip by reading its local file headers in
+// order, for archives whose central directory is missing or unreliable, such
+// as those generated on the fly with data descriptors.
+//
+// Entries are read until the central directory, or the end of the archive if
+// it is missing, and anything else is an error.
+//
+// Local headers do not record file permissions, so all files are extracted
+// as executable.
+func extractStreamingZip(b *ui.Task, r io.Reader, dest string, filter pathFilter) error {
+	br := bufio.NewReader(r)
+	for {
+		var sig uint32
+		err := binary.Read(br, binary.LittleEndian, &sig)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		if sig == zipCentralHeaderSignature {
+			return nil
+		} else if sig != zipLocalHeaderSignature {
+			return errors.Errorf("invalid zip header signature 0x%08x", sig)
+		}
+		if err := extractStreamingZipEntry(b, br, dest, filter); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+func extractStreamingZipEntry(b *ui.Task, br *bufio.Reader, dest string, filter pathFilter) error {
+	var hdr struct {
+		Version, Flags, Method, ModTime, ModDate uint16
+		CRC32, CompressedSize, UncompressedSize  uint32
+		NameLen, ExtraLen                        uint16
+	}
+	if err := binary.Read(br, binary.LittleEndian, &hdr); err != nil {
+		return errors.Wrap(err, "invalid zip local file header")
+	}
+	name := make([]byte, hdr.NameLen)
+	if _, err := io.ReadFull(br, name); err != nil {
+		return errors.WithStack(err)
+	}
+	extra := make([]byte, hdr.ExtraLen)
+	if _, err := io.ReadFull(br, extra); err != nil {
+		return errors.WithStack(err)
+	}
+	compressedSize := uint64(hdr.CompressedSize)
+	zip64 := false
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra)-4 {
+			break
+		}
+		if id == zipZip64ExtraID {
+			zip64 = true
+			// Uncompressed then compressed size, if present.
+			if size >= 16 && hdr.CompressedSize == 0xffffffff {
+				compressedSize = binary.LittleEndian.Uint64(extra[12:])
+			}
+		}
+		extra = extra[4+size:]
+	}
+	hasDescriptor := hdr.Flags&zipFlagDataDescriptor != 0
+
+	var data io.Reader
+	switch hdr.Method {
+	case 0: // Stored
+		if hasDescriptor {
+			return errors.Errorf("%s: stored entries with data descriptors are not supported", name)
+		}
+		data = io.LimitReader(br, int64(compressedSize))
+	case 8: // Deflate
+		// The bufio.Reader is an io.ByteReader, so the decompressor does not
+		// read past the end of the compressed data.
+		fr := flate.NewReader(br)
+		defer fr.Close()
+		data = fr
+		if !hasDescriptor {
+			data = io.LimitReader(fr, int64(hdr.UncompressedSize))
+		}
+	default:
+		return errors.Errorf("%s: unsupported zip compression method %d", name, hdr.Method)
+	}
+
+	b.Tracef("  %s", name)
+	destFile, err := makeDestPath(dest, string(name), filter)
+	if err != nil {
+		return err
+	}
+	crc := crc32.NewIEEE()
+	if destFile == "" {
+		_, err = io.Copy(crc, data)
+	} else {
+		err = writeStreamingZipEntry(io.TeeReader(data, crc), destFile, strings.HasSuffix(string(name), "/"))
+	}
+	if err != nil {
+		return errors.Wrap(err, string(name))
+	}
+
+	expected := hdr.CRC32
+	if hasDescriptor {
+		expected, err = readZipDataDescriptor(br, zip64)
+		if err != nil {
+			return errors.Wrap(err, string(name))
+		}
+	}
+	if crc.Sum32() != expected {
+		return errors.Errorf("%s: checksum mismatch", name)
+	}
+	return nil
+}
+
+func writeStreamingZipEntry(r io.Reader, destFile string, dir bool) error {
+	if dir {
+		_, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(os.MkdirAll(destFile, 0700))
+	}
+	err := os.MkdirAll(filepath.Dir(destFile), 0700)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w, err := os.OpenFile(destFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(w, r) // nolint: gosec
+	if err != nil {
+		_ = w.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(w.Close())
+}
+
+// readZipDataDescriptor reads the data descriptor following an entry's data,
+// returning its CRC-32.
+func readZipDataDescriptor(br *bufio.Reader, zip64 bool) (uint32, error) {
+	var crc uint32
+	if err := binary.Read(br, binary.LittleEndian, &crc); err != nil {
+		return 0, errors.Wrap(err, "invalid zip data descriptor")
+	}
+	// The signature is optional.
+	if crc == zipDataDescriptorSignature {
+		if err := binary.Read(br, binary.LittleEndian, &crc); err != nil {
+			return 0, errors.Wrap(err, "invalid zip data descriptor")
+		}
+	}
+	sizes := 8
+	if zip64 {
+		sizes = 16
+	}
+	if _, err := br.Discard(sizes); err != nil {
+		return 0, errors.Wrap(err, "invalid zip data descriptor")
+	}
+	return crc, nil
+}
@@ -0,0 +1,187 @@
+// Package bundle reads and writes bundles of cached package artefacts, for
+// transferring packages into air-gapped environments.
+//
+// A bundle is a tarball containing an "index.json" describing each artefact,
+// followed by the artefacts themselves.
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cashapp/hermit/cache"
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/ui"
+)
+
+const indexName = "index.json"
+
+// Entry describes a single artefact in a bundle.
+type Entry struct {
+	// Reference of the package the artefact belongs to.
+	Reference string `json:"reference"`
+	// Platform the artefact was resolved for.
+	Platform string `json:"platform"`
+	// Source URL of the artefact.
+	Source string `json:"source"`
+	// SHA256 declared in the manifest, if any. This is part of the cache key.
+	SHA256 string `json:"sha256,omitempty"`
+	// Digest is the actual SHA256 digest of the artefact.
+	Digest string `json:"digest"`
+	// Path of the artefact within the bundle.
+	Path string `json:"path"`
+}
+
+// Index of a bundle.
+type Index struct {
+	Entries []Entry `json:"entries"`
+}
+
+// EntryPath returns the path within a bundle for an artefact.
+func EntryPath(checksum, source string) string {
+	return path.Join("artefacts", filepath.ToSlash(cache.BasePath(checksum, source)))
+}
+
+// Write a bundle containing the given entries, read from the cache, to w.
+func Write(w io.Writer, c *cache.Cache, entries []Entry) error {
+	tw := tar.NewWriter(w)
+	index, err := json.MarshalIndent(&Index{Entries: entries}, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    indexName,
+		Mode:    0600,
+		Size:    int64(len(index)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err = tw.Write(index); err != nil {
+		return errors.WithStack(err)
+	}
+	written := map[string]bool{}
+	for _, entry := range entries {
+		if written[entry.Path] {
+			continue
+		}
+		written[entry.Path] = true
+		if err := writeEntry(tw, c, entry); err != nil {
+			return errors.Wrap(err, entry.Reference)
+		}
+	}
+	return errors.WithStack(tw.Close())
+}
+
+func writeEntry(tw *tar.Writer, c *cache.Cache, entry Entry) error {
+	r, err := os.Open(c.Path(entry.SHA256, entry.Source))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer r.Close() // nolint
+	info, err := r.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    entry.Path,
+		Mode:    0600,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(tw, r)
+	return errors.WithStack(err)
+}
+
+// Read a bundle from r and populate the cache with its artefacts.
+//
+// Each artefact is verified against the digest recorded in the index.
+func Read(b *ui.Task, r io.Reader, c *cache.Cache) (*Index, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid bundle")
+	}
+	if hdr.Name != indexName {
+		return nil, errors.Errorf("invalid bundle, expected %s but got %s", indexName, hdr.Name)
+	}
+	index := &Index{}
+	if err := json.NewDecoder(tr).Decode(index); err != nil {
+		return nil, errors.Wrap(err, indexName)
+	}
+	entries := map[string][]Entry{}
+	for _, entry := range index.Entries {
+		entries[entry.Path] = append(entries[entry.Path], entry)
+	}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		matching, ok := entries[hdr.Name]
+		if !ok {
+			return nil, errors.Errorf("%s is not in the bundle index", hdr.Name)
+		}
+		b.Debugf("Importing %s", matching[0].Source)
+		if err := readEntry(tr, c, matching); err != nil {
+			return nil, errors.Wrap(err, hdr.Name)
+		}
+		delete(entries, hdr.Name)
+	}
+	if len(entries) > 0 {
+		missing := make([]string, 0, len(entries))
+		for path := range entries {
+			missing = append(missing, path)
+		}
+		sort.Strings(missing)
+		return nil, errors.Errorf("bundle is missing %s", strings.Join(missing, ", "))
+	}
+	return index, nil
+}
+
+// readEntry writes an artefact to the cache path of each matching entry.
+func readEntry(r io.Reader, c *cache.Cache, entries []Entry) error {
+	entry := entries[0]
+	dest := c.Path(entry.SHA256, entry.Source)
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	w, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.hermit.tmp.import")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(w.Name()) // nolint
+	h := sha256.New()
+	_, err = io.Copy(w, io.TeeReader(r, h))
+	_ = w.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	for _, entry := range entries {
+		if entry.Digest != digest {
+			return errors.Errorf("%s: checksum %s should have been %s", entry.Source, digest, entry.Digest)
+		}
+		// The manifest checksum is part of the cache key, so must match too.
+		if entry.SHA256 != "" && entry.SHA256 != digest {
+			return errors.Errorf("%s: checksum %s does not match manifest checksum %s", entry.Source, digest, entry.SHA256)
+		}
+	}
+	return errors.WithStack(os.Rename(w.Name(), dest))
+}
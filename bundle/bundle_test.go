@@ -0,0 +1,73 @@
+package bundle_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/bundle"
+	"github.com/cashapp/hermit/cache"
+	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	p, _ := ui.NewForTesting()
+	source := "https://example.com/pkg-1.0.0.tar.gz"
+
+	from, err := cache.Open(t.TempDir(), nil, nil, nil)
+	assert.NoError(t, err)
+	path := from.Path("", source)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, os.WriteFile(path, []byte("artefact"), 0600))
+	digest, err := util.Sha256LocalFile(path)
+	assert.NoError(t, err)
+
+	entries := []bundle.Entry{
+		{Reference: "pkg-1.0.0", Platform: "linux-amd64", Source: source, Digest: digest, Path: bundle.EntryPath("", source)},
+		{Reference: "pkg-1.0.0", Platform: "darwin-arm64", Source: source, Digest: digest, Path: bundle.EntryPath("", source)},
+	}
+	buf := &bytes.Buffer{}
+	err = bundle.Write(buf, from, entries)
+	assert.NoError(t, err)
+
+	to, err := cache.Open(t.TempDir(), nil, nil, nil)
+	assert.NoError(t, err)
+	index, err := bundle.Read(p.Task("import"), bytes.NewReader(buf.Bytes()), to)
+	assert.NoError(t, err)
+	assert.Equal(t, entries, index.Entries)
+	assert.True(t, to.IsCached("", source))
+
+	// Corrupt the index digest and ensure the import is rejected.
+	entries[0].Digest = "bad"
+	buf.Reset()
+	err = bundle.Write(buf, from, entries)
+	assert.NoError(t, err)
+	to, err = cache.Open(t.TempDir(), nil, nil, nil)
+	assert.NoError(t, err)
+	_, err = bundle.Read(p.Task("import"), bytes.NewReader(buf.Bytes()), to)
+	assert.Error(t, err)
+	assert.False(t, to.IsCached("", source))
+
+	// An artefact that does not match the manifest checksum is rejected, even
+	// if it matches the digest declared by the bundle.
+	sha256 := "0000000000000000000000000000000000000000000000000000000000000000"
+	path = from.Path(sha256, source)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, os.WriteFile(path, []byte("artefact"), 0600))
+	entries = []bundle.Entry{
+		{Reference: "pkg-1.0.0", Platform: "linux-amd64", Source: source, SHA256: sha256, Digest: digest, Path: bundle.EntryPath(sha256, source)},
+	}
+	buf.Reset()
+	err = bundle.Write(buf, from, entries)
+	assert.NoError(t, err)
+	to, err = cache.Open(t.TempDir(), nil, nil, nil)
+	assert.NoError(t, err)
+	_, err = bundle.Read(p.Task("import"), bytes.NewReader(buf.Bytes()), to)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match manifest checksum")
+	assert.False(t, to.IsCached(sha256, source))
+}
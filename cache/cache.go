@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -13,6 +14,22 @@ import (
 	"github.com/cashapp/hermit/util"
 )
 
+// ErrChecksumMismatch is matched by errors for downloads whose content does
+// not have the expected checksum, eg. from a stale or partial CDN object.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+type checksumMismatchError struct {
+	uri      string
+	actual   string
+	expected string
+}
+
+func (e checksumMismatchError) Error() string {
+	return fmt.Sprintf("%s: checksum %s should have been %s", e.uri, e.actual, e.expected)
+}
+
+func (e checksumMismatchError) Is(target error) bool { return target == ErrChecksumMismatch }
+
 // Cache manages the Hermit cache.
 type Cache struct {
 	// GetSource determines how to retrieve packages.
@@ -23,6 +40,10 @@ type Cache struct {
 	fastFailHTTPClient *http.Client
 }
 
+// Entries are stored in directories named by the first two hex digits of
+// their hash, as returned by BasePath.
+var entryDirRe = regexp.MustCompile(`^[0-9a-f]{2}$`)
+
 // BasePath returns the subfolder in the cache path for the given file
 func BasePath(checksum, uri string) string {
 	hash := util.Hash(uri, checksum)
@@ -41,8 +62,15 @@ func BasePath(checksum, uri string) string {
 // "strategies" are used to download URLS, attempted in order.
 // A default raw HTTP download strategy will always be the first strategy attempted.
 func Open(stateDir string, selector PackageSourceSelector, client *http.Client, fastFailClient *http.Client) (*Cache, error) {
-	stateDir = filepath.Join(stateDir, "cache")
-	err := os.MkdirAll(stateDir, os.ModePerm) //nolint:gosec
+	return OpenDir(filepath.Join(stateDir, "cache"), selector, client, fastFailClient)
+}
+
+// OpenDir opens or creates a Cache rooted at dir, rather than within a Hermit
+// state directory.
+//
+// See Open for details of the other arguments.
+func OpenDir(dir string, selector PackageSourceSelector, client *http.Client, fastFailClient *http.Client) (*Cache, error) {
+	err := os.MkdirAll(dir, os.ModePerm) //nolint:gosec
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -50,7 +78,7 @@ func Open(stateDir string, selector PackageSourceSelector, client *http.Client,
 		selector = GetSource
 	}
 	c := &Cache{
-		root:               stateDir,
+		root:               dir,
 		GetSource:          selector,
 		httpClient:         client,
 		fastFailHTTPClient: fastFailClient,
@@ -63,6 +91,11 @@ func (c *Cache) Root() string {
 	return c.root
 }
 
+// HTTPClient returns the client used for downloads.
+func (c *Cache) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
 // Mkdir makes a directory for the given URI.
 func (c *Cache) Mkdir(uri string) (string, error) {
 	path := c.Path("", uri)
@@ -114,13 +147,22 @@ func (c *Cache) Open(b *ui.Task, checksum, uri string, mirrors ...string) (*os.F
 // Download a local or remote artifact, transparently caching it.
 //
 // If checksum is present it must be the SHA256 hash of the downloaded artifact.
+// A source whose content has the wrong checksum is not tried again, and if
+// every source has the wrong checksum an error matching ErrChecksumMismatch
+// listing each of them is returned.
 func (c *Cache) Download(b *ui.Task, checksum, uri string, mirrors ...string) (path string, etag string, actualChecksum string, err error) {
 	uris := append([]string{uri}, mirrors...)
 	var lastError error
+	// Sources that served content with the wrong checksum, in the order they were tried.
+	var mismatches []error
+	mismatched := map[string]bool{}
 	attempts := 3
 	for attempt := 1; attempt <= attempts; attempt++ {
 		for _, uri := range uris {
-			defer ui.LogElapsed(b, "Download %s", uri)()
+			if mismatched[uri] {
+				continue
+			}
+			defer ui.LogElapsed(b, "Download %s", util.RedactURL(uri))()
 			source, err := c.GetSource(c.httpClient, uri)
 			if err != nil {
 				return "", "", "", errors.WithStack(err)
@@ -130,12 +172,21 @@ func (c *Cache) Download(b *ui.Task, checksum, uri string, mirrors ...string) (p
 				return path, etag, actualChecksum, nil
 			}
 			lastError = err
-			b.Debugf("%s: %s", uri, err)
+			if errors.Is(err, ErrChecksumMismatch) {
+				mismatched[uri] = true
+				mismatches = append(mismatches, err)
+				b.Warnf("%s, trying the next source", err)
+				continue
+			}
+			b.Debugf("%s: %s", util.RedactURL(uri), err)
+		}
+		if len(mismatched) == len(uris) {
+			return "", "", "", errors.Wrapf(errors.Join(mismatches...), "every source of %s had the wrong checksum", util.RedactURL(uris[0]))
 		}
 		if lastError == nil {
-			return "", "", "", errors.Errorf("failed to download from any of %s", strings.Join(uris, ", "))
+			return "", "", "", errors.Errorf("failed to download from any of %s", redactURLs(uris))
 		}
-		msg := fmt.Sprintf("Failed to download any of %s on attempt %d/%d: %s", strings.Join(uris, ", "), attempt, attempts, lastError)
+		msg := fmt.Sprintf("Failed to download any of %s on attempt %d/%d: %s", redactURLs(uris), attempt, attempts, lastError)
 		if attempt < attempts {
 			msg = "Retrying. " + msg
 		}
@@ -144,7 +195,15 @@ func (c *Cache) Download(b *ui.Task, checksum, uri string, mirrors ...string) (p
 			time.Sleep(time.Second)
 		}
 	}
-	return "", "", "", errors.Wrap(lastError, uris[len(uris)-1])
+	return "", "", "", errors.Wrap(lastError, util.RedactURL(uris[len(uris)-1]))
+}
+
+func redactURLs(uris []string) string {
+	redacted := make([]string, len(uris))
+	for i, uri := range uris {
+		redacted[i] = util.RedactURL(uri)
+	}
+	return strings.Join(redacted, ", ")
 }
 
 // ETag fetches the etag from given URI if available.
@@ -157,7 +216,7 @@ func (c *Cache) ETag(b *ui.Task, uri string, mirrors ...string) (etag string, er
 		}
 		result, err := source.ETag(b)
 		if err != nil {
-			b.Debugf("%s failed: %s", uri, err)
+			b.Debugf("%s failed: %s", util.RedactURL(uri), err)
 			continue
 		}
 		return result, nil
@@ -165,6 +224,20 @@ func (c *Cache) ETag(b *ui.Task, uri string, mirrors ...string) (etag string, er
 	return "", nil
 }
 
+// Suffix of the file recording the filename suggested by the server for a
+// cached download.
+const filenameSuffix = ".filename"
+
+// SuggestedFilename returns the filename suggested by the server when the URI
+// was downloaded, eg. from a Content-Disposition header, or "" if none was.
+func (c *Cache) SuggestedFilename(checksum, uri string) string {
+	data, err := os.ReadFile(c.Path(checksum, uri) + filenameSuffix)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
 // IsCached returns true if the URI is cached.
 func (c *Cache) IsCached(checksum, uri string) bool {
 	_, err := os.Stat(c.Path(checksum, uri))
@@ -178,12 +251,35 @@ func (c *Cache) Evict(b *ui.Task, checksum, uri string) error {
 	if err != nil && !os.IsNotExist(err) {
 		return errors.WithStack(err)
 	}
+	err = os.Remove(c.Path(checksum, uri) + filenameSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
 	return nil
 }
 
 // Clean the cache.
-func (c *Cache) Clean() error {
-	return os.RemoveAll(c.root)
+//
+// Only the entries created by the cache are removed, as its directory may be
+// shared with other files.
+func (c *Cache) Clean(b ui.Logger) error {
+	entries, err := os.ReadDir(c.root)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !entryDirRe.MatchString(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(c.root, entry.Name())
+		b.Debugf("rm -rf %q", path)
+		if err := os.RemoveAll(path); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
 }
 
 // Path to cached object.
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestDownloadTriesMirrorsOnChecksumMismatch(t *testing.T) {
+	content := []byte("good content")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	lock := sync.Mutex{}
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		lock.Unlock()
+		if r.URL.Path == "/good.tar.gz" {
+			_, _ = w.Write(content)
+		} else {
+			_, _ = w.Write([]byte("stale content"))
+		}
+	}))
+	defer server.Close()
+	c, err := Open(t.TempDir(), nil, server.Client(), server.Client())
+	assert.NoError(t, err)
+	p, _ := ui.NewForTesting()
+	task := p.Task("test")
+
+	path, _, actual, err := c.Download(task, checksum, server.URL+"/bad.tar.gz", server.URL+"/good.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, checksum, actual)
+	assert.NotZero(t, path)
+
+	_, _, _, err = c.Download(task, checksum, server.URL+"/bad1.tar.gz", server.URL+"/bad2.tar.gz")
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	assert.Contains(t, err.Error(), server.URL+"/bad1.tar.gz: checksum")
+	assert.Contains(t, err.Error(), server.URL+"/bad2.tar.gz: checksum")
+	assert.Equal(t, map[string]int{"/bad.tar.gz": 1, "/good.tar.gz": 1, "/bad1.tar.gz": 1, "/bad2.tar.gz": 1}, requests)
+}
+
+func TestDownloadRedactsURLOnRequestFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	uri := server.URL + "/pkg.tar.gz?token=secret"
+	server.Close()
+	c, err := Open(t.TempDir(), nil, server.Client(), server.Client())
+	assert.NoError(t, err)
+	p, _ := ui.NewForTesting()
+
+	_, _, _, err = c.Download(p.Task("test"), "", uri)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+	assert.Contains(t, err.Error(), "token=REDACTED")
+
+	err = HTTPSource(server.Client(), uri).Validate()
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
@@ -6,12 +6,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 
 	"github.com/cashapp/hermit/errors"
 	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
 )
 
 type httpSource struct {
@@ -31,15 +33,15 @@ func (s *httpSource) OpenLocal(c *Cache, checksum string) (*os.File, error) {
 
 func (s *httpSource) Download(b *ui.Task, cache *Cache, checksum string) (path string, etag string, actualChecksum string, err error) {
 	cachePath := cache.Path(checksum, s.url)
-	b.Tracef("cachePath %v checksum %v url %v \n", cachePath, checksum, s.url)
+	b.Tracef("cachePath %v checksum %v url %v \n", cachePath, checksum, util.RedactURL(s.url))
 	ctx := context.Background()
 	req, err := http.NewRequestWithContext(ctx, "GET", s.url, &bytes.Reader{})
 	if err != nil {
-		return "", "", "", errors.Wrap(err, "could not fetch")
+		return "", "", "", errors.Wrap(util.RedactURLError(err), "could not fetch")
 	}
 	response, err := s.client.Do(req)
 	if err != nil {
-		return "", "", "", errors.Wrap(err, "could not download to cache")
+		return "", "", "", errors.Wrap(util.RedactURLError(err), "could not download to cache")
 	}
 	defer response.Body.Close()
 	return downloadHTTP(b, response, checksum, s.url, cachePath)
@@ -49,16 +51,16 @@ func (s *httpSource) ETag(b *ui.Task) (etag string, err error) {
 	uri := s.url
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, uri, nil)
 	if err != nil {
-		return "", errors.Wrap(err, uri)
+		return "", errors.WithStack(util.RedactURLError(err))
 	}
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", errors.Wrap(err, uri)
+		return "", errors.WithStack(util.RedactURLError(err))
 	}
 	defer resp.Body.Close()
 	// Normal HTTP error, log and try the next mirror.
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", errors.Errorf("%s failed: %d", uri, resp.StatusCode)
+		return "", errors.Errorf("%s failed: %d", util.RedactURL(uri), resp.StatusCode)
 	}
 	result := resp.Header.Get("ETag")
 	return result, nil
@@ -67,16 +69,16 @@ func (s *httpSource) ETag(b *ui.Task) (etag string, err error) {
 func (s *httpSource) Validate() error {
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, s.url, nil)
 	if err != nil {
-		return errors.Wrap(err, s.url)
+		return errors.WithStack(util.RedactURLError(err))
 	}
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return errors.WithStack(err)
+		return errors.WithStack(util.RedactURLError(err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return errors.Errorf("could not retrieve source archive from %s: %s", s.url, resp.Status)
+		return errors.Errorf("could not retrieve source archive from %s: %s", util.RedactURL(s.url), resp.Status)
 	}
 
 	return nil
@@ -84,7 +86,7 @@ func (s *httpSource) Validate() error {
 
 func downloadHTTP(b *ui.Task, response *http.Response, checksum string, uri string, cachePath string) (path string, etag string, returnChecksum string, err error) {
 	if response.StatusCode < 200 || response.StatusCode > 299 {
-		return "", "", "", errors.Errorf("download failed: %s (%d), source url: %s", response.Status, response.StatusCode, uri)
+		return "", "", "", errors.Errorf("download failed: %s (%d), source url: %s", response.Status, response.StatusCode, util.RedactURL(uri))
 	}
 	task := b.SubTask("download")
 	cacheDir := filepath.Dir(cachePath)
@@ -98,7 +100,7 @@ func downloadHTTP(b *ui.Task, response *http.Response, checksum string, uri stri
 	defer os.Remove(w.Name())
 
 	// For HTTP files we download and cache them, then return the cached file.
-	task.Debugf("Downloading %s", uri)
+	task.Debugf("Downloading %s", util.RedactURL(uri))
 
 	etag = response.Header.Get("ETag")
 
@@ -126,7 +128,7 @@ func downloadHTTP(b *ui.Task, response *http.Response, checksum string, uri stri
 	// TODO: We'll need to checksum the existing content when resuming.
 	actualChecksum := hex.EncodeToString(h.Sum(nil))
 	if checksum != "" && checksum != actualChecksum {
-		return "", "", "", errors.Errorf("%s: checksum %s should have been %s", uri, actualChecksum, checksum)
+		return "", "", "", errors.WithStack(checksumMismatchError{util.RedactURL(uri), actualChecksum, checksum})
 	}
 
 	err = response.Body.Close()
@@ -139,5 +141,33 @@ func downloadHTTP(b *ui.Task, response *http.Response, checksum string, uri stri
 	if err != nil {
 		return "", "", "", errors.WithStack(err)
 	}
+	if filename := contentDispositionFilename(response.Header.Get("Content-Disposition")); filename != "" {
+		err = os.WriteFile(cachePath+filenameSuffix, []byte(filename), 0600)
+	} else {
+		err = os.Remove(cachePath + filenameSuffix)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", "", "", errors.WithStack(err)
+	}
 	return cachePath, etag, actualChecksum, nil
 }
+
+// contentDispositionFilename returns the base filename suggested by a
+// Content-Disposition header, or "" if there is none.
+func contentDispositionFilename(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	filename := filepath.Base(filepath.Clean("/" + params["filename"]))
+	if filename == "/" || filename == "." {
+		return ""
+	}
+	return filename
+}
@@ -45,7 +45,7 @@ func GetSource(client *http.Client, uri string) (PackageSource, error) {
 		return HTTPSource(client, uri), errors.WithStack(err)
 
 	default:
-		return nil, errors.Errorf("unsupported URI %s", uri)
+		return nil, errors.Errorf("unsupported URI %s", util.RedactURL(uri))
 	}
 }
 
@@ -73,7 +73,7 @@ func (s *fileSource) Download(_ *ui.Task, _ *Cache, checksum string) (path strin
 		return "", "", "", errors.WithStack(err)
 	}
 	if checksum != "" && checksum != calculatedDigest {
-		return "", "", "", errors.Errorf("%s: checksum %s should have been %s", s.path, calculatedDigest, checksum)
+		return "", "", "", errors.WithStack(checksumMismatchError{s.path, calculatedDigest, checksum})
 	}
 	return s.path, "", calculatedDigest, nil
 }
@@ -15,6 +15,7 @@ var (
 	baseDistURL = "https://github.com/cashapp/hermit/releases/download/"
 	channel     = "canary"
 	version     = "devel"
+	buildDate   string
 	//go:embed builtin
 	builtin embed.FS
 )
@@ -28,6 +29,8 @@ func main() {
 	app.Main(app.Config{
 		BaseDistURL: baseDistURL + channel,
 		Version:     fmt.Sprintf("%s (%s)", version, channel),
+		Channel:     channel,
+		BuildDate:   buildDate,
 		State: state.Config{
 			Builtin: sources.NewBuiltInSource(builtin),
 		},
@@ -8,3 +8,11 @@ short-prompt = boolean # (optional)
 no-git = boolean # (optional)
 # If true Hermit will try to add the IntelliJ IDEA plugin automatically.
 idea = boolean # (optional)
+# Path to a PEM encoded CA bundle to trust, in addition to the system roots, for downloads.
+ca-bundle = string # (optional)
+# Directory for downloaded package archives, if not within the Hermit state directory.
+cache-dir = string # (optional)
+# File to append a JSON record to for every package binary executed through Hermit.
+audit-log = string # (optional)
+# Regular expressions matching arguments to replace with [REDACTED] in the audit log, eg. "^--password=".
+audit-redact = [string] # (optional)
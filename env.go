@@ -10,7 +10,9 @@ import (
 	"io/fs"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"slices"
 	"sort"
@@ -88,13 +90,40 @@ const (
 
 // Config for a Hermit environment.
 type Config struct {
-	Envars        envars.Envars `hcl:"env,optional" help:"Extra environment variables."`
-	Sources       []string      `hcl:"sources,optional" help:"Package manifest sources."`
-	ManageGit     bool          `hcl:"manage-git,optional" default:"true" help:"Whether Hermit should automatically 'git add' new packages."`
-	InheritParent bool          `hcl:"inherit-parent,optional" default:"false" help:"Whether this environment inherits a potential parent environment from one of the parent directories"`
-	AddIJPlugin   bool          `hcl:"idea,optional" default:"false" help:"Whether Hermit should automatically add the IntelliJ IDEA plugin."`
+	Envars        envars.Envars     `hcl:"env,optional" help:"Extra environment variables."`
+	Sources       []string          `hcl:"sources,optional" help:"Package manifest sources."`
+	ManageGit     bool              `hcl:"manage-git,optional" default:"true" help:"Whether Hermit should automatically 'git add' new packages."`
+	GitIgnore     []string          `hcl:"manage-git-ignore,optional" help:"Glob patterns, relative to the environment root, of Hermit managed files that manage-git should not 'git add', eg. \"bin/.*.pkg\"."`
+	InheritParent bool              `hcl:"inherit-parent,optional" default:"false" help:"Whether this environment inherits a potential parent environment from one of the parent directories"`
+	AddIJPlugin   bool              `hcl:"idea,optional" default:"false" help:"Whether Hermit should automatically add the IntelliJ IDEA plugin."`
+	Packages      []string          `hcl:"packages,optional" help:"Packages saved to this environment with 'hermit install --save'. These are installed by 'hermit install' with no arguments."`
+	RequiredEnv   []string          `hcl:"required-env,optional" help:"Environment variables that must be set when the environment is activated."`
+	StrictEnv     bool              `hcl:"strict-required-env,optional" default:"false" help:"Whether activation fails, rather than warns, when a required environment variable is not set."`
+	VersionFiles  map[string]string `hcl:"version-files,optional" help:"Package names mapped to a file, relative to the environment root, to read the package's version from when it is installed without one, eg. {\"node\": \".nvmrc\"}. A .tool-versions file is read from the line naming the package, so may be shared by several packages."`
+	Vendor        []string          `hcl:"vendor,optional" help:"Names of packages to extract into .hermit/vendor in the environment rather than the Hermit state, so they can be committed with the project."`
+	LinkApps      bool              `hcl:"link-apps,optional" default:"false" help:"Whether the Mac .app bundles of installed packages are symlinked into apps-dir, so they appear in Spotlight and Launchpad. Apps installed from a DMG are always symlinked."`
+	AppsDir       string            `hcl:"apps-dir,optional" help:"Directory to symlink Mac .app bundles into, defaults to ~/Applications. A relative path is relative to the environment root."`
 
 	GitHubTokenAuth GitHubTokenAuthConfig `hcl:"github-token-auth,block" help:"When to use GitHub token authentication."`
+	Pins            []*PinConfig          `hcl:"pin,block" help:"Channel packages pinned with 'hermit pin', which are replaced by the version they resolved to until unpinned with 'hermit unpin'."`
+}
+
+// PinConfig records a channel package pinned with Env.Pin.
+type PinConfig struct {
+	Package string `hcl:"package,label" help:"Pinned channel, eg. go@stable."`
+	Version string `hcl:"version" help:"Version the channel resolved to when it was pinned, which is installed in its place."`
+}
+
+// reference returns the reference of the version installed in place of the
+// pinned channel.
+func (p *PinConfig) reference() manifest.Reference {
+	return manifest.Reference{Name: manifest.ParseReference(p.Package).Name, Version: manifest.ParseVersion(p.Version)}
+}
+
+// matches returns true if ref refers to either the pinned channel or the
+// version installed in its place.
+func (p *PinConfig) matches(ref manifest.Reference) bool {
+	return referenceSatisfies(ref, manifest.ParseReference(p.Package)) || referenceSatisfies(ref, p.reference())
 }
 
 // GitHubTokenAuthConfig configures under what conditions
@@ -114,6 +143,7 @@ type Env struct {
 	configFile      string
 	httpClient      *http.Client
 	scriptSums      []string
+	overrideLock    bool
 
 	// Lazily initialized fields
 	lazyResolver  *manifest.Resolver
@@ -148,7 +178,8 @@ func Init(l *ui.UI, env string, distURL string, stateDir string, config Config,
 	b := l.Task(filepath.Base(env))
 	for file, perm := range envBinFiles {
 		l.Infof("  -> %s", filepath.Join(env, "bin", file))
-		if err := writeFileToEnvBin(b, useGit, file, env, vars, perm); err != nil {
+		stage := useGit && !isGitIgnored(env, config.GitIgnore, filepath.Join(bin, file))
+		if err := writeFileToEnvBin(b, stage, file, env, vars, perm); err != nil {
 			return err
 		}
 	}
@@ -167,7 +198,7 @@ func Init(l *ui.UI, env string, distURL string, stateDir string, config Config,
 				return errors.WithStack(err)
 			}
 
-			if useGit {
+			if useGit && !isGitIgnored(env, config.GitIgnore, extDepPath) {
 				if err = util.RunInDir(b, env, "git", "add", "-f", extDepPath); err != nil {
 					return errors.WithStack(err)
 				}
@@ -190,8 +221,8 @@ func Init(l *ui.UI, env string, distURL string, stateDir string, config Config,
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		if useGit {
-			if err = util.RunInDir(b, env, "git", "add", "-f", filepath.Join(bin, "hermit.hcl")); err != nil {
+		if useGit && !isGitIgnored(env, config.GitIgnore, configPath) {
+			if err = util.RunInDir(b, env, "git", "add", "-f", configPath); err != nil {
 				return errors.WithStack(err)
 			}
 		}
@@ -256,15 +287,20 @@ func FindEnvDir(binary string) (envDir string, err error) {
 	return
 }
 
-func getSources(l *ui.UI, envDir string, config *Config, state *state.State, defaultSources []string) (*sources.Sources, error) {
+func getSources(l *ui.UI, envDir string, config *Config, state *state.State, defaultSources []string, client *http.Client) (*sources.Sources, error) {
 	configuredSources := config.Sources
 	if config.Sources == nil {
 		configuredSources = defaultSources
 	}
-	ss, err := sources.ForURIs(l, state.SourcesDir(), envDir, configuredSources)
+	ss, err := sources.ForURIs(l, state.SourcesDir(), envDir, configuredSources, client)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	// Manifests added with AddManifestSource are only used for packages the
+	// configured sources do not provide.
+	if _, err := os.Stat(filepath.Join(envDir, manifestsDir)); err == nil {
+		ss.Add(manifestsSource(envDir))
+	}
 	// Always include the builtin sources required by Hermit.
 	ss.Prepend(state.Config().Builtin)
 	return ss, nil
@@ -281,6 +317,9 @@ func readConfig(configFile string) (*Config, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, configFile)
 		}
+		if err := config.Envars.CheckProtected(); err != nil {
+			return nil, errors.Wrap(err, configFile)
+		}
 	}
 	return config, nil
 }
@@ -320,6 +359,36 @@ func LoadEnvInfo(envDir string) (*EnvInfo, error) {
 	}, nil
 }
 
+// LoadEnvInfoWithConfig is like LoadEnvInfo, but reads the configuration from
+// configFile rather than bin/hermit.hcl.
+//
+// configFile must exist, and must not be in the bin directory of a different
+// environment.
+func LoadEnvInfoWithConfig(envDir, configFile string) (*EnvInfo, error) {
+	info, err := LoadEnvInfo(envDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	configFile, err = filepath.Abs(configFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	configDir := util.RealPath(filepath.Dir(configFile))
+	if _, err := os.Stat(filepath.Join(configDir, "activate-hermit")); err == nil && configDir != info.BinDir {
+		return nil, errors.Errorf("%s belongs to the environment at %s, not %s", configFile, filepath.Dir(configDir), info.Root)
+	}
+	config, err := readConfig(configFile)
+	if err != nil {
+		return nil, errors.Wrap(err, configFile)
+	}
+	info.ConfigFile = configFile
+	info.Config = config
+	return info, nil
+}
+
 // OpenEnv opens a Hermit environment.
 //
 // The environment may not exist, in which case this will succeed but subsequent operations will fail.
@@ -357,38 +426,134 @@ func (e *Env) Root() string {
 	return e.envDir
 }
 
+// ErrUnknownScript is returned by Verify if a script has an unknown SHA256 sum.
+var ErrUnknownScript = errors.New("unknown Hermit script")
+
+// envScripts are the bin scripts verified by Verify.
+var envScripts = []string{"activate-hermit", "activate-hermit.fish", "hermit"}
+
 // Verify contains valid Hermit scripts.
 func (e *Env) Verify() error {
-next:
-	for _, file := range []string{"activate-hermit", "activate-hermit.fish", "hermit"} {
+	for _, file := range envScripts {
 		path := filepath.Join(e.binDir, file)
-		hasher := sha256.New()
-		r, err := os.Open(path)
-		if os.IsNotExist(err) {
+		hash, err := scriptSHA256(path)
+		if errors.Is(err, os.ErrNotExist) {
 			if file == "activate-hermit.fish" {
 				// Fish support was added later. Older hermit envs won't have it.
-				continue next
+				continue
 			}
 			return errors.Wrapf(err, "%s is missing, not a Hermit environment?", path)
 		} else if err != nil {
 			return errors.WithStack(err)
 		}
-		_, err = io.Copy(hasher, r)
-		_ = r.Close()
-		if err != nil {
-			return errors.WithStack(err)
+		if !e.isKnownScript(hash) {
+			return errors.Wrapf(ErrUnknownScript, "%s has an unknown SHA256 signature (%s); verify that you trust this environment and run 'hermit init %s'", path, hash, e.envDir)
 		}
-		hash := hex.EncodeToString(hasher.Sum(nil))
-		for _, candidate := range e.scriptSums {
-			if hash == candidate {
-				continue next
-			}
+	}
+	return nil
+}
+
+// UnknownScripts returns the names of scripts in the bin directory with
+// unknown SHA256 sums, such as those written by a Hermit release with a bug
+// that has since been fixed. Missing scripts are ignored.
+func (e *Env) UnknownScripts() ([]string, error) {
+	var unknown []string
+	for _, file := range envScripts {
+		hash, err := scriptSHA256(filepath.Join(e.binDir, file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !e.isKnownScript(hash) {
+			unknown = append(unknown, file)
+		}
+	}
+	return unknown, nil
+}
+
+// UpgradeScripts rewrites the named bin scripts with the versions bundled
+// with this Hermit, staging them with Git if it is managed.
+//
+// "distURL" and "installScriptSHA" are substituted into the scripts as by Init.
+func (e *Env) UpgradeScripts(l *ui.UI, scripts []string, distURL, installScriptSHA string) error {
+	vars := map[string]string{
+		"HERMIT_DEFAULT_DIST_URL":      distURL,
+		"HERMIT_INSTALL_SCRIPT_SHA256": installScriptSHA,
+	}
+	task := l.Task(filepath.Base(e.envDir))
+	defer task.Done()
+	for _, file := range scripts {
+		perm, ok := envBinFiles[file]
+		if !ok {
+			return errors.Errorf("unknown Hermit script %q", file)
+		}
+		path := filepath.Join(e.binDir, file)
+		l.Infof("  -> %s", path)
+		stage := e.useGit && !isGitIgnored(e.envDir, e.config.GitIgnore, path)
+		if err := writeFileToEnvBin(task, stage, file, e.envDir, vars, perm); err != nil {
+			return errors.WithStack(err)
 		}
-		return errors.Errorf("%s has an unknown SHA256 signature (%s); verify that you trust this environment and run 'hermit init %s'", path, hash, e.envDir)
 	}
 	return nil
 }
 
+func (e *Env) isKnownScript(hash string) bool {
+	for _, candidate := range e.scriptSums {
+		if hash == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func scriptSHA256(path string) (string, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer r.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// ErrNotActivated is returned by VerifyActivation if the environment is not active.
+var ErrNotActivated = errors.New("environment is not activated")
+
+// VerifyActivation checks that "dir" is this environment, that it is the one
+// activated in "environ" by the activation scripts, and that its scripts are valid.
+func (e *Env) VerifyActivation(dir string, environ envars.Envars) error {
+	if !isSameDir(dir, e.envDir) {
+		return errors.Errorf("%s is not the environment %s", dir, e.envDir)
+	}
+	active := environ["ACTIVE_HERMIT"]
+	if active == "" {
+		return errors.Wrap(ErrNotActivated, "ACTIVE_HERMIT is not set")
+	}
+	if environ["HERMIT_ENV"] != active {
+		return errors.Wrapf(ErrNotActivated, "HERMIT_ENV %q does not match ACTIVE_HERMIT %q", environ["HERMIT_ENV"], active)
+	}
+	if !isSameDir(active, e.envDir) {
+		return errors.Wrapf(ErrNotActivated, "%s is active, not %s", active, e.envDir)
+	}
+	return errors.WithStack(e.Verify())
+}
+
+func isSameDir(a, b string) bool {
+	ainfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	binfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ainfo, binfo)
+}
+
 // Trigger an event for all installed packages.
 func (e *Env) Trigger(l *ui.UI, event manifest.Event) (messages []string, err error) {
 	pkgs, err := e.ListInstalled(l)
@@ -407,7 +572,8 @@ func (e *Env) Trigger(l *ui.UI, event manifest.Event) (messages []string, err er
 
 // TriggerForPackage triggers an event for a single package.
 func (e *Env) TriggerForPackage(l *ui.UI, event manifest.Event, pkg *manifest.Package) (messages []string, err error) {
-	messages, err = pkg.Trigger(l, event)
+	defer e.state.Schedule(pkg)()
+	messages, err = e.state.Trigger(l, event, pkg)
 	if err != nil {
 		return nil, errors.Wrapf(err, "%s: on %s", pkg, event)
 	}
@@ -438,7 +604,7 @@ func (e *Env) LinkedBinaries(pkg *manifest.Package) (binaries []string, err erro
 		if err != nil || !strings.HasSuffix(link, ".pkg") {
 			continue
 		}
-		ref := e.referenceFromBinLink(link)
+		ref := referenceFromBinLink(link)
 		if ref.String() == pkg.String() {
 			binaries = append(binaries, bin)
 		}
@@ -446,8 +612,117 @@ func (e *Env) LinkedBinaries(pkg *manifest.Package) (binaries []string, err erro
 	return
 }
 
+// PruneBinaries removes links in the bin directory whose package is no
+// longer installed, or whose package no longer provides a binary of that
+// name, returning the paths removed.
+//
+// Links to packages that can not be resolved are left in place.
+func (e *Env) PruneBinaries(l *ui.UI) ([]string, error) {
+	files, err := os.ReadDir(e.binDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var pruned []string
+	for _, file := range files {
+		bin := filepath.Join(e.binDir, file.Name())
+		link, err := os.Readlink(bin)
+		if err != nil || !strings.HasSuffix(link, ".pkg") {
+			continue
+		}
+		ref := referenceFromBinLink(link)
+		task := l.Task(ref.String())
+		if _, err := os.Stat(filepath.Join(e.binDir, filepath.Base(link))); os.IsNotExist(err) {
+			task.Infof("Removing %s, %s is not installed", file.Name(), ref)
+		} else {
+			pkg, err := e.Resolve(l, manifest.ExactSelector(ref), false)
+			if err != nil {
+				task.Warnf("Keeping %s, could not resolve %s: %s", file.Name(), ref, err)
+				continue
+			}
+			if pkg.ProvidesBinary(file.Name()) {
+				continue
+			}
+			task.Infof("Removing %s, it is not provided by %s", file.Name(), ref)
+		}
+		if err := e.unlink(task, bin); err != nil {
+			return pruned, errors.WithStack(err)
+		}
+		pruned = append(pruned, bin)
+	}
+	return pruned, nil
+}
+
+// Relink rebuilds the links in the bin directory for every installed
+// package, returning the packages relinked.
+//
+// Links to packages, and any other symlinks in the way of their binaries,
+// are removed before the installed packages are linked again. Packages are
+// only extracted if they are missing from the state, and only downloaded if
+// they are also missing from the cache.
+func (e *Env) Relink(l *ui.UI) ([]*manifest.Package, error) {
+	refs, err := e.ListInstalledReferences()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Resolve everything up front, so nothing is unlinked that can't be relinked.
+	pkgs := make([]*manifest.Package, 0, len(refs))
+	binaries := map[string]bool{}
+	for _, ref := range refs {
+		pkg, err := e.Resolve(l, manifest.ExactSelector(ref), false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not resolve installed package %s", ref)
+		}
+		task := l.Task(pkg.Reference.String())
+		err = e.state.CacheAndUnpack(task, pkg)
+		task.Done()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		files, err := pkg.ResolveBinaries()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, file := range files {
+			binaries[filepath.Base(file)] = true
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	files, err := os.ReadDir(e.binDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	task := l.Task("relink")
+	for _, file := range files {
+		bin := filepath.Join(e.binDir, file.Name())
+		link, err := os.Readlink(bin)
+		if err != nil {
+			continue
+		}
+		if !strings.HasSuffix(link, ".pkg") && !strings.HasSuffix(file.Name(), ".pkg") && !binaries[file.Name()] {
+			continue
+		}
+		if err := e.unlink(task, bin); err != nil {
+			task.Done()
+			return nil, errors.WithStack(err)
+		}
+	}
+	task.Done()
+	for _, pkg := range pkgs {
+		task := l.Task(pkg.Reference.String())
+		err := e.linkPackage(task, pkg)
+		task.Done()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return pkgs, nil
+}
+
 // Uninstall uninstalls a single package.
 func (e *Env) Uninstall(l *ui.UI, pkg *manifest.Package) (*shell.Changes, error) {
+	if err := e.checkUnlocked(); err != nil {
+		return nil, err
+	}
 	return e.uninstall(l.Task(pkg.Reference.String()), pkg)
 }
 
@@ -468,6 +743,11 @@ func (e *Env) uninstall(l *ui.Task, pkg *manifest.Package) (*shell.Changes, erro
 		return nil, errors.WithStack(err)
 	}
 
+	err = e.removeVendored(l, pkg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	changes := shell.NewChanges(envars.Parse(os.Environ()))
 	changes.Remove = ops
 
@@ -500,11 +780,11 @@ func (e *Env) unlinkPackage(l *ui.Task, pkg *manifest.Package) error {
 			return errors.WithStack(err)
 		}
 	}
-	return nil
+	return errors.WithStack(e.unlinkApps(task, pkg))
 }
 
 func (e *Env) unlink(l *ui.Task, path string) error {
-	if e.useGit {
+	if e.manageGit(path) {
 		err := util.RunInDir(l, e.envDir, "git", "rm", "-f", path)
 		if err != nil {
 			l.Errorf("non-fatal: %s", err)
@@ -547,7 +827,7 @@ func (e *Env) Test(l *ui.UI, pkg *manifest.Package) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	cmd.Env = e.envarsFromOps(true, e.allEnvarOpsForPackages(deps, pkg))
+	cmd.Env = e.envarsFromOps(true, e.allEnvarOpsForPackages(l, deps, pkg))
 	err = cmd.Run()
 	if err != nil {
 		return errors.Wrap(err, out.String())
@@ -563,6 +843,81 @@ func (e *Env) Unpack(l *ui.Task, p *manifest.Package) error {
 
 // Install package. If a package with same name exists, uninstall it first.
 func (e *Env) Install(l *ui.UI, pkg *manifest.Package) (*shell.Changes, error) {
+	return e.installRecorded(l, pkg, nil)
+}
+
+// InstallByName resolves a package by name, eg. "protoc" or "protoc-3.7.2",
+// and installs it, syncing sources if the package is not found.
+//
+// If the package still can't be found, the returned error matches
+// manifest.ErrUnknownPackage.
+func (e *Env) InstallByName(l *ui.UI, name string) (*manifest.Package, *shell.Changes, error) {
+	selector, err := manifest.ParseGlobSelector(name)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	pkg, err := e.Resolve(l, selector, true)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	changes, err := e.Install(l, pkg)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return pkg, changes, nil
+}
+
+// InstallTransaction installs a set of packages into an environment such that
+// if any fails, those already installed can be rolled back.
+//
+// Configuration is not written by the transaction, so callers should only
+// save packages to the configuration once all installs have succeeded.
+type InstallTransaction struct {
+	env         *Env
+	installed   []*manifest.Package
+	uninstalled []*manifest.Package
+}
+
+// BeginInstall starts a new InstallTransaction.
+func (e *Env) BeginInstall() *InstallTransaction {
+	return &InstallTransaction{env: e}
+}
+
+// Install a package as part of the transaction. See Env.Install.
+func (t *InstallTransaction) Install(l *ui.UI, pkg *manifest.Package) (*shell.Changes, error) {
+	return t.env.installRecorded(l, pkg, t)
+}
+
+// Rollback unlinks all packages installed by the transaction, and relinks any
+// packages they replaced.
+func (t *InstallTransaction) Rollback(l *ui.UI) error {
+	var errs []error
+	for i := len(t.installed) - 1; i >= 0; i-- {
+		pkg := t.installed[i]
+		task := l.Task(pkg.Reference.String())
+		task.Debugf("Rolling back install of %s", pkg)
+		if err := t.env.unlinkPackage(task, pkg); err != nil {
+			errs = append(errs, errors.Wrap(err, pkg.String()))
+		}
+	}
+	for i := len(t.uninstalled) - 1; i >= 0; i-- {
+		pkg := t.uninstalled[i]
+		task := l.Task(pkg.Reference.String())
+		task.Debugf("Restoring %s", pkg)
+		if err := t.env.linkPackage(task, pkg); err != nil {
+			errs = append(errs, errors.Wrap(err, pkg.String()))
+		}
+	}
+	t.installed = nil
+	t.uninstalled = nil
+	return errors.Join(errs...)
+}
+
+// installRecorded installs pkg, recording changes to the environment in tx if it is not nil.
+func (e *Env) installRecorded(l *ui.UI, pkg *manifest.Package, tx *InstallTransaction) (*shell.Changes, error) {
+	if err := e.checkUnlocked(); err != nil {
+		return nil, err
+	}
 	task := l.Task(pkg.Reference.String())
 
 	installed, err := e.ListInstalled(l)
@@ -582,8 +937,17 @@ func (e *Env) Install(l *ui.UI, pkg *manifest.Package) (*shell.Changes, error) {
 			if err != nil {
 				return nil, errors.WithStack(err)
 			}
+			if tx != nil {
+				tx.uninstalled = append(tx.uninstalled, ipkg)
+			}
 			allChanges = allChanges.Merge(changes)
 			didUninstall = true
+			// Reinstalling a mutable package discards any changes made to it.
+			if ipkg.Reference.Compare(pkg.Reference) == 0 {
+				if err := e.state.ResetPackage(task, pkg); err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
 		}
 	}
 
@@ -602,6 +966,18 @@ func (e *Env) Install(l *ui.UI, pkg *manifest.Package) (*shell.Changes, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if tx != nil {
+		tx.installed = append(tx.installed, pkg)
+	}
+	resulting := []*manifest.Package{pkg}
+	for _, ipkg := range installed {
+		if ipkg.Reference.Name != pkg.Reference.Name {
+			resulting = append(resulting, ipkg)
+		}
+	}
+	for _, collision := range e.envarCollisions(resulting, pkg) {
+		task.Warnf("%s", collision)
+	}
 
 	return allChanges.Merge(changes), nil
 }
@@ -651,11 +1027,15 @@ func (e *Env) ensureRuntimeDepsPresent(l *ui.UI, p *manifest.Package) ([]*manife
 		return nil, errors.WithStack(err)
 	}
 	result := make([]*manifest.Package, 0, len(deps))
+	p.RuntimeDepPaths = nil
 	for _, pkg := range deps {
-		if err := e.state.CacheAndUnpack(l.Task(p.Reference.String()), pkg); err != nil {
-			return nil, errors.WithStack(err)
-		}
 		result = append(result, pkg)
+		p.RuntimeDepPaths = append(p.RuntimeDepPaths, filepath.Join(e.state.BinaryDir(), pkg.Reference.String()))
+	}
+	// Download p along with its dependencies, it is extracted last as its
+	// unpack hooks may use them.
+	if err := e.state.CacheAll(l.Task(p.Reference.String()), append(result, p)); err != nil {
+		return nil, errors.WithStack(err)
 	}
 	return result, nil
 }
@@ -674,21 +1054,28 @@ func (e *Env) writePackageState(pkgs ...*manifest.Package) error {
 func (e *Env) install(l *ui.UI, p *manifest.Package) (*shell.Changes, error) {
 	task := l.Task(p.Reference.String())
 
-	pkgs, err := e.ensureRuntimeDepsPresent(l, p)
-	if err != nil {
+	if err := checkSystemRequires(p); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	p.UpdatedAt = time.Now()
 	log := task.SubTask("install")
 	log.Infof("Installing %s", p)
-	log.Debugf("From %s", p.Source)
+	log.Debugf("From %s", util.RedactURL(p.Source))
 	log.Debugf("To %s", p.Dest)
-	err = e.state.CacheAndUnpack(task, p)
+	// Also downloads and extracts p.
+	pkgs, err := e.ensureRuntimeDepsPresent(l, p)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if err := e.stageVendored(task, p); err != nil {
+		return nil, errors.WithStack(err)
+	}
 	if _, err := os.Stat(e.pkgLink(p)); os.IsNotExist(err) {
 		if err = e.linkPackage(task, p); err != nil {
+			// Remove any links created before the failure.
+			if _, lerr := os.Lstat(e.pkgLink(p)); lerr == nil {
+				_ = e.unlinkPackage(task, p)
+			}
 			return nil, errors.WithStack(err)
 		}
 		pkgs = append(pkgs, p)
@@ -700,19 +1087,133 @@ func (e *Env) install(l *ui.UI, p *manifest.Package) (*shell.Changes, error) {
 	return changes, errors.WithStack(e.writePackageState(pkgs...))
 }
 
+// stageVendored adds p to Git if it is extracted within the environment, such
+// as a vendored package, and Git is managed.
+func (e *Env) stageVendored(l *ui.Task, p *manifest.Package) error {
+	// Mutable packages are changed in place, so are not committed.
+	if p.Mutable || !e.withinEnv(p.Dest) || !e.manageGit(p.Dest) {
+		return nil
+	}
+	return util.RunInDir(l, e.envDir, "git", "add", "-f", p.Dest)
+}
+
+// removeVendored removes p if it was extracted within the environment,
+// unstaging it from Git first if Git is managed.
+func (e *Env) removeVendored(l *ui.Task, p *manifest.Package) error {
+	if !e.withinEnv(p.Dest) {
+		return nil
+	}
+	if e.manageGit(p.Dest) {
+		err := util.RunInDir(l, e.envDir, "git", "rm", "-r", "-q", "--cached", "--ignore-unmatch", p.Dest)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(e.state.RemovePackage(l, p))
+}
+
+// withinEnv returns true if path is within the environment.
+func (e *Env) withinEnv(path string) bool {
+	rel, err := filepath.Rel(e.envDir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+var systemToolVersionRe = regexp.MustCompile(`\d+(?:\.\d+)+`)
+
+// checkSystemRequires verifies that the tools p requires from the host system
+// are on the $PATH and, if constrained, that their "--version" output
+// satisfies the constraint.
+func checkSystemRequires(p *manifest.Package) error {
+	for _, requirement := range p.SystemRequires {
+		req, err := manifest.ParseSystemRequirement(requirement)
+		if err != nil {
+			return errors.Wrap(err, p.String())
+		}
+		bin, err := exec.LookPath(req.Name)
+		if err != nil {
+			return errors.Errorf("%s requires %s to be installed on the system", p, req)
+		}
+		if !req.Version.IsSet() {
+			continue
+		}
+		output, err := exec.Command(bin, "--version").CombinedOutput() // nolint: gosec
+		if err != nil {
+			return errors.Wrapf(err, "%s: could not determine the version of %s", p, bin)
+		}
+		version := systemToolVersionRe.FindString(string(output))
+		if version == "" {
+			return errors.Errorf("%s: could not determine the version of %s from its --version output", p, bin)
+		}
+		if !req.Satisfied(manifest.ParseVersion(version)) {
+			return errors.Errorf("%s requires %s but %s is version %s", p, req, bin, version)
+		}
+	}
+	return nil
+}
+
 // Upgrade package.
 //
 // If an upgrade does not occur, returns all nils.
 func (e *Env) Upgrade(l *ui.UI, pkg *manifest.Package) (*shell.Changes, *manifest.Package, error) {
+	if err := e.checkUnlocked(); err != nil {
+		return nil, nil, err
+	}
 	task := l.Task(pkg.Reference.String())
 
 	if pkg.Reference.IsChannel() {
 		err := e.state.UpgradeChannel(task, pkg)
 		return nil, nil, errors.WithStack(err)
 	}
+	if pin := e.pinned(pkg.Reference); pin != nil {
+		task.Warnf("%s is pinned from %s, run \"hermit unpin %s\" to upgrade it", pkg, pin.Package, pkg.Reference.Name)
+		return nil, nil, nil
+	}
 	return e.upgradeVersion(l, pkg)
 }
 
+// UpgradeResult is the outcome of upgrading a single package with UpgradeAll.
+type UpgradeResult struct {
+	// Package as it was before the upgrade.
+	Package *manifest.Package
+	// Upgraded package, or nil if the package was unchanged or failed to upgrade.
+	Upgraded *manifest.Package
+	Changes  *shell.Changes
+	Err      error
+}
+
+// UpgradeAll upgrades each of the given packages, continuing past individual
+// failures and returning the outcome for every package.
+func (e *Env) UpgradeAll(l *ui.UI, pkgs []*manifest.Package) []UpgradeResult {
+	results := make([]UpgradeResult, 0, len(pkgs))
+	locked := e.checkUnlocked()
+	for _, pkg := range pkgs {
+		result := UpgradeResult{Package: pkg}
+		if locked != nil {
+			result.Err = locked
+			results = append(results, result)
+			continue
+		}
+		if pkg.Pinned {
+			l.Task(pkg.Reference.String()).Debugf("Upgrade skipped, pinned")
+			results = append(results, result)
+			continue
+		}
+		if pkg.Reference.IsChannel() {
+			// Channel upgrades happen in place, so detect them by a change in ETag.
+			previous := *pkg
+			result.Err = e.state.UpgradeChannel(l.Task(pkg.Reference.String()), pkg)
+			if result.Err == nil && pkg.ETag != previous.ETag {
+				result.Package = &previous
+				result.Upgraded = pkg
+			}
+		} else {
+			result.Changes, result.Upgraded, result.Err = e.upgradeVersion(l, pkg)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
 // ResolveLink returns the package for a hermit bin dir link.
 //
 // Link chains are in the form
@@ -737,7 +1238,7 @@ func (e *Env) ResolveLink(l *ui.UI, executable string) (pkg *manifest.Package, b
 	if !found {
 		return nil, "", errors.Errorf("%s: could not find Hermit .pkg in symlink chain", executable)
 	}
-	ref := e.referenceFromBinLink(link)
+	ref := referenceFromBinLink(link)
 	pkg, err = e.Resolve(l, manifest.ExactSelector(ref), true)
 	if err != nil {
 		return nil, "", errors.WithStack(err)
@@ -754,19 +1255,16 @@ func (e *Env) ResolveLink(l *ui.UI, executable string) (pkg *manifest.Package, b
 func (e *Env) Exec(l *ui.UI, pkg *manifest.Package, binary string, args []string, deps map[string]*manifest.Package) error {
 	b := l.Task(pkg.Reference.String())
 	timer := ui.LogElapsed(l, "exec")
-	err := e.state.CacheAndUnpack(l.Task(pkg.Reference.String()), pkg)
-	if err != nil {
-		return errors.WithStack(err)
-	}
+	pkgs := []*manifest.Package{pkg}
 	for _, dep := range deps {
-		if dep.Reference.Compare(pkg.Reference) == 0 {
-			continue
-		}
-		err := e.state.CacheAndUnpack(l.Task(dep.Reference.String()), dep)
-		if err != nil {
-			return errors.WithStack(err)
+		if dep.Reference.Compare(pkg.Reference) != 0 {
+			pkgs = append(pkgs, dep)
 		}
 	}
+	err := e.state.CacheAll(b, pkgs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	pkg, err = e.Resolve(l, manifest.ExactSelector(pkg.Reference), true)
 	if err != nil {
 		return errors.WithStack(err)
@@ -778,6 +1276,9 @@ func (e *Env) Exec(l *ui.UI, pkg *manifest.Package, binary string, args []string
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if err := e.triggerExec(l, pkg); err != nil {
+		return errors.WithStack(err)
+	}
 	binaries, err := pkg.ResolveBinaries()
 	if err != nil {
 		return errors.WithStack(err)
@@ -787,7 +1288,7 @@ func (e *Env) Exec(l *ui.UI, pkg *manifest.Package, binary string, args []string
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	ops := e.allEnvarOpsForPackages(runtimeDeps, pkg, installed...)
+	ops := e.allEnvarOpsForPackages(l, runtimeDeps, pkg, installed...)
 	packageHermitBin, err := e.getPackageRuntimeEnvops(pkg)
 	if err != nil {
 		return errors.WithStack(err)
@@ -817,6 +1318,31 @@ func (e *Env) Exec(l *ui.UI, pkg *manifest.Package, binary string, args []string
 	return errors.Errorf("%s: could not find binary %q", pkg, binary)
 }
 
+// ExecHookEnvar is set while the exec hooks of a package run, so that
+// binaries executed by the hooks do not trigger exec hooks themselves.
+const ExecHookEnvar = "HERMIT_EXEC_HOOK"
+
+// triggerExec runs the exec hooks of pkg, unless called from within a hook.
+func (e *Env) triggerExec(l *ui.UI, pkg *manifest.Package) error {
+	if os.Getenv(ExecHookEnvar) != "" {
+		return nil
+	}
+	if err := os.Setenv(ExecHookEnvar, pkg.Reference.String()); err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Unsetenv(ExecHookEnvar) // nolint
+	messages, err := e.TriggerForPackage(l, manifest.EventExec, pkg)
+	if err != nil {
+		return errors.Wrap(err, "exec hook failed")
+	}
+	w := l.WriterAt(ui.LevelInfo)
+	defer w.Sync() // nolint
+	for _, message := range messages {
+		fmt.Fprintln(w, message)
+	}
+	return nil
+}
+
 func (e *Env) getPackageRuntimeEnvops(pkg *manifest.Package) (envars.Op, error) {
 	// If the package contains a Hermit env, add that to the PATH for runtime dependencies
 	pkgEnvInfo, err := LoadEnvInfo(pkg.Root)
@@ -861,10 +1387,100 @@ func (e *Env) Resolve(l *ui.UI, selector manifest.Selector, syncOnMissing bool)
 	return resolved, nil
 }
 
-// ValidationOptions for manifest validation
-type ValidationOptions struct {
-	// CheckSources if true, check that the package sources are reachable
-	CheckSources bool
+// Explain how a package reference resolves, reporting which manifest layers
+// contributed to each field.
+func (e *Env) Explain(l *ui.UI, selector manifest.Selector) (*manifest.Package, []manifest.Provenance, error) {
+	resolver, err := e.resolver(l)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return resolver.Explain(l, selector)
+}
+
+// ResolveForPlatform resolves a package and its direct and runtime
+// dependencies for a platform other than the host.
+//
+// The returned packages are not associated with the environment and must not be
+// extracted or linked, but may be downloaded.
+func (e *Env) ResolveForPlatform(l *ui.UI, p platform.Platform, selector manifest.Selector) ([]*manifest.Package, error) {
+	sources, err := e.sources(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resolver, err := manifest.New(sources, manifest.Config{
+		Env:      e.envDir,
+		State:    e.state.Root(),
+		Vendor:   e.config.Vendor,
+		Platform: p,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pkg, err := resolver.Resolve(l, selector)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pkgs := []*manifest.Package{pkg}
+	seen := map[string]bool{pkg.Reference.String(): true}
+	add := func(dep *manifest.Package) {
+		if !seen[dep.Reference.String()] {
+			seen[dep.Reference.String()] = true
+			pkgs = append(pkgs, dep)
+		}
+	}
+	for i := 0; i < len(pkgs); i++ {
+		for _, ref := range pkgs[i].RuntimeDeps {
+			if seen[ref.String()] {
+				continue
+			}
+			dep, err := resolver.Resolve(l, manifest.ExactSelector(ref))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			add(dep)
+		}
+		for _, req := range pkgs[i].Requires {
+			dep, err := resolveRequirement(l, resolver, req)
+			if err != nil {
+				return nil, errors.Wrap(err, pkgs[i].String())
+			}
+			add(dep)
+		}
+	}
+	return pkgs, nil
+}
+
+// resolveRequirement resolves a "requires" entry of a package with resolver,
+// as ResolveWithDeps does but without consulting the installed packages or
+// prompting to choose between virtual providers.
+func resolveRequirement(l *ui.UI, resolver *manifest.Resolver, req string) (*manifest.Package, error) {
+	if ref := manifest.ParseReference(req); ref.IsChannel() {
+		pkg, err := resolver.Resolve(l, manifest.ExactSelector(ref))
+		return pkg, errors.WithStack(err)
+	}
+	virtual, err := resolver.ResolveVirtual(req)
+	if errors.Is(err, manifest.ErrUnknownPackage) {
+		sel, err := manifest.ParseGlobSelector(req)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		pkg, err := resolver.Resolve(l, sel)
+		return pkg, errors.WithStack(err)
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(virtual) > 1 {
+		return nil, errors.Errorf("multiple packages satisfy the required dependency %q, please download one of the following explicitly: %s", req, strings.Join(packageCandidates(virtual), ", "))
+	}
+	// Virtual providers are unversioned, so resolve the latest version.
+	pkg, err := resolver.Resolve(l, manifest.NameSelector(virtual[0].Reference.Name))
+	return pkg, errors.WithStack(err)
+}
+
+// ValidationOptions for manifest validation
+type ValidationOptions struct {
+	// CheckSources if true, check that the package sources are reachable
+	CheckSources bool
 }
 
 // ValidateManifest with given name.
@@ -902,8 +1518,9 @@ func (e *Env) validateReference(l *ui.UI, srcs *sources.Sources, ref manifest.Re
 	var warnings []string
 	for _, p := range platform.Core {
 		resolver, err := manifest.New(srcs, manifest.Config{
-			Env:   e.envDir,
-			State: e.state.Root(),
+			Env:    e.envDir,
+			State:  e.state.Root(),
+			Vendor: e.config.Vendor,
 			Platform: platform.Platform{
 				OS:   p.OS,
 				Arch: p.Arch,
@@ -951,6 +1568,49 @@ func (e *Env) ResolveVirtual(l *ui.UI, name string) ([]*manifest.Package, error)
 	return resolved, nil
 }
 
+// WhichProvides finds packages providing the command name. See manifest.Resolver.WhichProvides.
+func (e *Env) WhichProvides(l *ui.UI, name string) (manifest.Packages, error) {
+	resolver, err := e.resolver(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pkgs, err := resolver.WhichProvides(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, pkg := range pkgs {
+		e.readPackageState(pkg)
+	}
+	return pkgs, nil
+}
+
+// ResolveBinary resolves the single package providing a binary named name.
+//
+// An error listing the candidates is returned if multiple packages provide the binary.
+func (e *Env) ResolveBinary(l *ui.UI, name string) (*manifest.Package, error) {
+	resolver, err := e.resolver(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resolved, err := resolver.ResolveBinary(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pkg := resolved[0]
+	if len(resolved) > 1 {
+		candidates := packageCandidates(resolved)
+		if !l.Interactive() {
+			return nil, errors.Errorf("multiple packages provide the binary %q, please install one of the following: %s", name, strings.Join(candidates, ", "))
+		}
+		pkg, err = choosePackage(l, fmt.Sprintf("multiple packages provide the binary %q, which should be installed?", name), resolved)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	e.readPackageState(pkg)
+	return pkg, nil
+}
+
 // UpdateUsage updates the package usage time stamps in the underlying database.
 // if the package was not previously present, it is inserted to the DB.
 func (e *Env) UpdateUsage(pkg *manifest.Package) error {
@@ -965,19 +1625,115 @@ func (e *Env) UpdateUsage(pkg *manifest.Package) error {
 //
 // This function is much faster than ListInstalled, if all you need is the Reference.
 func (e *Env) ListInstalledReferences() ([]manifest.Reference, error) {
-	matches, err := filepath.Glob(filepath.Join(e.binDir, ".*.pkg"))
+	return listInstalledReferences(e.binDir)
+}
+
+// ListInstalledReferences from the environment, without opening it.
+func (i *EnvInfo) ListInstalledReferences() ([]manifest.Reference, error) {
+	return listInstalledReferences(i.BinDir)
+}
+
+func listInstalledReferences(binDir string) ([]manifest.Reference, error) {
+	matches, err := filepath.Glob(filepath.Join(binDir, ".*.pkg"))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	sort.Strings(matches)
 	out := []manifest.Reference{}
 	for _, pkgLink := range matches {
-		ref := e.referenceFromBinLink(pkgLink)
+		ref := referenceFromBinLink(pkgLink)
 		out = append(out, ref)
 	}
 	return out, nil
 }
 
+// lockFile is the marker in the bin directory that locks the installed packages of an environment.
+const lockFile = ".hermit.lock"
+
+// Lock the set of installed packages, so that Install, Uninstall and Upgrade
+// fail until the environment is unlocked.
+//
+// A fingerprint of the installed packages is recorded in the lock, so that
+// changes made regardless can be reported.
+func (e *Env) Lock(l *ui.UI) error {
+	fingerprint, err := e.installedFingerprint()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	path := filepath.Join(e.binDir, lockFile)
+	if err := os.WriteFile(path, []byte(fingerprint+"\n"), 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	if e.manageGit(path) {
+		return util.RunInDir(l.Task("lock"), e.envDir, "git", "add", "-f", path)
+	}
+	return nil
+}
+
+// Unlock an environment locked with Lock. Unlocking an unlocked environment is a no-op.
+func (e *Env) Unlock(l *ui.UI) error {
+	path := filepath.Join(e.binDir, lockFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return errors.WithStack(e.unlink(l.Task("unlock"), path))
+}
+
+// Locked returns true if the environment is locked, and whether its installed
+// packages have changed since it was locked.
+func (e *Env) Locked() (locked bool, changed bool, err error) {
+	data, err := os.ReadFile(filepath.Join(e.binDir, lockFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, errors.WithStack(err)
+	}
+	fingerprint, err := e.installedFingerprint()
+	if err != nil {
+		return false, false, errors.WithStack(err)
+	}
+	return true, strings.TrimSpace(string(data)) != fingerprint, nil
+}
+
+// OverrideLock allows Install, Uninstall and Upgrade to change the packages of
+// a locked environment, eg. for "--force".
+func (e *Env) OverrideLock() {
+	e.overrideLock = true
+}
+
+// checkUnlocked returns an error if the installed packages of the environment
+// are locked against changes.
+func (e *Env) checkUnlocked() error {
+	if e.overrideLock {
+		return nil
+	}
+	locked, changed, err := e.Locked()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !locked {
+		return nil
+	}
+	msg := fmt.Sprintf("environment %s is locked", e.envDir)
+	if changed {
+		msg += " (its packages have changed since it was locked)"
+	}
+	return errors.Errorf(`%s, run "hermit env unlock" or pass --force to change its packages`, msg)
+}
+
+// installedFingerprint returns a digest of the references of the installed packages.
+func (e *Env) installedFingerprint() (string, error) {
+	refs, err := e.ListInstalledReferences()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	h := sha256.New()
+	for _, ref := range refs {
+		fmt.Fprintln(h, ref)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // ListInstalled packages from this environment.
 func (e *Env) ListInstalled(l *ui.UI) ([]*manifest.Package, error) {
 	refs, err := e.ListInstalledReferences()
@@ -995,6 +1751,54 @@ func (e *Env) ListInstalled(l *ui.UI) ([]*manifest.Package, error) {
 	return out, nil
 }
 
+// OutdatedPackage is an installed package with a newer version, or a changed
+// channel, available.
+type OutdatedPackage struct {
+	Package *manifest.Package
+	// Upgrade is the version "hermit upgrade" would install, if newer.
+	Upgrade manifest.Reference
+	// Latest is the highest available version, if newer.
+	Latest manifest.Reference
+	// ChannelUpdated is true if the source of a channel package has changed
+	// since it was last fetched.
+	ChannelUpdated bool
+}
+
+// Outdated returns the installed packages that have newer versions available
+// in the sources, or channels whose source has changed, without upgrading them.
+func (e *Env) Outdated(l *ui.UI) ([]OutdatedPackage, error) {
+	pkgs, err := e.ListInstalled(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var out []OutdatedPackage
+	for _, pkg := range pkgs {
+		outdated := OutdatedPackage{Package: pkg}
+		if pkg.Reference.IsChannel() {
+			outdated.ChannelUpdated, err = e.state.ChannelUpdateAvailable(l.Task(pkg.Reference.String()), pkg)
+			if err != nil {
+				l.Warnf("Could not check updates for %s: %s", pkg.Reference, err)
+			}
+		} else {
+			outdated.Upgrade = e.newerVersion(l, pkg, manifest.PrefixSelector(pkg.Reference.Major()))
+			outdated.Latest = e.newerVersion(l, pkg, manifest.MustParseGlobSelector(pkg.Reference.Name+"-*"))
+		}
+		if outdated.Upgrade.IsSet() || outdated.Latest.IsSet() || outdated.ChannelUpdated {
+			out = append(out, outdated)
+		}
+	}
+	return out, nil
+}
+
+// newerVersion returns the version matching selector if it is newer than pkg.
+func (e *Env) newerVersion(l *ui.UI, pkg *manifest.Package, selector manifest.Selector) manifest.Reference {
+	resolved, err := e.Resolve(l, selector, false)
+	if err != nil || !pkg.Reference.Less(resolved.Reference) {
+		return manifest.Reference{}
+	}
+	return resolved.Reference
+}
+
 // Envars returns the fully expanded envars for this environment.
 //
 // PATH, HERMIT_BIN and HERMIT_ENV will always be explicitly set, plus all
@@ -1012,6 +1816,16 @@ func (e *Env) Envars(l *ui.UI, inherit bool) ([]string, error) {
 	return e.envarsFromOps(inherit, ops), nil
 }
 
+// Path returns the value of PATH in the activated environment, with the
+// environment's entries applied in the same order as activation.
+func (e *Env) Path(l *ui.UI) (string, error) {
+	ops, err := e.EnvOps(l)
+	if err != nil {
+		return "", err
+	}
+	return envars.Parse(os.Environ()).Apply(e.Root(), ops).Combined()["PATH"], nil
+}
+
 // EnvOps returns the envar mutation operations for this environment.
 //
 // PATH, HERMIT_BIN and HERMIT_ENV will always be explicitly set, plus all
@@ -1038,22 +1852,185 @@ func (e *Env) EnvOps(l *ui.UI) (envars.Ops, error) {
 	if err != nil {
 		return nil, err
 	}
-	return append(ops, e.allEnvarOpsForPackages(nil, nil, pkgs...)...), nil
+	return append(ops, e.allEnvarOpsForPackages(l, nil, nil, pkgs...)...), nil
 }
 
 // SetEnv sets an extra environment variable.
 func (e *Env) SetEnv(key, value string) error {
-	e.config.Envars[key] = value
-	data, err := hcl.Marshal(e.config)
-	if err != nil {
+	if err := (envars.Envars{key: value}).CheckProtected(); err != nil {
 		return errors.WithStack(err)
 	}
-	return os.WriteFile(e.configFile, data, 0600)
+	e.config.Envars[key] = value
+	return e.writeConfig()
 }
 
 // DelEnv deletes a custom environment variable.
 func (e *Env) DelEnv(key string) error {
 	delete(e.config.Envars, key)
+	return e.writeConfig()
+}
+
+// RenameEnv renames a custom environment variable, preserving its value.
+//
+// The returned changes unset the old variable and set the new one.
+func (e *Env) RenameEnv(from, to string) (*shell.Changes, error) {
+	value, ok := e.config.Envars[from]
+	if !ok {
+		return nil, errors.Errorf("%s is not set in %s", from, e.configFile)
+	}
+	if _, ok := e.config.Envars[to]; ok {
+		return nil, errors.Errorf("%s is already set in %s", to, e.configFile)
+	}
+	if err := (envars.Envars{to: value}).CheckProtected(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.config.Envars[to] = value
+	delete(e.config.Envars, from)
+	if err := e.writeConfig(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	changes := shell.NewChanges(envars.Parse(os.Environ()))
+	changes.Remove = envars.Infer(envars.Envars{from: value}.System())
+	changes.Add = envars.Infer(envars.Envars{to: value}.System())
+	return changes, nil
+}
+
+// CheckRequiredEnv verifies that all environment variables required by the
+// environment configuration are set in "environ".
+//
+// Missing variables are logged as a warning, or returned as an error if the
+// environment is configured to be strict.
+func (e *Env) CheckRequiredEnv(l ui.Logger, environ envars.Envars) error {
+	var missing []string
+	for _, name := range e.config.RequiredEnv {
+		if environ[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("this environment requires %s to be set, see %s", strings.Join(missing, ", "), e.configFile)
+	if e.config.StrictEnv {
+		return errors.New(msg)
+	}
+	l.Warnf("%s", msg)
+	return nil
+}
+
+// ApplyVersionFiles returns selectors with the version of each package that
+// has none filled in from the environment's version files, if present.
+//
+// Explicit versions and channels always take precedence over version files.
+func (e *Env) ApplyVersionFiles(l ui.Logger, selectors []manifest.GlobSelector) ([]manifest.GlobSelector, error) {
+	out := make([]manifest.GlobSelector, 0, len(selectors))
+	for _, selector := range selectors {
+		file, ok := e.config.VersionFiles[selector.Name()]
+		if selector.IsFullyQualified() || !ok {
+			out = append(out, selector)
+			continue
+		}
+		version, err := readVersionFile(filepath.Join(e.envDir, file), selector.Name())
+		if errors.Is(err, os.ErrNotExist) || (err == nil && version == "") {
+			out = append(out, selector)
+			continue
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		resolved, err := manifest.ParseGlobSelector(selector.Name() + "-" + versionGlob(version))
+		if err != nil {
+			return nil, errors.Wrap(err, file)
+		}
+		l.Infof("Using %s version %s from %s", selector.Name(), version, file)
+		out = append(out, resolved)
+	}
+	return out, nil
+}
+
+// readVersionFile returns the version of pkg declared in path.
+//
+// This is the first line that is not blank or a comment, or for a
+// .tool-versions file the version on the line naming pkg. A .tool-versions
+// file without a line naming pkg declares no version, and "" is returned.
+func readVersionFile(path, pkg string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	toolVersions := filepath.Base(path) == ".tool-versions"
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		version := line
+		if toolVersions {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != pkg {
+				continue
+			}
+			version = fields[1]
+		}
+		version = strings.TrimPrefix(version, "v")
+		if version == "" || version[0] < '0' || version[0] > '9' {
+			return "", errors.Errorf("%s: unsupported version %q for %s", path, line, pkg)
+		}
+		return version, nil
+	}
+	if toolVersions {
+		return "", nil
+	}
+	return "", errors.Errorf("%s: no version for %s", path, pkg)
+}
+
+// versionGlob returns a glob matching version, and for partial versions such
+// as "18" or "1.21" also any more specific version.
+func versionGlob(version string) string {
+	if strings.Count(version, ".") >= 2 {
+		return version
+	}
+	return fmt.Sprintf("{%s,%s.*}", version, version)
+}
+
+// SavedPackages returns the packages saved to the environment configuration.
+func (e *Env) SavedPackages() []manifest.Reference {
+	out := make([]manifest.Reference, 0, len(e.config.Packages))
+	for _, pkg := range e.config.Packages {
+		out = append(out, manifest.ParseReference(pkg))
+	}
+	return out
+}
+
+// SavePackage records a package in the environment configuration, replacing
+// any other saved version of the same package.
+func (e *Env) SavePackage(ref manifest.Reference) error {
+	var packages []string
+	for _, saved := range e.SavedPackages() {
+		if saved.Name != ref.Name {
+			packages = append(packages, saved.String())
+		}
+	}
+	e.config.Packages = append(packages, ref.String())
+	sort.Strings(e.config.Packages)
+	return e.writeConfig()
+}
+
+// UnsavePackage removes a package from the environment configuration, if present.
+func (e *Env) UnsavePackage(name string) error {
+	var packages []string
+	for _, saved := range e.SavedPackages() {
+		if saved.Name != name {
+			packages = append(packages, saved.String())
+		}
+	}
+	if len(packages) == len(e.config.Packages) {
+		return nil
+	}
+	e.config.Packages = packages
+	return e.writeConfig()
+}
+
+func (e *Env) writeConfig() error {
 	data, err := hcl.Marshal(e.config)
 	if err != nil {
 		return errors.WithStack(err)
@@ -1126,9 +2103,110 @@ func (e *Env) EnsureChannelIsUpToDate(l *ui.UI, pkg *manifest.Package) error {
 		// No updates needed for this package
 		return nil
 	}
+	if pkg.UpdateSchedule != nil && !pkg.UpdateSchedule.Matches(time.Now()) {
+		task.Tracef("No update required outside the update schedule %q", pkg.UpdateSchedule)
+		return nil
+	}
 	return errors.WithStack(e.state.UpgradeChannel(task, pkg))
 }
 
+// Pin an installed channel package, replacing it with the version the
+// channel currently resolves to until unpinned with Unpin. The pin is recorded
+// in the environment configuration, and the pinned version replaces the
+// channel in the saved packages.
+//
+// A bare package name pins whichever channel of the package is installed.
+func (e *Env) Pin(l *ui.UI, ref manifest.Reference) (*shell.Changes, error) {
+	for _, pin := range e.config.Pins {
+		if pin.matches(ref) {
+			return nil, errors.Errorf("%s is already pinned", ref)
+		}
+	}
+	installed, err := e.ListInstalled(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var pkg *manifest.Package
+	for _, candidate := range installed {
+		if referenceSatisfies(ref, candidate.Reference) {
+			pkg = candidate
+			break
+		}
+	}
+	if pkg == nil {
+		return nil, errors.Errorf("%s is not installed", ref)
+	}
+	if !pkg.Reference.IsChannel() {
+		return nil, errors.Errorf("%s is not a channel, only channel packages can be pinned", pkg)
+	}
+	if !pkg.ChannelVersion.IsSet() {
+		return nil, errors.Errorf("%s does not resolve to a version, so can not be pinned", pkg)
+	}
+	pin := &PinConfig{Package: pkg.Reference.String(), Version: pkg.ChannelVersion.String()}
+	changes, err := e.replacePinned(l, pin.reference())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.config.Pins = append(e.config.Pins, pin)
+	return changes, errors.WithStack(e.writeConfig())
+}
+
+// Unpin a channel package pinned with Pin, replacing the pinned version with
+// the channel again.
+func (e *Env) Unpin(l *ui.UI, ref manifest.Reference) (*shell.Changes, error) {
+	var pin *PinConfig
+	pins := make([]*PinConfig, 0, len(e.config.Pins))
+	for _, candidate := range e.config.Pins {
+		if pin == nil && candidate.matches(ref) {
+			pin = candidate
+			continue
+		}
+		pins = append(pins, candidate)
+	}
+	if pin == nil {
+		return nil, errors.Errorf("%s is not pinned", ref)
+	}
+	changes, err := e.replacePinned(l, manifest.ParseReference(pin.Package))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.config.Pins = pins
+	return changes, errors.WithStack(e.writeConfig())
+}
+
+// replacePinned installs ref in place of the installed package of the same
+// name, replacing it in the saved packages too.
+func (e *Env) replacePinned(l *ui.UI, ref manifest.Reference) (*shell.Changes, error) {
+	pkg, err := e.Resolve(l, manifest.ExactSelector(ref), false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	changes, err := e.Install(l, pkg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, saved := range e.SavedPackages() {
+		if saved.Name == ref.Name {
+			return changes, errors.WithStack(e.SavePackage(ref))
+		}
+	}
+	return changes, nil
+}
+
+// pinned returns the pin that installed the package ref, or nil if it is not
+// pinned.
+func (e *Env) pinned(ref manifest.Reference) *PinConfig {
+	if ref.IsChannel() {
+		return nil
+	}
+	for _, pin := range e.config.Pins {
+		if pin.reference().String() == ref.String() {
+			return pin
+		}
+	}
+	return nil
+}
+
 // AddSource adds a new source bundle and refreshes the packages from it
 func (e *Env) AddSource(l *ui.UI, s sources.Source) error {
 	sources, err := e.sources(l)
@@ -1139,6 +2217,83 @@ func (e *Env) AddSource(l *ui.UI, s sources.Source) error {
 	return e.Update(l, true)
 }
 
+// manifestsDir is the directory, relative to the environment root, of package
+// manifests added with AddManifestSource.
+const manifestsDir = ".hermit/manifests"
+
+// AddManifestSource fetches a single package manifest from an http(s) URL or
+// a local file and adds it as an in-memory source, taking precedence over the
+// configured sources for this Env. It returns the name of the package.
+//
+// The manifest is also saved to the environment's manifests directory, which
+// later Envs search after the configured sources.
+func (e *Env) AddManifestSource(l *ui.UI, uri string) (string, error) {
+	var (
+		content []byte
+		err     error
+	)
+	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+		content, err = e.fetchManifest(uri)
+	} else {
+		content, err = os.ReadFile(strings.TrimPrefix(uri, "file://"))
+	}
+	if err != nil {
+		return "", errors.Wrap(err, uri)
+	}
+	filename := filepath.Base(strings.SplitN(uri, "?", 2)[0])
+	name := strings.TrimSuffix(filename, ".hcl")
+	source := sources.NewMemSource(filename, string(content))
+	if _, err := manifest.LoadManifestFile(source.Bundle(), filename); err != nil {
+		return "", errors.Wrapf(err, "invalid manifest %s", uri)
+	}
+	dir := filepath.Join(e.envDir, manifestsDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", errors.WithStack(err)
+	}
+	path := filepath.Join(dir, filename)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if e.manageGit(path) {
+		if err := util.RunInDir(l.Task(name), e.envDir, "git", "add", "-f", path); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	srcs, err := e.sources(l)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	srcs.Prepend(source)
+	if saved := manifestsSource(e.envDir); !slices.Contains(srcs.Sources(), saved.URI()) {
+		srcs.Add(saved)
+	}
+	l.Debugf("Added manifest %s for %s to %s", uri, name, dir)
+	return name, nil
+}
+
+// manifestsSource returns the source for the manifests added to the
+// environment at envDir with AddManifestSource.
+func manifestsSource(envDir string) sources.Source {
+	return sources.NewLocalSource("env:///"+manifestsDir, os.DirFS(filepath.Join(envDir, manifestsDir)))
+}
+
+func (e *Env) fetchManifest(uri string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil) //nolint: noctx
+	if err != nil {
+		return nil, errors.WithStack(util.RedactURLError(err))
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(util.RedactURLError(err))
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("could not fetch manifest: %s", resp.Status)
+	}
+	content, err := io.ReadAll(resp.Body)
+	return content, errors.WithStack(err)
+}
+
 // EnvDir returns the directory where this environment is rooted
 func (e *Env) EnvDir() string {
 	return e.envDir
@@ -1184,10 +2339,11 @@ func (e *Env) upgradeVersion(l *ui.UI, pkg *manifest.Package) (*shell.Changes, *
 func (e *Env) readPackageState(pkg *manifest.Package) {
 	_, err := os.Stat(e.pkgLink(pkg))
 	pkg.Linked = err == nil
+	pkg.Pinned = e.pinned(pkg.Reference) != nil
 	e.state.ReadPackageState(pkg)
 }
 
-func (e *Env) referenceFromBinLink(pkgLink string) manifest.Reference {
+func referenceFromBinLink(pkgLink string) manifest.Reference {
 	name := strings.TrimPrefix(strings.TrimSuffix(filepath.Base(pkgLink), ".pkg"), ".")
 	return manifest.ParseReference(name)
 }
@@ -1211,6 +2367,7 @@ func (e *Env) checkForConflicts(files []string, pkg *manifest.Package) error {
 }
 
 func (e *Env) linkPackage(l *ui.Task, pkg *manifest.Package) error {
+	defer ui.LogElapsed(l, "Link %s", pkg)()
 	task := l.SubTask("link")
 	files, err := pkg.ResolveBinaries()
 	if err != nil {
@@ -1239,7 +2396,7 @@ func (e *Env) linkPackage(l *ui.Task, pkg *manifest.Package) error {
 		}
 	}
 	for _, app := range pkg.Apps {
-		err = e.linkApp(app)
+		err = e.linkApp(task, pkg, app)
 		if err != nil {
 			return err
 		}
@@ -1252,7 +2409,7 @@ func (e *Env) linkIntoEnv(l *ui.Task, oldname, newname string) error {
 	if err := os.Symlink(oldname, newname); err != nil {
 		return errors.WithStack(err)
 	}
-	if e.useGit {
+	if e.manageGit(newname) {
 		return util.RunInDir(l, e.envDir, "git", "add", "-f", newname)
 	}
 	return nil
@@ -1266,7 +2423,7 @@ func (e *Env) pkgLink(pkg *manifest.Package) string {
 // If a targetPkg is specified, the runtime dependencies and target package environment
 // variables are applied on top all the rest of package environment variables, so that
 // they take precedence.
-func (e *Env) allEnvarOpsForPackages(runtimeDeps []*manifest.Package, targetPkg *manifest.Package, allPkgs ...*manifest.Package) envars.Ops {
+func (e *Env) allEnvarOpsForPackages(l ui.Logger, runtimeDeps []*manifest.Package, targetPkg *manifest.Package, allPkgs ...*manifest.Package) envars.Ops {
 	var ops envars.Ops
 	ops = append(ops, e.hermitEnvarOps()...)
 	ops = append(ops, e.envarsForPackages(allPkgs...)...)
@@ -1291,14 +2448,73 @@ func (e *Env) envarsFromOps(inherit bool, ops envars.Ops) []string {
 }
 
 // envarsForPackages returns the environment variable operations by the given packages.
+//
+// Packages are applied in order of priority then name, independent of the
+// order they were installed or listed in, so that precedence is reproducible.
 func (e *Env) envarsForPackages(pkgs ...*manifest.Package) envars.Ops {
 	out := envars.Ops{}
-	for _, pkg := range pkgs {
+	for _, pkg := range sortByEnvarPrecedence(pkgs) {
 		out = append(out, pkg.Env...)
 	}
 	return out
 }
 
+// sortByEnvarPrecedence returns pkgs in the order their environment variables
+// are applied, so later packages take precedence.
+func sortByEnvarPrecedence(pkgs []*manifest.Package) []*manifest.Package {
+	pkgs = slices.Clone(pkgs)
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		if pkgs[i].Priority != pkgs[j].Priority {
+			return pkgs[i].Priority < pkgs[j].Priority
+		}
+		return pkgs[i].Reference.Name < pkgs[j].Reference.Name
+	})
+	return pkgs
+}
+
+// WarnEnvarCollisions warns about each variable that more than one of pkgs
+// sets to a different value, such as when the environment is activated.
+func (e *Env) WarnEnvarCollisions(l ui.Logger, pkgs []*manifest.Package) {
+	for _, collision := range e.envarCollisions(pkgs, nil) {
+		l.Warnf("%s", collision)
+	}
+}
+
+// envarCollisions describes each variable that more than one package sets to
+// a different value, once expanded. If involving is not nil, only collisions
+// with it are described.
+//
+// Variables that packages append or prepend to, such as PATH, compose and so
+// never collide.
+func (e *Env) envarCollisions(pkgs []*manifest.Package, involving *manifest.Package) []string {
+	type setter struct {
+		pkg   *manifest.Package
+		value string
+	}
+	base := envars.Parse(os.Environ()).Apply(e.Root(), e.hermitEnvarOps()).Combined()
+	setters := map[string]setter{}
+	var out []string
+	for _, pkg := range sortByEnvarPrecedence(pkgs) {
+		for _, op := range pkg.Env {
+			set, ok := op.(*envars.Set)
+			if !ok {
+				continue
+			}
+			value := base.Apply(e.Root(), envars.Ops{set}).Combined()[set.Name]
+			prev, ok := setters[set.Name]
+			setters[set.Name] = setter{pkg: pkg, value: value}
+			if !ok || prev.pkg.Reference.Name == pkg.Reference.Name || prev.value == value {
+				continue
+			}
+			if involving != nil && prev.pkg.Reference.Name != involving.Reference.Name && pkg.Reference.Name != involving.Reference.Name {
+				continue
+			}
+			out = append(out, fmt.Sprintf("%s and %s both set %s, the value from %s is used", prev.pkg, pkg, set.Name, pkg))
+		}
+	}
+	return out
+}
+
 // localEnvarOps returns the environment variables defined in the local configuration
 func (e *Env) localEnvarOps() envars.Ops {
 	return envars.Infer(e.config.Envars.System())
@@ -1314,6 +2530,9 @@ func (e *Env) hermitEnvarOps() envars.Ops {
 }
 
 // hermitRuntimeDepOps returns the environment variables for runtime dependencies
+//
+// Manifest variables such as ${root} are expanded when each dependency is
+// resolved, so they refer to the dependency itself, not the requiring package.
 func (e *Env) hermitRuntimeDepOps(pkgs []*manifest.Package) envars.Ops {
 	ops := e.envarsForPackages(pkgs...)
 	for _, pkg := range pkgs {
@@ -1322,7 +2541,7 @@ func (e *Env) hermitRuntimeDepOps(pkgs []*manifest.Package) envars.Ops {
 	return ops
 }
 
-func (e *Env) linkApp(app string) error {
+func (e *Env) linkApp(l *ui.Task, pkg *manifest.Package, app string) error {
 	root := filepath.Join(e.binDir, filepath.Base(app))
 	for _, dir := range []string{"Contents/MacOS", "Contents/Resources"} {
 		err := os.MkdirAll(filepath.Join(root, dir), 0700)
@@ -1330,9 +2549,97 @@ func (e *Env) linkApp(app string) error {
 			return errors.WithStack(err)
 		}
 	}
+	if !e.linksApps(pkg) {
+		return nil
+	}
+	appsDir, err := e.appsDir()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(appsDir, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	link := filepath.Join(appsDir, filepath.Base(app))
+	target := appPath(pkg, app)
+	if info, err := os.Lstat(link); err == nil {
+		// Replace links to other versions of the app, but never a real app.
+		if info.Mode()&os.ModeSymlink == 0 {
+			l.Warnf("Not linking %s, %s already exists", app, link)
+			return nil
+		}
+		if existing, _ := os.Readlink(link); existing == target {
+			return nil
+		}
+		if err := os.Remove(link); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	l.Debugf("ln -s %q %q", target, link)
+	return errors.WithStack(os.Symlink(target, link))
+}
+
+// unlinkApps removes the links to pkg's apps created by linkApp.
+func (e *Env) unlinkApps(l *ui.Task, pkg *manifest.Package) error {
+	if len(pkg.Apps) == 0 || !e.linksApps(pkg) {
+		return nil
+	}
+	appsDir, err := e.appsDir()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, app := range pkg.Apps {
+		link := filepath.Join(appsDir, filepath.Base(app))
+		// Leave the link alone if another version of the app has replaced it.
+		if existing, err := os.Readlink(link); err != nil || existing != appPath(pkg, app) {
+			continue
+		}
+		l.Tracef("rm %s", link)
+		if err := os.Remove(link); err != nil {
+			return errors.WithStack(err)
+		}
+	}
 	return nil
 }
 
+// linksApps returns true if the apps of pkg should be symlinked into the apps directory.
+func (e *Env) linksApps(pkg *manifest.Package) bool {
+	return e.config.LinkApps || strings.HasSuffix(pkg.Source, ".dmg")
+}
+
+// appsDir returns the directory that apps are symlinked into.
+func (e *Env) appsDir() (string, error) {
+	dir := e.config.AppsDir
+	switch {
+	case dir == "":
+		home, err := system.UserHomeDir()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return filepath.Join(home, "Applications"), nil
+	case dir == "~" || strings.HasPrefix(dir, "~/"):
+		home, err := system.UserHomeDir()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return filepath.Join(home, dir[1:]), nil
+	case !filepath.IsAbs(dir):
+		return filepath.Join(e.envDir, dir), nil
+	default:
+		return dir, nil
+	}
+}
+
+// appPath returns the path of an extracted app.
+//
+// Apps are relative to the package root, except for DMGs whose apps are
+// copied to the top of the package.
+func appPath(pkg *manifest.Package, app string) string {
+	if strings.HasSuffix(pkg.Source, ".dmg") {
+		return filepath.Join(pkg.Dest, filepath.Base(app))
+	}
+	return filepath.Join(pkg.Root, app)
+}
+
 // Update sources and auto-update channels.
 //
 // Will be updated at most every SyncFrequency unless "force" is true.
@@ -1365,6 +2672,15 @@ func (e *Env) Update(l *ui.UI, force bool) error {
 	return nil
 }
 
+// Sync manifest sources without upgrading any installed packages.
+func (e *Env) Sync(l *ui.UI, force bool) error {
+	resolver, err := e.resolver(l)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(resolver.Sync(l, force))
+}
+
 // Sources enabled in this environment.
 func (e *Env) Sources(l *ui.UI) ([]string, error) {
 	sources, err := e.sources(l)
@@ -1387,6 +2703,18 @@ func (e *Env) ResolveWithDeps(l *ui.UI, installed []manifest.Reference, selector
 	}
 	out[pkg.Reference.String()] = pkg
 	for _, req := range pkg.Requires {
+		// Requirements on a channel, eg. "openssl@stable", are resolved exactly.
+		if ref := manifest.ParseReference(req); ref.IsChannel() {
+			if err := e.resolveChannelDep(l, installed, pkg, ref, out); err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		}
+		// A requirement on the name alone is met by any version or channel
+		// of the package already being installed, eg. by "openssl@stable".
+		if pendingByName(out, req) != nil {
+			continue
+		}
 		// First search from virtual providers
 		ref, err := e.resolveVirtual(l, req)
 		if err != nil && errors.Is(err, manifest.ErrUnknownPackage) {
@@ -1410,6 +2738,196 @@ func (e *Env) ResolveWithDeps(l *ui.UI, installed []manifest.Reference, selector
 	return nil
 }
 
+// InstallExplanation is why a package is present in an environment.
+type InstallExplanation struct {
+	// Package present in the environment.
+	Package manifest.Reference
+	// Saved is true if the package is saved to the environment configuration.
+	Saved bool
+	// Chains of packages that transitively pull the package in through
+	// "requires" or "runtime-dependencies", each outermost first.
+	Chains [][]manifest.Reference
+}
+
+// Direct returns true if the package was installed directly, rather than only
+// as a dependency of other packages.
+func (i *InstallExplanation) Direct() bool {
+	return i.Saved || len(i.Chains) == 0
+}
+
+// ExplainInstall returns why the package matching ref is present in the
+// environment.
+func (e *Env) ExplainInstall(l *ui.UI, ref manifest.Reference) (*InstallExplanation, error) {
+	installed, err := e.ListInstalled(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Runtime dependencies are not linked into the environment, so resolve them too.
+	pkgs := map[string]*manifest.Package{}
+	queue := installed
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if _, ok := pkgs[pkg.Reference.String()]; ok {
+			continue
+		}
+		pkgs[pkg.Reference.String()] = pkg
+		for _, dep := range pkg.RuntimeDeps {
+			if _, ok := pkgs[dep.String()]; ok {
+				continue
+			}
+			depPkg, err := e.Resolve(l, manifest.ExactSelector(dep), false)
+			if err != nil {
+				return nil, errors.Wrap(err, pkg.String())
+			}
+			queue = append(queue, depPkg)
+		}
+	}
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var target *manifest.Package
+	for _, name := range names {
+		if referenceSatisfies(ref, pkgs[name].Reference) {
+			target = pkgs[name]
+			break
+		}
+	}
+	if target == nil {
+		return nil, errors.Errorf("%s is not installed", ref)
+	}
+	explanation := &InstallExplanation{Package: target.Reference}
+	for _, saved := range e.SavedPackages() {
+		if saved.Name == target.Reference.Name {
+			explanation.Saved = true
+		}
+	}
+	// Walk up through every dependent, recording a chain for each package
+	// that nothing else depends on.
+	var walk func(current *manifest.Package, chain []manifest.Reference)
+	walk = func(current *manifest.Package, chain []manifest.Reference) {
+		found := false
+		for _, name := range names {
+			dependent := pkgs[name]
+			inChain := slices.ContainsFunc(chain, func(ref manifest.Reference) bool { return ref.String() == name })
+			if inChain || dependent == target || !dependsOn(dependent, current) {
+				continue
+			}
+			found = true
+			walk(dependent, append([]manifest.Reference{dependent.Reference}, chain...))
+		}
+		if !found && len(chain) > 0 {
+			explanation.Chains = append(explanation.Chains, chain)
+		}
+	}
+	walk(target, nil)
+	return explanation, nil
+}
+
+// dependsOn returns true if pkg requires dep, or has it as a runtime dependency.
+func dependsOn(pkg, dep *manifest.Package) bool {
+	for _, ref := range pkg.RuntimeDeps {
+		if ref.String() == dep.Reference.String() {
+			return true
+		}
+	}
+	for _, req := range pkg.Requires {
+		if slices.Contains(dep.Provides, req) || referenceSatisfies(manifest.ParseReference(req), dep.Reference) {
+			return true
+		}
+	}
+	return false
+}
+
+// referenceSatisfies returns true if ref is satisfied by the package other,
+// where a bare name is satisfied by any version or channel of the package.
+func referenceSatisfies(ref, other manifest.Reference) bool {
+	if !ref.Version.IsSet() && ref.Channel == "" {
+		return ref.Name == other.Name
+	}
+	return ref.Match(other)
+}
+
+// resolveChannelDep resolves the requirement of pkg on the channel ref into
+// out, failing if a different version or channel of the package is installed
+// or already being installed.
+//
+// A version already being installed only because it was required by name is
+// replaced by the channel.
+func (e *Env) resolveChannelDep(l *ui.UI, installed []manifest.Reference, pkg *manifest.Package, ref manifest.Reference, out map[string]*manifest.Package) error {
+	if _, ok := out[ref.String()]; ok {
+		return nil
+	}
+	if other := pendingByName(out, ref.Name); other != nil && onlyRequiredByName(out, other) {
+		delete(out, other.Reference.String())
+	}
+	names := make([]string, 0, len(out))
+	for name := range out {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if other := out[name]; other.Reference.Name == ref.Name {
+			return errors.Errorf("%s requires %s, but %s", pkg, ref, requiredBy(out, other))
+		}
+	}
+	for _, existing := range installed {
+		if existing.String() == ref.String() {
+			return nil
+		} else if existing.Name == ref.Name {
+			return errors.Errorf("%s requires %s, but %s is installed", pkg, ref, existing)
+		}
+	}
+	return e.ResolveWithDeps(l, installed, manifest.ExactSelector(ref), out)
+}
+
+// pendingByName returns the package named name in the set of packages being
+// installed, or nil if there is none.
+func pendingByName(pkgs map[string]*manifest.Package, name string) *manifest.Package {
+	for _, pkg := range pkgs {
+		if pkg.Reference.Name == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// onlyRequiredByName returns true if dep is a version in the set of packages
+// being installed that other packages there require by name alone, rather
+// than by its version or channel.
+func onlyRequiredByName(pkgs map[string]*manifest.Package, dep *manifest.Package) bool {
+	if dep.Reference.IsChannel() {
+		return false
+	}
+	byName := false
+	for _, pkg := range pkgs {
+		if slices.Contains(pkg.Requires, dep.Reference.String()) {
+			return false
+		}
+		if slices.Contains(pkg.Requires, dep.Reference.Name) {
+			byName = true
+		}
+	}
+	return byName
+}
+
+// requiredBy describes why dep is in the set of packages being installed.
+func requiredBy(pkgs map[string]*manifest.Package, dep *manifest.Package) string {
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if slices.Contains(pkgs[name].Requires, dep.Reference.String()) {
+			return fmt.Sprintf("%s requires %s", pkgs[name], dep)
+		}
+	}
+	return fmt.Sprintf("%s is also being installed", dep)
+}
+
 func (e *Env) resolveVirtual(l *ui.UI, name string) (manifest.Reference, error) {
 	installed, err := e.ListInstalled(l)
 	if err != nil {
@@ -1427,13 +2945,48 @@ func (e *Env) resolveVirtual(l *ui.UI, name string) (manifest.Reference, error)
 		return manifest.Reference{}, errors.WithStack(err)
 	}
 
+	if !l.Interactive() {
+		candidates := packageCandidates(virtual)
+		return manifest.Reference{}, errors.Errorf("multiple packages satisfy the required dependency %q, please install one of the following manually: %s", name, strings.Join(candidates, ", "))
+	}
+	chosen, err := choosePackage(l, fmt.Sprintf("multiple packages satisfy the required dependency %q, which should be installed?", name), virtual)
+	if err != nil {
+		return manifest.Reference{}, errors.WithStack(err)
+	}
+	// Virtual providers are unversioned, so resolve the latest version.
+	pkg, err := e.Resolve(l, manifest.NameSelector(chosen.Reference.Name), false)
+	if err != nil {
+		return manifest.Reference{}, errors.WithStack(err)
+	}
+	return pkg.Reference, nil
+}
+
+// packageCandidates returns the sorted names of pkgs.
+func packageCandidates(pkgs []*manifest.Package) []string {
 	candidates := []string{}
-	for _, vpkg := range virtual {
-		candidates = append(candidates, vpkg.Reference.Name)
+	for _, pkg := range pkgs {
+		candidates = append(candidates, pkg.Reference.Name)
 	}
 	sort.Strings(candidates)
-
-	return manifest.Reference{}, errors.Errorf("multiple packages satisfy the required dependency %q, please install one of the following manually: %s", name, strings.Join(candidates, ", "))
+	return candidates
+}
+
+// choosePackage prompts the user to pick one of pkgs, listed by name.
+func choosePackage(l *ui.UI, message string, pkgs []*manifest.Package) (*manifest.Package, error) {
+	sorted := slices.Clone(pkgs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Reference.Name < sorted[j].Reference.Name })
+	options := make([]string, len(sorted))
+	for i, pkg := range sorted {
+		options[i] = pkg.Reference.Name
+		if pkg.Description != "" {
+			options[i] += " - " + pkg.Description
+		}
+	}
+	i, err := l.Choose(message, options)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return sorted[i], nil
 }
 
 func isEnvAGitRepo(env string) bool {
@@ -1441,6 +2994,26 @@ func isEnvAGitRepo(env string) bool {
 	return err == nil
 }
 
+// manageGit returns true if Hermit should add or remove "path" from git.
+func (e *Env) manageGit(path string) bool {
+	return e.useGit && !isGitIgnored(e.envDir, e.config.GitIgnore, path)
+}
+
+// isGitIgnored returns true if "path" matches one of the manage-git-ignore
+// "patterns", which are relative to the environment root.
+func isGitIgnored(envDir string, patterns []string, path string) bool {
+	rel, err := filepath.Rel(envDir, path)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // Write a file from the bundled VFS to the env bin dir, performing basic variable substitution.
 func writeFileToEnvBin(l *ui.Task, useGit bool, src, envDir string, vars map[string]string, perm os.FileMode) error {
 	dest := filepath.Join(envDir, "bin", src)
@@ -1466,7 +3039,7 @@ func (e *Env) sources(l *ui.UI) (*sources.Sources, error) {
 	if e.lazySources != nil {
 		return e.lazySources, nil
 	}
-	sources, err := getSources(l, e.envDir, e.config, e.state, e.state.Config().Sources)
+	sources, err := getSources(l, e.envDir, e.config, e.state, e.state.Config().Sources, e.httpClient)
 	if err != nil {
 		return nil, errors.Wrap(err, e.configFile)
 	}
@@ -1483,8 +3056,9 @@ func (e *Env) resolver(l *ui.UI) (*manifest.Resolver, error) {
 		return nil, errors.WithStack(err)
 	}
 	resolver, err := manifest.New(sources, manifest.Config{
-		Env:   e.envDir,
-		State: e.state.Root(),
+		Env:    e.envDir,
+		State:  e.state.Root(),
+		Vendor: e.config.Vendor,
 		Platform: platform.Platform{
 			OS:   runtime.GOOS,
 			Arch: runtime.GOARCH,
@@ -1,10 +1,13 @@
 package hermit_test
 
 import (
+	"maps"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -16,6 +19,10 @@ import (
 	"github.com/cashapp/hermit/hermittest"
 	"github.com/cashapp/hermit/manifest"
 	"github.com/cashapp/hermit/manifest/manifesttest"
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/sources"
+	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
 )
 
 // Test that when installing a package that has binaries conflicting
@@ -153,6 +160,44 @@ func TestEnsureUpToDate(t *testing.T) {
 	assert.Equal(t, etag, dbPkg.Etag)
 }
 
+func TestEnsureUpToDateOutsideUpdateSchedule(t *testing.T) {
+	headCalls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			headCalls++
+			return
+		}
+		tar := TestTarGz{map[string]string{"bin": "data"}}
+		tar.Write(t, w)
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+
+	pkg := manifesttest.NewPkgBuilder(fixture.RootDir()).
+		WithName("test").
+		WithBinaries("bin").
+		WithChannel("chan").
+		WithUpdateInterval(1 * time.Hour).
+		WithSource(fixture.Server.URL).
+		Result()
+	_, err := fixture.Env.Install(fixture.P, pkg)
+	assert.NoError(t, err)
+
+	// February 31st never happens.
+	pkg.UpdateSchedule, err = manifest.ParseSchedule("* * 31 feb *")
+	assert.NoError(t, err)
+	pkg.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	err = fixture.Env.EnsureChannelIsUpToDate(fixture.P, pkg)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, headCalls)
+
+	pkg.UpdateSchedule, err = manifest.ParseSchedule("* * * * *")
+	assert.NoError(t, err)
+	err = fixture.Env.EnsureChannelIsUpToDate(fixture.P, pkg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, headCalls)
+}
+
 // Test that files referred in the Files map are copied correctly
 func TestCopyFiles(t *testing.T) {
 	dir, err := os.MkdirTemp("", "")
@@ -367,6 +412,151 @@ func TestDependencyResolution(t *testing.T) {
 	assert.EqualError(t, err, "multiple packages satisfy the required dependency \"virtual2\", please install one of the following manually: pkg1, pkg2")
 }
 
+func TestChannelDependencyResolution(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"bin1": "foo"}}
+		tar.Write(t, w)
+	})
+
+	f := hermittest.NewEnvTestFixture(t, handler)
+	pkg := func(requires ...string) string {
+		return `
+			description = ""
+			binaries = ["bin1"]
+			version "1.0.0" {
+			  source = "` + f.Server.URL + `"
+			}
+			requires = ["` + strings.Join(requires, `", "`) + `"]
+		`
+	}
+	f.WithManifests(map[string]string{
+		"dep.hcl": `
+			description = ""
+			binaries = ["bin1"]
+			version "1.0.0" "2.0.0" {
+			  source = "` + f.Server.URL + `"
+			}
+			channel "legacy" {
+			  update = "24h"
+			  version = "1.*"
+			}
+		`,
+		"current.hcl":      pkg("dep@latest"),
+		"old.hcl":          pkg("dep@legacy"),
+		"plain.hcl":        pkg("dep"),
+		"channelfirst.hcl": pkg("dep@legacy", "plain"),
+		"plainfirst.hcl":   pkg("plain", "dep@legacy"),
+	})
+	defer f.Clean()
+
+	out := map[string]*manifest.Package{}
+	err := f.Env.ResolveWithDeps(f.P, nil, manifest.NameSelector("current"), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "dep@latest", out["dep@latest"].Reference.String())
+
+	err = f.Env.ResolveWithDeps(f.P, nil, manifest.NameSelector("old"), out)
+	assert.EqualError(t, err, "old-1.0.0 requires dep@legacy, but current-1.0.0 requires dep@latest")
+
+	installed := []manifest.Reference{manifest.ParseReference("dep-1.0.0")}
+	err = f.Env.ResolveWithDeps(f.P, installed, manifest.NameSelector("old"), map[string]*manifest.Package{})
+	assert.EqualError(t, err, "old-1.0.0 requires dep@legacy, but dep-1.0.0 is installed")
+
+	installed = []manifest.Reference{manifest.ParseReference("dep@legacy")}
+	out = map[string]*manifest.Package{}
+	err = f.Env.ResolveWithDeps(f.P, installed, manifest.NameSelector("old"), out)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(out))
+
+	// A requirement on the name alone is met by the channel, whichever is
+	// resolved first.
+	for _, name := range []string{"channelfirst", "plainfirst"} {
+		out = map[string]*manifest.Package{}
+		err = f.Env.ResolveWithDeps(f.P, nil, manifest.NameSelector(name), out)
+		assert.NoError(t, err)
+		expected := []string{name + "-1.0.0", "dep@legacy", "plain-1.0.0"}
+		slices.Sort(expected)
+		assert.Equal(t, expected, slices.Sorted(maps.Keys(out)), name)
+	}
+}
+
+func TestAmbiguousPackagesPromptWhenInteractive(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, nil)
+	f.WithManifests(map[string]string{
+		"pkg1.hcl": `
+			description = "first"
+			binaries = ["bin1"]
+			version "1.0.0" { source = "www.example.com" }
+			provides = ["virtual"]
+		`,
+		"pkg2.hcl": `
+			description = "second"
+			binaries = ["bin1"]
+			version "1.0.0" { source = "www.example.com" }
+			provides = ["virtual"]
+		`,
+		"pkg3.hcl": `
+			description = ""
+			binaries = ["bin3"]
+			version "1.0.0" { source = "www.example.com" }
+			requires = ["virtual"]
+		`,
+	})
+	defer f.Clean()
+
+	_, err := f.Env.ResolveBinary(f.P, "bin1")
+	assert.EqualError(t, err, `multiple packages provide the binary "bin1", please install one of the following: pkg1, pkg2`)
+
+	// Invalid answers are re-prompted.
+	f.P.SetStdin(strings.NewReader("3\n2\n1\n"))
+	pkg, err := f.Env.ResolveBinary(f.P, "bin1")
+	assert.NoError(t, err)
+	assert.Equal(t, "pkg2", pkg.Reference.Name)
+
+	out := map[string]*manifest.Package{}
+	err = f.Env.ResolveWithDeps(f.P, nil, manifest.NameSelector("pkg3"), out)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pkg1-1.0.0", "pkg3-1.0.0"}, slices.Sorted(maps.Keys(out)))
+
+	_, err = f.Env.ResolveBinary(f.P, "bin1")
+	assert.EqualError(t, err, "no choice made: EOF")
+}
+
+func TestOnceTriggers(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, nil)
+	f.WithManifests(map[string]string{
+		"pkg.hcl": `
+			description = ""
+			binaries = ["bin"]
+			version "1.0.0" "2.0.0" { source = "www.example.com" }
+			on "install" {
+			  once = true
+			  message { text = "once" }
+			}
+			on "install" {
+			  once-per-version = true
+			  message { text = "once per version" }
+			}
+			on "install" {
+			  message { text = "always" }
+			}
+		`,
+	})
+	defer f.Clean()
+
+	trigger := func(version string) []string {
+		t.Helper()
+		pkg, err := f.Env.Resolve(f.P, manifest.ExactSelector(manifest.ParseReference("pkg-"+version)), false)
+		assert.NoError(t, err)
+		messages, err := f.Env.TriggerForPackage(f.P, manifest.EventInstall, pkg)
+		assert.NoError(t, err)
+		return messages
+	}
+	assert.Equal(t, []string{"always", "once", "once per version"}, trigger("1.0.0"))
+	assert.Equal(t, []string{"always"}, trigger("1.0.0"))
+	assert.Equal(t, []string{"always", "once per version"}, trigger("2.0.0"))
+	assert.Equal(t, []string{"always"}, trigger("2.0.0"))
+}
+
 func TestManifestValidation(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/bar" {
@@ -414,6 +604,60 @@ func TestEnv_EphemeralVariableSubstitutionOverride(t *testing.T) {
 	opsContains(t, vars, "TOOL_HOME="+fixture.Env.Root()+"/.hermit/tool")
 }
 
+func TestRenameEnv(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, nil)
+	defer fixture.Clean()
+
+	assert.NoError(t, fixture.Env.SetEnv("OLD", "value"))
+	assert.NoError(t, fixture.Env.SetEnv("OTHER", "other"))
+
+	changes, err := fixture.Env.RenameEnv("OLD", "NEW")
+	assert.NoError(t, err)
+	assert.Equal(t, envars.Ops{&envars.Set{Name: "OLD", Value: "value"}}, changes.Remove)
+	assert.Equal(t, envars.Ops{&envars.Set{Name: "NEW", Value: "value"}}, changes.Add)
+
+	// Re-read the configuration from disk.
+	vars, err := fixture.ReopenEnv().Envars(fixture.P, false)
+	assert.NoError(t, err)
+	opsContains(t, vars, "NEW=value")
+	for _, v := range vars {
+		assert.False(t, strings.HasPrefix(v, "OLD="), "%s should have been renamed", v)
+	}
+
+	_, err = fixture.Env.RenameEnv("MISSING", "NEW2")
+	assert.Error(t, err)
+	_, err = fixture.Env.RenameEnv("NEW", "OTHER")
+	assert.Error(t, err)
+}
+
+func TestProtectedEnvars(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, nil)
+	defer fixture.Clean()
+
+	assert.Error(t, fixture.Env.SetEnv("HERMIT_NO_VERIFY_SCRIPTS", "true"))
+	assert.NoError(t, fixture.Env.SetEnv("OTHER", "true"))
+	_, err := fixture.Env.RenameEnv("OTHER", "HERMIT_NO_VERIFY_SCRIPTS")
+	assert.Error(t, err)
+
+	config := filepath.Join(fixture.Env.BinDir(), "hermit.hcl")
+	err = os.WriteFile(config, []byte(`env = {"HERMIT_NO_VERIFY_SCRIPTS": "true"}`), 0600)
+	assert.NoError(t, err)
+	_, err = hermit.LoadEnvInfo(fixture.Env.Root())
+	assert.Contains(t, err.Error(), "HERMIT_NO_VERIFY_SCRIPTS can only be set in the user's own environment")
+
+	fixture.WithManifests(map[string]string{
+		"pkg.hcl": `
+			description = ""
+			binaries = ["bin"]
+			env = {"HERMIT_NO_VERIFY_SCRIPTS": "true"}
+			version "1.0.0" { source = "www.example.com" }
+		`,
+	})
+	_, err = fixture.Env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference("pkg-1.0.0")), false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "HERMIT_NO_VERIFY_SCRIPTS can only be set in the user's own environment")
+}
+
 func TestLoadEnvInfo(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -485,3 +729,1047 @@ func opsContains[T any](t *testing.T, slice []T, needle T) {
 func joinLines(lines ...string) string {
 	return strings.Join(lines, "\n") + "\n"
 }
+
+func TestVerifyActivation(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, nil)
+	defer fixture.Clean()
+	root := fixture.Env.Root()
+
+	err := fixture.Env.VerifyActivation(root, envars.Envars{})
+	assert.True(t, errors.Is(err, hermit.ErrNotActivated))
+
+	err = fixture.Env.VerifyActivation(root, envars.Envars{"ACTIVE_HERMIT": root, "HERMIT_ENV": "/other"})
+	assert.True(t, errors.Is(err, hermit.ErrNotActivated))
+
+	other := t.TempDir()
+	err = fixture.Env.VerifyActivation(root, envars.Envars{"ACTIVE_HERMIT": other, "HERMIT_ENV": other})
+	assert.True(t, errors.Is(err, hermit.ErrNotActivated))
+
+	err = fixture.Env.VerifyActivation(other, envars.Envars{"ACTIVE_HERMIT": root, "HERMIT_ENV": root})
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, hermit.ErrNotActivated))
+}
+
+func TestSavePackage(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, nil)
+	defer fixture.Clean()
+
+	err := fixture.Env.SavePackage(manifest.ParseReference("protoc-3.7.2"))
+	assert.NoError(t, err)
+	err = fixture.Env.SavePackage(manifest.ParseReference("go-1.17.1"))
+	assert.NoError(t, err)
+	err = fixture.Env.SavePackage(manifest.ParseReference("protoc-3.8.0"))
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{
+		manifest.ParseReference("go-1.17.1"),
+		manifest.ParseReference("protoc-3.8.0"),
+	}, fixture.Env.SavedPackages())
+
+	// The saved packages are persisted to the environment configuration.
+	config, err := os.ReadFile(filepath.Join(fixture.Env.BinDir(), "hermit.hcl"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), "protoc-3.8.0")
+
+	err = fixture.Env.UnsavePackage("protoc")
+	assert.NoError(t, err)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("go-1.17.1")}, fixture.Env.SavedPackages())
+}
+
+func TestUpgradeAllContinuesPastFailures(t *testing.T) {
+	etag := "first"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("ETag", etag)
+		if r.Method == "GET" {
+			tar := TestTarGz{map[string]string{"bin": etag, "other": etag}}
+			tar.Write(t, w)
+		}
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+
+	missing := manifesttest.NewPkgBuilder(filepath.Join(fixture.RootDir(), "missing")).
+		WithName("missing").
+		WithVersion("1.0.0").
+		WithBinaries("other").
+		WithSource(fixture.Server.URL + "/missing").
+		Result()
+	channel := manifesttest.NewPkgBuilder(filepath.Join(fixture.RootDir(), "test")).
+		WithName("test").
+		WithBinaries("bin").
+		WithChannel("chan").
+		WithSource(fixture.Server.URL + "/test").
+		Result()
+	_, err := fixture.Env.Install(fixture.P, missing)
+	assert.NoError(t, err)
+	_, err = fixture.Env.Install(fixture.P, channel)
+	assert.NoError(t, err)
+
+	etag = "changed"
+	results := fixture.Env.UpgradeAll(fixture.P, []*manifest.Package{missing, channel})
+	assert.Equal(t, 2, len(results))
+	assert.Error(t, results[0].Err)
+	assert.Zero(t, results[0].Upgraded)
+	assert.NoError(t, results[1].Err)
+	assert.NotZero(t, results[1].Upgraded)
+	assert.Equal(t, "first", results[1].Package.ETag)
+	assert.Equal(t, "changed", results[1].Upgraded.ETag)
+}
+
+func TestCheckRequiredEnv(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, nil)
+	defer fixture.Clean()
+
+	config := filepath.Join(fixture.Env.BinDir(), "hermit.hcl")
+	env := fixture.WithEnvConfig(`required-env = ["AWS_PROFILE"]`).Env
+	assert.NoError(t, env.CheckRequiredEnv(fixture.P, envars.Envars{}))
+	assert.NoError(t, env.CheckRequiredEnv(fixture.P, envars.Envars{"AWS_PROFILE": "dev"}))
+
+	env = fixture.WithEnvConfig("required-env = [\"AWS_PROFILE\"]\nstrict-required-env = true\n").Env
+	err := env.CheckRequiredEnv(fixture.P, envars.Envars{})
+	assert.EqualError(t, err, "this environment requires AWS_PROFILE to be set, see "+config)
+	assert.NoError(t, env.CheckRequiredEnv(fixture.P, envars.Envars{"AWS_PROFILE": "dev"}))
+}
+
+func TestInstallVendored(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"bin": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	defer fixture.Clean()
+	env := fixture.WithEnvConfig(`vendor = ["test"]`).Env
+	fixture.WithManifests(map[string]string{
+		"test.hcl": `
+			description = ""
+			binaries = ["bin"]
+			source = "` + fixture.Server.URL + `/test"
+			version "1.0.0" {}
+		`,
+	})
+
+	pkg, err := env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference("test-1.0.0")), false)
+	assert.NoError(t, err)
+	_, err = env.Install(fixture.P, pkg)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(env.Root(), ".hermit", "vendor", "test-1.0.0", "bin"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(fixture.State.PkgDir(), "test-1.0.0"))
+	assert.True(t, os.IsNotExist(err))
+	link, err := os.Readlink(filepath.Join(env.BinDir(), "bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, ".test-1.0.0.pkg", link)
+
+	_, err = env.Uninstall(fixture.P, pkg)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(env.Root(), ".hermit", "vendor", "test-1.0.0"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMutablePackagesArePerEnvironment(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"bin": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	defer fixture.Clean()
+	files := map[string]string{
+		"test.hcl": `
+			description = ""
+			binaries = ["bin"]
+			source = "` + fixture.Server.URL + `/test"
+			mutable = true
+			version "1.0.0" {}
+		`,
+	}
+	fixture.WithManifests(files)
+	other := fixture.NewEnv()
+	for name, content := range files {
+		assert.NoError(t, other.AddSource(fixture.P, sources.NewMemSource(name, content)))
+	}
+
+	pkg, _, err := fixture.Env.InstallByName(fixture.P, "test-1.0.0")
+	assert.NoError(t, err)
+	otherPkg, _, err := other.InstallByName(fixture.P, "test-1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(other.Root(), ".hermit", "mutable", "test-1.0.0"), otherPkg.Dest)
+	mutated := filepath.Join(otherPkg.Dest, "mutated")
+	assert.NoError(t, os.WriteFile(mutated, []byte("mutated"), 0600))
+
+	// Reinstalling resets only this environment's copy.
+	assert.NoError(t, os.WriteFile(filepath.Join(pkg.Dest, "mutated"), []byte("mutated"), 0600))
+	_, err = fixture.Env.Install(fixture.P, pkg)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(pkg.Dest, "mutated"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(mutated)
+	assert.NoError(t, err)
+}
+
+func TestInstallByName(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"bin": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	defer fixture.Clean()
+	fixture.WithManifests(map[string]string{
+		"test.hcl": `
+			description = ""
+			binaries = ["bin"]
+			source = "` + fixture.Server.URL + `/test"
+			version "1.0.0" "1.1.0" {}
+		`,
+	})
+
+	pkg, changes, err := fixture.Env.InstallByName(fixture.P, "test-1.0.0")
+	assert.NoError(t, err)
+	assert.NotZero(t, changes)
+	assert.Equal(t, "test-1.0.0", pkg.Reference.String())
+	link, err := os.Readlink(filepath.Join(fixture.Env.BinDir(), "bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, ".test-1.0.0.pkg", link)
+
+	_, _, err = fixture.Env.InstallByName(fixture.P, "missing")
+	assert.True(t, errors.Is(err, manifest.ErrUnknownPackage))
+}
+
+func TestExplainInstall(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		tar := TestTarGz{map[string]string{name: "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	defer fixture.Clean()
+	manifests := map[string]string{}
+	for name, attrs := range map[string]string{
+		"app":   `requires = ["lib"]`,
+		"tool":  `requires = ["lib"]`,
+		"lib":   `runtime-dependencies = ["rt-1.0.0"]`,
+		"rt":    ``,
+		"other": ``,
+	} {
+		manifests[name+".hcl"] = `
+			description = ""
+			binaries = ["` + name + `"]
+			source = "` + fixture.Server.URL + `/` + name + `"
+			version "1.0.0" {}
+			` + attrs
+	}
+	fixture.WithManifests(manifests)
+	for _, name := range []string{"lib", "app", "tool", "other"} {
+		pkg, _, err := fixture.Env.InstallByName(fixture.P, name)
+		assert.NoError(t, err)
+		if name == "lib" {
+			assert.NoError(t, fixture.Env.SavePackage(pkg.Reference))
+		}
+	}
+	app := manifest.ParseReference("app-1.0.0")
+	tool := manifest.ParseReference("tool-1.0.0")
+	lib := manifest.ParseReference("lib-1.0.0")
+
+	explanation, err := fixture.Env.ExplainInstall(fixture.P, manifest.ParseReference("other"))
+	assert.NoError(t, err)
+	assert.Equal(t, &hermit.InstallExplanation{Package: manifest.ParseReference("other-1.0.0")}, explanation)
+	assert.True(t, explanation.Direct())
+
+	explanation, err = fixture.Env.ExplainInstall(fixture.P, manifest.ParseReference("lib"))
+	assert.NoError(t, err)
+	assert.Equal(t, &hermit.InstallExplanation{
+		Package: lib,
+		Saved:   true,
+		Chains:  [][]manifest.Reference{{app}, {tool}},
+	}, explanation)
+	assert.True(t, explanation.Direct())
+
+	explanation, err = fixture.Env.ExplainInstall(fixture.P, manifest.ParseReference("rt-1.0.0"))
+	assert.NoError(t, err)
+	assert.Equal(t, &hermit.InstallExplanation{
+		Package: manifest.ParseReference("rt-1.0.0"),
+		Chains:  [][]manifest.Reference{{app, lib}, {tool, lib}},
+	}, explanation)
+	assert.False(t, explanation.Direct())
+
+	_, err = fixture.Env.ExplainInstall(fixture.P, manifest.ParseReference("missing"))
+	assert.EqualError(t, err, "missing is not installed")
+}
+
+func TestLinkApps(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"Test.app/Contents/MacOS/test": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	defer fixture.Clean()
+	env := fixture.WithEnvConfig(`
+		link-apps = true
+		apps-dir = "Applications"
+	`).Env
+	fixture.WithManifests(map[string]string{
+		"test.hcl": `
+			description = ""
+			apps = ["Test.app"]
+			source = "` + fixture.Server.URL + `/test.tar.gz"
+			version "1.0.0" {}
+		`,
+	})
+
+	pkg, err := env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference("test-1.0.0")), false)
+	assert.NoError(t, err)
+	_, err = env.Install(fixture.P, pkg)
+	assert.NoError(t, err)
+	link := filepath.Join(env.Root(), "Applications", "Test.app")
+	target, err := os.Readlink(link)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(pkg.Root, "Test.app"), target)
+	_, err = os.Stat(filepath.Join(link, "Contents", "MacOS", "test"))
+	assert.NoError(t, err)
+
+	_, err = env.Uninstall(fixture.P, pkg)
+	assert.NoError(t, err)
+	_, err = os.Lstat(link)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApplyVersionFiles(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, nil)
+	defer fixture.Clean()
+	env := fixture.WithEnvConfig(`version-files = { "node": ".nvmrc", "go": ".tool-versions", "python": ".tool-versions", "protoc": ".missing" }`).Env
+	fixture.WithManifests(map[string]string{
+		"node.hcl": `
+			description = ""
+			binaries = ["node"]
+			source = "https://example.com/node-${version}.tar.gz"
+			version "18.1.0" "18.2.0" "20.0.0" {}
+		`,
+	})
+	err := os.WriteFile(filepath.Join(env.Root(), ".nvmrc"), []byte("# LTS\nv18\n"), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(env.Root(), ".tool-versions"), []byte("nodejs 20.0.0\ngo 1.21.0\n"), 0600)
+	assert.NoError(t, err)
+
+	selectors, err := env.ApplyVersionFiles(fixture.P, []manifest.GlobSelector{
+		manifest.MustParseGlobSelector("node"),
+		manifest.MustParseGlobSelector("go"),
+		manifest.MustParseGlobSelector("python"),
+		manifest.MustParseGlobSelector("protoc"),
+		manifest.MustParseGlobSelector("node-20.0.0"),
+		manifest.MustParseGlobSelector("node@stable"),
+	})
+	assert.NoError(t, err)
+	actual := []string{}
+	for _, selector := range selectors {
+		actual = append(actual, selector.String())
+	}
+	// python has no line in .tool-versions, so has no version.
+	assert.Equal(t, []string{"node-{18,18.*}", "go-1.21.0", "python", "protoc", "node-20.0.0", "node@stable"}, actual)
+
+	pkg, err := env.Resolve(fixture.P, selectors[0], false)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-18.2.0", pkg.Reference.String())
+
+	err = os.WriteFile(filepath.Join(env.Root(), ".nvmrc"), []byte("lts/*\n"), 0600)
+	assert.NoError(t, err)
+	_, err = env.ApplyVersionFiles(fixture.P, []manifest.GlobSelector{manifest.MustParseGlobSelector("node")})
+	assert.Error(t, err)
+}
+
+func TestEnvOpsAppliedInPriorityOrder(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{strings.TrimPrefix(r.URL.Path, "/"): "bin"}}
+		tar.Write(t, w)
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+	fixture.WithManifests(map[string]string{
+		"alpha.hcl": `
+			description = ""
+			binaries = ["alpha"]
+			env = { "PATH": "${HERMIT_ENV}/alpha:${PATH}" }
+			priority = 10
+			version "1.0.0" {
+			  source = "` + fixture.Server.URL + `/alpha"
+			}
+		`,
+		"zeta.hcl": `
+			description = ""
+			binaries = ["zeta"]
+			env = { "PATH": "${HERMIT_ENV}/zeta:${PATH}" }
+			version "1.0.0" {
+			  source = "` + fixture.Server.URL + `/zeta"
+			}
+		`,
+	})
+
+	// Install in the opposite order to that in which ops should be applied.
+	for _, name := range []string{"zeta", "alpha"} {
+		pkg, err := fixture.Env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference(name+"-1.0.0")), false)
+		assert.NoError(t, err)
+		_, err = fixture.Env.Install(fixture.P, pkg)
+		assert.NoError(t, err)
+	}
+
+	vars, err := fixture.Env.Envars(fixture.P, false)
+	assert.NoError(t, err)
+	path := ""
+	for _, v := range vars {
+		if strings.HasPrefix(v, "PATH=") {
+			path = v
+		}
+	}
+	alpha := strings.Index(path, "/alpha")
+	zeta := strings.Index(path, "/zeta")
+	assert.True(t, alpha >= 0 && zeta >= 0 && alpha < zeta, "higher priority package should be first in %s", path)
+}
+
+func TestPathMatchesActivation(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{strings.TrimPrefix(r.URL.Path, "/"): "bin"}}
+		tar.Write(t, w)
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+	fixture.WithManifests(map[string]string{
+		"tool.hcl": `
+			description = ""
+			binaries = ["tool"]
+			env = { "TOOL_HOME": "${HERMIT_ENV}/tool", "PATH": "${HERMIT_ENV}/tool/bin:${PATH}" }
+			version "1.0.0" {
+			  source = "` + fixture.Server.URL + `/tool"
+			}
+		`,
+	})
+	pkg, err := fixture.Env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference("tool-1.0.0")), false)
+	assert.NoError(t, err)
+	_, err = fixture.Env.Install(fixture.P, pkg)
+	assert.NoError(t, err)
+
+	path, err := fixture.Env.Path(fixture.P)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(path, fixture.Env.Root()+"/tool/bin:"+fixture.Env.BinDir()+":"), path)
+	vars, err := fixture.Env.Envars(fixture.P, false)
+	assert.NoError(t, err)
+	assert.True(t, slices.Contains(vars, "PATH="+path), "%v", vars)
+}
+
+func TestInstallWarnsOnCollidingVariables(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{strings.TrimPrefix(r.URL.Path, "/"): "bin"}}
+		tar.Write(t, w)
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+	manifestFor := func(name, config, data string) string {
+		return `
+			description = ""
+			binaries = ["` + name + `"]
+			env = { "PATH": "${HERMIT_ENV}/` + name + `:${PATH}", "TOOL_CONFIG": "` + config + `", "SHARED": "same", "DATA": "` + data + `" }
+			version "1.0.0" {
+			  source = "` + fixture.Server.URL + `/` + name + `"
+			}
+		`
+	}
+	fixture.WithManifests(map[string]string{
+		// DATA is the same once expanded.
+		"alpha.hcl": manifestFor("alpha", "alpha.conf", "${HERMIT_ENV}/data"),
+		"zeta.hcl":  manifestFor("zeta", "zeta.conf", fixture.Env.Root()+"/data"),
+	})
+	for _, name := range []string{"zeta", "alpha"} {
+		pkg, err := fixture.Env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference(name+"-1.0.0")), false)
+		assert.NoError(t, err)
+		_, err = fixture.Env.Install(fixture.P, pkg)
+		assert.NoError(t, err)
+	}
+	logs := fixture.Logs.String()
+	assert.Contains(t, logs, "alpha-1.0.0 and zeta-1.0.0 both set TOOL_CONFIG, the value from zeta-1.0.0 is used")
+	assert.NotContains(t, logs, "SHARED")
+	assert.NotContains(t, logs, "DATA")
+	assert.NotContains(t, logs, "PATH")
+
+	// Using the environment doesn't warn again.
+	fixture.Logs.Reset()
+	vars, err := fixture.Env.Envars(fixture.P, false)
+	assert.NoError(t, err)
+	assert.True(t, slices.Contains(vars, "TOOL_CONFIG=zeta.conf"), "%v", vars)
+	assert.NotContains(t, fixture.Logs.String(), "TOOL_CONFIG")
+}
+
+func TestExecHooks(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"tool": "tool"}}
+		tar.Write(t, w)
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+	marker := filepath.Join(t.TempDir(), "marker")
+	fixture.WithManifests(map[string]string{
+		"hooked.hcl": `
+			description = ""
+			binaries = ["tool"]
+			version "1.0.0" {
+			  source = "` + fixture.Server.URL + `/hooked"
+			}
+			on exec {
+			  run { cmd = "/bin/sh -c 'echo $HERMIT_EXEC_HOOK > ` + marker + `'" }
+			}
+		`,
+		"failing.hcl": `
+			description = ""
+			binaries = ["tool"]
+			version "1.0.0" {
+			  source = "` + fixture.Server.URL + `/failing"
+			}
+			on exec {
+			  run { cmd = "/bin/false" }
+			}
+		`,
+	})
+	resolve := func(name string) *manifest.Package {
+		pkg, err := fixture.Env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference(name)), false)
+		assert.NoError(t, err)
+		return pkg
+	}
+
+	// Exec the hooked package via a binary it doesn't have, so that the hook
+	// runs but the test process is not replaced.
+	err := fixture.Env.Exec(fixture.P, resolve("hooked-1.0.0"), "missing", []string{"missing"}, nil)
+	assert.EqualError(t, err, `hooked-1.0.0: could not find binary "missing"`)
+	content, err := os.ReadFile(marker)
+	assert.NoError(t, err)
+	assert.Equal(t, "hooked-1.0.0\n", string(content))
+	assert.Equal(t, "", os.Getenv(hermit.ExecHookEnvar))
+
+	err = fixture.Env.Exec(fixture.P, resolve("failing-1.0.0"), "tool", []string{"tool"}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exec hook failed")
+
+	// Hooks don't fire for binaries executed by other hooks.
+	assert.NoError(t, os.Remove(marker))
+	t.Setenv(hermit.ExecHookEnvar, "other-1.0.0")
+	err = fixture.Env.Exec(fixture.P, resolve("hooked-1.0.0"), "missing", []string{"missing"}, nil)
+	assert.EqualError(t, err, `hooked-1.0.0: could not find binary "missing"`)
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallChecksSystemRequires(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{strings.TrimPrefix(r.URL.Path, "/"): "bin"}}
+		tar.Write(t, w)
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+
+	tools := t.TempDir()
+	err := os.WriteFile(filepath.Join(tools, "hosttool"), []byte("#!/bin/sh\necho 'hosttool version 2.31.1 (build 7)'\n"), 0700) // nolint: gosec
+	assert.NoError(t, err)
+	t.Setenv("PATH", tools+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	manifests := map[string]string{}
+	for name, requires := range map[string]string{
+		"present": "hosttool",
+		"recent":  "hosttool>=2.30",
+		"tooold":  "hosttool>=2.32",
+		"missing": "nosuchtool",
+		"invalid": "hosttool>=",
+	} {
+		manifests[name+".hcl"] = `
+			description = ""
+			binaries = ["` + name + `"]
+			system-requires = ["` + requires + `"]
+			version "1.0.0" {
+			  source = "` + fixture.Server.URL + `/` + name + `"
+			}
+		`
+	}
+	fixture.WithManifests(manifests)
+
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{name: "present"},
+		{name: "recent"},
+		{name: "tooold", err: "tooold-1.0.0 requires hosttool>=2.32 but " + filepath.Join(tools, "hosttool") + " is version 2.31.1"},
+		{name: "missing", err: "missing-1.0.0 requires nosuchtool to be installed on the system"},
+		{name: "invalid", err: `invalid-1.0.0: invalid system requirement "hosttool>="`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pkg, err := fixture.Env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference(test.name+"-1.0.0")), false)
+			assert.NoError(t, err)
+			_, err = fixture.Env.Install(fixture.P, pkg)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestInstallTransactionRollback(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "-")[0]
+		tar := TestTarGz{map[string]string{name: "bin"}}
+		tar.Write(t, w)
+	})
+	fixture := hermittest.NewEnvTestFixture(t, handler)
+	defer fixture.Clean()
+	manifests := map[string]string{}
+	for _, name := range []string{"upgraded", "added", "existing", "conflicting"} {
+		binary := name
+		if name == "conflicting" {
+			binary = "existing"
+		}
+		manifests[name+".hcl"] = `
+			description = ""
+			binaries = ["` + binary + `"]
+			version "1.0.0" "2.0.0" {
+			  source = "` + fixture.Server.URL + `/` + binary + `-${version}"
+			}
+		`
+	}
+	fixture.WithManifests(manifests)
+	resolve := func(ref string) *manifest.Package {
+		pkg, err := fixture.Env.Resolve(fixture.P, manifest.ExactSelector(manifest.ParseReference(ref)), false)
+		assert.NoError(t, err)
+		return pkg
+	}
+	for _, ref := range []string{"upgraded-1.0.0", "existing-1.0.0"} {
+		_, err := fixture.Env.Install(fixture.P, resolve(ref))
+		assert.NoError(t, err)
+	}
+
+	tx := fixture.Env.BeginInstall()
+	_, err := tx.Install(fixture.P, resolve("upgraded-2.0.0"))
+	assert.NoError(t, err)
+	_, err = tx.Install(fixture.P, resolve("added-1.0.0"))
+	assert.NoError(t, err)
+	_, err = tx.Install(fixture.P, resolve("conflicting-1.0.0"))
+	assert.Error(t, err)
+	assert.NoError(t, tx.Rollback(fixture.P))
+
+	refs, err := fixture.Env.ListInstalledReferences()
+	assert.NoError(t, err)
+	actual := []string{}
+	for _, ref := range refs {
+		actual = append(actual, ref.String())
+	}
+	assert.Equal(t, []string{"existing-1.0.0", "upgraded-1.0.0"}, actual)
+	for bin, expected := range map[string]string{
+		"upgraded": ".upgraded-1.0.0.pkg",
+		"existing": ".existing-1.0.0.pkg",
+	} {
+		link, err := os.Readlink(filepath.Join(fixture.Env.BinDir(), bin))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, link)
+	}
+	_, err = os.Lstat(filepath.Join(fixture.Env.BinDir(), "added"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestManageGitIgnore(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+	cmd := exec.Command("git", "init", "-q", ".")
+	cmd.Dir = root
+	assert.NoError(t, cmd.Run())
+
+	l, _ := ui.NewForTesting()
+	err = hermit.Init(l, root, "", t.TempDir(), hermit.Config{
+		ManageGit: true,
+		GitIgnore: []string{"bin/activate-*", "bin/*.md"},
+	}, "BYPASS")
+	assert.NoError(t, err)
+
+	cmd = exec.Command("git", "diff", "--cached", "--name-only")
+	cmd.Dir = root
+	staged, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bin/hermit", "bin/hermit.hcl"}, strings.Fields(string(staged)))
+}
+
+func TestOutdated(t *testing.T) {
+	etag := "first"
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("ETag", etag)
+		if r.Method == "GET" {
+			tar := TestTarGz{map[string]string{"bin": etag, "other": etag, "third": etag}}
+			tar.Write(t, w)
+		}
+	}))
+	f.WithManifests(map[string]string{
+		"versioned.hcl": `
+			description = ""
+			binaries = ["bin"]
+			source = "` + f.Server.URL + `/versioned-${version}"
+			version "1.0.0" "1.1.0" "2.0.0" {}
+		`,
+		"current.hcl": `
+			description = ""
+			binaries = ["other"]
+			source = "` + f.Server.URL + `/current-${version}"
+			version "1.0.0" {}
+		`,
+		"channel.hcl": `
+			description = ""
+			binaries = ["third"]
+			channel "chan" {
+			  source = "` + f.Server.URL + `/channel"
+			  update = "1h"
+			}
+		`,
+	})
+	defer f.Clean()
+
+	for _, ref := range []string{"versioned-1.0.0", "current-1.0.0", "channel@chan"} {
+		pkg, err := f.Env.Resolve(f.P, manifest.ExactSelector(manifest.ParseReference(ref)), false)
+		assert.NoError(t, err)
+		_, err = f.Env.Install(f.P, pkg)
+		assert.NoError(t, err)
+	}
+
+	outdated, err := f.Env.Outdated(f.P)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(outdated))
+	assert.Equal(t, "versioned-1.0.0", outdated[0].Package.Reference.String())
+	assert.Equal(t, "versioned-1.1.0", outdated[0].Upgrade.String())
+	assert.Equal(t, "versioned-2.0.0", outdated[0].Latest.String())
+
+	etag = "changed"
+	outdated, err = f.Env.Outdated(f.P)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(outdated))
+	assert.Equal(t, "channel@chan", outdated[0].Package.Reference.String())
+	assert.True(t, outdated[0].ChannelUpdated)
+}
+
+func TestLoadEnvInfoWithConfig(t *testing.T) {
+	newEnvDir := func() string {
+		dir := t.TempDir()
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0700))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "bin", "activate-hermit"), nil, 0600))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "bin", "hermit.hcl"), []byte(`env = {"FROM": "env"}`), 0600))
+		return dir
+	}
+	envDir := newEnvDir()
+	alt := filepath.Join(t.TempDir(), "alt.hcl")
+	assert.NoError(t, os.WriteFile(alt, []byte(`env = {"FROM": "alt"}`), 0600))
+
+	info, err := hermit.LoadEnvInfoWithConfig(envDir, alt)
+	assert.NoError(t, err)
+	assert.Equal(t, alt, info.ConfigFile)
+	assert.Equal(t, "alt", info.Config.Envars["FROM"])
+	assert.Equal(t, filepath.Join(util.RealPath(envDir), "bin"), info.BinDir)
+
+	_, err = hermit.LoadEnvInfoWithConfig(envDir, filepath.Join(envDir, "bin", "hermit.hcl"))
+	assert.NoError(t, err)
+
+	other := newEnvDir()
+	_, err = hermit.LoadEnvInfoWithConfig(envDir, filepath.Join(other, "bin", "hermit.hcl"))
+	assert.Error(t, err)
+
+	_, err = hermit.LoadEnvInfoWithConfig(envDir, filepath.Join(t.TempDir(), "missing.hcl"))
+	assert.Error(t, err)
+}
+
+func TestRuntimeDepEnvExpandsRelativeToDepRoot(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env.txt")
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		files := map[string]string{"dep": "#!/bin/sh\n"}
+		if r.URL.Path == "/tool" {
+			files = map[string]string{"check": "#!/bin/sh\necho \"$DEP_HOME $TOOL_HOME\" > " + out + "\n"}
+		}
+		tar := TestTarGz{files}
+		tar.Write(t, w)
+	}))
+	f.WithManifests(map[string]string{
+		"dep.hcl": `
+			description = ""
+			binaries = ["dep"]
+			env = {DEP_HOME: "${root}/x"}
+			source = "` + f.Server.URL + `/dep"
+			version "1.0.0" {}
+		`,
+		"tool.hcl": `
+			description = ""
+			binaries = ["check"]
+			env = {TOOL_HOME: "${root}/y"}
+			runtime-dependencies = ["dep-1.0.0"]
+			test = "check"
+			source = "` + f.Server.URL + `/tool"
+			version "1.0.0" {}
+		`,
+	})
+	defer f.Clean()
+
+	dep, err := f.Env.Resolve(f.P, manifest.ExactSelector(manifest.ParseReference("dep-1.0.0")), false)
+	assert.NoError(t, err)
+	pkg, err := f.Env.Resolve(f.P, manifest.ExactSelector(manifest.ParseReference("tool-1.0.0")), false)
+	assert.NoError(t, err)
+	assert.NotEqual(t, dep.Root, pkg.Root)
+
+	assert.NoError(t, f.Env.Test(f.P, pkg))
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dep.Root, "x")+" "+filepath.Join(pkg.Root, "y"), strings.TrimSpace(string(data)))
+}
+
+func TestPruneBinaries(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"bin": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	f.WithManifests(map[string]string{
+		"test.hcl": `
+			description = ""
+			binaries = ["bin"]
+			source = "` + f.Server.URL + `/test"
+			version "1.0.0" {}
+		`,
+	})
+	defer f.Clean()
+
+	pkg, err := f.Env.Resolve(f.P, manifest.ExactSelector(manifest.ParseReference("test-1.0.0")), false)
+	assert.NoError(t, err)
+	_, err = f.Env.Install(f.P, pkg)
+	assert.NoError(t, err)
+	binDir := f.Env.BinDir()
+	// A binary that is no longer provided by an installed package, and one for a package that was removed.
+	assert.NoError(t, os.Symlink(".test-1.0.0.pkg", filepath.Join(binDir, "renamed")))
+	assert.NoError(t, os.Symlink(".gone-1.0.0.pkg", filepath.Join(binDir, "gone")))
+
+	pruned, err := f.Env.PruneBinaries(f.P)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(binDir, "gone"), filepath.Join(binDir, "renamed")}, pruned)
+	_, err = os.Lstat(filepath.Join(binDir, "bin"))
+	assert.NoError(t, err)
+	_, err = os.Lstat(filepath.Join(binDir, "renamed"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLock(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"bin": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	f.WithManifests(map[string]string{
+		"test.hcl": `
+			description = ""
+			binaries = ["bin"]
+			source = "` + f.Server.URL + `/test-${version}"
+			version "1.0.0" "2.0.0" {}
+		`,
+	})
+	defer f.Clean()
+	resolve := func(ref string) *manifest.Package {
+		pkg, err := f.Env.Resolve(f.P, manifest.ExactSelector(manifest.ParseReference(ref)), false)
+		assert.NoError(t, err)
+		return pkg
+	}
+
+	installed := resolve("test-1.0.0")
+	_, err := f.Env.Install(f.P, installed)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Env.Lock(f.P))
+	locked, changed, err := f.Env.Locked()
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.False(t, changed)
+
+	_, err = f.Env.Install(f.P, resolve("test-2.0.0"))
+	assert.EqualError(t, err, `environment `+f.Env.Root()+` is locked, run "hermit env unlock" or pass --force to change its packages`)
+	_, err = f.Env.Uninstall(f.P, installed)
+	assert.Error(t, err)
+
+	// Changes made behind Hermit's back are detected.
+	assert.NoError(t, os.Symlink("hermit", filepath.Join(f.Env.BinDir(), ".other-1.0.0.pkg")))
+	locked, changed, err = f.Env.Locked()
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.True(t, changed)
+	assert.NoError(t, os.Remove(filepath.Join(f.Env.BinDir(), ".other-1.0.0.pkg")))
+
+	assert.NoError(t, f.Env.Unlock(f.P))
+	locked, _, err = f.Env.Locked()
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	installed = resolve("test-2.0.0")
+	_, err = f.Env.Install(f.P, installed)
+	assert.NoError(t, err)
+
+	assert.NoError(t, f.Env.Lock(f.P))
+	f.Env.OverrideLock()
+	_, err = f.Env.Uninstall(f.P, installed)
+	assert.NoError(t, err)
+}
+
+func TestRelink(t *testing.T) {
+	downloads := 0
+	f := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		tar := TestTarGz{map[string]string{"bin": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	f.WithManifests(map[string]string{
+		"test.hcl": `
+			description = ""
+			binaries = ["bin"]
+			source = "` + f.Server.URL + `/test"
+			version "1.0.0" {}
+		`,
+	})
+	defer f.Clean()
+
+	pkg, err := f.Env.Resolve(f.P, manifest.ExactSelector(manifest.ParseReference("test-1.0.0")), false)
+	assert.NoError(t, err)
+	_, err = f.Env.Install(f.P, pkg)
+	assert.NoError(t, err)
+	binDir := f.Env.BinDir()
+	// A binary link rewritten by another tool, and a stale link.
+	assert.NoError(t, os.Remove(filepath.Join(binDir, "bin")))
+	assert.NoError(t, os.Symlink("/usr/bin/true", filepath.Join(binDir, "bin")))
+	assert.NoError(t, os.Symlink(".test-1.0.0.pkg", filepath.Join(binDir, "renamed")))
+
+	pkgs, err := f.Env.Relink(f.P)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pkgs))
+	link, err := os.Readlink(filepath.Join(binDir, "bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, ".test-1.0.0.pkg", link)
+	link, err = os.Readlink(filepath.Join(binDir, ".test-1.0.0.pkg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hermit", link)
+	_, err = os.Lstat(filepath.Join(binDir, "renamed"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 1, downloads)
+}
+
+func TestUpgradeScripts(t *testing.T) {
+	f := hermittest.NewEnvTestFixture(t, nil)
+	defer f.Clean()
+
+	path := filepath.Join(f.Env.BinDir(), "hermit")
+	assert.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\necho tampered\n"), 0700))
+	unknown, err := f.Env.UnknownScripts()
+	assert.NoError(t, err)
+	assert.True(t, slices.Contains(unknown, "hermit"), "%v", unknown)
+
+	assert.NoError(t, f.Env.UpgradeScripts(f.P, []string{"hermit"}, "", "BYPASS"))
+	// The script matches one written by Init with the same variables.
+	expected, err := os.ReadFile(filepath.Join(f.NewEnv().BinDir(), "hermit"))
+	assert.NoError(t, err)
+	actual, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+
+	assert.Error(t, f.Env.UpgradeScripts(f.P, []string{"hermit.hcl"}, "", "BYPASS"))
+}
+
+func TestPin(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tar := TestTarGz{map[string]string{"bin": "#!/bin/sh\n"}}
+		tar.Write(t, w)
+	}))
+	defer fixture.Clean()
+	testManifest := `
+		description = ""
+		binaries = ["bin"]
+		source = "` + fixture.Server.URL + `/test-${version}"
+		version "1.0.0" "1.1.0" "2.0.0" {}
+		channel "stable" {
+			update = "1h"
+			version = "1.*"
+		}
+		channel "nightly" {
+			update = "1h"
+			source = "` + fixture.Server.URL + `/test-nightly"
+		}
+	`
+	fixture.WithManifests(map[string]string{"test.hcl": testManifest})
+	installed := func(env *hermit.Env) *manifest.Package {
+		t.Helper()
+		pkgs, err := env.ListInstalled(fixture.P)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(pkgs))
+		return pkgs[0]
+	}
+
+	pkg, _, err := fixture.Env.InstallByName(fixture.P, "test@nightly")
+	assert.NoError(t, err)
+	_, err = fixture.Env.Pin(fixture.P, manifest.ParseReference("test"))
+	assert.EqualError(t, err, "test@nightly does not resolve to a version, so can not be pinned")
+	pkg, _, err = fixture.Env.InstallByName(fixture.P, "test@stable")
+	assert.NoError(t, err)
+	assert.NoError(t, fixture.Env.SavePackage(pkg.Reference))
+
+	// Pinning replaces the channel with the version it resolves to.
+	_, err = fixture.Env.Pin(fixture.P, manifest.ParseReference("test"))
+	assert.NoError(t, err)
+	pkg = installed(fixture.Env)
+	assert.Equal(t, "test-1.1.0", pkg.Reference.String())
+	assert.True(t, pkg.Pinned)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("test-1.1.0")}, fixture.Env.SavedPackages())
+	_, err = fixture.Env.Pin(fixture.P, manifest.ParseReference("test@stable"))
+	assert.EqualError(t, err, "test@stable is already pinned")
+	_, err = fixture.Env.Pin(fixture.P, manifest.ParseReference("missing"))
+	assert.EqualError(t, err, "missing is not installed")
+	config, err := os.ReadFile(filepath.Join(fixture.Env.BinDir(), "hermit.hcl"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), `pin "test@stable"`)
+	assert.Contains(t, string(config), `version = "1.1.0"`)
+
+	// Pinned packages are not upgraded.
+	_, upgraded, err := fixture.Env.Upgrade(fixture.P, pkg)
+	assert.NoError(t, err)
+	assert.Zero(t, upgraded)
+	assert.Equal(t, "test-1.1.0", installed(fixture.Env).Reference.String())
+
+	// The pin is read back from the configuration.
+	env := fixture.ReopenEnv()
+	assert.NoError(t, env.AddSource(fixture.P, sources.NewMemSource("test.hcl", testManifest)))
+	assert.True(t, installed(env).Pinned)
+
+	_, err = fixture.Env.Unpin(fixture.P, manifest.ParseReference("test"))
+	assert.NoError(t, err)
+	_, err = fixture.Env.Unpin(fixture.P, manifest.ParseReference("test"))
+	assert.EqualError(t, err, "test is not pinned")
+	pkg = installed(fixture.Env)
+	assert.Equal(t, "test@stable", pkg.Reference.String())
+	assert.False(t, pkg.Pinned)
+	assert.Equal(t, []manifest.Reference{manifest.ParseReference("test@stable")}, fixture.Env.SavedPackages())
+}
+
+func TestResolveForPlatformIncludesDependencies(t *testing.T) {
+	fixture := hermittest.NewEnvTestFixture(t, nil)
+	defer fixture.Clean()
+	manifests := map[string]string{}
+	for name, attrs := range map[string]string{
+		"app":  `requires = ["virt", "lib@stable"]`,
+		"impl": `provides = ["virt"]`,
+		"lib":  `runtime-dependencies = ["rt-1.0.0"]`,
+		"rt":   ``,
+	} {
+		manifests[name+".hcl"] = `
+			description = ""
+			binaries = ["` + name + `"]
+			source = "https://example.com/` + name + `-${version}-${os}-${arch}.tar.gz"
+			version "1.0.0" {}
+			channel "stable" {
+			  update = "24h"
+			  version = "1.*"
+			}
+			` + attrs
+	}
+	fixture.WithManifests(manifests)
+
+	target := platform.Platform{OS: "linux", Arch: "arm64"}
+	pkgs, err := fixture.Env.ResolveForPlatform(fixture.P, target, manifest.ExactSelector(manifest.ParseReference("app-1.0.0")))
+	assert.NoError(t, err)
+	sources := []string{}
+	for _, pkg := range pkgs {
+		sources = append(sources, pkg.Source)
+	}
+	assert.Equal(t, []string{
+		"https://example.com/app-1.0.0-linux-arm64.tar.gz",
+		"https://example.com/impl-1.0.0-linux-arm64.tar.gz",
+		"https://example.com/lib-1.0.0-linux-arm64.tar.gz",
+		"https://example.com/rt-1.0.0-linux-arm64.tar.gz",
+	}, sources)
+}
@@ -25,6 +25,22 @@ func (e Envars) Clone() Envars {
 	return out
 }
 
+// protected environment variables weaken Hermit's safety checks, so they may
+// only be set by the user, not by an environment's configuration or its
+// packages, which may come from an untrusted repository.
+var protected = []string{"HERMIT_NO_VERIFY_SCRIPTS"}
+
+// CheckProtected returns an error if any protected variable is set, such as
+// HERMIT_NO_VERIFY_SCRIPTS.
+func (e Envars) CheckProtected() error {
+	for _, name := range protected {
+		if _, ok := e[name]; ok {
+			return errors.Errorf("%s can only be set in the user's own environment", name)
+		}
+	}
+	return nil
+}
+
 // System renders the Envars in the format expected by the system, ie. KEY=VALUE
 func (e Envars) System() []string {
 	out := make([]string, 0, len(e))
@@ -122,7 +138,8 @@ func UnmarshalOps(data []byte) (Ops, error) {
 
 // Infer uses simple heuristics to build a sequence of transformations for environment variables.
 //
-// Currently this consists of detecting prepend/append to :-separated lists, set and unset.
+// Currently this consists of detecting prepend/append to :-separated lists,
+// defaults in the form ${NAME:-value}, set and unset.
 func Infer(env []string) Ops {
 	ops := make(Ops, 0, len(env))
 	for _, envar := range env {
@@ -144,6 +161,12 @@ func Infer(env []string) Ops {
 				Value: insertion,
 			}
 
+		case strings.HasPrefix(value, "${"+key+":-") && strings.HasSuffix(value, "}"): // Default
+			op = &SetDefault{
+				Name:  key,
+				Value: strings.TrimSuffix(strings.TrimPrefix(value, "${"+key+":-"), "}"),
+			}
+
 		case value == "":
 			op = &Unset{Name: key}
 
@@ -158,12 +181,13 @@ func Infer(env []string) Ops {
 // These tables need to be kept in sync.
 var (
 	marshalKeys = map[reflect.Type]string{
-		reflect.TypeOf(&Append{}):  "a",
-		reflect.TypeOf(&Prepend{}): "p",
-		reflect.TypeOf(&Set{}):     "s",
-		reflect.TypeOf(&Unset{}):   "u",
-		reflect.TypeOf(&Force{}):   "f",
-		reflect.TypeOf(&Prefix{}):  "P",
+		reflect.TypeOf(&Append{}):     "a",
+		reflect.TypeOf(&Prepend{}):    "p",
+		reflect.TypeOf(&Set{}):        "s",
+		reflect.TypeOf(&Unset{}):      "u",
+		reflect.TypeOf(&Force{}):      "f",
+		reflect.TypeOf(&Prefix{}):     "P",
+		reflect.TypeOf(&SetDefault{}): "d",
 	}
 	unmarshalKeys = func() map[string]reflect.Type {
 		out := make(map[string]reflect.Type, len(marshalKeys))
@@ -179,6 +203,7 @@ var (
 	_ Op = &Unset{}
 	_ Op = &Force{}
 	_ Op = &Prefix{}
+	_ Op = &SetDefault{}
 )
 
 // Append ensures an element exists at the end of a colon separated list.
@@ -286,6 +311,37 @@ func (e *Set) Revert(transform *Transform) { // nolint: golint
 	}
 }
 
+// SetDefault sets an environment variable only if it is unset or empty.
+type SetDefault struct {
+	Name  string ` json:"n"`
+	Value string ` json:"v"`
+}
+
+func (e *SetDefault) sealed() {}
+func (e *SetDefault) String() string {
+	return fmt.Sprintf(`%s="${%s:-%s}"`, e.Name, e.Name, shellquote.Join(e.Value))
+}
+func (e *SetDefault) Envar() string { return e.Name } // nolint: golint
+func (e *SetDefault) Apply(transform *Transform) { // nolint: golint
+	if value, ok := transform.get(e.Name); ok && value != "" {
+		return
+	}
+	// Record that the default was applied, so that only it is reverted.
+	transform.set(makeRevertKey(transform, e), "1")
+	transform.set(e.Name, e.Value)
+}
+func (e *SetDefault) Revert(transform *Transform) { // nolint: golint
+	marker := makeRevertKey(transform, e)
+	if applied, _ := transform.get(marker); applied == "" {
+		return
+	}
+	transform.unset(marker)
+	// Check if the user has changed the value and if so, keep it.
+	if value, _ := transform.get(e.Name); value == transform.expand(e.Value) {
+		transform.unset(e.Name)
+	}
+}
+
 // Unset an environment variable.
 type Unset struct {
 	Name string ` json:"n"`
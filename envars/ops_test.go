@@ -38,6 +38,14 @@ func TestOpApplyRevert(t *testing.T) {
 			Envars{"GOPATH": "/go/bin"},
 			&Unset{Name: "GOPATH"},
 			Envars{"_HERMIT_OLD_GOPATH_A3751075A9D52FD8": "/go/bin"}},
+		{"SetDefaultUnset",
+			Envars{"PATH": "/bin"},
+			&SetDefault{Name: "EDITOR", Value: "vim"},
+			Envars{"PATH": "/bin", "EDITOR": "vim", "_HERMIT_OLD_EDITOR_1F803C3EBBC59891": "1"}},
+		{"SetDefaultSet",
+			Envars{"EDITOR": "emacs"},
+			&SetDefault{Name: "EDITOR", Value: "vim"},
+			Envars{"EDITOR": "emacs"}},
 		{"PrependWithVariablePrefix",
 			Envars{"GOBIN": "/go/bin", "PATH": "/bin"},
 			&Prepend{Name: "PATH", Value: "${GOBIN}"},
@@ -126,6 +134,7 @@ func TestEncodeDecodeOps(t *testing.T) {
 		&Unset{"UNSET"},
 		&Force{"FORCE", "text"},
 		&Prefix{"PREFIX", "prefix_"},
+		&SetDefault{"DEFAULT", "text"},
 	}
 	data, err := MarshalOps(actual)
 	assert.NoError(t, err)
@@ -134,3 +143,18 @@ func TestEncodeDecodeOps(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, repr.String(expected, repr.Indent("  ")), repr.String(actual, repr.Indent("  ")))
 }
+
+func TestSetDefault(t *testing.T) {
+	ops := Infer([]string{"EDITOR=${EDITOR:-vim}", "PAGER=${PAGER:-less -R}"})
+	assert.Equal(t, Ops{&SetDefault{Name: "EDITOR", Value: "vim"}, &SetDefault{Name: "PAGER", Value: "less -R"}}, ops)
+
+	original := Envars{"EDITOR": "", "PAGER": "more"}
+	applied := original.Apply("", ops).Combined()
+	assert.Equal(t, "vim", applied["EDITOR"])
+	assert.Equal(t, "more", applied["PAGER"])
+
+	// A value changed by the user after activation is kept.
+	applied["EDITOR"] = "nano"
+	reverted := applied.Revert("", ops).Combined()
+	assert.Equal(t, Envars{"EDITOR": "nano", "PAGER": "more"}, reverted)
+}
@@ -3,6 +3,7 @@ module github.com/cashapp/hermit
 go 1.23
 
 require (
+	github.com/ProtonMail/go-crypto v1.0.0
 	github.com/acarl005/stripansi v0.0.0-20180116102854-5a71ef0e047d
 	github.com/alecthomas/assert/v2 v2.1.0
 	github.com/alecthomas/colour v0.1.0
@@ -10,6 +11,7 @@ require (
 	github.com/alecthomas/kong v0.5.0
 	github.com/alecthomas/participle/v2 v2.0.0-beta.5
 	github.com/alecthomas/repr v0.1.0
+	github.com/andybalholm/brotli v1.1.0
 	github.com/andybalholm/cascadia v1.3.2
 	github.com/antchfx/htmlquery v1.2.4
 	github.com/antchfx/xpath v1.2.0
@@ -28,10 +30,12 @@ require (
 	github.com/qdm12/reprint v0.0.0-20200326205758-722754a53494
 	github.com/saracen/go7z v0.0.0-20191010121135-9c09b6bd7fda
 	github.com/sassoftware/go-rpmutils v0.2.0
+	github.com/ulikunitz/xz v0.5.10
 	github.com/willabides/kongplete v0.3.0
 	github.com/willdonnelly/passwd v0.0.0-20141013001024-7935dab3074c
 	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8
 	golang.org/x/net v0.9.0
+	golang.org/x/sync v0.3.0
 	golang.org/x/sys v0.7.0
 	golang.org/x/term v0.7.0
 	howett.net/plist v1.0.0
@@ -40,6 +44,7 @@ require (
 
 require (
 	github.com/DataDog/zstd v1.5.0 // indirect
+	github.com/cloudflare/circl v1.3.3 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
@@ -50,9 +55,7 @@ require (
 	github.com/riywo/loginshell v0.0.0-20200815045211-7d26008be1ab // indirect
 	github.com/saracen/go7z-fixtures v0.0.0-20190623165746-aa6b8fba1d2f // indirect
 	github.com/saracen/solidblock v0.0.0-20190426153529-45df20abab6f // indirect
-	github.com/ulikunitz/xz v0.5.10 // indirect
-	golang.org/x/crypto v0.0.0-20220214200702-86341886e292 // indirect
-	golang.org/x/sync v0.3.0 // indirect
+	golang.org/x/crypto v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
 )
@@ -55,21 +55,18 @@ func NewEnvTestFixture(t *testing.T, handler http.Handler) *EnvTestFixture {
 		Builtin: sources.NewBuiltInSource(vfs.InMemoryFS(nil)),
 	}, cache)
 	assert.NoError(t, err)
-	info, err := hermit.LoadEnvInfo(envDir)
-	assert.NoError(t, err)
-	env, err := hermit.OpenEnv(info, sta, cache.GetSource, envars.Envars{}, server.Client(), nil)
-	assert.NoError(t, err)
 
-	return &EnvTestFixture{
+	f := &EnvTestFixture{
 		Cache:   cache,
 		State:   sta,
 		EnvDirs: []string{envDir},
 		Logs:    buf,
-		Env:     env,
 		Server:  server,
 		t:       t,
 		P:       log,
 	}
+	f.Env = f.openEnv(envDir)
+	return f
 }
 
 // RootDir returns the directory to the environment package root
@@ -100,6 +97,29 @@ func (f *EnvTestFixture) NewEnv() *hermit.Env {
 	log, _ := ui.NewForTesting()
 	err = hermit.Init(log, envDir, "", f.State.Root(), hermit.Config{}, "BYPASS")
 	assert.NoError(f.t, err)
+	return f.openEnv(envDir)
+}
+
+// WithEnvConfig writes config to the bin/hermit.hcl of the current environment
+// and reopens it.
+// Warning: manifests previously set with WithManifests will not be available
+// in the reopened environment.
+func (f *EnvTestFixture) WithEnvConfig(config string) *EnvTestFixture {
+	err := os.WriteFile(filepath.Join(f.Env.BinDir(), "hermit.hcl"), []byte(config), 0600)
+	assert.NoError(f.t, err)
+	f.Env = f.ReopenEnv()
+	return f
+}
+
+// ReopenEnv returns the current environment opened again, re-reading its
+// configuration from disk.
+// Warning: manifests previously set with WithManifests will not be available
+// in the reopened environment.
+func (f *EnvTestFixture) ReopenEnv() *hermit.Env {
+	return f.openEnv(f.Env.Root())
+}
+
+func (f *EnvTestFixture) openEnv(envDir string) *hermit.Env {
 	info, err := hermit.LoadEnvInfo(envDir)
 	assert.NoError(f.t, err)
 	env, err := hermit.OpenEnv(info, f.State, f.Cache.GetSource, envars.Envars{}, f.Server.Client(), nil)
@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"encoding/json"
 	"io"
 	"os"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 type DAO struct {
 	stateDir    string
 	metadataDir string
+	readOnly    bool // Writes are discarded.
 }
 
 // Package is the package information stored in the DB
@@ -21,15 +23,49 @@ type Package struct {
 	UpdateCheckedAt time.Time
 }
 
+// ErrCorrupt is returned by Open if the metadata in the state directory is
+// corrupt, and may be recreated with Recreate.
+var ErrCorrupt = errors.New("state metadata is corrupt")
+
 // Open returns a new DAO at the given state directory
+//
+// Errors other than ErrCorrupt, eg. from a read-only or inaccessible state
+// directory, do not indicate that the metadata is corrupt.
 func Open(stateDir string) (*DAO, error) {
 	metadataDir := filepath.Join(stateDir, "metadata")
+	if info, err := os.Stat(metadataDir); err == nil && !info.IsDir() {
+		return nil, errors.Wrapf(ErrCorrupt, "%s is not a directory", metadataDir)
+	}
 	if err := os.MkdirAll(metadataDir, 0700); err != nil && !os.IsExist(err) {
 		return nil, errors.WithStack(err)
 	}
 	return &DAO{stateDir: stateDir, metadataDir: metadataDir}, nil
 }
 
+// Recreate moves the metadata in the given state directory aside, to
+// "metadata.corrupt", and opens a new empty DAO in its place.
+//
+// Etags, update check times and indexes are rebuilt as they are needed, but
+// the record of which "once" triggers have run is lost, so they will run
+// again.
+func Recreate(stateDir string) (*DAO, error) {
+	metadataDir := filepath.Join(stateDir, "metadata")
+	aside := metadataDir + ".corrupt"
+	if err := os.RemoveAll(aside); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := os.Rename(metadataDir, aside); err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+	return Open(stateDir)
+}
+
+// ReadOnly returns a DAO that reads whatever metadata it can from the given
+// state directory, but discards all writes.
+func ReadOnly(stateDir string) *DAO {
+	return &DAO{stateDir: stateDir, metadataDir: filepath.Join(stateDir, "metadata"), readOnly: true}
+}
+
 // Dump content of database to w.
 func (d *DAO) Dump(w io.Writer) error {
 	return nil
@@ -61,11 +97,17 @@ func (d *DAO) GetPackage(pkgRef string) (*Package, error) {
 
 // UpdatePackage Updates the update check time, etag, and the used at time for a package
 func (d *DAO) UpdatePackage(pkgRef string, pkg *Package) error {
+	if d.readOnly {
+		return nil
+	}
 	return errors.WithStack(os.WriteFile(d.metadataPath(pkgRef), []byte(pkg.Etag), 0600))
 }
 
 // DeletePackage removes a package from the DB
 func (d *DAO) DeletePackage(pkgRef string) error {
+	if d.readOnly {
+		return nil
+	}
 	if err := os.Remove(d.metadataPath(pkgRef)); err != nil {
 		return errors.WithStack(err)
 	}
@@ -75,3 +117,90 @@ func (d *DAO) DeletePackage(pkgRef string) error {
 func (d *DAO) metadataPath(pkgRef string) string {
 	return filepath.Join(d.metadataDir, pkgRef+".etag")
 }
+
+// Index records the extracted packages and linked binaries in the state.
+//
+// It is consulted before hitting the filesystem to avoid repeated checks on
+// slow (eg. network backed) state directories.
+type Index struct {
+	// Extracted package roots.
+	Extracted map[string]bool `json:"extracted"`
+	// Linked binaries keyed by package reference.
+	Linked map[string][]string `json:"linked"`
+}
+
+// GetIndex returns the on-disk index, or an empty index if none exists.
+func (d *DAO) GetIndex() (*Index, error) {
+	index := &Index{Extracted: map[string]bool{}, Linked: map[string][]string{}}
+	data, err := os.ReadFile(d.indexPath())
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, errors.Wrap(err, d.indexPath())
+	}
+	if index.Extracted == nil {
+		index.Extracted = map[string]bool{}
+	}
+	if index.Linked == nil {
+		index.Linked = map[string][]string{}
+	}
+	return index, nil
+}
+
+// UpdateIndex atomically replaces the on-disk index.
+func (d *DAO) UpdateIndex(index *Index) error {
+	if d.readOnly {
+		return nil
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w, err := os.CreateTemp(d.metadataDir, "index.json.*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(w.Name()) // nolint
+	_, err = w.Write(data)
+	_ = w.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(w.Name(), d.indexPath()))
+}
+
+func (d *DAO) indexPath() string {
+	return filepath.Join(d.metadataDir, "index.json")
+}
+
+// HasRunTrigger returns true if the "once" trigger identified by key has
+// been recorded as run.
+func (d *DAO) HasRunTrigger(key string) (bool, error) {
+	_, err := os.Stat(d.triggerPath(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+// RecordTriggerRun records that the "once" trigger identified by key has run.
+func (d *DAO) RecordTriggerRun(key string) error {
+	if d.readOnly {
+		return errors.Errorf("can't record that %s has run, the state metadata is read-only", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(d.triggerPath(key)), 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(d.triggerPath(key), []byte(time.Now().UTC().Format(time.RFC3339)), 0600))
+}
+
+func (d *DAO) triggerPath(key string) string {
+	return filepath.Join(d.metadataDir, "triggers", filepath.Base(key))
+}
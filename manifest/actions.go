@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/alecthomas/hcl"
@@ -113,7 +114,7 @@ func (r *RunAction) Apply(p *Package) error { // nolint
 	}
 	args = append(args, r.Args...)
 	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Env = r.Env
+	cmd.Env = r.commandEnv(p)
 	if r.Dir == "" {
 		cmd.Dir = p.Root
 	} else {
@@ -130,6 +131,30 @@ func (r *RunAction) Apply(p *Package) error { // nolint
 	return nil
 }
 
+// commandEnv returns the environment for the command, with the binaries of
+// any runtime dependencies prepended to PATH.
+func (r *RunAction) commandEnv(p *Package) []string {
+	if len(p.RuntimeDepPaths) == 0 {
+		return r.Env
+	}
+	env := r.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append([]string{}, env...)
+	path := strings.Join(p.RuntimeDepPaths, string(os.PathListSeparator))
+	for i, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "PATH="); ok {
+			if value != "" {
+				path += string(os.PathListSeparator) + value
+			}
+			env[i] = "PATH=" + path
+			return env
+		}
+	}
+	return append(env, "PATH="+path)
+}
+
 // CopyAction is an action for copying
 type CopyAction struct {
 	Pos hcl.Position `hcl:"-"`
@@ -205,3 +230,142 @@ func (s *SymlinkAction) String() string {
 func (s *SymlinkAction) Apply(*Package) error { // nolint
 	return os.Symlink(s.From, s.To)
 }
+
+// RelocateAction rewrites the library search paths baked into binaries and
+// shared libraries, eg. RPATHs pointing at build directories, so that bundled
+// libraries are loaded from the extracted package.
+//
+// Uses patchelf on Linux and install_name_tool on macOS. On macOS the install
+// name of a shared library, and the install names of the bundled libraries it
+// loads, are also changed to be relative to the rpath, and the file is then
+// ad-hoc re-signed as editing it invalidates its code signature.
+type RelocateAction struct {
+	Pos hcl.Position `hcl:"-"`
+
+	Files []string `hcl:"files" help:"The absolute paths of binaries and shared libraries to relocate."`
+	RPath []string `hcl:"rpath" help:"The absolute library search paths to set, eg. ${root}/lib."`
+}
+
+func (r *RelocateAction) position() hcl.Position { return r.Pos }
+func (r *RelocateAction) String() string {
+	var cmds []string
+	for _, file := range r.Files {
+		for _, args := range r.commands(runtime.GOOS, file, machOLoadCommands{}) {
+			cmds = append(cmds, shellquote.Join(args...))
+		}
+	}
+	return strings.Join(cmds, "; ")
+}
+func (r *RelocateAction) Apply(p *Package) error { // nolint
+	for _, file := range r.Files {
+		var existing machOLoadCommands
+		if runtime.GOOS == "darwin" {
+			out, err := exec.Command("otool", "-l", file).CombinedOutput()
+			if err != nil {
+				return errors.Wrapf(err, "%s: failed to list load commands of %s: %s", p, file, string(out))
+			}
+			existing = parseOtoolLoadCommands(string(out))
+			existing.Dylibs = r.bundled(existing.Dylibs)
+		}
+		cmds := r.commands(runtime.GOOS, file, existing)
+		if cmds == nil {
+			return errors.Errorf("%s: relocating binaries is not supported on %s", p, runtime.GOOS)
+		}
+		for _, args := range cmds {
+			if _, err := exec.LookPath(args[0]); err != nil {
+				return errors.Wrapf(err, "%s: %s is required to relocate %s", p, args[0], file)
+			}
+			out, err := exec.Command(args[0], args[1:]...).CombinedOutput() //nolint:gosec
+			if err != nil {
+				return errors.Wrapf(err, "%s: failed to relocate %s: %s", p, file, string(out))
+			}
+		}
+	}
+	return nil
+}
+
+// bundled returns the absolute dylib paths whose library exists in one of the
+// rpath directories, so that they can be loaded relative to the rpath.
+func (r *RelocateAction) bundled(dylibs []string) []string {
+	var out []string
+	for _, dylib := range dylibs {
+		if strings.HasPrefix(dylib, "@") {
+			continue
+		}
+		for _, dir := range r.RPath {
+			if _, err := os.Stat(filepath.Join(dir, filepath.Base(dylib))); err == nil {
+				out = append(out, dylib)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// commands returns the commands to relocate file on the given OS, replacing
+// the existing load commands, or nil if relocation is not supported.
+func (r *RelocateAction) commands(goos, file string, existing machOLoadCommands) [][]string {
+	switch goos {
+	case "linux":
+		return [][]string{{"patchelf", "--set-rpath", strings.Join(r.RPath, ":"), file}}
+	case "darwin":
+		args := []string{"install_name_tool"}
+		if existing.ID != "" && !strings.HasPrefix(existing.ID, "@rpath/") {
+			args = append(args, "-id", "@rpath/"+filepath.Base(existing.ID))
+		}
+		for _, dylib := range existing.Dylibs {
+			args = append(args, "-change", dylib, "@rpath/"+filepath.Base(dylib))
+		}
+		for _, rpath := range existing.RPaths {
+			args = append(args, "-delete_rpath", rpath)
+		}
+		for _, rpath := range r.RPath {
+			args = append(args, "-add_rpath", rpath)
+		}
+		// Binaries with an invalid signature are killed on Apple Silicon.
+		return [][]string{append(args, file), {"codesign", "--force", "--sign", "-", file}}
+	default:
+		return nil
+	}
+}
+
+// machOLoadCommands are the library paths recorded in a Mach-O binary or
+// shared library.
+type machOLoadCommands struct {
+	ID     string   // LC_ID_DYLIB, if the file is a shared library.
+	Dylibs []string // LC_LOAD_DYLIB and LC_LOAD_WEAK_DYLIB.
+	RPaths []string // LC_RPATH.
+}
+
+// parseOtoolLoadCommands returns the library paths from the output of "otool -l".
+func parseOtoolLoadCommands(out string) machOLoadCommands {
+	var (
+		loads machOLoadCommands
+		cmd   string
+	)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[0] == "cmd" {
+			cmd = fields[1]
+			continue
+		}
+		if fields[0] != "name" && fields[0] != "path" {
+			continue
+		}
+		// eg. "path /usr/lib (offset 12)"
+		value, _, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0])), " (offset ")
+		switch {
+		case cmd == "LC_RPATH" && fields[0] == "path":
+			loads.RPaths = append(loads.RPaths, value)
+		case cmd == "LC_ID_DYLIB" && fields[0] == "name":
+			loads.ID = value
+		case (cmd == "LC_LOAD_DYLIB" || cmd == "LC_LOAD_WEAK_DYLIB") && fields[0] == "name":
+			loads.Dylibs = append(loads.Dylibs, value)
+		}
+		cmd = ""
+	}
+	return loads
+}
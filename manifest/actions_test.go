@@ -0,0 +1,96 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestRunActionEnvIncludesRuntimeDeps(t *testing.T) {
+	pkg := &Package{RuntimeDepPaths: []string{"/state/binaries/dep-1.0.0"}}
+
+	action := &RunAction{Env: []string{"FOO=bar", "PATH=/usr/bin"}}
+	assert.Equal(t, []string{"FOO=bar", "PATH=/state/binaries/dep-1.0.0:/usr/bin"}, action.commandEnv(pkg))
+
+	action = &RunAction{Env: []string{"FOO=bar"}}
+	assert.Equal(t, []string{"FOO=bar", "PATH=/state/binaries/dep-1.0.0"}, action.commandEnv(pkg))
+
+	action = &RunAction{Env: []string{"FOO=bar"}}
+	assert.Equal(t, []string{"FOO=bar"}, action.commandEnv(&Package{}))
+}
+
+func TestRelocateActionCommands(t *testing.T) {
+	action := &RelocateAction{Files: []string{"/pkg/bin/tool"}, RPath: []string{"/pkg/lib", "/pkg/lib64"}}
+	assert.Equal(t,
+		[][]string{{"patchelf", "--set-rpath", "/pkg/lib:/pkg/lib64", "/pkg/bin/tool"}},
+		action.commands("linux", "/pkg/bin/tool", machOLoadCommands{}))
+	assert.Equal(t,
+		[][]string{
+			{"install_name_tool", "-delete_rpath", "/build/lib", "-add_rpath", "/pkg/lib", "-add_rpath", "/pkg/lib64", "/pkg/bin/tool"},
+			{"codesign", "--force", "--sign", "-", "/pkg/bin/tool"},
+		},
+		action.commands("darwin", "/pkg/bin/tool", machOLoadCommands{RPaths: []string{"/build/lib"}}))
+	assert.Equal(t,
+		[][]string{
+			{"install_name_tool",
+				"-id", "@rpath/libfoo.dylib",
+				"-change", "/build/lib/libbar.dylib", "@rpath/libbar.dylib",
+				"-delete_rpath", "/build/lib",
+				"-add_rpath", "/pkg/lib", "-add_rpath", "/pkg/lib64",
+				"/pkg/lib/libfoo.dylib"},
+			{"codesign", "--force", "--sign", "-", "/pkg/lib/libfoo.dylib"},
+		},
+		action.commands("darwin", "/pkg/lib/libfoo.dylib", machOLoadCommands{
+			ID:     "/build/lib/libfoo.dylib",
+			Dylibs: []string{"/build/lib/libbar.dylib"},
+			RPaths: []string{"/build/lib"},
+		}))
+	assert.Equal(t,
+		[][]string{
+			{"install_name_tool", "-add_rpath", "/pkg/lib", "-add_rpath", "/pkg/lib64", "/pkg/lib/libfoo.dylib"},
+			{"codesign", "--force", "--sign", "-", "/pkg/lib/libfoo.dylib"},
+		},
+		action.commands("darwin", "/pkg/lib/libfoo.dylib", machOLoadCommands{ID: "@rpath/libfoo.dylib"}))
+	assert.Zero(t, action.commands("windows", "/pkg/bin/tool", machOLoadCommands{}))
+}
+
+func TestRelocateActionBundled(t *testing.T) {
+	lib := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(lib, "libbar.dylib"), nil, 0600))
+	action := &RelocateAction{RPath: []string{lib}}
+	assert.Equal(t,
+		[]string{"/build/lib/libbar.dylib"},
+		action.bundled([]string{"/build/lib/libbar.dylib", "/usr/lib/libSystem.B.dylib", "@rpath/libbar.dylib"}))
+}
+
+func TestParseOtoolLoadCommands(t *testing.T) {
+	out := `
+Load command 3
+          cmd LC_ID_DYLIB
+      cmdsize 48
+         name /build/out/lib/libfoo.dylib (offset 24)
+Load command 12
+          cmd LC_LOAD_DYLIB
+      cmdsize 56
+         name /usr/lib/libSystem.B.dylib (offset 24)
+Load command 13
+          cmd LC_LOAD_WEAK_DYLIB
+      cmdsize 56
+         name /build/out/lib/libbar.dylib (offset 24)
+Load command 14
+          cmd LC_RPATH
+      cmdsize 32
+         path /build/out/lib (offset 12)
+Load command 15
+          cmd LC_RPATH
+      cmdsize 40
+         path @loader_path/../lib (offset 12)
+`
+	assert.Equal(t, machOLoadCommands{
+		ID:     "/build/out/lib/libfoo.dylib",
+		Dylibs: []string{"/usr/lib/libSystem.B.dylib", "/build/out/lib/libbar.dylib"},
+		RPaths: []string{"/build/out/lib", "@loader_path/../lib"},
+	}, parseOtoolLoadCommands(out))
+}
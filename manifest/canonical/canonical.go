@@ -0,0 +1,148 @@
+// Package canonical reorders package manifests into a canonical order, so
+// that they diff and merge cleanly.
+package canonical
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+)
+
+// attributeOrder ranks top-level attributes. The package metadata comes
+// first, followed by layer attributes in schema order, with "sha256sums"
+// after everything else as "add-digests" writes it.
+var attributeOrder = func() map[string]int {
+	keys := []string{"description", "homepage", "repository", "default"}
+	t := reflect.TypeOf(manifest.Layer{})
+	for i := 0; i < t.NumField(); i++ {
+		name, options, _ := strings.Cut(t.Field(i).Tag.Get("hcl"), ",")
+		if name == "" || name == "-" || strings.Contains(options, "block") {
+			continue
+		}
+		keys = append(keys, name)
+	}
+	order := map[string]int{}
+	for i, key := range keys {
+		order[key] = i
+	}
+	return order
+}()
+
+// Entry groups, in canonical order.
+const (
+	groupAttribute = iota
+	groupUnknownAttribute
+	groupBlock // Platform blocks and triggers, which keep their relative order.
+	groupVersion
+	groupChannel
+	groupSHA256Sums
+)
+
+// Sort the manifest at path into canonical order, returning true if it was
+// reordered. The manifest is left untouched if it is already in order.
+func Sort(path string) (changed bool, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	ast, err := hcl.ParseBytes(content)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if !SortAST(ast) {
+		return false, nil
+	}
+	content, err = hcl.MarshalAST(ast)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	w, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer w.Close() // nolint
+	defer os.Remove(w.Name())
+	if _, err = w.Write(content); err != nil {
+		return false, errors.WithStack(err)
+	}
+	if err = w.Chmod(info.Mode()); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, errors.WithStack(os.Rename(w.Name(), path))
+}
+
+// SortAST reorders the top-level entries of a manifest AST in place,
+// returning true if their order changed.
+//
+// Top-level attributes are ordered as in the schema, followed by platform
+// blocks and triggers in their original order, version blocks from the
+// highest version down, and channel blocks by name. Comments move with the
+// entries they are attached to.
+func SortAST(ast *hcl.AST) (changed bool) {
+	original := slices.Clone(ast.Entries)
+	sort.SliceStable(ast.Entries, func(i, j int) bool {
+		a, b := ast.Entries[i], ast.Entries[j]
+		ga, gb := group(a), group(b)
+		if ga != gb {
+			return ga < gb
+		}
+		switch ga {
+		case groupAttribute:
+			return attributeOrder[a.Attribute.Key] < attributeOrder[b.Attribute.Key]
+		case groupVersion:
+			return highestVersion(b.Block).Less(highestVersion(a.Block))
+		case groupChannel:
+			return label(a.Block) < label(b.Block)
+		}
+		return false
+	})
+	return !slices.Equal(original, ast.Entries)
+}
+
+func group(entry *hcl.Entry) int {
+	if entry.Attribute != nil {
+		if entry.Attribute.Key == "sha256sums" {
+			return groupSHA256Sums
+		}
+		if _, ok := attributeOrder[entry.Attribute.Key]; ok {
+			return groupAttribute
+		}
+		return groupUnknownAttribute
+	}
+	switch entry.Block.Name {
+	case "version":
+		return groupVersion
+	case "channel":
+		return groupChannel
+	}
+	return groupBlock
+}
+
+func highestVersion(block *hcl.Block) manifest.Version {
+	var highest manifest.Version
+	for _, l := range block.Labels {
+		if version := manifest.ParseVersion(l); !highest.IsSet() || highest.Less(version) {
+			highest = version
+		}
+	}
+	return highest
+}
+
+func label(block *hcl.Block) string {
+	if len(block.Labels) == 0 {
+		return ""
+	}
+	return block.Labels[0]
+}
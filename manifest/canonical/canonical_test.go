@@ -0,0 +1,98 @@
+package canonical_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/manifest/canonical"
+)
+
+func TestSort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go.hcl")
+	err := os.WriteFile(path, []byte(`
+source = "https://golang.org/dl/go${version}.${os}-${arch}.tar.gz"
+binaries = ["bin/go"]
+description = "Go"
+
+channel "tip" {
+  update = "24h"
+}
+
+// Old but still used.
+version "1.9.7" "1.18.0" {
+}
+
+channel "stable" {
+  update = "24h"
+  version = "1.*"
+}
+
+version "1.20.0" {
+  // Set by the installer.
+  env = {
+    "GOROOT": "${root}",
+  }
+}
+
+sha256sums = {
+  "https://golang.org/dl/go1.20.0.linux-amd64.tar.gz": "abc",
+}
+
+linux {
+  dest = "${HERMIT_ENV}"
+}
+
+version "1.19.0" {
+}
+`), 0600)
+	assert.NoError(t, err)
+
+	changed, err := canonical.Sort(path)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `description = "Go"
+binaries = ["bin/go"]
+source = "https://golang.org/dl/go${version}.${os}-${arch}.tar.gz"
+
+linux {
+  dest = "${HERMIT_ENV}"
+}
+
+version "1.20.0" {
+  // Set by the installer.
+  env = {
+    "GOROOT": "${root}",
+  }
+}
+
+version "1.19.0" {
+}
+
+// Old but still used.
+version "1.9.7" "1.18.0" {
+}
+
+channel "stable" {
+  update = "24h"
+  version = "1.*"
+}
+
+channel "tip" {
+  update = "24h"
+}
+
+sha256sums = {
+  "https://golang.org/dl/go1.20.0.linux-amd64.tar.gz": "abc",
+}
+`, string(content))
+
+	// Sorted manifests are left untouched.
+	changed, err = canonical.Sort(path)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
@@ -3,6 +3,7 @@ package manifest
 import (
 	"reflect"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/cashapp/hermit/envars"
@@ -24,33 +25,51 @@ const (
 
 // A Layer contributes to the final merged manifest definition.
 type Layer struct {
-	Arch         string            `hcl:"arch,optional" help:"CPU architecture to match (amd64, 386, arm, etc.)."`
-	Binaries     []string          `hcl:"binaries,optional" help:"Relative glob from $root to individual terminal binaries."`
-	Apps         []string          `hcl:"apps,optional" help:"Relative paths to Mac .app packages to install."`
-	Rename       map[string]string `hcl:"rename,optional" help:"Rename files after unpacking to ${root}."`
-	Requires     []string          `hcl:"requires,optional" help:"Packages this one requires."`
-	RuntimeDeps  []string          `hcl:"runtime-dependencies,optional" help:"Packages used internally by this package, but not installed to the target environment"`
-	Provides     []string          `hcl:"provides,optional" help:"This package provides the given virtual packages."`
-	Dest         string            `hcl:"dest,optional" help:"Override archive extraction destination for package."`
-	Files        map[string]string `hcl:"files,optional" help:"Files to load strings from to be used in the manifest."`
-	Strip        int               `hcl:"strip,optional" help:"Number of path prefix elements to strip."`
-	Root         string            `hcl:"root,optional" help:"Override root for package."`
-	Test         *string           `hcl:"test,optional" help:"Command that will test the package is operational."`
-	Env          envars.Envars     `hcl:"env,optional" help:"Environment variables to export."`
-	Vars         map[string]string `hcl:"vars,optional" help:"Set local variables used during manifest evaluation."`
-	Source       string            `hcl:"source,optional" help:"URL for source package. Valid URLs are Git repositories (using .git[#<tag>] suffix), Local Files (using file:// prefix), and Remote Files (using http:// or https:// prefix)"`
-	DontExtract  bool              `hcl:"dont-extract,optional" help:"Don't extract the package source, just copy it into the installation directory."`
-	Mirrors      []string          `hcl:"mirrors,optional" help:"Mirrors to use if the primary source is unavailable."`
-	SHA256       string            `hcl:"sha256,optional" help:"SHA256 of source package for verification. When in conflict with SHA256 in sha256sums, this value takes precedence."`
-	SHA256Source string            `hcl:"sha256-source,optional" help:"URL for SHA256 checksum file for source package."`
-	Darwin       []*Layer          `hcl:"darwin,block" help:"Darwin-specific configuration."`
-	Linux        []*Layer          `hcl:"linux,block" help:"Linux-specific configuration."`
-	Platform     []*PlatformBlock  `hcl:"platform,block" help:"Platform-specific configuration. <attr> is a set regexes that must all match against one of CPU, OS, etc.."`
-	Triggers     []*Trigger        `hcl:"on,block" help:"Triggers to run on lifecycle events."`
-	Mutable      bool              `hcl:"mutable,optional" help:"Package will not be made read-only."`
-}
-
-func (c Layer) layers(os string, arch string) (out layers) {
+	Arch                  string            `hcl:"arch,optional" help:"CPU architecture to match (amd64, 386, arm, etc.)."`
+	Binaries              []string          `hcl:"binaries,optional" help:"Relative glob from $root to individual terminal binaries."`
+	Apps                  []string          `hcl:"apps,optional" help:"Relative paths to Mac .app packages to install."`
+	Rename                map[string]string `hcl:"rename,optional" help:"Rename files after unpacking to ${root}."`
+	Requires              []string          `hcl:"requires,optional" help:"Packages this one requires, by name, by a name in \"provides\", or on a channel, eg. openssl@stable."`
+	RuntimeDeps           []string          `hcl:"runtime-dependencies,optional" help:"Packages used internally by this package, but not installed to the target environment"`
+	SystemRequires        []string          `hcl:"system-requires,optional" help:"Tools this package requires from the host system rather than Hermit, with optional version constraints, eg. git>=2.30."`
+	Provides              []string          `hcl:"provides,optional" help:"This package provides the given virtual packages."`
+	Dest                  string            `hcl:"dest,optional" help:"Override archive extraction destination for package. Use ${HERMIT_ENV} for a destination within the environment."`
+	Files                 map[string]string `hcl:"files,optional" help:"Files to load strings from to be used in the manifest."`
+	Strip                 int               `hcl:"strip,optional" help:"Number of path prefix elements to strip."`
+	Exclude               []string          `hcl:"exclude,optional" help:"Glob patterns of archive entries not to extract, relative to the package after stripping, eg. \"doc/**\". Patterns without a / match file names at any depth. Entries that symlinks within the package point to are always extracted."`
+	Inner                 string            `hcl:"inner,optional" help:"Path of an archive within the source archive to extract instead, eg. \"tool.tar.gz\". strip and exclude apply to the inner archive."`
+	UnsafeSymlinks        string            `hcl:"unsafe-symlinks,optional" help:"How to extract symlinks in tar and zip archives that are absolute or point outside the package: keep (the default) creates them as they are, reject fails extraction, and rewrite points them at the same path relative to the package."`
+	Root                  string            `hcl:"root,optional" help:"Override root for package."`
+	Test                  *string           `hcl:"test,optional" help:"Command that will test the package is operational."`
+	Env                   envars.Envars     `hcl:"env,optional" help:"Environment variables to export."`
+	Vars                  map[string]string `hcl:"vars,optional" help:"Set local variables used during manifest evaluation."`
+	VersionVars           map[string]string `hcl:"version-vars,optional" help:"Set local variables captured from the package version by regular expression, eg. major = \"^[0-9]+\". The value is the first capture group, or the whole match if there are none."`
+	Source                string            `hcl:"source,optional" help:"URL for source package. Valid URLs are Git repositories (using .git[#<tag>] suffix), Local Files (using file:// prefix), and Remote Files (using http:// or https:// prefix)"`
+	DontExtract           bool              `hcl:"dont-extract,optional" help:"Don't extract the package source, just copy it into the installation directory."`
+	Filename              string            `hcl:"filename,optional" help:"Name for a source that is not an archive, such as a single executable. Defaults to the filename suggested by the server's Content-Disposition header, or the last element of the source URL."`
+	Mirrors               []string          `hcl:"mirrors,optional" help:"Mirrors to use if the primary source is unavailable or its content has the wrong checksum."`
+	SHA256                string            `hcl:"sha256,optional" help:"SHA256 of source package for verification, in hex or as sha256-<base64>. When in conflict with SHA256 in sha256sums, this value takes precedence."`
+	Integrity             string            `hcl:"integrity,optional" help:"Space separated digests of the source package in Subresource Integrity format, eg. sha256-<base64> sha512-<base64>. The package is verified if any digest matches."`
+	SHA256Source          string            `hcl:"sha256-source,optional" help:"URL for SHA256 checksum file for source package."`
+	SHA256SourceSignature string            `hcl:"sha256-source-signature,optional" help:"URL for a detached GPG signature over the checksum file in sha256-source."`
+	SHA256SourceKey       string            `hcl:"sha256-source-key,optional" help:"ASCII armored GPG public key(s) used to verify sha256-source-signature."`
+	Darwin                []*Layer          `hcl:"darwin,block" help:"Darwin-specific configuration."`
+	Linux                 []*Layer          `hcl:"linux,block" help:"Linux-specific configuration."`
+	Platform              []*PlatformBlock  `hcl:"platform,block" help:"Platform-specific configuration. <attr> is a set regexes that must all match against one of CPU, OS, etc.."`
+	Triggers              []*Trigger        `hcl:"on,block" help:"Triggers to run on lifecycle events."`
+	Mutable               bool              `hcl:"mutable,optional" help:"Package will not be made read-only. Each environment extracts its own copy of a mutable package into .hermit/mutable, unless dest is set."`
+	Concurrency           int               `hcl:"concurrency,optional" help:"Set to 1 to prevent this package being downloaded, extracted, or its hooks run, concurrently with other packages."`
+	Priority              int               `hcl:"priority,optional" help:"Order in which environment variables from this package are applied relative to other packages, lowest first. Later packages take precedence, eg. in $PATH. Ties are applied in package name order."`
+	Deprecated            string            `hcl:"deprecated,optional" help:"Deprecation warning shown when this package is installed, eg. because the version is end-of-life."`
+
+	// Description of where this layer was defined in the manifest.
+	name string `hcl:"-"`
+}
+
+// Return this layer and its matching OS, architecture and platform layers, in
+// merge order. "name" describes where this layer was defined.
+func (c Layer) layers(name string, os string, arch string) (out layers) {
+	c.name = name
 	out = append(out, &c)
 	var selected []*Layer
 	switch os {
@@ -62,7 +81,9 @@ func (c Layer) layers(os string, arch string) (out layers) {
 	if len(selected) != 0 {
 		for _, layer := range selected {
 			if layer.match(arch) {
-				out = append(out, layer)
+				nested := *layer
+				nested.name = name + " > " + os
+				out = append(out, &nested)
 			}
 		}
 	}
@@ -78,7 +99,9 @@ nextPlatform:
 				continue nextPlatform
 			}
 		}
-		out = append(out, &platform.Layer)
+		nested := platform.Layer
+		nested.name = name + " > platform " + strings.Join(platform.Attrs, " ")
+		out = append(out, &nested)
 	}
 	return out
 }
@@ -124,11 +147,17 @@ type ChannelBlock struct {
 	Name    string        `hcl:"name,label" help:"Name of the channel (eg. stable, alpha, etc.)."`
 	Update  time.Duration `hcl:"update" help:"Update frequency for this channel."`
 	Version string        `hcl:"version,optional" help:"Use the latest version matching this version glob as the source of this channel. Empty string matches all versions"`
+	// Parsed by ParseSchedule.
+	UpdateSchedule string `hcl:"update-schedule,optional" help:"Cron-like schedule of when this channel may be updated, in addition to the update frequency, eg. \"* * * * mon-fri\" for weekdays. The fields are minute, hour, day of month, month and day of week, and are interpreted in the local time of the machine running Hermit."`
 	Layer
 }
 
+func (v *VersionBlock) layerName() string {
+	return "version " + strings.Join(v.Version, ", ")
+}
+
 func (c *ChannelBlock) layersWithReferences(os string, arch string, m *Manifest) (layers, error) {
-	layer := c.layers(os, arch)
+	layer := c.layers("channel "+c.Name, os, arch)
 	if c.Version != "" {
 		v := c.Version
 		g, err := ParseGlob(v)
@@ -137,7 +166,7 @@ func (c *ChannelBlock) layersWithReferences(os string, arch string, m *Manifest)
 		}
 		result, _ := m.HighestMatch(g)
 		if result != nil {
-			return append(result.layers(os, arch), layer...), nil
+			return append(result.layers(result.layerName(), os, arch), layer...), nil
 		}
 
 		return nil, errors.Errorf("@%s: no version found matching %s", c.Name, v)
@@ -153,7 +182,7 @@ type Manifest struct {
 	Description string            `hcl:"description" help:"Human readable description of the package."`
 	Homepage    string            `hcl:"homepage,optional" help:"Home page."`
 	Repository  string            `hcl:"repository,optional" help:"Source Repository."`
-	SHA256Sums  map[string]string `hcl:"sha256sums,optional" help:"SHA256 checksums of source packages for verification."`
+	SHA256Sums  map[string]string `hcl:"sha256sums,optional" help:"SHA256 checksums of source packages for verification, in hex or as sha256-<base64>."`
 	Versions    []VersionBlock    `hcl:"version,block" help:"Definition of and configuration for a specific version."`
 	Channels    []ChannelBlock    `hcl:"channel,block" help:"Definition of and configuration for an auto-update channel."`
 }
@@ -163,11 +192,11 @@ func (m *Manifest) layers(ref Reference, os string, arch string) (layers, error)
 	versionLayers := map[string]layers{}
 
 	for _, v := range m.Versions {
-		l := v.layers(os, arch)
+		l := v.layers(v.layerName(), os, arch)
 		for _, version := range v.Version {
 			versionLayers[version] = l
 			if version == ref.Version.String() {
-				return append(m.Layer.layers(os, arch), l...), nil
+				return append(m.Layer.layers("manifest", os, arch), l...), nil
 			}
 		}
 	}
@@ -177,7 +206,7 @@ func (m *Manifest) layers(ref Reference, os string, arch string) (layers, error)
 			if err != nil {
 				return nil, err
 			}
-			return append(m.Layer.layers(os, arch), l...), nil
+			return append(m.Layer.layers("manifest", os, arch), l...), nil
 		}
 	}
 	return nil, nil
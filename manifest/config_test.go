@@ -293,6 +293,7 @@ func TestManifest(t *testing.T) {
 				assert.NoError(t, err)
 				test.expected.Root = "/tmp/hermit/pkg/" + test.pkg
 				test.expected.Dest = "/tmp/hermit/pkg/" + test.pkg
+				test.expected.Platform = platform.Platform{OS: hos, Arch: arch}
 				pkg.FS = nil
 				assert.Equal(t,
 					repr.String(test.expected, repr.Indent("  ")),
@@ -0,0 +1,88 @@
+// Package coverage reports which platforms the packages in a set of manifests
+// support.
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/platform"
+)
+
+// Support is the degree to which a package supports a platform.
+type Support string
+
+// Degrees of Support.
+const (
+	// SupportFull means all versions and channels have a source for the platform.
+	SupportFull Support = "full"
+	// SupportPartial means only some versions and channels have a source for the platform.
+	SupportPartial Support = "partial"
+	// SupportNone means no version or channel has a source for the platform.
+	SupportNone Support = "none"
+)
+
+// Package is the platform coverage of a single package.
+type Package struct {
+	Name      string             `json:"name"`
+	Platforms map[string]Support `json:"platforms"`
+	// Core platforms with no support at all.
+	MissingCore []string `json:"missing_core,omitempty"`
+}
+
+// Report computes the coverage of platforms for the manifest at each path.
+//
+// A package supports a platform for a given version or channel if it resolves
+// to a source with binaries on that platform.
+func Report(paths []string, platforms []platform.Platform) ([]*Package, error) {
+	out := make([]*Package, 0, len(paths))
+	for _, path := range paths {
+		pkg, err := reportManifest(path, platforms)
+		if err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+		out = append(out, pkg)
+	}
+	return out, nil
+}
+
+func reportManifest(path string, platforms []platform.Platform) (*Package, error) {
+	filename := filepath.Base(path)
+	name := strings.TrimSuffix(filename, ".hcl")
+	mani, err := manifest.LoadManifestFile(os.DirFS(filepath.Dir(path)), filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	refs := mani.References(name)
+	out := &Package{Name: name, Platforms: map[string]Support{}}
+	for _, p := range platforms {
+		config := manifest.Config{Env: ".", State: "/tmp", Platform: p}
+		supported := 0
+		for _, ref := range refs {
+			_, err := manifest.Resolve(mani, config, ref)
+			if errors.Is(err, manifest.ErrNoSource) || errors.Is(err, manifest.ErrNoBinaries) {
+				continue
+			} else if err != nil {
+				return nil, errors.Wrapf(err, "%s on %s", ref, p)
+			}
+			supported++
+		}
+		support := SupportPartial
+		switch supported {
+		case 0:
+			support = SupportNone
+		case len(refs):
+			support = SupportFull
+		}
+		out.Platforms[p.String()] = support
+	}
+	for _, p := range platform.Core {
+		if out.Platforms[p.String()] == SupportNone {
+			out.MissingCore = append(out.MissingCore, p.String())
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,54 @@
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/platform"
+)
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	manifests := map[string]string{
+		"partial.hcl": `
+			description = ""
+			binaries = ["partial"]
+			linux { source = "https://example.com/partial-${version}-linux.tgz" }
+			version "1.0.0" {}
+			version "2.0.0" {
+			  darwin { source = "https://example.com/partial-${version}-darwin.tgz" }
+			}
+		`,
+		"linuxonly.hcl": `
+			description = ""
+			binaries = ["linuxonly"]
+			linux { source = "https://example.com/linuxonly-${version}.tgz" }
+			version "1.0.0" {}
+		`,
+	}
+	paths := []string{}
+	for name, content := range manifests {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		paths = append(paths, path)
+	}
+	platforms := []platform.Platform{{OS: platform.Linux, Arch: platform.Amd64}, {OS: platform.Darwin, Arch: platform.Arm64}}
+	report, err := Report(paths, platforms)
+	assert.NoError(t, err)
+	byName := map[string]*Package{}
+	for _, pkg := range report {
+		byName[pkg.Name] = pkg
+	}
+	assert.Equal(t, &Package{
+		Name:      "partial",
+		Platforms: map[string]Support{"linux-amd64": SupportFull, "darwin-arm64": SupportPartial},
+	}, byName["partial"])
+	assert.Equal(t, &Package{
+		Name:        "linuxonly",
+		Platforms:   map[string]Support{"linux-amd64": SupportFull, "darwin-arm64": SupportNone},
+		MissingCore: []string{"darwin-arm64"},
+	}, byName["linuxonly"])
+}
@@ -0,0 +1,108 @@
+// Package deprecate marks versions in a package manifest as deprecated.
+package deprecate
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// Deprecate adds a "deprecated" attribute with message to the version block
+// for version in the manifest at path.
+//
+// If the version shares a block with other versions, it is split out into
+// its own copy of the block so that only it is deprecated.
+func Deprecate(path, version, message string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ast, err := hcl.ParseBytes(content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	block, err := splitVersionBlock(ast, version)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	setAttribute(block, "deprecated", message)
+
+	content, err = hcl.MarshalAST(ast)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer w.Close() // nolint
+	defer os.Remove(w.Name())
+	_, err = w.Write(content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err = w.Chmod(info.Mode()); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(w.Name(), path))
+}
+
+// splitVersionBlock returns the top-level version block labelled only with
+// version, splitting it out of a multi-version block if necessary.
+func splitVersionBlock(ast *hcl.AST, version string) (*hcl.Block, error) {
+	for i, entry := range ast.Entries {
+		block := entry.Block
+		if block == nil || block.Name != "version" {
+			continue
+		}
+		for j, label := range block.Labels {
+			if label != version {
+				continue
+			}
+			if len(block.Labels) == 1 {
+				return block, nil
+			}
+			split, err := cloneEntry(entry)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			split.Block.Labels = []string{version}
+			block.Labels = append(block.Labels[:j:j], block.Labels[j+1:]...)
+			ast.Entries = append(ast.Entries[:i+1:i+1], append([]*hcl.Entry{split}, ast.Entries[i+1:]...)...)
+			return split.Block, nil
+		}
+	}
+	return nil, errors.Errorf("no version %s in manifest", version)
+}
+
+// cloneEntry deep copies entry by round-tripping it through the marshaller,
+// as hcl.Entry.Clone does not copy map values.
+func cloneEntry(entry *hcl.Entry) (*hcl.Entry, error) {
+	content, err := hcl.MarshalAST(&hcl.AST{Entries: []*hcl.Entry{entry}})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ast, err := hcl.ParseBytes(content)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ast.Entries[0], nil
+}
+
+// setAttribute sets a string attribute in block, replacing any existing value.
+func setAttribute(block *hcl.Block, key, value string) {
+	for _, entry := range block.Body {
+		if entry.Attribute != nil && entry.Attribute.Key == key {
+			entry.Attribute.Value = &hcl.Value{Str: &value}
+			return
+		}
+	}
+	block.Body = append(block.Body, &hcl.Entry{Attribute: &hcl.Attribute{Key: key, Value: &hcl.Value{Str: &value}}})
+}
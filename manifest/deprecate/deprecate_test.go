@@ -0,0 +1,66 @@
+package deprecate_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/manifest/deprecate"
+)
+
+func TestDeprecate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go.hcl")
+	err := os.WriteFile(path, []byte(`
+description = "Go"
+binaries = ["bin/go"]
+source = "https://golang.org/dl/go${version}.${os}-${arch}.tar.gz"
+
+version "1.18.0" "1.19.0" {
+  env = {
+    "GOROOT": "${root}",
+  }
+}
+
+version "1.20.0" {
+}
+`), 0600)
+	assert.NoError(t, err)
+
+	err = deprecate.Deprecate(path, "1.18.0", "EOL, upgrade to 1.20+")
+	assert.NoError(t, err)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `description = "Go"
+binaries = ["bin/go"]
+source = "https://golang.org/dl/go${version}.${os}-${arch}.tar.gz"
+
+version "1.19.0" {
+  env = {
+    "GOROOT": "${root}",
+  }
+}
+
+version "1.18.0" {
+  env = {
+    "GOROOT": "${root}",
+  }
+  deprecated = "EOL, upgrade to 1.20+"
+}
+
+version "1.20.0" {
+}
+`, string(content))
+
+	// Deprecating again replaces the message.
+	err = deprecate.Deprecate(path, "1.18.0", "Unsupported")
+	assert.NoError(t, err)
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `deprecated = "Unsupported"`)
+	assert.NotContains(t, string(content), "EOL")
+
+	err = deprecate.Deprecate(path, "1.17.0", "EOL")
+	assert.EqualError(t, err, "no version 1.17.0 in manifest")
+}
@@ -1,6 +1,7 @@
 package digest
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -13,18 +14,42 @@ import (
 	"sync"
 
 	"github.com/alecthomas/hcl"
+	"golang.org/x/sync/errgroup"
+
 	"github.com/cashapp/hermit/errors"
 	"github.com/cashapp/hermit/manifest"
 	"github.com/cashapp/hermit/platform"
 	"github.com/cashapp/hermit/state"
 	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
+)
+
+// Format of digests written to manifests.
+type Format string
+
+const (
+	// FormatHex writes hex encoded sha256 digests.
+	FormatHex Format = "hex"
+	// FormatSRI writes sha256 digests in Subresource Integrity format, eg. "sha256-<base64>".
+	FormatSRI Format = "sri"
 )
 
+// DefaultConcurrency is the number of sources digested at once by default.
+const DefaultConcurrency = 4
+
 // UpdateDigests for the manifest at the given path.
 //
 // Sources with existing digests will be skipped. All sources for the core
 // supported platforms will be checked.
-func UpdateDigests(l *ui.UI, client *http.Client, state *state.State, path string) error {
+//
+// If "keepGoing" is true, every source is attempted even if some fail, and
+// all failures are returned together once the digests that could be computed
+// have been written. Otherwise the first failure is returned immediately.
+//
+// New digests are written in the given format. Up to "concurrency" sources
+// are digested at once, with new digests written to the manifest as they are
+// computed.
+func UpdateDigests(l *ui.UI, client *http.Client, state *state.State, path string, keepGoing bool, format Format, concurrency int) error {
 	filename := filepath.Base(path)
 	name := strings.TrimSuffix(filename, ".hcl")
 	task := l.Task(name)
@@ -33,33 +58,19 @@ func UpdateDigests(l *ui.UI, client *http.Client, state *state.State, path strin
 	if err != nil {
 		return errors.Wrap(err, "failed to load manifest")
 	}
-	// Dedupe by source, as channels often have the same source as normal packages.
-	pkgsBySource := map[string]pkgAndref{}
-	for _, ref := range mani.References(name) {
-		for _, platform := range platform.Core {
-			config := manifest.Config{Env: ".", State: "/tmp", Platform: platform}
-			pkg, err := manifest.Resolve(mani, config, ref)
-			if errors.Is(err, manifest.ErrNoSource) {
-				task.Warnf("No source provided for %s on %s", ref, platform)
-				continue
-			}
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			// Skip git repos
-			if strings.Contains(pkg.Source, ".git#") || strings.HasSuffix(pkg.Source, ".git") {
-				continue
-			}
-			// Skip checksums for channels.
-			if pkg.Reference.Channel != "" {
-				continue
-			}
-			existing, ok := pkgsBySource[pkg.Source]
-			if ok && existing.pkg.SHA256 != "" {
-				continue
-			}
-			pkgsBySource[pkg.Source] = pkgAndref{pkg, ref, platform}
+	var errs []error
+	// fail returns err if not keeping going, otherwise records it for later.
+	fail := func(err error) error {
+		if !keepGoing {
+			return err
 		}
+		task.Warnf("%s", err)
+		errs = append(errs, err)
+		return nil
+	}
+	pkgsBySource, err := packagesBySource(task, mani, name, fail)
+	if err != nil {
+		return err
 	}
 
 	missing := 0
@@ -70,40 +81,204 @@ func UpdateDigests(l *ui.UI, client *http.Client, state *state.State, path strin
 	}
 
 	if missing == 0 {
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
 		task.Infof("All packages have checksums!")
 		return nil
 	}
 
 	task.Infof("Updating %d checksums...", missing)
 
-	updated := []pkgAndDigest{}
+	var (
+		lock    sync.Mutex
+		updated []pkgAndDigest
+		// The first error that stops further digests being computed.
+		stopped error
+	)
+	wg := errgroup.Group{}
+	wg.SetLimit(max(1, concurrency))
 
-	// Compute missing checksums
-	for _, pkg := range pkgsBySource {
+	// Compute missing checksums, started in a stable order so failures are reported consistently.
+	sources := make([]string, 0, len(pkgsBySource))
+	for source := range pkgsBySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		pkg := pkgsBySource[source]
 		if pkg.pkg.SHA256 != "" {
 			task.Debugf("  %s %s (existing)", pkg.pkg.SHA256, pkg.pkg.Source)
 			continue
 		}
-		digest, err := computeDigest(task, client, state, pkg.pkg)
-		if err != nil {
-			return errors.Wrapf(err, "failed to compute digest for %s/%s", pkg.ref.String(), pkg.platform)
+		lock.Lock()
+		stop := stopped != nil
+		lock.Unlock()
+		if stop {
+			break
 		}
-		task.Infof("  %s %s", digest, pkg.pkg.Source)
-		updated = append(updated, pkgAndDigest{pkg.pkg.Reference, pkg.pkg.Source, digest})
-		if len(updated) > 10 {
-			err := snapshotDigests(path, updated)
+		wg.Go(func() error {
+			digest, err := computeDigest(task, client, state, pkg.pkg)
+			if err == nil && format == FormatSRI {
+				digest, err = manifest.SRIFromSHA256(digest)
+			}
+			lock.Lock()
+			defer lock.Unlock()
 			if err != nil {
-				return errors.WithStack(err)
+				if err := fail(errors.Wrapf(err, "failed to compute digest for %s/%s", pkg.ref.String(), pkg.platform)); err != nil && stopped == nil {
+					stopped = err
+				}
+				return nil
+			}
+			task.Infof("  %s %s", digest, pkg.pkg.Source)
+			updated = append(updated, pkgAndDigest{pkg.pkg.Reference, pkg.pkg.Source, digest})
+			if len(updated) > 10 {
+				if err := snapshotDigests(path, updated); err != nil && stopped == nil {
+					stopped = errors.WithStack(err)
+				}
+				updated = nil
 			}
-			updated = []pkgAndDigest{}
+			return nil
+		})
+	}
+	_ = wg.Wait()
+	if stopped != nil {
+		return stopped
+	}
+
+	if len(updated) > 0 {
+		if err := snapshotDigests(path, updated); err != nil {
+			return errors.WithStack(err)
 		}
 	}
+	return errors.Join(errs...)
+}
+
+// Drift is a declared digest that does not match the live artefact.
+type Drift struct {
+	Reference manifest.Reference
+	Platform  platform.Platform
+	Source    string
+	Declared  string
+	Live      string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s/%s: %s declared %s but is %s", d.Reference, d.Platform, d.Source, d.Declared, d.Live)
+}
 
-	if len(updated) == 0 {
+// VerifyDigests for the manifest at the given path, without modifying it.
+//
+// Every source with a declared digest is downloaded again and its live digest
+// compared against the declared one. Sources without digests are skipped.
+//
+// "keepGoing" behaves as in UpdateDigests.
+func VerifyDigests(l *ui.UI, state *state.State, path string, keepGoing bool) ([]Drift, error) {
+	filename := filepath.Base(path)
+	name := strings.TrimSuffix(filename, ".hcl")
+	task := l.Task(name)
+	defer task.Done()
+	mani, err := manifest.LoadManifestFile(os.DirFS(filepath.Dir(path)), filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load manifest")
+	}
+	var errs []error
+	fail := func(err error) error {
+		if !keepGoing {
+			return err
+		}
+		task.Warnf("%s", err)
+		errs = append(errs, err)
 		return nil
 	}
+	pkgsBySource, err := packagesBySource(task, mani, name, fail)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]string, 0, len(pkgsBySource))
+	for source, pkg := range pkgsBySource {
+		if pkg.pkg.SHA256 != "" {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	task.Infof("Verifying %d checksums...", len(sources))
+	var drift []Drift
+	for _, source := range sources {
+		pkg := pkgsBySource[source]
+		live, err := liveDigest(task, state, pkg.pkg)
+		if err != nil {
+			if err := fail(errors.Wrapf(err, "failed to compute digest for %s/%s", pkg.ref.String(), pkg.platform)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !strings.EqualFold(live, pkg.pkg.SHA256) {
+			drift = append(drift, Drift{pkg.ref, pkg.platform, source, pkg.pkg.SHA256, live})
+			continue
+		}
+		task.Debugf("  %s %s (verified)", live, source)
+	}
+	return drift, errors.Join(errs...)
+}
+
+// liveDigest downloads the package source, ignoring its declared digest and
+// any copy already in the cache.
+//
+// The unverified download is evicted again, so that it is never used in place
+// of the package's verified source.
+func liveDigest(task *ui.Task, state *state.State, pkg *manifest.Package) (digest string, err error) {
+	unverified := *pkg
+	unverified.SHA256 = ""
+	if err := state.EvictCached(task, &unverified); err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer func() {
+		if evictErr := state.EvictCached(task, &unverified); evictErr != nil && err == nil {
+			err = errors.WithStack(evictErr)
+		}
+	}()
+	digest, err = state.CacheAndDigest(task, &unverified)
+	return digest, errors.WithStack(err)
+}
 
-	return snapshotDigests(path, updated)
+// packagesBySource resolves the manifest for the core platforms, returning
+// the versioned packages to digest keyed by source.
+//
+// Packages with a digest are preferred, as channels often have the same
+// source as versions.
+func packagesBySource(task *ui.Task, mani *manifest.AnnotatedManifest, name string, fail func(error) error) (map[string]pkgAndref, error) {
+	pkgsBySource := map[string]pkgAndref{}
+	for _, ref := range mani.References(name) {
+		for _, platform := range platform.Core {
+			config := manifest.Config{Env: ".", State: "/tmp", Platform: platform}
+			pkg, err := manifest.Resolve(mani, config, ref)
+			if errors.Is(err, manifest.ErrNoSource) {
+				task.Warnf("No source provided for %s on %s", ref, platform)
+				continue
+			}
+			if err != nil {
+				if err := fail(errors.Wrapf(err, "%s/%s", ref, platform)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			// Skip git repos
+			if strings.Contains(pkg.Source, ".git#") || strings.HasSuffix(pkg.Source, ".git") {
+				continue
+			}
+			// Skip checksums for channels.
+			if pkg.Reference.Channel != "" {
+				continue
+			}
+			existing, ok := pkgsBySource[pkg.Source]
+			if ok && existing.pkg.SHA256 != "" {
+				continue
+			}
+			pkgsBySource[pkg.Source] = pkgAndref{pkg, ref, platform}
+		}
+	}
+	return pkgsBySource, nil
 }
 
 func snapshotDigests(path string, updated []pkgAndDigest) error {
@@ -152,11 +327,15 @@ type pkgAndref struct {
 
 func computeDigest(task *ui.Task, client *http.Client, state *state.State, pkg *manifest.Package) (string, error) {
 	// As an optimisation we'll first try <source>.sha256.txt
-	if digest := tryGetSHA(task, client, pkg); digest != "" {
+	digest, err := tryGetSHA(task, client, pkg)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if digest != "" {
 		return digest, nil
 	}
 
-	digest, err := state.CacheAndDigest(task, pkg)
+	digest, err = state.CacheAndDigest(task, pkg)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -167,56 +346,93 @@ var digestRe = regexp.MustCompile(`^([A-Z0-9a-z]{64})(?:\s+(.*))?`)
 
 var checksumCache sync.Map
 
-func tryGetSHA(task *ui.Task, client *http.Client, pkg *manifest.Package) string {
+// tryGetSHA returns the digest of pkg from a remote checksums file, or "" if
+// none is found.
+//
+// If pkg has a sha256-source-signature, the signed checksums file must provide
+// the digest, so an error is returned if it cannot be fetched, its signature
+// cannot be verified, or it has no digest for the source. Otherwise an error is
+// never returned.
+func tryGetSHA(task *ui.Task, client *http.Client, pkg *manifest.Package) (string, error) {
 	u := pkg.Source
 	dir := u[:strings.LastIndex(u, "/")]
 	variants := []string{u + ".sha256.txt", u + ".sha256", dir + "/checksums.txt", dir + "/sha256.txt", dir + "/SHA256SUMS"}
 	if pkg.SHA256Source != "" {
 		variants = []string{pkg.SHA256Source}
+	} else if pkg.SHA256SourceSignature != "" {
+		return "", errors.Errorf("sha256-source-signature requires sha256-source")
 	}
+	signed := pkg.SHA256SourceSignature != ""
 	filename, err := url.PathUnescape(path.Base(u))
 	if err != nil {
 		filename = u
 	}
 	for _, variant := range variants {
-		content, ok := checksumCache.Load(variant)
+		redacted := util.RedactURL(variant)
+		// Checksums are only shared between packages verified the same way.
+		key := variant
+		if signed {
+			key += "\x00" + pkg.SHA256SourceSignature + "\x00" + pkg.SHA256SourceKey
+		}
+		content, ok := checksumCache.Load(key)
 		if !ok {
-			task.Tracef("Trying %s", variant)
+			task.Tracef("Trying %s", redacted)
 			req, err := http.NewRequest(http.MethodGet, variant, &strings.Reader{}) //nolint: noctx
 			if err != nil {
-				return ""
+				if signed {
+					return "", errors.WithStack(util.RedactURLError(err))
+				}
+				return "", nil
 			}
 			resp, err := client.Do(req)
 			if err != nil {
-				task.Tracef("Failed to fetch %s: %v", variant, err)
-				return ""
+				err = util.RedactURLError(err)
+				if signed {
+					return "", errors.Wrapf(err, "failed to fetch signed checksums %s", redacted)
+				}
+				task.Tracef("Failed to fetch %s: %v", redacted, err)
+				return "", nil
 			}
 			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 				_ = resp.Body.Close()
-				task.Tracef("%s %s", variant, resp.Status)
+				if signed {
+					return "", errors.Errorf("failed to fetch signed checksums %s: %s", redacted, resp.Status)
+				}
+				task.Tracef("%s %s", redacted, resp.Status)
 				continue
 			}
 			data, err := io.ReadAll(resp.Body)
 			_ = resp.Body.Close()
 			if err != nil {
-				task.Tracef("Failed to read %s: %v", variant, err)
+				if signed {
+					return "", errors.Wrapf(err, "failed to read signed checksums %s", redacted)
+				}
+				task.Tracef("Failed to read %s: %v", redacted, err)
 				continue
 			}
+			if signed {
+				if err := verifyChecksumSignature(task, client, pkg, data); err != nil {
+					return "", errors.WithStack(err)
+				}
+			}
 			content = string(data)
-			checksumCache.Store(variant, content)
+			checksumCache.Store(key, content)
 		}
 		lines := strings.Split(strings.TrimSpace(content.(string)), "\n") // nolint
 		allowMissingFilename := len(lines) == 1
 		for _, line := range lines {
-			task.Tracef("%s: %s", variant, line)
+			task.Tracef("%s: %s", redacted, line)
 			groups := digestRe.FindStringSubmatch(line)
 			if len(groups) > 0 && (allowMissingFilename || strings.EqualFold(groups[2], filename)) {
-				task.Tracef("Short-circuit match %s", variant)
-				return groups[1]
+				task.Tracef("Short-circuit match %s", redacted)
+				return groups[1], nil
 			}
 		}
+		if signed {
+			return "", errors.Errorf("signed checksums %s have no digest for %s", redacted, filename)
+		}
 	}
-	return ""
+	return "", nil
 }
 
 // UpdateChecksums for the manifest at the given path.
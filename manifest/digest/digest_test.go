@@ -0,0 +1,235 @@
+package digest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/cache"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/sources"
+	"github.com/cashapp/hermit/state"
+	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/vfs"
+)
+
+func TestUpdateDigestsKeepGoing(t *testing.T) {
+	const digest = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/good.tar.gz.sha256") {
+			_, _ = w.Write([]byte(digest + "\n"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool.hcl")
+	write := func() {
+		assert.NoError(t, os.WriteFile(path, []byte(`
+description = "Tool"
+binaries = ["tool"]
+
+version "1.0.0" {
+  source = "`+server.URL+`/1.0.0/missing.tar.gz"
+}
+
+version "2.0.0" {
+  source = "`+server.URL+`/${os}-${arch}/good.tar.gz"
+}
+`), 0600))
+	}
+	client := server.Client()
+	cache, err := cache.Open(dir, nil, client, client)
+	assert.NoError(t, err)
+	sta, err := state.Open(filepath.Join(dir, "state"), state.Config{
+		Builtin: sources.NewBuiltInSource(vfs.InMemoryFS(nil)),
+	}, cache)
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+
+	write()
+	err = UpdateDigests(l, client, sta, path, false, FormatHex, DefaultConcurrency)
+	assert.Error(t, err)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), digest)
+
+	write()
+	err = UpdateDigests(l, client, sta, path, true, FormatHex, DefaultConcurrency)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to compute digest for tool-1.0.0")
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, len(platform.Core), strings.Count(string(content), digest))
+
+	write()
+	err = UpdateDigests(l, client, sta, path, true, FormatSRI, DefaultConcurrency)
+	assert.Error(t, err)
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, len(platform.Core), strings.Count(string(content), "sha256-ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0="))
+}
+
+func TestUpdateDigestsConcurrently(t *testing.T) {
+	const digest = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("abc"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool.hcl")
+	manifest := `
+description = "Tool"
+binaries = ["tool"]
+`
+	const versions = 30
+	for i := range versions {
+		manifest += fmt.Sprintf(`
+version "1.0.%d" {
+  source = "%s/1.0.%d/tool.tar.gz"
+}
+`, i, server.URL, i)
+	}
+	assert.NoError(t, os.WriteFile(path, []byte(manifest), 0600))
+	client := server.Client()
+	cache, err := cache.Open(dir, nil, client, client)
+	assert.NoError(t, err)
+	sta, err := state.Open(filepath.Join(dir, "state"), state.Config{
+		Builtin: sources.NewBuiltInSource(vfs.InMemoryFS(nil)),
+	}, cache)
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+
+	err = UpdateDigests(l, client, sta, path, false, FormatHex, 8)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, versions, strings.Count(string(content), digest))
+	for i := range versions {
+		assert.Contains(t, string(content), fmt.Sprintf("%s/1.0.%d/tool.tar.gz", server.URL, i))
+	}
+}
+
+func TestVerifyDigests(t *testing.T) {
+	const digest = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	const wrong = "0000000000000000000000000000000000000000000000000000000000000000"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("abc"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool.hcl")
+	manifest := `
+description = "Tool"
+binaries = ["tool"]
+
+version "1.0.0" {
+  source = "` + server.URL + `/1.0.0/tool.tar.gz"
+}
+
+version "2.0.0" {
+  source = "` + server.URL + `/2.0.0/tool.tar.gz"
+}
+
+version "3.0.0" {
+  source = "` + server.URL + `/3.0.0/tool.tar.gz"
+}
+
+sha256sums = {
+  "` + server.URL + `/1.0.0/tool.tar.gz": "` + digest + `",
+  "` + server.URL + `/2.0.0/tool.tar.gz": "` + wrong + `",
+}
+`
+	assert.NoError(t, os.WriteFile(path, []byte(manifest), 0600))
+	client := server.Client()
+	cache, err := cache.Open(dir, nil, client, client)
+	assert.NoError(t, err)
+	sta, err := state.Open(filepath.Join(dir, "state"), state.Config{
+		Builtin: sources.NewBuiltInSource(vfs.InMemoryFS(nil)),
+	}, cache)
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+
+	drift, err := VerifyDigests(l, sta, path, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(drift))
+	assert.Equal(t, "tool-2.0.0", drift[0].Reference.String())
+	assert.Equal(t, wrong, drift[0].Declared)
+	assert.Equal(t, digest, drift[0].Live)
+
+	// The unverified downloads are not left in the cache.
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		_, err = os.Stat(cache.Path("", server.URL+"/"+version+"/tool.tar.gz"))
+		assert.True(t, os.IsNotExist(err), version)
+	}
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, string(content))
+}
+
+func TestMergeDigests(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool.hcl")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+description = "Tool"
+binaries = ["tool"]
+
+version "1.0.0" {}
+
+sha256sums = {
+  "https://example.com/linux.tar.gz": "aaa",
+  "https://example.com/darwin.tar.gz": "",
+}
+`), 0600))
+	other := filepath.Join(dir, "other.hcl")
+	assert.NoError(t, os.WriteFile(other, []byte(`
+description = "Tool"
+
+sha256sums = {
+  "https://example.com/linux.tar.gz": "",
+  "https://example.com/darwin.tar.gz": "bbb",
+  "https://example.com/windows.zip": "ccc",
+}
+`), 0600))
+	sidecar := filepath.Join(dir, "sidecar.hcl")
+	assert.NoError(t, os.WriteFile(sidecar, []byte(`
+sha256sums = {
+  "https://example.com/windows.zip": "ccc",
+  "https://example.com/freebsd.tar.gz": "ddd",
+}
+`), 0600))
+
+	added, err := MergeDigests(path, other, sidecar)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added)
+	mani, err := manifest.LoadManifestFile(os.DirFS(dir), "tool.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"https://example.com/linux.tar.gz":   "aaa",
+		"https://example.com/darwin.tar.gz":  "bbb",
+		"https://example.com/windows.zip":    "ccc",
+		"https://example.com/freebsd.tar.gz": "ddd",
+	}, mani.SHA256Sums)
+
+	conflict := filepath.Join(dir, "conflict.hcl")
+	assert.NoError(t, os.WriteFile(conflict, []byte(`sha256sums = {"https://example.com/linux.tar.gz": "zzz"}`), 0600))
+	before, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	_, err = MergeDigests(path, conflict)
+	assert.EqualError(t, err, conflict+": https://example.com/linux.tar.gz has digest zzz, but aaa in "+path)
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+}
@@ -0,0 +1,108 @@
+package digest
+
+import (
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// MergeDigests merges the sha256sums of the manifests or sidecar files at
+// "from" into the manifest at path, returning the number of digests added.
+//
+// A sidecar file is an HCL file containing only a sha256sums attribute.
+// Digests are deduplicated by source, with non-empty digests preferred over
+// empty ones. Sources with different non-empty digests are reported as
+// conflicts and the manifest is not modified.
+func MergeDigests(path string, from ...string) (int, error) {
+	ast, err := loadAST(path)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	sha256Sums, err := upsertSHA256SumsKey(ast)
+	if err != nil {
+		return 0, errors.Wrap(err, path)
+	}
+	// Deduplicate the existing entries first, eg. from a hand resolved merge conflict.
+	entries := sha256Sums.Map
+	sha256Sums.Map = nil
+	bySource := map[string]*hcl.MapEntry{}
+	var conflicts []error
+	added := 0
+	merge := func(file string, entry *hcl.MapEntry) error {
+		source, digest, err := digestEntry(entry)
+		if err != nil {
+			return errors.Wrap(err, file)
+		}
+		existing, ok := bySource[source]
+		if !ok {
+			bySource[source] = entry
+			sha256Sums.Map = append(sha256Sums.Map, entry)
+			if file != path {
+				added++
+			}
+			return nil
+		}
+		existingDigest := *existing.Value.Str
+		switch {
+		case digest == "" || digest == existingDigest:
+		case existingDigest == "":
+			existing.Value = entry.Value
+			if file != path {
+				added++
+			}
+		default:
+			conflicts = append(conflicts, errors.Errorf("%s: %s has digest %s, but %s in %s", file, source, digest, existingDigest, path))
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		if err := merge(path, entry); err != nil {
+			return 0, err
+		}
+	}
+	for _, file := range from {
+		other, err := loadAST(file)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		otherSums, err := findSHA256Sums(other)
+		if err != nil {
+			return 0, errors.Wrap(err, file)
+		}
+		if otherSums == nil {
+			continue
+		}
+		for _, entry := range otherSums.Map {
+			if err := merge(file, entry); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return 0, errors.Join(conflicts...)
+	}
+	if err := writeAST(path, ast, path); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return added, nil
+}
+
+// findSHA256Sums returns the sha256sums map of ast, or nil if it has none.
+func findSHA256Sums(ast *hcl.AST) (*hcl.Value, error) {
+	for _, v := range ast.Entries {
+		if v.Attribute != nil && v.Attribute.Key == "sha256sums" {
+			if !v.Attribute.Value.HaveMap {
+				return nil, errors.Errorf("%s: sha256sums is not a map", v.Attribute.Pos)
+			}
+			return v.Attribute.Value, nil
+		}
+	}
+	return nil, nil
+}
+
+func digestEntry(entry *hcl.MapEntry) (source, digest string, err error) {
+	if entry.Key.Str == nil || entry.Value.Str == nil {
+		return "", "", errors.Errorf("%s: sha256sums entries must be strings", entry.Pos)
+	}
+	return *entry.Key.Str, *entry.Value.Str, nil
+}
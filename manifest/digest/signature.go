@@ -0,0 +1,55 @@
+package digest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
+)
+
+// verifyChecksumSignature verifies the detached GPG signature of pkg over
+// content, the checksums file retrieved from pkg.SHA256Source.
+//
+// Both ASCII armored (.asc) and binary (.sig) signatures are supported.
+func verifyChecksumSignature(task *ui.Task, client *http.Client, pkg *manifest.Package, content []byte) error {
+	if pkg.SHA256SourceKey == "" {
+		return errors.Errorf("sha256-source-signature requires sha256-source-key")
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(pkg.SHA256SourceKey))
+	if err != nil {
+		return errors.Wrap(err, "invalid sha256-source-key")
+	}
+	task.Tracef("Verifying signature %s", util.RedactURL(pkg.SHA256SourceSignature))
+	req, err := http.NewRequest(http.MethodGet, pkg.SHA256SourceSignature, nil) //nolint: noctx
+	if err != nil {
+		return errors.WithStack(util.RedactURLError(err))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(util.RedactURLError(err), "could not fetch signature")
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("could not fetch signature %s: %s", util.RedactURL(pkg.SHA256SourceSignature), resp.Status)
+	}
+	signature, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "could not read signature")
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature), nil)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "signature verification of %s failed", pkg.SHA256Source)
+	}
+	return nil
+}
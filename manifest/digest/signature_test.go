@@ -0,0 +1,104 @@
+package digest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestTryGetSHAVerifiesSignature(t *testing.T) {
+	const digest = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	sums := []byte(digest + "  pkg.tar.gz\n" + digest + "  pkg.zip\n")
+	signer := newTestEntity(t, "signer")
+	other := newTestEntity(t, "other")
+
+	signatures := map[string][]byte{}
+	sig := &bytes.Buffer{}
+	assert.NoError(t, openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader(sums), nil))
+	signatures["/SHA256SUMS.asc"] = sig.Bytes()
+	sig = &bytes.Buffer{}
+	assert.NoError(t, openpgp.DetachSign(sig, signer, bytes.NewReader(sums), nil))
+	signatures["/SHA256SUMS.sig"] = sig.Bytes()
+	sig = &bytes.Buffer{}
+	assert.NoError(t, openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader([]byte("tampered")), nil))
+	signatures["/tampered.asc"] = sig.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/SHA256SUMS" {
+			_, _ = w.Write(sums)
+		} else if sig, ok := signatures[r.URL.Path]; ok {
+			_, _ = w.Write(sig)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		signature string
+		key       string
+		sums      string
+		source    string
+		err       string
+	}{
+		{name: "Armored", signature: "/SHA256SUMS.asc", key: armoredPublicKey(t, signer)},
+		{name: "Binary", signature: "/SHA256SUMS.sig", key: armoredPublicKey(t, signer)},
+		{name: "WrongKey", signature: "/SHA256SUMS.asc", key: armoredPublicKey(t, other), err: "signature verification of " + server.URL + "/SHA256SUMS failed"},
+		{name: "Tampered", signature: "/tampered.asc", key: armoredPublicKey(t, signer), err: "signature verification of " + server.URL + "/SHA256SUMS failed"},
+		{name: "MissingSignature", signature: "/missing.asc", key: armoredPublicKey(t, signer), err: "could not fetch signature"},
+		{name: "MissingKey", signature: "/SHA256SUMS.asc", err: "sha256-source-signature requires sha256-source-key"},
+		{name: "MissingSums", signature: "/SHA256SUMS.asc", key: armoredPublicKey(t, signer), sums: "/missing", err: "failed to fetch signed checksums " + server.URL + "/missing: 404 Not Found"},
+		{name: "NoDigestForSource", signature: "/SHA256SUMS.asc", key: armoredPublicKey(t, signer), source: "/other.tar.gz", err: "signed checksums " + server.URL + "/SHA256SUMS have no digest for other.tar.gz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, _ := ui.NewForTesting()
+			if test.sums == "" {
+				test.sums = "/SHA256SUMS"
+			}
+			if test.source == "" {
+				test.source = "/pkg.tar.gz"
+			}
+			pkg := &manifest.Package{
+				Source:                server.URL + test.source,
+				SHA256Source:          server.URL + test.sums,
+				SHA256SourceSignature: server.URL + test.signature,
+				SHA256SourceKey:       test.key,
+			}
+			actual, err := tryGetSHA(p.Task("test"), server.Client(), pkg)
+			if test.err != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, digest, actual)
+		})
+	}
+}
+
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	assert.NoError(t, err)
+	return entity
+}
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(w))
+	assert.NoError(t, w.Close())
+	return buf.String()
+}
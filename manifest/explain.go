@@ -0,0 +1,69 @@
+package manifest
+
+import (
+	"sort"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/ui"
+)
+
+// Provenance records which manifest layers contributed to a resolved field.
+type Provenance struct {
+	// Field name, with map keys and trigger events qualified (eg. "env.PATH").
+	Field string
+	// Layers that set the field, in merge order.
+	Layers []string
+	// Merged is true if all layers contribute to the final value, rather than
+	// the last layer overriding the others.
+	Merged bool
+}
+
+// provenance records the layers contributing to each field of a package as
+// resolvePackage applies them. A nil provenance records nothing.
+type provenance map[string]*Provenance
+
+// set records that layer overrides field.
+func (p provenance) set(field, layer string) { p.add(field, layer, false) }
+
+// merge records that layer contributes to field alongside earlier layers.
+func (p provenance) merge(field, layer string) { p.add(field, layer, true) }
+
+func (p provenance) add(field, layer string, merged bool) {
+	if p == nil {
+		return
+	}
+	entry, ok := p[field]
+	if !ok {
+		p[field] = &Provenance{Field: field, Layers: []string{layer}, Merged: merged}
+		return
+	}
+	if entry.Layers[len(entry.Layers)-1] == layer {
+		return
+	}
+	entry.Merged = merged && (entry.Merged || len(entry.Layers) == 1)
+	entry.Layers = append(entry.Layers, layer)
+}
+
+func (p provenance) sorted() []Provenance {
+	out := make([]Provenance, 0, len(p))
+	for _, entry := range p {
+		out = append(out, *entry)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+	return out
+}
+
+// Explain resolves a package and reports which layers of its manifest
+// contributed to each field of the result.
+func (r *Resolver) Explain(l *ui.UI, selector Selector) (*Package, []Provenance, error) {
+	manifest, err := r.loader.Load(l, selector.Name())
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	prov := provenance{}
+	pkg, err := resolvePackage(manifest, r.config, selector, nil, prov)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return pkg, prov.sorted(), nil
+}
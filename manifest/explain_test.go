@@ -0,0 +1,50 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/sources"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestExplain(t *testing.T) {
+	resolver, err := New(sources.New("", []sources.Source{
+		sources.NewMemSource("go.hcl", `
+			description = "Go"
+			binaries = ["bin/go"]
+			env = {
+			  GOROOT: "${root}",
+			  PATH: "${root}/misc:${PATH}"
+			}
+			source = "https://golang.org/dl/go${version}.${os}-${arch}.tar.gz"
+			darwin {
+			  source = "https://golang.org/dl/go${version}.darwin.pkg"
+			}
+			version "1.13.5" "1.14.4" {
+			  binaries = ["bin/gofmt"]
+			  env = {
+			    GOROOT: "${root}/libexec",
+			    PATH: "${root}/tools:${PATH}"
+			  }
+			}
+		`),
+	}), Config{
+		Env:      "/project",
+		State:    "/tmp/hermit",
+		Platform: platform.Platform{OS: "darwin", Arch: "amd64"},
+	})
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+	pkg, provenance, err := resolver.Explain(l, ExactSelector(ParseReference("go-1.14.4")))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://golang.org/dl/go1.14.4.darwin.pkg", pkg.Source)
+	assert.Equal(t, []Provenance{
+		{Field: "binaries", Layers: []string{"manifest", "version 1.13.5, 1.14.4"}, Merged: true},
+		{Field: "env.GOROOT", Layers: []string{"manifest", "version 1.13.5, 1.14.4"}},
+		{Field: "env.PATH", Layers: []string{"manifest", "version 1.13.5, 1.14.4"}, Merged: true},
+		{Field: "source", Layers: []string{"manifest", "manifest > darwin"}},
+	}, provenance)
+}
@@ -153,37 +153,60 @@ nextPlatform:
 	return substituteM1, nil
 }
 
+// InferVersion attempts to infer the package version from a release artefact
+// URL, eg. a GitHub release download.
+func InferVersion(url string) (string, error) {
+	groups := inferVerRe.FindStringSubmatch(url)
+	if len(groups) != 2 {
+		return "", errors.Errorf("%s: could not infer version", url)
+	}
+	return groups[1], nil
+}
+
 func insertVariables(url, inVersion string, skipArch bool) (source, version string, err error) {
 	version = inVersion
 	// Pull out variables.
 	if version == "" {
-		groups := inferVerRe.FindStringSubmatch(url)
-		if len(groups) == 2 {
-			version = groups[1]
-		} else {
-			return "", "", errors.Errorf("%s: could not infer version", url)
+		if version, err = InferVersion(url); err != nil {
+			return "", "", err
 		}
 	}
-	pkgOS := inferOSRe.FindString(url)
-	if pkgOS == "" {
+	if inferOSRe.FindString(url) == "" {
 		return "", "", errors.Errorf("%s: could not infer OS", url)
 	}
-	var arch, xarch string
-	if !skipArch {
-		arch = inferArchRe.FindString(url)
-		xarch = inferXArchRe.FindString(url)
-		if xarch == "" && arch == "" {
-			return "", "", errors.Errorf("%s: could not infer CPU architecture", url)
+	if !skipArch && inferArchRe.FindString(url) == "" && inferXArchRe.FindString(url) == "" {
+		return "", "", errors.Errorf("%s: could not infer CPU architecture", url)
+	}
+	return substituteVariables(url, version, skipArch), version, nil
+}
+
+// InsertVariables replaces the version in a package source URL with
+// ${version}, and any OS and CPU architecture with ${os} and ${arch} or
+// ${xarch}, so that the source can be reused across versions and platforms.
+//
+// If version is empty it is inferred from url. Unlike InferFromArtefact the
+// URL need not contain an OS or CPU architecture.
+func InsertVariables(url, version string) (source, inferredVersion string, err error) {
+	if version == "" {
+		if version, err = InferVersion(url); err != nil {
+			return "", "", err
 		}
 	}
+	return substituteVariables(url, version, false), version, nil
+}
 
-	// Substitute in variables.
-	source = strings.ReplaceAll(url, version, "${version}")
-	source = strings.ReplaceAll(source, pkgOS, "${os}")
-	if arch != "" {
+func substituteVariables(url, version string, skipArch bool) string {
+	source := strings.ReplaceAll(url, version, "${version}")
+	if pkgOS := inferOSRe.FindString(url); pkgOS != "" {
+		source = strings.ReplaceAll(source, pkgOS, "${os}")
+	}
+	if skipArch {
+		return source
+	}
+	if arch := inferArchRe.FindString(url); arch != "" {
 		source = strings.ReplaceAll(source, arch, "${arch}")
-	} else if xarch != "" {
+	} else if xarch := inferXArchRe.FindString(url); xarch != "" {
 		source = strings.ReplaceAll(source, xarch, "${xarch}")
 	}
-	return source, version, nil
+	return source
 }
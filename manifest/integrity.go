@@ -0,0 +1,114 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// integrityAlgorithms supported in Subresource Integrity style digests.
+var integrityAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// IntegrityDigest is a single algorithm-prefixed digest, eg. "sha256-<base64>".
+type IntegrityDigest struct {
+	Algorithm string
+	Digest    []byte
+}
+
+// Integrity is a set of digests of a package source in the web Subresource
+// Integrity format, eg. "sha256-<base64> sha512-<base64>".
+type Integrity []IntegrityDigest
+
+// ParseIntegrity parses space separated, algorithm-prefixed, base64 encoded
+// digests.
+func ParseIntegrity(s string) (Integrity, error) {
+	var out Integrity
+	for _, field := range strings.Fields(s) {
+		algorithm, encoded, ok := strings.Cut(field, "-")
+		if !ok {
+			return nil, errors.Errorf("invalid integrity digest %q, expected <algorithm>-<base64>", field)
+		}
+		newHash, ok := integrityAlgorithms[algorithm]
+		if !ok {
+			return nil, errors.Errorf("unsupported integrity algorithm %q", algorithm)
+		}
+		digest, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid integrity digest %q", field)
+		}
+		if len(digest) != newHash().Size() {
+			return nil, errors.Errorf("invalid integrity digest %q, expected %d bytes", field, newHash().Size())
+		}
+		out = append(out, IntegrityDigest{Algorithm: algorithm, Digest: digest})
+	}
+	if len(out) == 0 {
+		return nil, errors.Errorf("no integrity digests")
+	}
+	return out, nil
+}
+
+// SHA256 returns the hex encoded sha256 digest, if any.
+func (i Integrity) SHA256() string {
+	for _, d := range i {
+		if d.Algorithm == "sha256" {
+			return hex.EncodeToString(d.Digest)
+		}
+	}
+	return ""
+}
+
+// Verify that the content of r matches any of the digests.
+func (i Integrity) Verify(r io.Reader) error {
+	hashes := map[string]hash.Hash{}
+	writers := []io.Writer{}
+	for _, d := range i {
+		if _, ok := hashes[d.Algorithm]; !ok {
+			h := integrityAlgorithms[d.Algorithm]()
+			hashes[d.Algorithm] = h
+			writers = append(writers, h)
+		}
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, d := range i {
+		if bytes.Equal(hashes[d.Algorithm].Sum(nil), d.Digest) {
+			return nil
+		}
+	}
+	return errors.Errorf("content does not match any integrity digest")
+}
+
+// SRIFromSHA256 converts a hex encoded sha256 digest to Subresource Integrity
+// format.
+func SRIFromSHA256(digest string) (string, error) {
+	raw, err := hex.DecodeString(digest)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid sha256 digest %q", digest)
+	}
+	return "sha256-" + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// normaliseSHA256 converts a sha256 digest in Subresource Integrity format to
+// hex, leaving hex digests unchanged.
+func normaliseSHA256(digest string) (string, error) {
+	if !strings.HasPrefix(digest, "sha256-") {
+		return digest, nil
+	}
+	integrity, err := ParseIntegrity(digest)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return integrity.SHA256(), nil
+}
@@ -0,0 +1,48 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestIntegrity(t *testing.T) {
+	content := "hello world"
+	sum256 := sha256.Sum256([]byte(content))
+	sum512 := sha512.Sum512([]byte(content))
+	sri256 := "sha256-" + base64.StdEncoding.EncodeToString(sum256[:])
+	sri512 := "sha512-" + base64.StdEncoding.EncodeToString(sum512[:])
+	wrong := "sha512-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+
+	integrity, err := ParseIntegrity(sri256 + " " + sri512)
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum256[:]), integrity.SHA256())
+	assert.NoError(t, integrity.Verify(strings.NewReader(content)))
+	assert.Error(t, integrity.Verify(strings.NewReader("goodbye world")))
+
+	// Any matching digest is sufficient.
+	integrity, err = ParseIntegrity(wrong + " " + sri256)
+	assert.NoError(t, err)
+	assert.NoError(t, integrity.Verify(strings.NewReader(content)))
+
+	integrity, err = ParseIntegrity(sri512)
+	assert.NoError(t, err)
+	assert.Equal(t, "", integrity.SHA256())
+
+	for _, invalid := range []string{"", "sha256", "md5-" + base64.StdEncoding.EncodeToString(sum256[:16]), "sha256-!!!", "sha512-" + base64.StdEncoding.EncodeToString(sum256[:])} {
+		_, err = ParseIntegrity(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	sri, err := SRIFromSHA256(hex.EncodeToString(sum256[:]))
+	assert.NoError(t, err)
+	assert.Equal(t, sri256, sri)
+	digest, err := normaliseSHA256(sri)
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum256[:]), digest)
+}
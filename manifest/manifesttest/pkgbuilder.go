@@ -62,6 +62,12 @@ func (b PkgBuilder) WithDest(dst string) PkgBuilder {
 	return b
 }
 
+// WithMutable marks the package as mutable
+func (b PkgBuilder) WithMutable() PkgBuilder {
+	b.result.Mutable = true
+	return b
+}
+
 // WithBinaries sets the name of the binaries in the package
 func (b PkgBuilder) WithBinaries(bins ...string) PkgBuilder {
 	b.result.Binaries = bins
@@ -92,6 +98,12 @@ func (b PkgBuilder) WithUpdateInterval(dur time.Duration) PkgBuilder {
 	return b
 }
 
+// WithChannelVersion sets the version a channel package resolves to
+func (b PkgBuilder) WithChannelVersion(version string) PkgBuilder {
+	b.result.ChannelVersion = manifest.ParseVersion(version)
+	return b
+}
+
 // WithFile adds an external file to the package
 func (b PkgBuilder) WithFile(src, dst string, fs fs.FS) PkgBuilder {
 	b.result.Files = append(b.result.Files, &manifest.ResolvedFileRef{
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -39,9 +40,20 @@ type Config struct {
 	Env string
 	// State path where packages are installed.
 	State string
+	// Names of packages to extract into VendorDir in the environment rather
+	// than the state, unless their manifest sets "dest".
+	Vendor []string
 	platform.Platform
 }
 
+// VendorDir is the directory, relative to the environment root, that vendored
+// packages are extracted to.
+const VendorDir = ".hermit/vendor"
+
+// MutableDir is the directory, relative to the environment root, that mutable
+// packages are extracted to.
+const MutableDir = ".hermit/mutable"
+
 // Packages sortable by name + version.
 //
 // Prerelease versions will sort as the oldest versions.
@@ -67,39 +79,58 @@ type ResolvedFileRef struct {
 
 // Package resolved from a manifest.
 type Package struct {
-	Description          string
-	Homepage             string
-	Repository           string
-	Reference            Reference
-	Arch                 string
-	Binaries             []string
-	Apps                 []string
-	Requires             []string
-	RuntimeDeps          []Reference
-	Provides             []string
-	Env                  envars.Ops
-	Source               string
-	SHA256Source         string
-	DontExtract          bool // Don't extract the package, just download it.
+	Description string
+	Homepage    string
+	Repository  string
+	Reference   Reference
+	Arch        string
+	Binaries    []string
+	Apps        []string
+	Requires    []string
+	// Tools required from the host, eg. "git>=2.30". See ParseSystemRequirement.
+	SystemRequires []string
+	RuntimeDeps    []Reference
+	Provides       []string
+	Env            envars.Ops
+	Source         string
+	SHA256Source   string
+	// Detached GPG signature over SHA256Source, verified with SHA256SourceKey.
+	SHA256SourceSignature string
+	SHA256SourceKey       string
+	DontExtract           bool // Don't extract the package, just download it.
+	// Name for a single file source, if not the last element of Source.
+	Filename             string `json:"-"`
 	Mirrors              []string
 	Root                 string
 	SHA256               string
-	Mutable              bool
+	Integrity            string // Subresource Integrity style digests of the source, any of which must match.
+	Mutable              bool   // Writable, and extracted into MutableDir in the environment rather than shared.
+	Concurrency          int
+	Priority             int
 	Dest                 string
 	Test                 string
 	Strip                int
-	Triggers             map[Event][]Action  `json:"-"` // Triggers keyed by event.
-	UpdateInterval       time.Duration       // How often should we check for updates? 0, if never
-	Files                []*ResolvedFileRef  `json:"-"`
-	FS                   fs.FS               `json:"-"` // FS the Package was loaded from.
-	Warnings             []string            `json:"-"`
-	UnsupportedPlatforms []platform.Platform // Unsupported core platforms
+	Exclude              []string                 // Globs of archive entries not to extract.
+	Inner                string                   // Path of an archive within the source to extract instead.
+	UnsafeSymlinks       string                   // Policy for absolute or escaping symlinks: "keep", "reject" or "rewrite".
+	Triggers             map[Event][]Action       `json:"-"` // Triggers keyed by event.
+	OnceTriggers         map[Event][]*OnceTrigger `json:"-"` // Triggers only run the first time the event occurs, keyed by event.
+	UpdateInterval       time.Duration            // How often should we check for updates? 0, if never
+	UpdateSchedule       *Schedule                // When updates may be checked for, or nil if at any time.
+	ChannelVersion       Version                  // Version a channel with a version glob currently resolves to.
+	Files                []*ResolvedFileRef       `json:"-"`
+	FS                   fs.FS                    `json:"-"` // FS the Package was loaded from.
+	Warnings             []string                 `json:"-"`
+	UnsupportedPlatforms []platform.Platform      // Unsupported core platforms
+	Platform             platform.Platform        `json:"-"` // Platform the package was resolved for.
 
 	// Filled in by Env.
-	Linked    bool `json:"-"` // Linked into environment.
-	State     PackageState
-	ETag      string
-	UpdatedAt time.Time
+	Linked          bool     `json:"-"` // Linked into environment.
+	Pinned          bool     // Installed in place of a channel pinned with "hermit pin", so upgrades are skipped.
+	RuntimeDepPaths []string `json:"-"` // Binary directories of runtime dependencies, added to PATH for triggers.
+	State           PackageState
+	ETag            string
+	UpdatedAt       time.Time
 }
 
 func (p *Package) String() string {
@@ -107,8 +138,15 @@ func (p *Package) String() string {
 }
 
 // Trigger triggers an event in this package. Noop if the event is not defined for the package
+//
+// "once" triggers are not run, see OnceTriggers.
 func (p *Package) Trigger(l ui.Logger, event Event) (messages []string, err error) {
-	for _, action := range p.Triggers[event] {
+	return p.RunActions(l, p.Triggers[event])
+}
+
+// RunActions applies actions to this package, returning any messages for the user.
+func (p *Package) RunActions(l ui.Logger, actions []Action) (messages []string, err error) {
+	for _, action := range actions {
 		l.Debugf("%s", action)
 		if msg, ok := action.(*MessageAction); ok {
 			messages = append(messages, msg.Text)
@@ -119,6 +157,20 @@ func (p *Package) Trigger(l ui.Logger, event Event) (messages []string, err erro
 	return messages, nil
 }
 
+// triggerActions returns the actions of all triggers, including "once" triggers.
+func (p *Package) triggerActions() [][]Action {
+	var out [][]Action
+	for _, actions := range p.Triggers {
+		out = append(out, actions)
+	}
+	for _, triggers := range p.OnceTriggers {
+		for _, trigger := range triggers {
+			out = append(out, trigger.Actions)
+		}
+	}
+	return out
+}
+
 // ResolveBinaries resolves binary globs from the filesystem.
 func (p *Package) ResolveBinaries() ([]string, error) {
 	// Expand binaries globs.
@@ -137,6 +189,31 @@ func (p *Package) ResolveBinaries() ([]string, error) {
 	return binaries, nil
 }
 
+// ProvidesBinary returns true if the package has a binary called name.
+//
+// The extracted package is checked if possible, otherwise the binary globs
+// are matched against name, ignoring bare "*" globs that would match anything.
+func (p *Package) ProvidesBinary(name string) bool {
+	if binaries, err := p.ResolveBinaries(); err == nil {
+		for _, bin := range binaries {
+			if filepath.Base(bin) == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, bin := range p.Binaries {
+		base := path.Base(bin)
+		if base == "*" {
+			continue
+		}
+		if ok, _ := path.Match(base, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // LogWarnings logs possible warnings found in the package manifest
 func (p *Package) LogWarnings(l *ui.UI) {
 	task := l.Task(p.Reference.String())
@@ -207,6 +284,7 @@ func (r *Resolver) Errors() ManifestErrors {
 //
 // A Sources set can only be synchronised once. Following calls will not have any effect.
 func (r *Resolver) Sync(l *ui.UI, force bool) error {
+	defer ui.LogElapsed(l, "Sync sources")()
 	if err := r.sources.Sync(l, force); err != nil {
 		return errors.WithStack(err)
 	}
@@ -295,6 +373,62 @@ func (r *Resolver) ResolveVirtual(name string) (pkgs []*Package, err error) {
 	return pkgs, nil
 }
 
+// ResolveBinary finds packages whose binaries include one named name.
+//
+// Each package is resolved to its default version. Binaries matched only by a
+// bare wildcard, eg. "bin/*", are ignored.
+func (r *Resolver) ResolveBinary(name string) (pkgs []*Package, err error) {
+	pkgs, err = r.resolveMatching(func(pkg *Package) bool { return pkg.ProvidesBinary(name) })
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(pkgs) == 0 {
+		return nil, errors.Wrapf(ErrUnknownPackage, "no package provides the binary %q", name)
+	}
+	return pkgs, nil
+}
+
+// WhichProvides finds packages providing the command name, either as one of
+// their binaries or by declaring it in "provides", sorted by name.
+//
+// As with ResolveBinary, each package is resolved to its default version.
+func (r *Resolver) WhichProvides(name string) (Packages, error) {
+	pkgs, err := r.resolveMatching(func(pkg *Package) bool {
+		return pkg.ProvidesBinary(name) || slices.Contains(pkg.Provides, name)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(pkgs) == 0 {
+		return nil, errors.Wrapf(ErrUnknownPackage, "no package provides %q", name)
+	}
+	sort.Sort(pkgs)
+	return pkgs, nil
+}
+
+// resolveMatching resolves every valid manifest to its default version,
+// returning the packages for which match returns true.
+func (r *Resolver) resolveMatching(match func(pkg *Package) bool) (Packages, error) {
+	manifests, err := r.loader.All()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var pkgs Packages
+	for _, manifest := range manifests {
+		if len(manifest.Errors) > 0 {
+			continue
+		}
+		pkg, err := newPackage(manifest, r.config, NameSelector(manifest.Name))
+		if err != nil {
+			continue
+		}
+		if match(pkg) {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
+
 // Resolve a package reference.
 //
 // Returns the highest version matching the given reference
@@ -319,13 +453,13 @@ func matchVersion(manifest *AnnotatedManifest, selector Selector) (collected Ref
 	return
 }
 
-func matchChannel(manifest *AnnotatedManifest, selector Selector) (collected References, foundUpdateInterval time.Duration, selected Reference) {
+func matchChannel(manifest *AnnotatedManifest, selector Selector) (collected References, foundChannel *ChannelBlock, selected Reference) {
 	for _, ch := range manifest.Channels {
 		candidate := Reference{Name: selector.Name(), Channel: ch.Name}
 		collected = append(collected, candidate)
 		if selector.Matches(candidate) {
 			selected = candidate
-			foundUpdateInterval = ch.Update
+			foundChannel = &ch
 		}
 	}
 	return
@@ -337,6 +471,13 @@ func Resolve(manifest *AnnotatedManifest, config Config, ref Reference) (*Packag
 }
 
 func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (*Package, error) {
+	return resolvePackage(manifest, config, selector, nil, nil)
+}
+
+// resolvePackage resolves a package, recording the "vars" and "files" entries
+// used in "used", and the layers contributing to each field in "prov", if they
+// are not nil.
+func resolvePackage(manifest *AnnotatedManifest, config Config, selector Selector, used usage, prov provenance) (*Package, error) {
 	// If a version was not specified and the manifest defines a default, use it.
 	if !selector.IsFullyQualified() && manifest.Default != "" {
 		if strings.HasPrefix(manifest.Default, "@") {
@@ -353,14 +494,30 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 	// Clone the entire manifest, as we mutate stuff.
 	manifest = reprint.This(manifest).(*AnnotatedManifest)
 	// Resolve version in manifest from ref.
-	var foundUpdateInterval time.Duration
+	var (
+		foundUpdateInterval time.Duration
+		foundUpdateSchedule *Schedule
+	)
 	// Search versions first.
 	allRefs, found := matchVersion(manifest, selector)
 	// Then channels if no match.
 	if !found.IsSet() {
-		var channelRefs References
-		channelRefs, foundUpdateInterval, found = matchChannel(manifest, selector)
+		var (
+			channelRefs References
+			channel     *ChannelBlock
+		)
+		channelRefs, channel, found = matchChannel(manifest, selector)
 		allRefs = append(allRefs, channelRefs...)
+		if channel != nil {
+			foundUpdateInterval = channel.Update
+			if channel.UpdateSchedule != "" {
+				schedule, err := ParseSchedule(channel.UpdateSchedule)
+				if err != nil {
+					return nil, errors.Wrapf(err, "%s: channel %s", manifest.Path, channel.Name)
+				}
+				foundUpdateSchedule = schedule
+			}
+		}
 	}
 	if len(allRefs) == 0 {
 		return nil, errors.Errorf("could not find any versions matching %s", selector)
@@ -398,6 +555,9 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 	}
 
 	root := filepath.Join(config.State, "pkg", found.String())
+	if slices.Contains(config.Vendor, found.Name) {
+		root = filepath.Join(config.Env, VendorDir, found.String())
+	}
 	p := &Package{
 		Description:          manifest.Description,
 		Homepage:             manifest.Homepage,
@@ -407,12 +567,18 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 		Dest:                 root,
 		Triggers:             map[Event][]Action{},
 		UpdateInterval:       foundUpdateInterval,
+		UpdateSchedule:       foundUpdateSchedule,
 		Files:                []*ResolvedFileRef{},
 		FS:                   manifest.FS,
 		UnsupportedPlatforms: manifest.unsupported(found, platform.Core),
+		Platform:             platform.Platform{OS: config.OS, Arch: config.Arch},
 	}
 
 	files := map[string]string{}
+	// Layers and fields that "vars" and "files" entries were last set in.
+	varLayers := map[string]string{}
+	varFields := map[string]string{}
+	fileLayers := map[string]string{}
 
 	// Merge all the layers.
 	layers, err := manifest.layers(found, config.OS, config.Arch)
@@ -432,60 +598,172 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 				return nil, errors.Errorf("no matching version found for channel %s", found)
 			}
 			found.Version = *version
+			p.ChannelVersion = *version
 		}
 	}
 
 	vars := map[string]string{}
+	unmatchedVersionVars := map[string]string{}
+	deprecated := ""
 	layerEnvars := make([]envars.Envars, 0, len(layers))
 	for _, layer := range layers {
 		if len(layer.Env) > 0 {
 			layerEnvars = append(layerEnvars, layer.Env)
 		}
+		for k, v := range layer.Env {
+			// Appending or prepending to a variable builds on earlier layers,
+			// anything else replaces it.
+			switch envars.Infer([]string{k + "=" + v})[0].(type) {
+			case *envars.Append, *envars.Prepend:
+				prov.merge("env."+k, layer.name)
+			default:
+				prov.set("env."+k, layer.name)
+			}
+		}
 		for k, v := range layer.Vars {
 			vars[k] = v
+			varLayers[k] = layer.name
+			varFields[k] = "vars"
+			delete(unmatchedVersionVars, k)
+			prov.set("vars."+k, layer.name)
+		}
+		for k, pattern := range layer.VersionVars {
+			value, ok, err := captureVersionVar(pattern, found.Version.String())
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: version-vars.%s", layer.name, k)
+			}
+			if ok {
+				vars[k] = value
+				delete(unmatchedVersionVars, k)
+			} else {
+				// Only an error if the variable is used, as the version may be
+				// empty or differently shaped for channels.
+				delete(vars, k)
+				unmatchedVersionVars[k] = pattern
+			}
+			varLayers[k] = layer.name
+			varFields[k] = "version-vars"
+			prov.set("version-vars."+k, layer.name)
 		}
 		if layer.Arch != "" {
 			p.Arch = layer.Arch
 		}
 		if layer.Mutable {
 			p.Mutable = layer.Mutable
+			prov.set("mutable", layer.name)
+		}
+		if layer.Concurrency != 0 {
+			p.Concurrency = layer.Concurrency
+			prov.set("concurrency", layer.name)
+		}
+		if layer.Priority != 0 {
+			p.Priority = layer.Priority
+			prov.set("priority", layer.name)
+		}
+		if layer.Deprecated != "" {
+			deprecated = layer.Deprecated
+			prov.set("deprecated", layer.name)
+		}
+		if layer.Strip != 0 {
+			p.Strip = layer.Strip
+			prov.set("strip", layer.name)
 		}
 		if layer.Test != nil {
 			p.Test = *layer.Test
+			prov.set("test", layer.name)
 		}
 		if layer.Source != "" {
 			p.Source = layer.Source
+			prov.set("source", layer.name)
 		}
 		if layer.SHA256Source != "" {
 			p.SHA256Source = layer.SHA256Source
+			prov.set("sha256-source", layer.name)
+		}
+		if layer.SHA256SourceSignature != "" {
+			p.SHA256SourceSignature = layer.SHA256SourceSignature
+			prov.set("sha256-source-signature", layer.name)
+		}
+		if layer.SHA256SourceKey != "" {
+			p.SHA256SourceKey = layer.SHA256SourceKey
+			prov.set("sha256-source-key", layer.name)
 		}
 		if layer.DontExtract {
 			p.DontExtract = layer.DontExtract
+			prov.set("dont-extract", layer.name)
+		}
+		if layer.Filename != "" {
+			p.Filename = layer.Filename
+			prov.set("filename", layer.name)
+		}
+		if layer.Integrity != "" {
+			p.Integrity = layer.Integrity
+			prov.set("integrity", layer.name)
 		}
 		if len(layer.Mirrors) > 0 {
 			p.Mirrors = layer.Mirrors
+			prov.set("mirrors", layer.name)
+		}
+		if layer.Inner != "" {
+			p.Inner = layer.Inner
+			prov.set("inner", layer.name)
+		}
+		if layer.UnsafeSymlinks != "" {
+			p.UnsafeSymlinks = layer.UnsafeSymlinks
+			prov.set("unsafe-symlinks", layer.name)
 		}
 		if layer.Root != "" {
 			p.Root = layer.Root
+			prov.set("root", layer.name)
 		}
 		if layer.Dest != "" {
 			p.Dest = layer.Dest
+			prov.set("dest", layer.name)
 		}
 		if len(layer.Apps) != 0 {
 			p.Apps = append(p.Apps, layer.Apps...)
+			prov.merge("apps", layer.name)
 		}
 		if len(layer.Binaries) != 0 {
 			p.Binaries = append(p.Binaries, layer.Binaries...)
+			prov.merge("binaries", layer.name)
+		}
+		if len(layer.Exclude) != 0 {
+			p.Exclude = append(p.Exclude, layer.Exclude...)
+			prov.merge("exclude", layer.name)
+		}
+		if len(layer.SystemRequires) != 0 {
+			p.SystemRequires = append(p.SystemRequires, layer.SystemRequires...)
+			prov.merge("system-requires", layer.name)
 		}
 		if len(layer.Requires) != 0 {
 			p.Requires = append(p.Requires, layer.Requires...)
+			prov.merge("requires", layer.name)
 		}
 		if len(layer.Provides) != 0 {
 			p.Provides = append(p.Provides, layer.Provides...)
+			prov.merge("provides", layer.name)
 		}
 		if len(layer.Triggers) > 0 {
 			for _, trigger := range layer.Triggers {
-				p.Triggers[trigger.Event] = append(p.Triggers[trigger.Event], trigger.Ordered()...)
+				prov.merge("on."+string(trigger.Event), layer.name)
+				switch {
+				case trigger.Once || trigger.OncePerVersion:
+					scope := p.Reference.Name
+					if trigger.OncePerVersion {
+						scope = p.Reference.String()
+					}
+					if p.OnceTriggers == nil {
+						p.OnceTriggers = map[Event][]*OnceTrigger{}
+					}
+					key, err := onceTriggerKey(scope, layer.name, trigger)
+					if err != nil {
+						return nil, errors.Wrapf(err, "%s: once trigger", p)
+					}
+					p.OnceTriggers[trigger.Event] = append(p.OnceTriggers[trigger.Event], &OnceTrigger{Key: key, Actions: trigger.Ordered()})
+				default:
+					p.Triggers[trigger.Event] = append(p.Triggers[trigger.Event], trigger.Ordered()...)
+				}
 			}
 		}
 		if len(layer.RuntimeDeps) > 0 {
@@ -493,9 +771,12 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 				ref := ParseReference(dep)
 				p.RuntimeDeps = append(p.RuntimeDeps, ref)
 			}
+			prov.merge("runtime-dependencies", layer.name)
 		}
 		for k, v := range layer.Files {
 			files[k] = v
+			fileLayers[k] = layer.name
+			prov.set("files."+k, layer.name)
 		}
 	}
 	// Verify.
@@ -505,6 +786,14 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 	if p.Source == "" {
 		return p, errors.Wrapf(ErrNoSource, "%s: %s", manifest.Path, found)
 	}
+	if deprecated != "" {
+		p.DeprecationWarningf("%s", deprecated)
+	}
+	// Mutable packages are changed by the environment using them, so each
+	// environment extracts its own copy rather than sharing one in the state.
+	if p.Mutable && p.Dest == root && config.Env != "" {
+		p.Dest = filepath.Join(config.Env, MutableDir, found.String())
+	}
 
 	// Expand variables.
 	//
@@ -516,7 +805,30 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 		return nil, errors.WithStack(err)
 	}
 	mapping := func(ignoreMissing bool) func(s string) string {
-		return func(key string) string {
+		var lookup func(key string) string
+		lookup = func(key string) string {
+			// ${<var> | <filter> <args>... | ...}
+			if name, pipeline, ok := strings.Cut(key, "|"); ok {
+				name = strings.TrimSpace(name)
+				value := lookup(name)
+				if ignoreMissing && value == "${"+name+"}" {
+					return "${" + key + "}"
+				}
+				filtered, ferr := applyFilters(value, pipeline)
+				if ferr != nil {
+					err = errors.Wrapf(ferr, "${%s}", key)
+					return ""
+				}
+				return filtered
+			}
+			if component, ok := strings.CutPrefix(key, "version."); ok {
+				value, verr := versionComponent(found.Version, component)
+				if verr != nil {
+					err = errors.Wrapf(verr, "${%s}", key)
+					return ""
+				}
+				return value
+			}
 			switch key {
 			case "name":
 				return found.Name
@@ -563,15 +875,21 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 			default:
 				value, ok := vars[key]
 				if ok {
+					used.mark(varLayers[key], varFields[key], key)
 					return value
 				}
 				if ignoreMissing {
 					return "${" + key + "}"
 				}
+				if pattern, ok := unmatchedVersionVars[key]; ok {
+					err = errors.Errorf("version %q does not match %q for variable $%s", found.Version, pattern, key)
+					return ""
+				}
 				err = errors.Errorf("unknown variable $%s", key)
 				return ""
 			}
 		}
+		return lookup
 	}
 
 	// Expand envars in "s". If "ignoreMissing is true then unknown variable references will be
@@ -589,6 +907,9 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 	}
 
 	for _, env := range layerEnvars {
+		if err := env.CheckProtected(); err != nil {
+			return nil, errors.Wrapf(err, "%s", p)
+		}
 		// Expand manifest variables but keep other variable references.
 		for k, v := range env {
 			env[k] = expand(v, true)
@@ -598,9 +919,9 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 		sort.Slice(ops, func(i, j int) bool { return ops[i].Envar() < ops[j].Envar() })
 		p.Env = append(p.Env, ops...)
 	}
-	p.Strip = layers.field("Strip", 0).(int)
 	p.Dest = expand(p.Dest, false)
 	p.Root = expand(p.Root, false)
+	p.Inner = expand(p.Inner, false)
 	p.Test = expand(p.Test, false)
 	for i, bin := range p.Binaries {
 		p.Binaries[i] = expand(bin, false)
@@ -613,20 +934,44 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 	}
 	p.Source = expand(p.Source, false)
 	p.SHA256Source = expand(p.SHA256Source, false)
+	p.Filename = expand(p.Filename, false)
+	p.SHA256SourceSignature = expand(p.SHA256SourceSignature, false)
 	for i, mirror := range p.Mirrors {
 		p.Mirrors[i] = expand(mirror, false)
 	}
+	for i, exclude := range p.Exclude {
+		p.Exclude[i] = expand(exclude, false)
+	}
 	// Get sha256 checksum after variable expansion for source, taking care of
 	// autoversion
 	for _, layer := range layers {
 		if layer.SHA256 != "" {
 			p.SHA256 = layer.SHA256
+			prov.set("sha256", layer.name)
 		} else if sum, ok := manifest.SHA256Sums[p.Source]; ok {
 			p.SHA256 = sum
+			prov.set("sha256", "sha256sums")
+		}
+	}
+	sha256, serr := normaliseSHA256(p.SHA256)
+	if serr != nil {
+		return nil, errors.Wrapf(serr, "%s: sha256", p)
+	}
+	p.SHA256 = sha256
+	if p.Integrity != "" {
+		integrity, ierr := ParseIntegrity(p.Integrity)
+		if ierr != nil {
+			return nil, errors.Wrapf(ierr, "%s: integrity", p)
+		}
+		// The sha256 digest also verifies the download and addresses the cache,
+		// so it can only be derived when it is the sole digest. Otherwise any of
+		// the listed digests must be allowed to match.
+		if p.SHA256 == "" && len(integrity) == 1 {
+			p.SHA256 = integrity.SHA256()
 		}
 	}
 	inferPackageRepository(p, manifest.Manifest)
-	for _, actions := range p.Triggers {
+	for _, actions := range p.triggerActions() {
 		for _, action := range actions {
 			switch action := action.(type) {
 			case *RunAction:
@@ -686,6 +1031,20 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 			case *MkdirAction:
 				action.Dir = expand(action.Dir, false)
 
+			case *RelocateAction:
+				for i := range action.Files {
+					action.Files[i] = expand(action.Files[i], false)
+					if err := mustAbs(action, action.Files[i]); err != nil {
+						return nil, err
+					}
+				}
+				for i := range action.RPath {
+					action.RPath[i] = expand(action.RPath[i], false)
+					if err := mustAbs(action, action.RPath[i]); err != nil {
+						return nil, err
+					}
+				}
+
 			default:
 				panic(fmt.Sprintf("unsupported action %T", action))
 			}
@@ -703,6 +1062,9 @@ func newPackage(manifest *AnnotatedManifest, config Config, selector Selector) (
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	for k := range files {
+		used.mark(fileLayers[k], "files", k)
+	}
 	return p, err
 }
 
@@ -827,3 +1189,21 @@ func mustAbs(action Action, path string) error {
 	}
 	return participle.Errorf(action.position(), "%q must be an absolute path", path)
 }
+
+// captureVersionVar matches pattern against version, returning the first
+// capture group, or the whole match if there are no groups.
+func captureVersionVar(pattern, version string) (value string, ok bool, err error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+	match := re.FindStringSubmatch(version)
+	switch {
+	case match == nil:
+		return "", false, nil
+	case len(match) > 1:
+		return match[1], true, nil
+	default:
+		return match[0], true, nil
+	}
+}
@@ -1,7 +1,11 @@
 package manifest_test
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +14,7 @@ import (
 	"github.com/alecthomas/repr"
 
 	"github.com/cashapp/hermit/envars"
+	"github.com/cashapp/hermit/errors"
 	. "github.com/cashapp/hermit/manifest" //nolint:revive // dot import
 	"github.com/cashapp/hermit/manifest/manifesttest"
 	"github.com/cashapp/hermit/platform"
@@ -149,7 +154,8 @@ func TestResolver_Resolve(t *testing.T) {
 				env = {
 					PATH: "${env}/bin:${PATH}",
 					LD_LIBRARY_PATH: "${LD_LIBRARY_PATH}:${env}/lib",
-					GOPATH: "${env}/go"
+					GOPATH: "${env}/go",
+					EDITOR: "${EDITOR:-vim}"
 				}
 				version "1.0.0" {
 				  source = "www.example.com"
@@ -162,6 +168,7 @@ func TestResolver_Resolve(t *testing.T) {
 			WithBinaries("bin").
 			WithVersion("1.0.0").
 			WithEnvOps(
+				&envars.SetDefault{Name: "EDITOR", Value: "vim"},
 				&envars.Set{Name: "GOPATH", Value: config.Env + "/go"},
 				&envars.Append{Name: "LD_LIBRARY_PATH", Value: config.Env + "/lib"},
 				&envars.Prepend{Name: "PATH", Value: config.Env + "/bin"},
@@ -209,6 +216,25 @@ func TestResolver_Resolve(t *testing.T) {
 			WithSource("www.example.com/01").
 			WithDest("/test-1.0.1").
 			WithUpdateInterval(5 * time.Hour).
+			WithChannelVersion("1.0.1").
+			Result(),
+	}, {
+		name: "Dest can be relative to the environment",
+		files: map[string]string{
+			"test.hcl": `
+                description = ""
+				binaries = ["bin"]
+				dest = "${HERMIT_ENV}/vendor/${name}-${version}"
+				version "1.0.0" { source = "www.example.com" }
+            `,
+		},
+		reference: "test-1.0.0",
+		wantPkg: manifesttest.NewPkgBuilder(config.Env + "/vendor/test-1.0.0").
+			WithName("test").
+			WithBinaries("bin").
+			WithVersion("1.0.0").
+			WithSource("www.example.com").
+			WithDest(config.Env + "/vendor/test-1.0.0").
 			Result(),
 	}, {
 		name: "Supports version matched channels with any match",
@@ -233,6 +259,7 @@ func TestResolver_Resolve(t *testing.T) {
 			WithChannel("testc").
 			WithSource("www.example.com/1.1.0").
 			WithUpdateInterval(5 * time.Hour).
+			WithChannelVersion("1.1.0").
 			Result(),
 	}, {
 		name: "Returns an error if channel version does not match anything",
@@ -317,6 +344,27 @@ func TestResolver_Resolve(t *testing.T) {
 			WithVersion("1.0.0").
 			WithSource("www.example.com/foo/bar").
 			Result(),
+	}, {
+		name: "Deprecated versions warn",
+		files: map[string]string{
+			`test.hcl`: `
+			description = ""
+			binaries = ["bin"]
+			source = "www.example.com"
+
+			version "1.0.0" {
+				deprecated = "EOL, upgrade to 2.0+"
+			}
+			`,
+		},
+		reference: "test-1.0.0",
+		wantPkg: manifesttest.NewPkgBuilder(config.State + "/pkg/test-1.0.0").
+			WithName("test").
+			WithBinaries("bin").
+			WithVersion("1.0.0").
+			WithSource("www.example.com").
+			WithWarnings("DEPRECATED: EOL, upgrade to 2.0+").
+			Result(),
 	},
 	}
 	for _, tt := range tests {
@@ -336,6 +384,9 @@ func TestResolver_Resolve(t *testing.T) {
 				if gotPkg != nil {
 					gotPkg.FS = nil
 				}
+				if tt.wantPkg != nil {
+					tt.wantPkg.Platform = config.Platform
+				}
 				assert.Equal(t,
 					repr.String(tt.wantPkg, repr.Indent("  "), repr.Hide(hcl.Position{})),
 					repr.String(gotPkg, repr.Indent("  "), repr.Hide(hcl.Position{})))
@@ -397,6 +448,7 @@ func TestSearchVersionsAndChannelsCoexist(t *testing.T) {
 			WithChannel("1").
 			WithSource("www.example.com").
 			WithUpdateInterval(time.Hour * 24).
+			WithChannelVersion("1.0.0").
 			WithFS(ffs).
 			Result(),
 		manifesttest.NewPkgBuilder(config.State + "/pkg/test@1.0").
@@ -405,6 +457,7 @@ func TestSearchVersionsAndChannelsCoexist(t *testing.T) {
 			WithChannel("1.0").
 			WithSource("www.example.com").
 			WithUpdateInterval(time.Hour * 24).
+			WithChannelVersion("1.0.0").
 			WithFS(ffs).
 			Result(),
 		manifesttest.NewPkgBuilder(config.State + "/pkg/test@latest").
@@ -413,6 +466,7 @@ func TestSearchVersionsAndChannelsCoexist(t *testing.T) {
 			WithChannel("latest").
 			WithSource("www.example.com").
 			WithUpdateInterval(time.Hour * 24).
+			WithChannelVersion("1.0.0").
 			WithFS(ffs).
 			Result(),
 		manifesttest.NewPkgBuilder(config.State + "/pkg/test@stable").
@@ -431,5 +485,355 @@ func TestSearchVersionsAndChannelsCoexist(t *testing.T) {
 			WithFS(ffs).
 			Result(),
 	}
+	for _, pkg := range expected {
+		pkg.Platform = config.Platform
+	}
 	assert.Equal(t, repr.String(expected, repr.Indent("  ")), repr.String(pkgs, repr.Indent("  ")))
 }
+
+func TestResolveBinary(t *testing.T) {
+	ss := []sources.Source{
+		sources.NewMemSource("protobuf.hcl", `
+			description = ""
+			binaries = ["bin/protoc"]
+			source = "www.example.com"
+			version "3.20.0" {}
+		`),
+		sources.NewMemSource("tools.hcl", `
+			description = ""
+			binaries = ["bin/*"]
+			source = "www.example.com"
+			version "1.0.0" {}
+		`),
+		sources.NewMemSource("protoc-gen.hcl", `
+			description = ""
+			binaries = ["protoc-gen-*"]
+			source = "www.example.com"
+			version "1.0.0" {}
+		`),
+	}
+	r, err := New(sources.New("", ss), Config{State: "/tmp/hermit"})
+	assert.NoError(t, err)
+
+	pkgs, err := r.ResolveBinary("protoc")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pkgs))
+	assert.Equal(t, "protobuf-3.20.0", pkgs[0].Reference.String())
+
+	pkgs, err = r.ResolveBinary("protoc-gen-go")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pkgs))
+	assert.Equal(t, "protoc-gen-1.0.0", pkgs[0].Reference.String())
+
+	_, err = r.ResolveBinary("missing")
+	assert.True(t, errors.Is(err, ErrUnknownPackage))
+}
+
+func TestPackageProvidesBinary(t *testing.T) {
+	root := t.TempDir()
+	pkg := &Package{Root: root, Binaries: []string{"bin/*"}}
+	assert.False(t, pkg.ProvidesBinary("tool"), "bare glob should not match before extraction")
+
+	assert.NoError(t, os.MkdirAll(root+"/bin", 0o700))
+	assert.NoError(t, os.WriteFile(root+"/bin/tool", nil, 0o700))
+	assert.True(t, pkg.ProvidesBinary("tool"))
+	assert.False(t, pkg.ProvidesBinary("other"))
+}
+
+func TestResolveIntegrity(t *testing.T) {
+	sri256 := "sha256-" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	sri512 := "sha512-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+	ss := []sources.Source{
+		sources.NewMemSource("single.hcl", `
+			description = ""
+			binaries = ["bin/single"]
+			source = "www.example.com"
+			integrity = "`+sri256+`"
+			version "1.0.0" {}
+		`),
+		sources.NewMemSource("multiple.hcl", `
+			description = ""
+			binaries = ["bin/multiple"]
+			source = "www.example.com"
+			integrity = "`+sri256+` `+sri512+`"
+			version "1.0.0" {}
+		`),
+	}
+	r, err := New(sources.New("", ss), Config{State: "/tmp/hermit"})
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+
+	pkg, err := r.Resolve(l, MustParseGlobSelector("single"))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("00", sha256.Size), pkg.SHA256)
+
+	// With several digests any may match, so none is enforced as the sha256.
+	pkg, err = r.Resolve(l, MustParseGlobSelector("multiple"))
+	assert.NoError(t, err)
+	assert.Equal(t, "", pkg.SHA256)
+}
+
+func TestOnceTriggerKeys(t *testing.T) {
+	keys := func(triggers string) []string {
+		t.Helper()
+		r, err := New(sources.New("", []sources.Source{sources.NewMemSource("pkg.hcl", `
+			description = ""
+			binaries = ["bin"]
+			source = "www.example.com"
+			version "1.0.0" {}
+		`+triggers)}), Config{State: "/tmp/hermit"})
+		assert.NoError(t, err)
+		l, _ := ui.NewForTesting()
+		pkg, err := r.Resolve(l, MustParseGlobSelector("pkg"))
+		assert.NoError(t, err)
+		out := []string{}
+		for _, trigger := range pkg.OnceTriggers[EventInstall] {
+			out = append(out, trigger.Key)
+		}
+		return out
+	}
+	first := `on "install" {
+		once = true
+		message { text = "first" }
+	}`
+	second := `on "install" {
+		once = true
+		message { text = "second" }
+	}`
+	original := keys(first)
+	assert.Equal(t, 1, len(original))
+
+	// Adding a trigger before it does not change its key.
+	reordered := keys(second + "\n" + first)
+	assert.Equal(t, 2, len(reordered))
+	assert.Equal(t, original[0], reordered[1])
+	assert.NotEqual(t, reordered[0], reordered[1])
+
+	// Changing the trigger does.
+	changed := keys(strings.ReplaceAll(first, `"first"`, `"changed"`))
+	assert.NotEqual(t, original[0], changed[0])
+}
+
+func TestResolveVendored(t *testing.T) {
+	ss := []sources.Source{
+		sources.NewMemSource("protobuf.hcl", `
+			description = ""
+			binaries = ["bin/protoc"]
+			source = "www.example.com"
+			version "3.20.0" {}
+		`),
+		sources.NewMemSource("tools.hcl", `
+			description = ""
+			binaries = ["bin/*"]
+			source = "www.example.com"
+			version "1.0.0" {}
+		`),
+	}
+	r, err := New(sources.New("", ss), Config{Env: "/project", State: "/tmp/hermit", Vendor: []string{"protobuf"}})
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+
+	pkg, err := r.Resolve(l, MustParseGlobSelector("protobuf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/project/.hermit/vendor/protobuf-3.20.0", pkg.Dest)
+	assert.Equal(t, "/project/.hermit/vendor/protobuf-3.20.0", pkg.Root)
+
+	pkg, err = r.Resolve(l, MustParseGlobSelector("tools"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/hermit/pkg/tools-1.0.0", pkg.Dest)
+}
+
+func TestResolveMutable(t *testing.T) {
+	ss := []sources.Source{
+		sources.NewMemSource("tool.hcl", `
+			description = ""
+			binaries = ["bin/tool"]
+			source = "www.example.com"
+			mutable = true
+			version "1.0.0" {}
+		`),
+	}
+	r, err := New(sources.New("", ss), Config{Env: "/project", State: "/tmp/hermit"})
+	assert.NoError(t, err)
+	l, _ := ui.NewForTesting()
+
+	pkg, err := r.Resolve(l, MustParseGlobSelector("tool"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/project/.hermit/mutable/tool-1.0.0", pkg.Dest)
+	assert.Equal(t, "/project/.hermit/mutable/tool-1.0.0", pkg.Root)
+}
+
+func TestWhichProvides(t *testing.T) {
+	ss := []sources.Source{
+		sources.NewMemSource("openjdk.hcl", `
+			description = ""
+			binaries = ["bin/java", "bin/javac"]
+			provides = ["jre"]
+			source = "www.example.com"
+			version "17.0.0" {}
+		`),
+		sources.NewMemSource("corretto.hcl", `
+			description = ""
+			binaries = ["bin/*"]
+			provides = ["java"]
+			source = "www.example.com"
+			version "17.0.0" {}
+		`),
+		sources.NewMemSource("protobuf.hcl", `
+			description = ""
+			binaries = ["bin/protoc"]
+			source = "www.example.com"
+			version "3.20.0" {}
+		`),
+	}
+	r, err := New(sources.New("", ss), Config{State: "/tmp/hermit"})
+	assert.NoError(t, err)
+
+	pkgs, err := r.WhichProvides("java")
+	assert.NoError(t, err)
+	names := []string{}
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Reference.Name)
+	}
+	assert.Equal(t, []string{"corretto", "openjdk"}, names)
+
+	_, err = r.WhichProvides("missing")
+	assert.True(t, errors.Is(err, ErrUnknownPackage))
+}
+
+func TestVersionVars(t *testing.T) {
+	const manifest = `
+		description = "Tool"
+		binaries = ["tool"]
+		version-vars = {
+			major: "^[0-9]+",
+			minor: "^([0-9]+\\.[0-9]+)",
+			pre: "-(.+)$",
+			build: "\\+([0-9]+)$",
+		}
+		source = "https://example.com/${major}/tool-${version}.tar.gz"
+		version "1.21.3" "2023.01.02" "0.9" {}
+		version "1.2.3-beta.1" {
+			source = "https://example.com/${minor}/${pre}/tool.tar.gz"
+		}
+		version "17.0.2+8" {
+			source = "https://example.com/${major}/${build}/tool.tar.gz"
+		}
+		version "2.0.0" {
+			version-vars = {
+				major: "^([0-9]+)\\.0\\.0$",
+			}
+		}
+		version "9" {
+			source = "https://example.com/${minor}/tool.tar.gz"
+		}
+		version "0.0.1" {
+			version-vars = {
+				major: "([",
+			}
+		}
+	`
+	resolve := func(version string) (string, error) {
+		t.Helper()
+		resolver, err := New(sources.New("", []sources.Source{
+			sources.NewMemSource("tool.hcl", manifest),
+		}), Config{
+			Env:      "/project",
+			State:    "/tmp/hermit",
+			Platform: platform.Platform{OS: "linux", Arch: "amd64"},
+		})
+		assert.NoError(t, err)
+		l, _ := ui.NewForTesting()
+		pkg, err := resolver.Resolve(l, ExactSelector(ParseReference("tool-"+version)))
+		if err != nil {
+			return "", err
+		}
+		return pkg.Source, nil
+	}
+	tests := []struct {
+		version  string
+		expected string
+		fail     string
+	}{
+		{version: "1.21.3", expected: "https://example.com/1/tool-1.21.3.tar.gz"},
+		{version: "2023.01.02", expected: "https://example.com/2023/tool-2023.01.02.tar.gz"},
+		{version: "0.9", expected: "https://example.com/0/tool-0.9.tar.gz"},
+		{version: "1.2.3-beta.1", expected: "https://example.com/1.2/beta.1/tool.tar.gz"},
+		{version: "17.0.2+8", expected: "https://example.com/17/8/tool.tar.gz"},
+		{version: "2.0.0", expected: "https://example.com/2/tool-2.0.0.tar.gz"},
+		{version: "9", fail: `version "9" does not match "^([0-9]+\\.[0-9]+)" for variable $minor`},
+		{version: "0.0.1", fail: "version 0.0.1: version-vars.major: error parsing regexp: missing closing ]: `[`"},
+	}
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			source, err := resolve(test.version)
+			if test.fail != "" {
+				assert.EqualError(t, err, test.fail)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, source)
+		})
+	}
+}
+
+func TestSourceTemplateFilters(t *testing.T) {
+	const manifest = `
+		description = "Tool"
+		binaries = ["tool"]
+		source = "https://example.com/${version.major}/tool-${version | replace \".\" \"_\"}.tar.gz"
+		version "1.2.3" "10.0" {}
+		version "1.2" {
+			source = "https://example.com/${version.major | pad 2}.${version.minor | pad 2}/tool-${os | upper}.tar.gz"
+		}
+		version "2.5.1" {
+			source = "https://example.com/${version | trimprefix 2. | trimsuffix \".1\"}/${version.patch}.tar.gz"
+		}
+		version "3" {
+			source = "https://example.com/${version.minor}.tar.gz"
+		}
+		version "4.0" {
+			source = "https://example.com/${version | squash}.tar.gz"
+		}
+	`
+	resolve := func(version string) (string, error) {
+		t.Helper()
+		resolver, err := New(sources.New("", []sources.Source{
+			sources.NewMemSource("tool.hcl", manifest),
+		}), Config{
+			Env:      "/project",
+			State:    "/tmp/hermit",
+			Platform: platform.Platform{OS: "linux", Arch: "amd64"},
+		})
+		assert.NoError(t, err)
+		l, _ := ui.NewForTesting()
+		pkg, err := resolver.Resolve(l, ExactSelector(ParseReference("tool-"+version)))
+		if err != nil {
+			return "", err
+		}
+		return pkg.Source, nil
+	}
+	tests := []struct {
+		version  string
+		expected string
+		fail     string
+	}{
+		{version: "1.2.3", expected: "https://example.com/1/tool-1_2_3.tar.gz"},
+		{version: "10.0", expected: "https://example.com/10/tool-10_0.tar.gz"},
+		{version: "1.2", expected: "https://example.com/01.02/tool-LINUX.tar.gz"},
+		{version: "2.5.1", expected: "https://example.com/5/1.tar.gz"},
+		{version: "3", fail: `${version.minor}: version "3" has no minor component`},
+		{version: "4.0", fail: `${version | squash}: unknown filter "squash", expected one of replace, trimprefix, trimsuffix, pad, upper or lower`},
+	}
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			source, err := resolve(test.version)
+			if test.fail != "" {
+				assert.EqualError(t, err, test.fail)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, source)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package manifest
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// Schedule is a cron-like schedule of when something may happen.
+//
+// It has five space separated fields: minute, hour, day of month, month and
+// day of week. Each field is "*" or a comma separated list of values, ranges
+// "a-b" and steps "*/n" or "a-b/n". Months may be named "jan" to "dec", and
+// days of the week "sun" to "sat", where 0 and 7 are both Sunday.
+//
+// As with cron, if both the day of month and day of week are restricted, a
+// day matching either matches.
+type Schedule struct {
+	spec   string
+	fields [5]uint64 // Bitset of the values matched by each field.
+	// Whether the day of month and day of week fields are restricted.
+	dom, dow bool
+}
+
+var scheduleFields = [5]struct {
+	name     string
+	min, max int
+	names    []string // Names of values, starting from min.
+}{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// ParseSchedule parses a cron-like Schedule, eg. "* * * * mon-fri".
+func ParseSchedule(spec string) (*Schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(scheduleFields) {
+		return nil, errors.Errorf("schedule %q: expected 5 fields (minute, hour, day of month, month, day of week) but got %d", spec, len(parts))
+	}
+	s := &Schedule{spec: spec}
+	for i, part := range parts {
+		bits, err := parseScheduleField(part, i)
+		if err != nil {
+			return nil, errors.Wrapf(err, "schedule %q: %s", spec, scheduleFields[i].name)
+		}
+		s.fields[i] = bits
+	}
+	// Sunday is both 0 and 7.
+	if s.fields[4]&(1<<7) != 0 {
+		s.fields[4] |= 1
+	}
+	s.dom = parts[2] != "*"
+	s.dow = parts[4] != "*"
+	return s, nil
+}
+
+func parseScheduleField(field string, index int) (uint64, error) {
+	def := scheduleFields[index]
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(item, "/")
+		every := 1
+		if hasStep {
+			n, err := strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return 0, errors.Errorf("invalid step %q", step)
+			}
+			every = n
+		}
+		start, end := def.min, def.max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if start, err = parseScheduleValue(from, index); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseScheduleValue(to, index); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = def.max
+			}
+			if end < start {
+				return 0, errors.Errorf("invalid range %q", rng)
+			}
+		}
+		for value := start; value <= end; value += every {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func parseScheduleValue(value string, index int) (int, error) {
+	def := scheduleFields[index]
+	for i, name := range def.names {
+		if strings.EqualFold(value, name) {
+			return def.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < def.min || n > def.max {
+		return 0, errors.Errorf("invalid value %q, must be between %d and %d", value, def.min, def.max)
+	}
+	return n, nil
+}
+
+// Matches returns true if t, in its own location, is within the schedule.
+func (s *Schedule) Matches(t time.Time) bool {
+	matches := func(field, value int) bool { return s.fields[field]&(1<<value) != 0 }
+	if !matches(0, t.Minute()) || !matches(1, t.Hour()) || !matches(3, int(t.Month())) {
+		return false
+	}
+	dom, dow := matches(2, t.Day()), matches(4, int(t.Weekday()))
+	if s.dom && s.dow {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (s *Schedule) String() string { return s.spec }
+
+// MarshalText implements encoding.TextMarshaler.
+func (s *Schedule) MarshalText() ([]byte, error) { return []byte(s.spec), nil }
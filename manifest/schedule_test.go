@@ -0,0 +1,63 @@
+package manifest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestScheduleMatches(t *testing.T) {
+	// 2024-01-01 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec    string
+		matches []time.Time
+		misses  []time.Time
+	}{
+		{spec: "* * * * *", matches: []time.Time{at(1, 0, 0), at(6, 23, 59)}},
+		{spec: "* * * * mon-fri", matches: []time.Time{at(1, 12, 0), at(5, 12, 0)}, misses: []time.Time{at(6, 12, 0), at(7, 12, 0)}},
+		{spec: "* * * * 7", matches: []time.Time{at(7, 12, 0)}, misses: []time.Time{at(6, 12, 0)}},
+		{spec: "0-30/15 9,17 * jan *", matches: []time.Time{at(2, 9, 0), at(2, 17, 30)}, misses: []time.Time{at(2, 9, 10), at(2, 10, 0)}},
+		{spec: "*/20 * * * *", matches: []time.Time{at(2, 3, 40)}, misses: []time.Time{at(2, 3, 50)}},
+		// Either day field matches when both are restricted.
+		{spec: "* * 15 * sat", matches: []time.Time{at(15, 0, 0), at(6, 0, 0)}, misses: []time.Time{at(16, 0, 0)}},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.spec)
+			assert.NoError(t, err)
+			assert.Equal(t, test.spec, schedule.String())
+			for _, when := range test.matches {
+				assert.True(t, schedule.Matches(when), "%s", when)
+			}
+			for _, when := range test.misses {
+				assert.False(t, schedule.Matches(when), "%s", when)
+			}
+		})
+	}
+}
+
+func TestScheduleMatchesInTimeLocation(t *testing.T) {
+	schedule, err := ParseSchedule("* 9-17 * * *")
+	assert.NoError(t, err)
+	when := time.Date(2024, time.January, 1, 20, 0, 0, 0, time.UTC)
+	assert.False(t, schedule.Matches(when))
+	assert.True(t, schedule.Matches(when.In(time.FixedZone("UTC-8", -8*60*60))))
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* * 0 * *",
+		"* * * foo *",
+		"* * * * fri-mon",
+		"*/0 * * * *",
+	} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,153 @@
+package manifest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// Attributes parsed as durations, keyed by the name of their enclosing block.
+var durationAttributes = map[string]map[string]bool{
+	"channel": {"update": true},
+}
+
+// ValidateSchema checks a manifest for structural errors without resolving it
+// against a platform.
+//
+// Unlike loading the manifest, which stops at the first error, all unknown
+// attributes and blocks, invalid trigger events and invalid durations are
+// reported, each prefixed with its position.
+func ValidateSchema(data []byte) []error {
+	ast, err := hcl.ParseBytes(data)
+	if err != nil {
+		return []error{errors.WithStack(err)}
+	}
+	schema, err := hcl.Schema(&Manifest{})
+	if err != nil {
+		return []error{errors.WithStack(err)}
+	}
+	// Recursive blocks (eg. "platform" within "darwin") are only described in
+	// full at their first occurrence.
+	blocks := map[string]*hcl.Block{}
+	err = hcl.Visit(schema, func(node hcl.Node, next func() error) error {
+		if block, ok := node.(*hcl.Block); ok && !isRecursive(block) {
+			if _, ok := blocks[block.Name]; !ok {
+				blocks[block.Name] = block
+			}
+		}
+		return next()
+	})
+	if err != nil {
+		return []error{errors.WithStack(err)}
+	}
+	errs := validateEntries(blocks, "", ast.Entries, schema.Entries)
+	if len(errs) == 0 {
+		// Catch anything not detected structurally, such as type mismatches.
+		if err := hcl.Unmarshal(data, &Manifest{}); err != nil {
+			errs = append(errs, errors.WithStack(err))
+		}
+	}
+	return errs
+}
+
+func isRecursive(block *hcl.Block) bool {
+	return len(block.Body) == 1 && block.Body[0].RecursiveSchema
+}
+
+func validateEntries(blocks map[string]*hcl.Block, parent string, entries, schema []*hcl.Entry) (errs []error) {
+	attrs := map[string]bool{}
+	schemaBlocks := map[string]*hcl.Block{}
+	for _, entry := range schema {
+		switch {
+		case entry.Attribute != nil:
+			attrs[entry.Attribute.Key] = true
+		case entry.Block != nil:
+			schemaBlocks[entry.Block.Name] = entry.Block
+		}
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.Attribute != nil:
+			attr := entry.Attribute
+			if !attrs[attr.Key] {
+				errs = append(errs, errors.Errorf("%s: unknown attribute %q%s", attr.Pos, attr.Key, suggest(attr.Key, attrs)))
+				continue
+			}
+			if durationAttributes[parent][attr.Key] && attr.Value != nil && attr.Value.Str != nil {
+				if _, err := time.ParseDuration(*attr.Value.Str); err != nil {
+					errs = append(errs, errors.Errorf("%s: invalid duration %q for %q", attr.Pos, *attr.Value.Str, attr.Key))
+				}
+			}
+
+		case entry.Block != nil:
+			block := entry.Block
+			schemaBlock, ok := schemaBlocks[block.Name]
+			if !ok {
+				names := map[string]bool{}
+				for name := range schemaBlocks {
+					names[name] = true
+				}
+				errs = append(errs, errors.Errorf("%s: unknown block %q%s", block.Pos, block.Name, suggest(block.Name, names)))
+				continue
+			}
+			if isRecursive(schemaBlock) {
+				schemaBlock = blocks[block.Name]
+			}
+			if block.Name == "on" {
+				for _, label := range block.Labels {
+					var event Event
+					if err := event.UnmarshalText([]byte(label)); err != nil {
+						errs = append(errs, errors.Errorf("%s: %s", block.Pos, err))
+					}
+				}
+			}
+			errs = append(errs, validateEntries(blocks, block.Name, block.Body, schemaBlock.Body)...)
+		}
+	}
+	return errs
+}
+
+// suggest returns a hint naming the closest known name, if any is close enough
+// to be a likely typo.
+func suggest(name string, known map[string]bool) string {
+	candidates := make([]string, 0, len(known))
+	for candidate := range known {
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	best := ""
+	bestDistance := 3
+	for _, candidate := range candidates {
+		if d := levenshtein(name, candidate); d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return ", did you mean " + `"` + best + `"?`
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,54 @@
+package manifest_test
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	. "github.com/cashapp/hermit/manifest" //nolint:revive // dot import
+)
+
+func TestValidateSchema(t *testing.T) {
+	errs := ValidateSchema([]byte(`
+description = "Test"
+binaries = ["bin/test"]
+binaires = ["bin/other"]
+
+darwin {
+  platform "arm64" {
+    sorce = "https://example.com/test.tar.gz"
+  }
+}
+
+channel "stable" {
+  update = "1 day"
+}
+
+version "1.0.0" {
+  on "instal" {
+    message { text = "hello" }
+  }
+  flavour "foo" {}
+}
+`))
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		`4:1: unknown attribute "binaires", did you mean "binaries"?`,
+		`8:5: unknown attribute "sorce", did you mean "source"?`,
+		`13:3: invalid duration "1 day" for "update"`,
+		`17:3: invalid event "instal"`,
+		`20:3: unknown block "flavour"`,
+	}, msgs)
+
+	errs = ValidateSchema([]byte(`
+description = "Test"
+binaries = ["bin/test"]
+version "1.0.0" {
+  source = "https://example.com/test.tar.gz"
+}
+`))
+	assert.Equal(t, 0, len(errs))
+}
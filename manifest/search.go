@@ -0,0 +1,43 @@
+package manifest
+
+// SearchResult is the JSON representation of a package found by
+// Resolver.Search, with all of its versions and channels.
+//
+// It is deliberately separate from Package so that its schema remains stable
+// for tooling as Package changes.
+type SearchResult struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Homepage    string   `json:"homepage"`
+	Repository  string   `json:"repository"`
+	Versions    []string `json:"versions"`
+	Channels    []string `json:"channels"`
+}
+
+// SearchResults groups the packages by name, in the order each name first
+// appears, with versions and channels in the order of the packages.
+func (p Packages) SearchResults() []*SearchResult {
+	out := []*SearchResult{}
+	byName := map[string]*SearchResult{}
+	for _, pkg := range p {
+		result, ok := byName[pkg.Reference.Name]
+		if !ok {
+			result = &SearchResult{
+				Name:        pkg.Reference.Name,
+				Description: pkg.Description,
+				Homepage:    pkg.Homepage,
+				Repository:  pkg.Repository,
+				Versions:    []string{},
+				Channels:    []string{},
+			}
+			byName[pkg.Reference.Name] = result
+			out = append(out, result)
+		}
+		if pkg.Reference.IsChannel() {
+			result.Channels = append(result.Channels, pkg.Reference.Channel)
+		} else {
+			result.Versions = append(result.Versions, pkg.Reference.Version.String())
+		}
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/sources"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestSearchResultsJSON(t *testing.T) {
+	files := map[string]string{
+		"test.hcl": `
+			description = "A test package"
+			homepage = "https://example.com"
+			repository = "https://github.com/example/test"
+			binaries = ["bin"]
+			source = "www.example.com/${version}"
+			version "1.0.0" "2.0.0" {}
+			channel stable {
+			  update = "24h"
+			  version = "1.*"
+			}
+		`,
+	}
+	ss := []sources.Source{}
+	for name, content := range files {
+		ss = append(ss, sources.NewMemSource(name, content))
+	}
+	resolver, err := New(sources.New("", ss), Config{
+		Env:      "/home/user/project",
+		State:    "/home/user/.cache/hermit",
+		Platform: platform.Platform{OS: "Linux", Arch: "x86_64"},
+	})
+	assert.NoError(t, err)
+	logger := ui.New(ui.LevelInfo, os.Stdout, os.Stderr, true, true)
+	pkgs, err := resolver.Search(logger.Task("search"), "test")
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(pkgs.SearchResults())
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"name":"test","description":"A test package","homepage":"https://example.com",`+
+		`"repository":"https://github.com/example/test","versions":["1.0.0","2.0.0"],`+
+		`"channels":["1","1.0","2","2.0","latest","stable"]}]`, string(data))
+
+	data, err = json.Marshal(Packages{}.SearchResults())
+	assert.NoError(t, err)
+	assert.Equal(t, `[]`, string(data))
+}
@@ -0,0 +1,61 @@
+package manifest
+
+import (
+	"regexp"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+var systemRequirementRe = regexp.MustCompile(`^\s*([^<>=\s]+)\s*(?:(>=|<=|==|=|>|<)\s*([^<>=\s]\S*))?\s*$`)
+
+// SystemRequirement is a tool a package expects to be provided by the host
+// system rather than by Hermit, with an optional version constraint.
+type SystemRequirement struct {
+	Name string
+	// Op is one of ">=", ">", "<=", "<" or "=", or empty if Version is not set.
+	Op      string
+	Version Version
+}
+
+// ParseSystemRequirement parses a requirement of the form "<name>[<op><version>]", eg. "git>=2.30".
+func ParseSystemRequirement(requirement string) (SystemRequirement, error) {
+	groups := systemRequirementRe.FindStringSubmatch(requirement)
+	if groups == nil {
+		return SystemRequirement{}, errors.Errorf("invalid system requirement %q", requirement)
+	}
+	out := SystemRequirement{Name: groups[1], Op: groups[2]}
+	if out.Op == "==" {
+		out.Op = "="
+	}
+	if groups[3] != "" {
+		out.Version = ParseVersion(groups[3])
+	}
+	return out, nil
+}
+
+func (s SystemRequirement) String() string {
+	if !s.Version.IsSet() {
+		return s.Name
+	}
+	return s.Name + s.Op + s.Version.String()
+}
+
+// Satisfied returns true if version satisfies the version constraint, if any.
+func (s SystemRequirement) Satisfied(version Version) bool {
+	if !s.Version.IsSet() {
+		return true
+	}
+	n := version.Compare(s.Version)
+	switch s.Op {
+	case ">=":
+		return n >= 0
+	case ">":
+		return n > 0
+	case "<=":
+		return n <= 0
+	case "<":
+		return n < 0
+	default:
+		return n == 0
+	}
+}
@@ -0,0 +1,39 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestSystemRequirement(t *testing.T) {
+	tests := []struct {
+		requirement string
+		version     string
+		satisfied   bool
+		err         string
+	}{
+		{requirement: "bash", version: "3.2", satisfied: true},
+		{requirement: "git>=2.30", version: "2.30.0", satisfied: true},
+		{requirement: "git >= 2.30", version: "2.39.2", satisfied: true},
+		{requirement: "git>=2.30", version: "2.29.9", satisfied: false},
+		{requirement: "git>2.30", version: "2.30", satisfied: false},
+		{requirement: "make<4", version: "3.81", satisfied: true},
+		{requirement: "make<=4", version: "4.3", satisfied: false},
+		{requirement: "jq==1.6", version: "1.6", satisfied: true},
+		{requirement: "jq=1.6", version: "1.7", satisfied: false},
+		{requirement: "git>=", err: `invalid system requirement "git>="`},
+		{requirement: "", err: `invalid system requirement ""`},
+	}
+	for _, test := range tests {
+		t.Run(test.requirement, func(t *testing.T) {
+			req, err := ParseSystemRequirement(test.requirement)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.satisfied, req.Satisfied(ParseVersion(test.version)))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package manifest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// versionComponents that can be referenced as ${version.<component>}.
+var versionComponents = map[string]int{
+	"major": 0,
+	"minor": 1,
+	"patch": 2,
+}
+
+// versionComponent returns the numbered component of a version, eg. "minor"
+// is "2" in "1.2.3".
+func versionComponent(version Version, name string) (string, error) {
+	index, ok := versionComponents[name]
+	if !ok {
+		return "", errors.Errorf("unknown version component %q, expected major, minor or patch", name)
+	}
+	components := version.Components()
+	if index >= len(components) {
+		return "", errors.Errorf("version %q has no %s component", version, name)
+	}
+	return components[index], nil
+}
+
+// applyFilters to a variable value, from a pipeline such as
+// `replace "." "_" | upper`.
+func applyFilters(value, pipeline string) (string, error) {
+	for _, stage := range strings.Split(pipeline, "|") {
+		args, err := shellquote.Split(stage)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid filter %q", strings.TrimSpace(stage))
+		}
+		if len(args) == 0 {
+			return "", errors.Errorf("empty filter in %q", pipeline)
+		}
+		name, args := args[0], args[1:]
+		arity := map[string]int{"replace": 2, "trimprefix": 1, "trimsuffix": 1, "pad": 1, "upper": 0, "lower": 0}
+		expected, ok := arity[name]
+		if !ok {
+			return "", errors.Errorf("unknown filter %q, expected one of replace, trimprefix, trimsuffix, pad, upper or lower", name)
+		}
+		if len(args) != expected {
+			return "", errors.Errorf("filter %q expects %d argument(s) but got %d", name, expected, len(args))
+		}
+		switch name {
+		case "replace":
+			value = strings.ReplaceAll(value, args[0], args[1])
+		case "trimprefix":
+			value = strings.TrimPrefix(value, args[0])
+		case "trimsuffix":
+			value = strings.TrimSuffix(value, args[0])
+		case "pad":
+			width, err := strconv.Atoi(args[0])
+			if err != nil {
+				return "", errors.Errorf("filter \"pad\" expects a numeric width but got %q", args[0])
+			}
+			if len(value) < width {
+				value = strings.Repeat("0", width-len(value)) + value
+			}
+		case "upper":
+			value = strings.ToUpper(value)
+		case "lower":
+			value = strings.ToLower(value)
+		}
+	}
+	return value, nil
+}
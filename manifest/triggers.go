@@ -1,8 +1,12 @@
 package manifest
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"sort"
 
+	"github.com/alecthomas/hcl"
+
 	"github.com/cashapp/hermit/errors"
 )
 
@@ -41,16 +45,44 @@ var eventMap = map[Event]bool{
 
 // A Trigger applied when a lifecycle event occurs.
 type Trigger struct {
-	Event Event `hcl:"event,label" help:"Event to Trigger (unpack, install, activate)."`
+	Event          Event `hcl:"event,label" help:"Event to Trigger (unpack, install, activate)."`
+	Once           bool  `hcl:"once,optional" help:"Only run the actions the first time the event is triggered for the package, across all versions."`
+	OncePerVersion bool  `hcl:"once-per-version,optional" help:"Only run the actions the first time the event is triggered for each version of the package."`
+
+	Run      []*RunAction      `hcl:"run,block" help:"A command to run when the event is triggered."`
+	Copy     []*CopyAction     `hcl:"copy,block" help:"A file to copy when the event is triggered."`
+	Chmod    []*ChmodAction    `hcl:"chmod,block" help:"Change a files mode."`
+	Rename   []*RenameAction   `hcl:"rename,block" help:"Rename a file."`
+	Delete   []*DeleteAction   `hcl:"delete,block" help:"Delete files."`
+	Message  []*MessageAction  `hcl:"message,block" help:"Display a message to the user."`
+	Mkdir    []*MkdirAction    `hcl:"mkdir,block" help:"Create a directory and any missing parents."`
+	Symlink  []*SymlinkAction  `hcl:"symlink,block" help:"Create a symbolic link."`
+	Relocate []*RelocateAction `hcl:"relocate,block" help:"Rewrite the library search paths (RPATH) and, on macOS, install names of binaries and shared libraries."`
+}
+
+// OnceTrigger is a set of actions that are only run the first time an event
+// is triggered.
+type OnceTrigger struct {
+	// Key identifying the trigger in the record of those already run.
+	Key     string
+	Actions []Action
+}
 
-	Run     []*RunAction     `hcl:"run,block" help:"A command to run when the event is triggered."`
-	Copy    []*CopyAction    `hcl:"copy,block" help:"A file to copy when the event is triggered."`
-	Chmod   []*ChmodAction   `hcl:"chmod,block" help:"Change a files mode."`
-	Rename  []*RenameAction  `hcl:"rename,block" help:"Rename a file."`
-	Delete  []*DeleteAction  `hcl:"delete,block" help:"Delete files."`
-	Message []*MessageAction `hcl:"message,block" help:"Display a message to the user."`
-	Mkdir   []*MkdirAction   `hcl:"mkdir,block" help:"Create a directory and any missing parents."`
-	Symlink []*SymlinkAction `hcl:"symlink,block" help:"Create a symbolic link."`
+// onceTriggerKey returns the key recording that trigger, defined in the named
+// layer, has run for scope.
+//
+// The key is derived from the layer and the trigger's content rather than its
+// position, so that adding, removing or reordering other triggers does not
+// change it, while changing the trigger itself runs it again.
+func onceTriggerKey(scope, layer string, trigger *Trigger) (string, error) {
+	data, err := hcl.Marshal(&struct {
+		On []*Trigger `hcl:"on,block"`
+	}{On: []*Trigger{trigger}})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	sum := sha256.Sum256(append([]byte(layer+"\n"), data...))
+	return fmt.Sprintf("%s.on-%s.%x", scope, trigger.Event, sum[:8]), nil
 }
 
 // Ordered list of actions.
@@ -80,6 +112,9 @@ func (a *Trigger) Ordered() []Action {
 	for _, action := range a.Symlink {
 		out = append(out, action)
 	}
+	for _, action := range a.Relocate {
+		out = append(out, action)
+	}
 	sort.Slice(out, func(i, j int) bool {
 		return out[i].position().Line < out[j].position().Line
 	})
@@ -0,0 +1,103 @@
+package manifest
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/platform"
+)
+
+// UnusedEntry is a "vars" or "files" entry in a manifest that is never used.
+type UnusedEntry struct {
+	// Layer the entry is defined in, eg. "version 1.0 > linux".
+	Layer string `json:"layer"`
+	// Field is "vars", "version-vars" or "files".
+	Field string `json:"field"`
+	Key   string `json:"key"`
+}
+
+func (u UnusedEntry) String() string {
+	return u.Layer + ": " + u.Field + "." + u.Key
+}
+
+// usage records the "vars" and "files" entries used while resolving packages.
+type usage map[UnusedEntry]bool
+
+func (u usage) mark(layer, field, key string) {
+	if u == nil {
+		return
+	}
+	u[UnusedEntry{Layer: layer, Field: field, Key: key}] = true
+}
+
+// FindUnused resolves every version and channel of a manifest on each
+// platform, and reports "vars" entries that are never expanded and "files"
+// entries that are never included in a resolved package.
+//
+// Versions and channels without a source or binaries on a platform are
+// skipped.
+func FindUnused(manifest *AnnotatedManifest, platforms []platform.Platform) ([]UnusedEntry, error) {
+	used := usage{}
+	for _, p := range platforms {
+		config := Config{Env: ".", State: "/tmp", Platform: p}
+		for _, ref := range manifest.References(manifest.Name) {
+			_, err := resolvePackage(manifest, config, ExactSelector(ref), used, nil)
+			if errors.Is(err, ErrNoSource) || errors.Is(err, ErrNoBinaries) {
+				continue
+			} else if err != nil {
+				return nil, errors.Wrapf(err, "%s on %s", ref, p)
+			}
+		}
+	}
+	var out []UnusedEntry
+	unused := func(layer *Layer, field string, entries map[string]string) {
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if entry := (UnusedEntry{Layer: layer.name, Field: field, Key: key}); !used[entry] {
+				out = append(out, entry)
+			}
+		}
+	}
+	manifest.walkLayers(func(layer *Layer) {
+		unused(layer, "vars", layer.Vars)
+		unused(layer, "version-vars", layer.VersionVars)
+		unused(layer, "files", layer.Files)
+	})
+	return out, nil
+}
+
+// walkLayers calls fn for every layer in the manifest, in the order they are
+// defined, named as they are when merged.
+func (m *Manifest) walkLayers(fn func(layer *Layer)) {
+	walk := func(name string, layer Layer) {
+		layer.name = name
+		fn(&layer)
+		for _, nested := range layer.Darwin {
+			nested := *nested
+			nested.name = name + " > darwin"
+			fn(&nested)
+		}
+		for _, nested := range layer.Linux {
+			nested := *nested
+			nested.name = name + " > linux"
+			fn(&nested)
+		}
+		for _, platform := range layer.Platform {
+			nested := platform.Layer
+			nested.name = name + " > platform " + strings.Join(platform.Attrs, " ")
+			fn(&nested)
+		}
+	}
+	walk("manifest", m.Layer)
+	for _, v := range m.Versions {
+		walk(v.layerName(), v.Layer)
+	}
+	for _, c := range m.Channels {
+		walk("channel "+c.Name, c.Layer)
+	}
+}
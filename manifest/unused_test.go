@@ -0,0 +1,59 @@
+package manifest
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/platform"
+)
+
+func TestFindUnused(t *testing.T) {
+	fs := fstest.MapFS{
+		"tool.hcl": {Data: []byte(`
+			description = "Tool"
+			binaries = ["tool"]
+			vars = {
+			  base: "https://example.com/${name}",
+			  mirror: "https://mirror.example.com",
+			}
+			version-vars = {
+			  major: "^[0-9]+",
+			  minor: "^[0-9]+\\.[0-9]+",
+			}
+			files = {
+			  "config.txt": "${root}/config.txt",
+			}
+			source = "${base}/${major}/${version}/${name}-${os}.tar.gz"
+			linux {
+			  vars = { suffix: ".tgz" }
+			}
+			darwin {
+			  vars = { suffix: ".zip" }
+			  source = "${base}/${version}/${name}-mac${suffix}"
+			}
+			version "1.0.0" {
+			  files = { "extra.txt": "${root}/extra.txt" }
+			}
+			platform "windows" {
+			  files = { "extra.txt": "${root}/extra.txt" }
+			}
+			version "2.0.0" {
+			  files = { "config.txt": "${root}/etc/config.txt" }
+			}
+		`)},
+		"config.txt": {Data: []byte("config")},
+		"extra.txt":  {Data: []byte("extra")},
+	}
+	mani, err := LoadManifestFile(fs, "tool.hcl")
+	assert.NoError(t, err)
+	unused, err := FindUnused(mani, platform.Core)
+	assert.NoError(t, err)
+	assert.Equal(t, []UnusedEntry{
+		{Layer: "manifest", Field: "vars", Key: "mirror"},
+		{Layer: "manifest", Field: "version-vars", Key: "minor"},
+		{Layer: "manifest > linux", Field: "vars", Key: "suffix"},
+		{Layer: "manifest > platform windows", Field: "files", Key: "extra.txt"},
+	}, unused)
+}
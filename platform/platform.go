@@ -1,5 +1,12 @@
 package platform
 
+import (
+	"runtime"
+	"strings"
+
+	"github.com/cashapp/hermit/errors"
+)
+
 // Amd64 architecture
 const Amd64 = "amd64"
 
@@ -33,6 +40,11 @@ var Core = []Platform{
 	{Darwin, Arm64},
 }
 
+// Optional platforms supported by Hermit, but not required of packages.
+var Optional = []Platform{
+	{Linux, Arm64},
+}
+
 var xarch = map[string]string{
 	Amd64: "x86_64",
 	"386": "i386",
@@ -43,3 +55,25 @@ var xarch = map[string]string{
 func ArchToXArch(arch string) string {
 	return xarch[arch]
 }
+
+// Host is the platform Hermit is currently running on.
+var Host = Platform{runtime.GOOS, runtime.GOARCH}
+
+// Parse a platform in the form "<os>-<arch>".
+func Parse(s string) (Platform, error) {
+	goos, arch, ok := strings.Cut(s, "-")
+	if !ok || goos == "" || arch == "" {
+		return Platform{}, errors.Errorf("invalid platform %q, expected <os>-<arch>", s)
+	}
+	return Platform{OS: goos, Arch: arch}, nil
+}
+
+// UnmarshalText parses a platform in the form "<os>-<arch>".
+func (p *Platform) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
@@ -31,6 +31,11 @@ _hermit_deactivate() {
 
   hash -r 2>/dev/null
 
+  if test -n "${_HERMIT_COMMAND_NOT_FOUND+_}"; then
+    unset -f {{ .CommandNotFoundHandler }} >/dev/null 2>&1
+    unset _HERMIT_COMMAND_NOT_FOUND
+  fi
+
 {{- if .Bash }}
   unset PROMPT_COMMAND >/dev/null 2>&1
   if test -n "${_HERMIT_OLD_PROMPT_COMMAND+_}"; then PROMPT_COMMAND="${_HERMIT_OLD_PROMPT_COMMAND}"; unset _HERMIT_OLD_PROMPT_COMMAND; fi
@@ -83,3 +88,19 @@ fi
 {{- if .Zsh }}
 precmd_functions+=(update_hermit_env)
 {{- end}}
+
+{{- if .CommandNotFoundHandler }}
+# Suggest packages providing missing commands, unless the shell already has a handler.
+if ! type {{ .CommandNotFoundHandler }} &>/dev/null; then
+  _HERMIT_COMMAND_NOT_FOUND=1
+  {{ .CommandNotFoundHandler }}() {
+    "${HERMIT_ENV}/bin/hermit" --quiet which-provides --suggest "$1"
+{{- if .Bash }}
+    echo "bash: $1: command not found" >&2
+{{- else }}
+    echo "zsh: command not found: $1" >&2
+{{- end }}
+    return 127
+  }
+fi
+{{- end}}
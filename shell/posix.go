@@ -30,6 +30,19 @@ type posixActivationContext struct {
 func (a posixActivationContext) Bash() bool { return a.Shell == "bash" }
 func (a posixActivationContext) Zsh() bool  { return a.Shell == "zsh" }
 
+// CommandNotFoundHandler returns the name of the function the shell calls
+// when a command is not found, or "" if it does not support one.
+func (a posixActivationContext) CommandNotFoundHandler() string {
+	switch a.Shell {
+	case "bash":
+		return "command_not_found_handle"
+	case "zsh":
+		return "command_not_found_handler"
+	default:
+		return ""
+	}
+}
+
 // Functionality common to POSIX shells.
 type posixMixin struct{}
 
@@ -4,6 +4,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cashapp/hermit/errors"
@@ -79,14 +80,23 @@ func syncGit(b *ui.Task, dir, source, finalDest string, runner util.CommandRunne
 			err = errors.WithStack(os.Chtimes(finalDest, now, now))
 		}
 	}()
+	removeAbandonedClones(b, dir, finalDest)
 	// First, if a git repo exists, just pull.
 	info, _ := os.Stat(filepath.Join(finalDest, ".git"))
 	if info != nil {
+		removeStaleGitLocks(b, finalDest)
 		err = runner.RunInDir(b, finalDest, "git", "pull")
 		if err == nil {
 			return nil
 		}
-		// If pull fails, assume the repo is corrupted and just try and re-clone it.
+		// The repo may have been left in a dirty state by an interrupted
+		// sync, so try to repair it before falling back to re-cloning.
+		b.Debugf("git pull failed, attempting repair: %s", err)
+		err = repairGit(b, finalDest, runner)
+		if err == nil {
+			return nil
+		}
+		b.Debugf("git repair failed, re-cloning: %s", err)
 	}
 	// No git repo, clone down to temporary directory.
 	dest, err := os.MkdirTemp(dir, filepath.Base(finalDest)+"-*")
@@ -105,3 +115,58 @@ func syncGit(b *ui.Task, dir, source, finalDest string, runner util.CommandRunne
 
 	return nil
 }
+
+// Lock files older than this are assumed to have been left behind by a git
+// process that was killed, rather than belonging to one that is running.
+const staleGitLockAge = 5 * time.Minute
+
+// repairGit resets a clone to the remote HEAD, recovering from a fetch or
+// checkout that was interrupted part way through.
+func repairGit(b *ui.Task, dir string, runner util.CommandRunner) error {
+	if err := runner.RunInDir(b, dir, "git", "fetch", "--depth=1", "origin", "HEAD"); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(runner.RunInDir(b, dir, "git", "reset", "--hard", "FETCH_HEAD"))
+}
+
+// removeStaleGitLocks removes lock files left in a clone by a killed git
+// process, which would otherwise cause all further git operations to fail.
+func removeStaleGitLocks(b *ui.Task, dir string) {
+	gitDir := filepath.Join(dir, ".git")
+	_ = filepath.WalkDir(gitDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			// Only the top-level and ref lock files are held across operations.
+			if path != gitDir && !strings.HasPrefix(path, filepath.Join(gitDir, "refs")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < staleGitLockAge {
+			return nil
+		}
+		b.Debugf("Removing stale git lock %s", path)
+		_ = os.Remove(path)
+		return nil
+	})
+}
+
+// removeAbandonedClones removes temporary clones of "finalDest" left behind by
+// a sync that was interrupted before the clone was moved into place.
+func removeAbandonedClones(b *ui.Task, dir, finalDest string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, filepath.Base(finalDest)+"-*"))
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.IsDir() || time.Since(info.ModTime()) < staleGitLockAge {
+			continue
+		}
+		b.Debugf("Removing abandoned clone %s", match)
+		_ = os.RemoveAll(match)
+	}
+}
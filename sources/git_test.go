@@ -2,7 +2,10 @@ package sources_test
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
 	"github.com/cashapp/hermit/errors"
@@ -46,3 +49,60 @@ func TestGitDoesNotRemoveSourceAfterSyncFailure(t *testing.T) {
 	assert.Equal(t, gitDir, files[0].Name())
 
 }
+
+type ScriptedGit struct {
+	commands []string
+	fail     map[string]bool
+}
+
+func (s *ScriptedGit) RunInDir(_ *ui.Task, dir string, args ...string) error {
+	command := strings.Join(args, " ")
+	s.commands = append(s.commands, command)
+	if s.fail[args[1]] {
+		return errors.Errorf("%s failed", command)
+	}
+	if args[1] == "clone" {
+		return os.MkdirAll(filepath.Join(dir, ".git"), 0700)
+	}
+	return nil
+}
+
+func TestGitRepairsInterruptedSync(t *testing.T) {
+	git := &ScriptedGit{}
+	sourceDir := t.TempDir()
+	source := sources.NewGitSource("git://test", sourceDir, git)
+	u, _ := ui.NewForTesting()
+	assert.NoError(t, source.Sync(u, true))
+	files, err := os.ReadDir(sourceDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+	repo := filepath.Join(sourceDir, files[0].Name())
+
+	// Simulate a git process and a clone killed part way through.
+	old := time.Now().Add(-time.Hour)
+	lock := filepath.Join(repo, ".git", "index.lock")
+	assert.NoError(t, os.WriteFile(lock, nil, 0600))
+	assert.NoError(t, os.Chtimes(lock, old, old))
+	abandoned := repo + "-123"
+	assert.NoError(t, os.Mkdir(abandoned, 0700))
+	assert.NoError(t, os.Chtimes(abandoned, old, old))
+
+	git.commands = nil
+	git.fail = map[string]bool{"pull": true}
+	assert.NoError(t, source.Sync(u, true))
+	assert.Equal(t, []string{
+		"git pull",
+		"git fetch --depth=1 origin HEAD",
+		"git reset --hard FETCH_HEAD",
+	}, git.commands)
+	_, err = os.Stat(lock)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(abandoned)
+	assert.True(t, os.IsNotExist(err))
+
+	// If the repair fails too, fall back to re-cloning.
+	git.commands = nil
+	git.fail = map[string]bool{"pull": true, "reset": true}
+	assert.NoError(t, source.Sync(u, true))
+	assert.True(t, strings.HasPrefix(git.commands[len(git.commands)-1], "git clone --depth=1 git://test "))
+}
@@ -2,9 +2,11 @@ package sources
 
 import (
 	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -70,10 +72,16 @@ func (s *Sources) Sync(p *ui.UI, force bool) error {
 }
 
 // ForURIs returns Source instances for given uri strings
-func ForURIs(b *ui.UI, dir, env string, uris []string) (*Sources, error) {
+//
+// client is used to download tarball sources.
+func ForURIs(b *ui.UI, dir, env string, uris []string, client *http.Client) (*Sources, error) {
 	sources := make([]Source, 0, len(uris))
 	for _, uri := range uris {
-		s, err := getSource(b, uri, dir, env)
+		expanded, err := ExpandURI(uri)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s, err := getSource(b, uri, expanded, dir, env, client)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -87,14 +95,56 @@ func ForURIs(b *ui.UI, dir, env string, uris []string) (*Sources, error) {
 	}, nil
 }
 
-func getSource(b *ui.UI, source, dir, env string) (Source, error) {
-	task := b.Task(source)
+var envVarRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// SourceEnvPrefix is the prefix of the environment variables that source URIs
+// may reference.
+const SourceEnvPrefix = "HERMIT_SOURCE_"
+
+// ExpandURI expands ${NAME} references to environment variables in a source
+// URI, eg. to use a mirror host configured per developer.
+//
+// Sources may come from an untrusted hermit.hcl, so only variables prefixed
+// with SourceEnvPrefix can be referenced, so that other secrets in the
+// environment can not be sent to arbitrary hosts. Referencing any other
+// variable, or one that is not set, is an error.
+func ExpandURI(uri string) (string, error) {
+	var missing, disallowed []string
+	expanded := envVarRe.ReplaceAllStringFunc(uri, func(ref string) string {
+		name := envVarRe.FindStringSubmatch(ref)[1]
+		if !strings.HasPrefix(name, SourceEnvPrefix) {
+			disallowed = append(disallowed, "$"+name)
+			return ""
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, "$"+name)
+		}
+		return value
+	})
+	if len(disallowed) > 0 {
+		return "", errors.Errorf("source %q references environment variable(s) %s, only %s* variables can be used", uri, strings.Join(disallowed, ", "), SourceEnvPrefix)
+	}
+	if len(missing) > 0 {
+		return "", errors.Errorf("source %q references undefined environment variable(s) %s", uri, strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// getSource returns the Source for the expanded source URI, logging the
+// unexpanded URI so that the values of any variables are not revealed.
+func getSource(b *ui.UI, unexpanded, source, dir, env string, client *http.Client) (Source, error) {
+	task := b.Task(unexpanded)
 	defer task.Done()
 
 	if strings.HasSuffix(source, ".git") {
 		return NewGitSource(source, dir, &util.RealCommandRunner{}), nil
 	}
 
+	if isTarballURI(source) {
+		return NewTarballSource(source, dir, client), nil
+	}
+
 	uri, err := url.Parse(source)
 	if err != nil {
 		return nil, errors.Wrap(err, "invalid source")
@@ -107,7 +157,7 @@ func getSource(b *ui.UI, source, dir, env string) (Source, error) {
 	switch uri.Scheme {
 	case "env":
 		if uri.Path == "" {
-			task.Warnf("%s does not contain a path", uri)
+			task.Warnf("%s does not contain a path", unexpanded)
 			return nil, nil
 		}
 		checkDir = filepath.Join(env, uri.Path)
@@ -115,21 +165,21 @@ func getSource(b *ui.UI, source, dir, env string) (Source, error) {
 
 	case "file":
 		if uri.Path == "" {
-			task.Warnf("%s does not contain a path", uri)
+			task.Warnf("%s does not contain a path", unexpanded)
 			return nil, nil
 		}
 		checkDir = uri.Path
 		candidate = os.DirFS(uri.Path)
 
 	default:
-		return nil, errors.Errorf("unsupported source %q", source)
+		return nil, errors.Errorf("unsupported source %q", unexpanded)
 	}
 	if info, err := os.Stat(checkDir); err == nil {
 		return NewLocalSource(source, candidate), nil
 	} else if info != nil && !info.IsDir() {
-		task.Warnf("source %q should be a directory but is not", source)
+		task.Warnf("source %q should be a directory but is not", unexpanded)
 	} else {
-		task.Warnf("source %q not found: %s", source, err)
+		task.Warnf("source %q not found", unexpanded)
 	}
 	return nil, nil
 }
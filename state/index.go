@@ -0,0 +1,168 @@
+package state
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/cashapp/hermit/internal/dao"
+)
+
+// fsIndex is a read-through cache of the filesystem checks used to determine
+// whether packages are cached, extracted and linked.
+//
+// Only positive results are remembered, so a missed entry always falls back to
+// the filesystem. Entries are invalidated when packages are removed or evicted.
+//
+// If "dao" is non-nil the extracted and linked entries are also persisted to an
+// on-disk index, shared between processes.
+type fsIndex struct {
+	lock   sync.Mutex
+	dao    *dao.DAO
+	disk   *dao.Index
+	cached map[string]bool
+	// Extracted package roots.
+	extracted map[string]bool
+	// Linked binaries, keyed by package reference.
+	linked map[string][]string
+}
+
+func newFSIndex(d *dao.DAO) *fsIndex {
+	return &fsIndex{
+		dao:       d,
+		cached:    map[string]bool{},
+		extracted: map[string]bool{},
+		linked:    map[string][]string{},
+	}
+}
+
+// load the on-disk index on first use. Must be called with the lock held.
+func (i *fsIndex) load() *dao.Index {
+	if i.dao == nil {
+		return nil
+	}
+	if i.disk == nil {
+		disk, err := i.dao.GetIndex()
+		if err != nil {
+			// A corrupt index is ignored, and will be rewritten on the next update.
+			disk = &dao.Index{Extracted: map[string]bool{}, Linked: map[string][]string{}}
+		}
+		i.disk = disk
+	}
+	return i.disk
+}
+
+// update the on-disk index.
+//
+// The index is reloaded before applying "fn" as other processes may have
+// modified it. Callers must hold the state lock.
+func (i *fsIndex) update(fn func(index *dao.Index)) error {
+	if i.dao == nil {
+		return nil
+	}
+	i.disk = nil
+	disk := i.load()
+	fn(disk)
+	return i.dao.UpdateIndex(disk)
+}
+
+func (i *fsIndex) isCached(path string, check func() bool) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.cached[path] {
+		return true
+	}
+	if !check() {
+		return false
+	}
+	i.cached[path] = true
+	return true
+}
+
+func (i *fsIndex) isExtracted(root string, check func() bool) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.extracted[root] {
+		return true
+	}
+	if disk := i.load(); disk != nil && disk.Extracted[root] {
+		i.extracted[root] = true
+		return true
+	}
+	if !check() {
+		return false
+	}
+	i.extracted[root] = true
+	return true
+}
+
+func (i *fsIndex) areBinariesLinked(ref string, binaries []string, check func() bool) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if equalBinaries(i.linked[ref], binaries) {
+		return true
+	}
+	if disk := i.load(); disk != nil && equalBinaries(disk.Linked[ref], binaries) {
+		i.linked[ref] = binaries
+		return true
+	}
+	if !check() {
+		return false
+	}
+	i.linked[ref] = binaries
+	return true
+}
+
+func (i *fsIndex) markExtracted(root string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.extracted[root] = true
+	return i.update(func(index *dao.Index) { index.Extracted[root] = true })
+}
+
+func (i *fsIndex) markLinked(ref string, binaries []string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.linked[ref] = binaries
+	return i.update(func(index *dao.Index) { index.Linked[ref] = binaries })
+}
+
+// forgetPackage invalidates the extracted and linked entries for a package.
+func (i *fsIndex) forgetPackage(root, ref string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	delete(i.extracted, root)
+	delete(i.linked, ref)
+	return i.update(func(index *dao.Index) {
+		delete(index.Extracted, root)
+		delete(index.Linked, ref)
+	})
+}
+
+func (i *fsIndex) forgetCached(path string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	delete(i.cached, path)
+}
+
+// forgetPackages invalidates all extracted and linked entries.
+func (i *fsIndex) forgetPackages() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.extracted = map[string]bool{}
+	i.linked = map[string][]string{}
+	return i.update(func(index *dao.Index) {
+		index.Extracted = map[string]bool{}
+		index.Linked = map[string][]string{}
+	})
+}
+
+func (i *fsIndex) forgetAllCached() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.cached = map[string]bool{}
+}
+
+// equalBinaries returns true if "a" is a known set of binaries equal to "b".
+func equalBinaries(a, b []string) bool {
+	return a != nil && slices.Equal(a, b)
+}
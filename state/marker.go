@@ -0,0 +1,185 @@
+package state
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+)
+
+var (
+	// extractingHeartbeat is how often a held extraction marker is touched.
+	extractingHeartbeat = 10 * time.Second
+	// extractingStale is how long an extraction marker may go untouched
+	// before it is assumed its owner has died.
+	extractingStale = 2 * time.Minute
+	// extractingPoll is how often a held extraction marker is checked.
+	extractingPoll = 100 * time.Millisecond
+)
+
+// acquireExtractionMarker atomically creates a ".extracting" marker next to
+// the package destination, waiting for any other holder to release it.
+//
+// The state lock is host-local on some network filesystems, so this guards
+// against multiple hosts sharing a state directory extracting the same package
+// at the same time. The marker is created by hard linking a file containing a
+// unique token for its owner, which is atomic on NFS, so the marker is never
+// seen without its token.
+//
+// While held the marker is periodically touched, and markers that have not
+// been touched recently are assumed to belong to a dead process and removed.
+// Their age is measured with the filesystem's clock rather than the local one,
+// as the hosts sharing it may disagree on the time.
+func (s *State) acquireExtractionMarker(b *ui.Task, p *manifest.Package) (release func(), err error) {
+	marker := filepath.Clean(p.Dest) + ".extracting"
+	dir := filepath.Dir(marker)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hostname, _ := os.Hostname()
+	token := fmt.Sprintf("%s:%d:%d\n", hostname, os.Getpid(), time.Now().UnixNano())
+	deadline := time.Now().Add(s.lockTimeout)
+	var skew *time.Duration
+	for {
+		err := createMarker(marker, token)
+		if err == nil {
+			return heartbeat(marker), nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.WithStack(err)
+		}
+		info, err := os.Stat(marker)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		owner, err := os.ReadFile(marker)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if skew == nil {
+			d, err := clockSkew(dir)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			skew = &d
+		}
+		if age := time.Now().Add(*skew).Sub(info.ModTime()); age > extractingStale {
+			b.Warnf("Removing stale extraction marker %s held by %s, last updated %s ago", marker, owner, age.Round(time.Second))
+			if err := removeStaleMarker(marker, owner); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("timed out waiting for %s to be extracted by %s", p, owner)
+		}
+		b.Tracef("Waiting for %s to be extracted elsewhere", p)
+		time.Sleep(extractingPoll)
+	}
+}
+
+// createMarker atomically creates marker containing token, failing with an
+// os.IsExist error if it already exists.
+func createMarker(marker, token string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(marker), filepath.Base(marker)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(token)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return os.Link(tmp.Name(), marker)
+}
+
+// removeStaleMarker removes marker if it still belongs to owner.
+//
+// Every process that found the same stale marker races to create a takeover
+// file named after its owner, so only one of them removes it, and only after
+// checking that it has not since been replaced by a live marker. The others
+// wait for the marker to be released as usual.
+func removeStaleMarker(marker string, owner []byte) error {
+	sum := sha256.Sum256(owner)
+	takeover := fmt.Sprintf("%s.%x.takeover", marker, sum[:8])
+	f, err := os.OpenFile(takeover, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	_ = f.Close()
+	defer os.Remove(takeover)
+	current, err := os.ReadFile(marker)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	if !bytes.Equal(current, owner) {
+		return nil
+	}
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// clockSkew returns how far the clock of the filesystem containing dir, which
+// sets modification times, is ahead of the local clock.
+func clockSkew(dir string) (time.Duration, error) {
+	f, err := os.CreateTemp(dir, ".clock-*")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	now := time.Now()
+	_ = f.Close()
+	defer os.Remove(f.Name())
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return info.ModTime().Sub(now), nil
+}
+
+// heartbeat touches marker until the returned function is called, which then
+// removes it.
+func heartbeat(marker string) (release func()) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(extractingHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// Nil times are set by the filesystem, as UTIME_NOW, rather than
+				// from the local clock, so they are comparable with clockSkew.
+				_ = unix.UtimesNano(marker, nil)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		_ = os.Remove(marker)
+	}
+}
@@ -0,0 +1,237 @@
+package state
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/internal/dao"
+	"github.com/cashapp/hermit/ui"
+)
+
+// Migrate moves the contents of the state directory, including the download
+// cache if it is inside the state directory, to newRoot.
+//
+// Absolute symlinks in extracted packages and linked binaries that point into
+// the old state directory are rewritten to point into newRoot, as are the
+// package roots recorded in the on-disk index.
+//
+// newRoot must not exist, or be an empty directory. The State must not be
+// used after migration, it should be reopened at newRoot.
+func (s *State) Migrate(l *ui.UI, newRoot string) error {
+	oldRoot, err := filepath.Abs(s.root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	newRoot, err = filepath.Abs(newRoot)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if oldRoot == newRoot {
+		return errors.Errorf("state is already at %s", newRoot)
+	}
+	if isWithin(oldRoot, newRoot) {
+		return errors.Errorf("can't migrate state into itself: %s", newRoot)
+	}
+	if entries, err := os.ReadDir(newRoot); err == nil && len(entries) > 0 {
+		return errors.Errorf("%s is not empty", newRoot)
+	} else if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	release, err := s.acquireLock(l, "Migrating state to %s", newRoot)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release() //nolint:errcheck
+
+	if err := os.MkdirAll(newRoot, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	entries, err := os.ReadDir(oldRoot)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == filepath.Base(s.lock) {
+			continue
+		}
+		l.Debugf("Moving %s to %s", entry.Name(), newRoot)
+		if err := move(filepath.Join(oldRoot, entry.Name()), filepath.Join(newRoot, entry.Name())); err != nil {
+			return errors.Wrap(err, entry.Name())
+		}
+	}
+	for _, dir := range []string{"pkg", "base", "binaries"} {
+		rewritten, err := RewriteSymlinks(filepath.Join(newRoot, dir), oldRoot, newRoot)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		l.Debugf("Rewrote %d symlinks in %s", rewritten, dir)
+	}
+	return errors.WithStack(migrateIndex(newRoot, oldRoot))
+}
+
+// RewriteSymlinks rewrites absolute symlinks under dir that point into oldRoot
+// to point to the same path under newRoot, returning how many were rewritten.
+//
+// A missing dir is not an error.
+func RewriteSymlinks(dir, oldRoot, newRoot string) (int, error) {
+	rewritten := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !filepath.IsAbs(target) || !isWithin(oldRoot, target) {
+			return nil
+		}
+		rel, err := filepath.Rel(oldRoot, target)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// Extracted packages are read-only, so their directories need to be
+		// made writable while the link is replaced.
+		parent, err := os.Stat(filepath.Dir(path))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if parent.Mode()&0200 == 0 {
+			if err := os.Chmod(filepath.Dir(path), parent.Mode()|0200); err != nil {
+				return errors.WithStack(err)
+			}
+			defer os.Chmod(filepath.Dir(path), parent.Mode()) // nolint
+		}
+		if err := os.Remove(path); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := os.Symlink(filepath.Join(newRoot, rel), path); err != nil {
+			return errors.WithStack(err)
+		}
+		rewritten++
+		return nil
+	})
+	return rewritten, errors.WithStack(err)
+}
+
+// migrateIndex rewrites the package roots recorded in the on-disk index.
+func migrateIndex(newRoot, oldRoot string) error {
+	d, err := dao.Open(newRoot)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	index, err := d.GetIndex()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(index.Extracted) == 0 {
+		return nil
+	}
+	extracted := make(map[string]bool, len(index.Extracted))
+	for root, ok := range index.Extracted {
+		if rel, err := filepath.Rel(oldRoot, root); err == nil && isWithin(oldRoot, root) {
+			root = filepath.Join(newRoot, rel)
+		}
+		extracted[root] = ok
+	}
+	index.Extracted = extracted
+	return errors.WithStack(d.UpdateIndex(index))
+}
+
+// isWithin returns true if path is root or inside it.
+func isWithin(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// move renames src to dst, falling back to copying if they are on different
+// devices.
+func move(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return errors.WithStack(err)
+	}
+	if err := copyTree(src, dst); err != nil {
+		return errors.WithStack(err)
+	}
+	_ = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	return errors.WithStack(os.RemoveAll(src))
+}
+
+// copyTree copies src to dst, preserving modes and symlinks.
+func copyTree(src, dst string) error {
+	// Directory modes are applied once their contents have been copied, as
+	// extracted packages are read-only.
+	type dirMode struct {
+		path string
+		mode os.FileMode
+	}
+	var dirs []dirMode
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			return errors.WithStack(os.Symlink(link, target))
+		case d.IsDir():
+			dirs = append(dirs, dirMode{target, info.Mode().Perm()})
+			return errors.WithStack(os.MkdirAll(target, 0700))
+		default:
+			return errors.WithStack(copyFile(path, target, info.Mode().Perm()))
+		}
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer r.Close() // nolint
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(w.Close())
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cashapp/hermit/errors"
+	"github.com/cashapp/hermit/internal/dao"
+	"github.com/cashapp/hermit/manifest"
+	"github.com/cashapp/hermit/ui"
+	"github.com/cashapp/hermit/util"
+)
+
+// UpgradeSelf upgrades an installed Hermit package (eg. hermit@stable) to the
+// latest version in its channel, returning true if it was upgraded.
+//
+// The previous version and its ETag are kept alongside the new one so that
+// they can be restored with RollbackSelf. The new version is verified by executing it,
+// and the previous version is restored if verification fails.
+func (s *State) UpgradeSelf(b *ui.Task, pkg *manifest.Package) (bool, error) {
+	if !pkg.Reference.IsChannel() {
+		return false, errors.Errorf("%s is not a channel package", pkg)
+	}
+	release, err := s.acquireLock(b, "upgrading %s", pkg)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer release() //nolint:errcheck
+
+	mirrors := make([]string, len(pkg.Mirrors))
+	copy(mirrors, pkg.Mirrors)
+	mirrors = append(mirrors, s.generateMirrors(pkg)...)
+	etag, err := s.cache.ETag(b, pkg.Source, mirrors...)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if s.isExtracted(pkg) && etag != "" && etag == pkg.ETag {
+		return false, nil
+	}
+
+	previous := previousSelf(pkg)
+	hasPrevious := false
+	if _, err := os.Stat(pkg.Dest); err == nil {
+		if err := s.removeRecursive(b, previous); err != nil {
+			return false, errors.WithStack(err)
+		}
+		b.Debugf("mv %s %s", pkg.Dest, previous)
+		if err := os.Rename(pkg.Dest, previous); err != nil {
+			return false, errors.WithStack(err)
+		}
+		if err := s.dao.UpdatePackage(previousSelfRef(pkg), &dao.Package{Etag: pkg.ETag}); err != nil {
+			return false, errors.WithStack(err)
+		}
+		hasPrevious = true
+	}
+	if err := s.index.forgetPackage(pkg.Root, pkg.Reference.String()); err != nil {
+		return false, errors.WithStack(err)
+	}
+	s.index.forgetCached(s.cache.Path(pkg.SHA256, pkg.Source))
+	if err := s.cache.Evict(b, pkg.SHA256, pkg.Source); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	err = s.CacheAndUnpack(b, pkg)
+	if err == nil {
+		err = verifySelf(b, pkg)
+	}
+	if err != nil {
+		if hasPrevious {
+			if rerr := s.restoreSelf(b, pkg); rerr != nil {
+				return false, errors.Join(err, rerr)
+			}
+			return false, errors.Wrapf(err, "%s: upgrade failed, restored previous version", pkg)
+		}
+		return false, errors.WithStack(err)
+	}
+
+	if etag == "" {
+		etag = pkg.ETag
+	}
+	pkg.UpdatedAt = time.Now()
+	return true, errors.WithStack(s.dao.UpdatePackage(pkg.Reference.String(), &dao.Package{
+		Etag:            etag,
+		UpdateCheckedAt: pkg.UpdatedAt,
+	}))
+}
+
+// RollbackSelf restores the version of a Hermit package that was replaced by
+// the last UpgradeSelf.
+func (s *State) RollbackSelf(b *ui.Task, pkg *manifest.Package) error {
+	if _, err := os.Stat(previousSelf(pkg)); os.IsNotExist(err) {
+		return errors.Errorf("no previous version of %s to roll back to", pkg)
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	release, err := s.acquireLock(b, "rolling back %s", pkg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release() //nolint:errcheck
+
+	return errors.WithStack(s.restoreSelf(b, pkg))
+}
+
+// restoreSelf replaces a Hermit package with its previous version, along
+// with the ETag it was downloaded with.
+//
+// If the previous ETag is unknown the stored ETag is cleared, so that the next
+// upgrade does not mistake the previous version for the current one.
+func (s *State) restoreSelf(b *ui.Task, pkg *manifest.Package) error {
+	if err := s.index.forgetPackage(pkg.Root, pkg.Reference.String()); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.removeRecursive(b, pkg.Dest); err != nil {
+		return errors.WithStack(err)
+	}
+	b.Debugf("mv %s %s", previousSelf(pkg), pkg.Dest)
+	if err := os.Rename(previousSelf(pkg), pkg.Dest); err != nil {
+		return errors.WithStack(err)
+	}
+	previous, err := s.dao.GetPackage(previousSelfRef(pkg))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if previous == nil {
+		previous = &dao.Package{}
+	} else if err := s.dao.DeletePackage(previousSelfRef(pkg)); err != nil {
+		return errors.WithStack(err)
+	}
+	pkg.ETag = previous.Etag
+	return errors.WithStack(s.dao.UpdatePackage(pkg.Reference.String(), &dao.Package{Etag: previous.Etag}))
+}
+
+func previousSelf(pkg *manifest.Package) string {
+	return pkg.Dest + ".old"
+}
+
+// previousSelfRef is the key under which the ETag of the previous version of
+// a Hermit package is stored.
+func previousSelfRef(pkg *manifest.Package) string {
+	return pkg.Reference.String() + ".old"
+}
+
+// verifySelf checks that a freshly installed Hermit executes.
+func verifySelf(b *ui.Task, pkg *manifest.Package) error {
+	exe := filepath.Join(pkg.Root, "hermit")
+	if _, err := util.Capture(b, exe, "--version"); err != nil {
+		return errors.Wrapf(err, "%s failed verification", exe)
+	}
+	return nil
+}
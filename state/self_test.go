@@ -0,0 +1,87 @@
+package state_test
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/cashapp/hermit/manifest/manifesttest"
+	"github.com/cashapp/hermit/ui"
+)
+
+func TestUpgradeSelfRestoresPreviousOnFailure(t *testing.T) {
+	etag := "first"
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("ETag", etag)
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+		}))
+	defer fixture.Clean()
+	state := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	dest := filepath.Join(state.PkgDir(), "hermit@canary")
+	pkg := manifesttest.NewPkgBuilder(dest).
+		WithName("hermit").
+		WithChannel("canary").
+		WithBinaries("darwin_exe").
+		WithSource(fixture.Server.URL).
+		Result()
+	err := state.CacheAndUnpack(log.Task("test"), pkg)
+	assert.NoError(t, err)
+	assert.NoError(t, state.WritePackageState(pkg))
+	assert.NoError(t, os.Chmod(dest, 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(dest, "marker"), nil, 0600))
+
+	// The archive does not contain a "hermit" executable, so verification fails.
+	etag = "second"
+	upgraded, err := state.UpgradeSelf(log.Task("test"), pkg)
+	assert.Error(t, err)
+	assert.False(t, upgraded)
+	_, err = os.Stat(filepath.Join(dest, "marker"))
+	assert.NoError(t, err)
+	_, err = os.Stat(dest + ".old")
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "first", pkg.ETag)
+	state.ReadPackageState(pkg)
+	assert.Equal(t, "first", pkg.ETag)
+}
+
+func TestRollbackSelf(t *testing.T) {
+	fixture := NewStateTestFixture(t)
+	defer fixture.Clean()
+	state := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	dest := filepath.Join(state.PkgDir(), "hermit@canary")
+	pkg := manifesttest.NewPkgBuilder(dest).
+		WithName("hermit").
+		WithChannel("canary").
+		Result()
+
+	err := state.RollbackSelf(log.Task("test"), pkg)
+	assert.EqualError(t, err, "no previous version of hermit@canary to roll back to")
+
+	assert.NoError(t, os.MkdirAll(dest, 0700))
+	assert.NoError(t, os.MkdirAll(dest+".old", 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(dest+".old", "marker"), nil, 0600))
+	pkg.ETag = "current"
+	assert.NoError(t, state.WritePackageState(pkg))
+	err = state.RollbackSelf(log.Task("test"), pkg)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dest, "marker"))
+	assert.NoError(t, err)
+	_, err = os.Stat(dest + ".old")
+	assert.True(t, os.IsNotExist(err))
+	// The ETag of the previous version is unknown, so it must not be mistaken
+	// for the current one.
+	state.ReadPackageState(pkg)
+	assert.Equal(t, "", pkg.ETag)
+}
@@ -8,8 +8,11 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/sync/errgroup"
+
 	"github.com/cashapp/hermit/archive"
 	"github.com/cashapp/hermit/cache"
 	"github.com/cashapp/hermit/errors"
@@ -26,10 +29,15 @@ import (
 // DefaultSources if no others are defined.
 var DefaultSources = []string{"https://github.com/cashapp/hermit-packages.git"}
 
+// DefaultDownloadConcurrency is the number of package sources CacheAll
+// downloads at once by default.
+const DefaultDownloadConcurrency = 4
+
 type precompiledAutoMirror struct {
-	re     *regexp.Regexp
-	groups map[string]int
-	mirror string
+	re       *regexp.Regexp
+	groups   map[string]int
+	mirror   string
+	platform []*regexp.Regexp
 }
 
 // AutoMirror defines a dynamically generated mirror URL mapping.
@@ -38,6 +46,10 @@ type AutoMirror struct {
 	Origin string
 	// Mirror URL to add.
 	Mirror string
+	// Platform regexes that must all match against one of <os>, <arch> or
+	// <os>-<arch> of the package, for the mirror to be added. Empty matches
+	// all platforms.
+	Platform []string
 }
 
 // Config for Hermit's global state.
@@ -49,6 +61,12 @@ type Config struct {
 	// Builtin sources.
 	Builtin     *sources.BuiltInSource
 	LockTimeout time.Duration
+	// Index enables an on-disk index of extracted packages and linked
+	// binaries, consulted before the filesystem.
+	Index bool
+	// Number of package sources CacheAll downloads at once, defaulting to
+	// DefaultDownloadConcurrency.
+	DownloadConcurrency int
 }
 
 // State is the global hermit state shared between all local environments
@@ -62,12 +80,17 @@ type State struct {
 	autoMirrors []precompiledAutoMirror
 	cache       *cache.Cache
 	dao         *dao.DAO
+	warnings    []string // Problems opening the state that did not prevent its use.
+	index       *fsIndex
 	lock        string
 	lockTimeout time.Duration
+	exclusive   sync.RWMutex // Held for writing by packages with concurrency = 1.
 }
 
 // Open the global Hermit state.
 //
+// The download cache may be located outside stateDir.
+//
 // See cache.Open for details on downloadStrategies.
 func Open(stateDir string, config Config, cache *cache.Cache) (*State, error) {
 	if config.Builtin == nil {
@@ -75,12 +98,20 @@ func Open(stateDir string, config Config, cache *cache.Cache) (*State, error) {
 	}
 
 	pkgDir := filepath.Join(stateDir, "pkg")
-	cacheDir := filepath.Join(stateDir, "cache")
 	sourcesDir := filepath.Join(stateDir, "sources")
 	binaryDir := filepath.Join(stateDir, "binaries")
-	dao, err := dao.Open(stateDir)
+	var warnings []string
+	db, err := dao.Open(stateDir)
+	if errors.Is(err, dao.ErrCorrupt) {
+		// The metadata can mostly be rebuilt, so a corrupt database shouldn't
+		// lock the user out of Hermit.
+		warnings = append(warnings, fmt.Sprintf("state metadata is corrupt, recreating it: %s", err))
+		db, err = dao.Recreate(stateDir)
+	}
 	if err != nil {
-		return nil, errors.WithStack(err)
+		// Eg. a read-only state directory, which is left untouched.
+		warnings = append(warnings, fmt.Sprintf("could not open state metadata, continuing without saving it: %s", err))
+		db = dao.ReadOnly(stateDir)
 	}
 	if config.Sources == nil {
 		config.Sources = DefaultSources
@@ -91,11 +122,18 @@ func Open(stateDir string, config Config, cache *cache.Cache) (*State, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	index := newFSIndex(nil)
+	if config.Index {
+		index = newFSIndex(db)
+	}
+
 	s := &State{
-		dao:         dao,
+		dao:         db,
+		warnings:    warnings,
+		index:       index,
 		autoMirrors: autoMirrors,
 		root:        stateDir,
-		cacheDir:    cacheDir,
+		cacheDir:    cache.Root(),
 		sourcesDir:  sourcesDir,
 		binaryDir:   binaryDir,
 		config:      config,
@@ -122,6 +160,13 @@ func validateAndCompileAutoMirrors(config Config) ([]precompiledAutoMirror, erro
 		for id, name := range re.SubexpNames() {
 			pam.groups[name] = id
 		}
+		for _, attr := range mirror.Platform {
+			re, err := regexp.Compile(attr)
+			if err != nil {
+				return nil, errors.Errorf("auto-mirror platform %q is not a valid regular expression", attr)
+			}
+			pam.platform = append(pam.platform, re)
+		}
 		os.Expand(mirror.Mirror, func(name string) string {
 			_, ok := pam.groups[name]
 			if !ok {
@@ -146,6 +191,15 @@ func (s *State) Resolve(l *ui.UI, matcher manifest.Selector) (*manifest.Package,
 	return resolver.Resolve(l, matcher)
 }
 
+// Explain how a package reference resolves without an active environment.
+func (s *State) Explain(l *ui.UI, matcher manifest.Selector) (*manifest.Package, []manifest.Provenance, error) {
+	resolver, err := s.resolver(l)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return resolver.Explain(l, matcher)
+}
+
 // Search for packages without an active environment.
 func (s *State) Search(l *ui.UI, glob string) (manifest.Packages, error) {
 	resolver, err := s.resolver(l)
@@ -159,6 +213,16 @@ func (s *State) Search(l *ui.UI, glob string) (manifest.Packages, error) {
 	return pkgs, nil
 }
 
+// WhichProvides finds packages providing the command name without an active
+// environment.
+func (s *State) WhichProvides(l *ui.UI, name string) (manifest.Packages, error) {
+	resolver, err := s.resolver(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return resolver.WhichProvides(name)
+}
+
 // Config returns the configuration stored in the global state.
 func (s *State) Config() Config {
 	return s.config
@@ -169,6 +233,11 @@ func (s *State) SourcesDir() string {
 	return s.sourcesDir
 }
 
+// CacheDir returns the root directory of the download cache
+func (s *State) CacheDir() string {
+	return s.cacheDir
+}
+
 // Root returns the root directory for the hermit state
 func (s *State) Root() string {
 	return s.root
@@ -200,7 +269,7 @@ func (s *State) resolver(l *ui.UI) (*manifest.Resolver, error) {
 
 // Sources associated with the State.
 func (s *State) Sources(l *ui.UI) (*sources.Sources, error) {
-	ss, err := sources.ForURIs(l, s.SourcesDir(), "", s.config.Sources)
+	ss, err := sources.ForURIs(l, s.SourcesDir(), "", s.config.Sources, s.cache.HTTPClient())
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -219,11 +288,17 @@ func (s *State) acquireLock(log ui.Logger, format string, args ...any) (release
 	return
 }
 
+// Warnings returns problems encountered opening the state that did not
+// prevent it from being used, such as corrupt metadata being recreated.
+func (s *State) Warnings() []string {
+	return s.warnings
+}
+
 // ReadPackageState updates the package fields from the global database
 func (s *State) ReadPackageState(pkg *manifest.Package) {
-	if _, err := os.Stat(pkg.Root); err == nil {
+	if s.isExtracted(pkg) {
 		pkg.State = manifest.PackageStateInstalled
-	} else if s.cache.IsCached(pkg.SHA256, pkg.Source) {
+	} else if s.isCached(pkg) {
 		pkg.State = manifest.PackageStateDownloaded
 	}
 	// We are ignoring the error as we might be updating a non exiting package
@@ -282,6 +357,36 @@ func (s *State) removeRecursive(b *ui.Task, dest string) error {
 	return errors.WithStack(os.RemoveAll(dest))
 }
 
+// Trigger an event in a package.
+//
+// Triggers marked "once" are run after the package's other triggers, and only
+// if they have not already successfully run in this state directory.
+func (s *State) Trigger(l ui.Logger, event manifest.Event, p *manifest.Package) (messages []string, err error) {
+	messages, err = p.Trigger(l, event)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, trigger := range p.OnceTriggers[event] {
+		ran, err := s.dao.HasRunTrigger(trigger.Key)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if ran {
+			l.Debugf("Skipping %s, it has already run", trigger.Key)
+			continue
+		}
+		triggerMessages, err := p.RunActions(l, trigger.Actions)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		messages = append(messages, triggerMessages...)
+		if err := s.dao.RecordTriggerRun(trigger.Key); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return messages, nil
+}
+
 // CacheAndUnpack downloads a package and extracts it if it is not present.
 //
 // If the package has already been extracted, this is a no-op
@@ -289,10 +394,12 @@ func (s *State) CacheAndUnpack(b *ui.Task, p *manifest.Package) error {
 	// Double-checked locking. We check without the lock first, and then check
 	// again after acquiring the lock.
 
-	if (s.isExtracted(p) && s.areBinariesLinked(p)) || p.Source == "/" {
+	if s.IsPrepared(p) || p.Source == "/" {
 		return nil
 	}
 
+	defer s.Schedule(p)()
+
 	release, err := s.acquireLock(b, "downloading and extracting %s", p)
 	if err != nil {
 		return errors.WithStack(err)
@@ -300,7 +407,7 @@ func (s *State) CacheAndUnpack(b *ui.Task, p *manifest.Package) error {
 	defer release() //nolint:errcheck
 
 	if !s.isExtracted(p) {
-		if err := s.extract(b, p); err != nil {
+		if err := s.extractOnce(b, p); err != nil {
 			return errors.WithStack(err)
 		}
 	}
@@ -314,6 +421,128 @@ func (s *State) CacheAndUnpack(b *ui.Task, p *manifest.Package) error {
 	return nil
 }
 
+// CacheAll prepares pkgs as CacheAndUnpack does, but first downloads the
+// sources of those that are not cached concurrently.
+//
+// Packages with concurrency = 1 are downloaded on their own once the others
+// have finished. Packages are then extracted one at a time under the lock, in
+// order. Packages sharing a source are only downloaded once.
+func (s *State) CacheAll(b *ui.Task, pkgs []*manifest.Package) error {
+	concurrency := s.config.DownloadConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultDownloadConcurrency
+	}
+	wg := errgroup.Group{}
+	wg.SetLimit(concurrency)
+	var lock sync.Mutex
+	// ETags of the downloaded sources, recorded as CacheAndUnpack would.
+	etags := map[string]string{}
+	downloading := map[string]bool{}
+	download := func(p *manifest.Package) error {
+		_, etag, _, err := s.cache.Download(b, p.SHA256, p.Source, s.mirrors(p)...)
+		if err != nil {
+			return errors.Wrap(err, p.String())
+		}
+		lock.Lock()
+		etags[p.Source] = etag
+		lock.Unlock()
+		return nil
+	}
+	exclusive := []*manifest.Package{}
+	for _, p := range pkgs {
+		if downloading[p.Source] || p.Source == "/" || s.IsPrepared(p) || s.isCached(p) {
+			continue
+		}
+		downloading[p.Source] = true
+		if p.Concurrency == 1 {
+			exclusive = append(exclusive, p)
+			continue
+		}
+		wg.Go(func() error { return download(p) })
+	}
+	if err := wg.Wait(); err != nil {
+		return err
+	}
+	for _, p := range exclusive {
+		if err := download(p); err != nil {
+			return err
+		}
+	}
+	for _, p := range pkgs {
+		if etag, ok := etags[p.Source]; ok {
+			p.ETag = etag
+		}
+		if err := s.CacheAndUnpack(b, p); err != nil {
+			return errors.Wrap(err, p.String())
+		}
+	}
+	return nil
+}
+
+// extractOnce extracts p unless another host sharing the state directory
+// extracted it while we waited for the extraction marker.
+func (s *State) extractOnce(b *ui.Task, p *manifest.Package) error {
+	release, err := s.acquireExtractionMarker(b, p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release()
+	if s.isExtracted(p) {
+		return nil
+	}
+	return errors.WithStack(s.extract(b, p))
+}
+
+// Schedule blocks until p may run its extraction or hooks, returning a
+// function that must be called once they are complete.
+//
+// Packages with concurrency = 1 run exclusively of all other packages, while
+// any other packages may run concurrently with each other.
+func (s *State) Schedule(p *manifest.Package) (done func()) {
+	if p.Concurrency == 1 {
+		s.exclusive.Lock()
+		return s.exclusive.Unlock
+	}
+	s.exclusive.RLock()
+	return s.exclusive.RUnlock
+}
+
+// Cache downloads a package without extracting it.
+//
+// If the package has already been downloaded, this is a no-op.
+func (s *State) Cache(b *ui.Task, p *manifest.Package) error {
+	if s.isCached(p) {
+		return nil
+	}
+	_, _, _, err := s.cache.Download(b, p.SHA256, p.Source, s.mirrors(p)...)
+	return errors.WithStack(err)
+}
+
+// ExtractDetached downloads and extracts p to p.Dest and runs its unpack
+// triggers, without recording the package in the state, so that it can be
+// inspected before being installed.
+//
+// The extracted package is left writable, and is removed by "cleanup".
+func (s *State) ExtractDetached(b *ui.Task, p *manifest.Package) (cleanup func(), err error) {
+	cleanup = func() {
+		if err := s.removeRecursive(b, p.Dest); err != nil {
+			b.Warnf("Failed to remove %s: %s", p.Dest, err)
+		}
+	}
+	if _, err := s.extractArchive(b, p); err != nil {
+		return cleanup, errors.WithStack(err)
+	}
+	for _, file := range p.Files {
+		if err := vfs.CopyFile(file.FS, file.FromPath, file.ToPath); err != nil {
+			return cleanup, errors.WithStack(err)
+		}
+	}
+	if _, err := p.Trigger(b, manifest.EventUnpack); err != nil {
+		return cleanup, errors.WithStack(err)
+	}
+	return cleanup, nil
+}
+
 // CacheAndDigest Utility for Caching all platform artefacts.
 //
 // This method will only cache the values and get a digest.
@@ -321,10 +550,7 @@ func (s *State) CacheAndDigest(b *ui.Task, p *manifest.Package) (string, error)
 	var actualDigest string
 	var err error
 	if !s.isCached(p) {
-		mirrors := make([]string, len(p.Mirrors))
-		copy(mirrors, p.Mirrors)
-		mirrors = append(mirrors, s.generateMirrors(p.Source)...)
-		_, _, actualDigest, err = s.cache.Download(b, p.SHA256, p.Source, mirrors...)
+		_, _, actualDigest, err = s.cache.Download(b, p.SHA256, p.Source, s.mirrors(p)...)
 		if err != nil {
 			return "", errors.WithStack(err)
 		}
@@ -370,55 +596,154 @@ func (s *State) linkBinaries(p *manifest.Package) error {
 			return errors.WithStack(err)
 		}
 	}
-	return nil
+	return errors.WithStack(s.index.markLinked(p.Reference.String(), bins))
 }
 
 func (s *State) extract(b *ui.Task, p *manifest.Package) error {
-	var (
-		path string
-		etag string
-		err  error
-	)
+	finalise, err := s.extractArchive(b, p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// Copy manifest referred files
+	for _, file := range p.Files {
+		err = vfs.CopyFile(file.FS, file.FromPath, file.ToPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if _, err = s.Trigger(b, manifest.EventUnpack, p); err != nil {
+		_ = os.RemoveAll(p.Dest)
+		return errors.WithStack(err)
+	}
+	if err = finalise(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.index.markExtracted(p.Root))
+}
 
+// extractArchive downloads the package source if it is not cached, and
+// extracts it to the package destination.
+func (s *State) extractArchive(b *ui.Task, p *manifest.Package) (finalise func() error, err error) {
+	var path string
 	if !s.isCached(p) {
-		mirrors := make([]string, len(p.Mirrors))
-		copy(mirrors, p.Mirrors)
-		mirrors = append(mirrors, s.generateMirrors(p.Source)...)
-		path, etag, _, err = s.cache.Download(b, p.SHA256, p.Source, mirrors...)
+		var etag string
+		path, etag, _, err = s.cache.Download(b, p.SHA256, p.Source, s.mirrors(p)...)
 		p.ETag = etag
 
-		if err != nil {
-			return errors.WithStack(err)
+		if errors.Is(err, cache.ErrChecksumMismatch) {
+			return nil, errors.WithStack(err)
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "%s: download failed", p)
 		}
 	} else {
 		path = s.cache.Path(p.SHA256, p.Source)
+		// Unlike an archive, a file that isn't extracted is used as is, so
+		// nothing else would notice if the cached copy had been corrupted.
+		if p.DontExtract {
+			if err := s.verifyCachedChecksum(b, path, p); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+	if p.Filename == "" {
+		p.Filename = s.cache.SuggestedFilename(p.SHA256, p.Source)
 	}
+	if err := s.verifyIntegrity(b, path, p); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer ui.LogElapsed(b, "Extract %s", p)()
+	return archive.Extract(b, path, p)
+}
 
-	finalise, err := archive.Extract(b, path, p)
+// verifyCachedChecksum checks a previously downloaded package source against
+// the package's SHA256, evicting it from the cache if it does not match.
+func (s *State) verifyCachedChecksum(b *ui.Task, path string, p *manifest.Package) error {
+	if p.SHA256 == "" {
+		return nil
+	}
+	actual, err := util.Sha256LocalFile(path)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	// Copy manifest referred files
-	for _, file := range p.Files {
-		err = vfs.CopyFile(file.FS, file.FromPath, file.ToPath)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+	if actual == p.SHA256 {
+		return nil
 	}
-	if _, err = p.Trigger(b, manifest.EventUnpack); err != nil {
-		_ = os.RemoveAll(p.Dest)
+	s.index.forgetCached(path)
+	_ = s.cache.Evict(b, p.SHA256, p.Source)
+	return errors.Wrapf(cache.ErrChecksumMismatch, "%s: cached %s has checksum %s but should have %s", p, util.RedactURL(p.Source), actual, p.SHA256)
+}
+
+// verifyIntegrity checks the downloaded package source against the package's
+// integrity digests, evicting it from the cache if none of them match.
+func (s *State) verifyIntegrity(b *ui.Task, path string, p *manifest.Package) error {
+	if p.Integrity == "" {
+		return nil
+	}
+	integrity, err := manifest.ParseIntegrity(p.Integrity)
+	if err != nil {
+		return errors.Wrapf(err, "%s: integrity", p)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = integrity.Verify(f)
+	_ = f.Close()
+	if err != nil {
+		s.index.forgetCached(path)
+		_ = s.cache.Evict(b, p.SHA256, p.Source)
+		return errors.Wrapf(err, "%s: integrity check failed for %s", p, util.RedactURL(p.Source))
+	}
+	return nil
+}
+
+// ResetPackage discards any changes made to an extracted mutable package. The
+// package is extracted again from the cached source the next time it is
+// unpacked, so no pristine copy needs to be kept alongside it.
+//
+// Packages that are not mutable are left untouched.
+func (s *State) ResetPackage(b *ui.Task, p *manifest.Package) error {
+	if !p.Mutable || !s.isExtracted(p) {
+		return nil
+	}
+	release, err := s.acquireLock(b, "resetting %s", p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release() //nolint:errcheck
+
+	return errors.WithStack(s.removePackage(b, p))
+}
+
+// RemovePackage removes an extracted package, leaving its download in the
+// cache.
+func (s *State) RemovePackage(b *ui.Task, p *manifest.Package) error {
+	release, err := s.acquireLock(b, "removing %s", p)
+	if err != nil {
 		return errors.WithStack(err)
 	}
-	return errors.WithStack(finalise())
+	defer release() //nolint:errcheck
+
+	return errors.WithStack(s.removePackage(b, p))
 }
 
 func (s *State) isCached(p *manifest.Package) bool {
-	return s.cache.IsCached(p.SHA256, p.Source)
+	return s.index.isCached(s.cache.Path(p.SHA256, p.Source), func() bool {
+		return s.cache.IsCached(p.SHA256, p.Source)
+	})
+}
+
+// IsPrepared returns true if the package is extracted and its binaries are
+// linked, so installing it into an environment only requires linking it.
+func (s *State) IsPrepared(p *manifest.Package) bool {
+	return s.isExtracted(p) && s.areBinariesLinked(p)
 }
 
 func (s *State) isExtracted(p *manifest.Package) bool {
-	_, err := os.Stat(p.Root)
-	return err == nil
+	return s.index.isExtracted(p.Root, func() bool {
+		_, err := os.Stat(p.Root)
+		return err == nil
+	})
 }
 
 func (s *State) areBinariesLinked(p *manifest.Package) bool {
@@ -426,6 +751,12 @@ func (s *State) areBinariesLinked(p *manifest.Package) bool {
 	if err != nil {
 		return false
 	}
+	return s.index.areBinariesLinked(p.Reference.String(), binaries, func() bool {
+		return s.checkBinariesLinked(p, binaries)
+	})
+}
+
+func (s *State) checkBinariesLinked(p *manifest.Package, binaries []string) bool {
 	for _, bin := range binaries {
 		linkPath := filepath.Join(s.binaryDir, p.Reference.String(), filepath.Base(bin))
 		if _, err := os.Stat(linkPath); err != nil {
@@ -478,7 +809,7 @@ func (s *State) CleanPackages(b *ui.UI) error {
 		}
 	}
 
-	return nil
+	return errors.WithStack(s.index.forgetPackages())
 }
 
 // CleanCache clears the download cache
@@ -489,8 +820,8 @@ func (s *State) CleanCache(b ui.Logger) error {
 	}
 	defer release() //nolint:errcheck
 
-	b.Debugf("rm -rf %q", s.cacheDir)
-	return os.RemoveAll(s.cacheDir)
+	defer s.index.forgetAllCached()
+	return errors.WithStack(s.cache.Clean(b))
 }
 
 // UpgradeChannel checks if the given binary has changed in its channel, and if so, downloads it.
@@ -502,11 +833,12 @@ func (s *State) UpgradeChannel(b *ui.Task, pkg *manifest.Package) error {
 	}
 
 	name := pkg.Reference.String()
-	mirrors := make([]string, len(pkg.Mirrors))
-	copy(mirrors, pkg.Mirrors)
-	mirrors = append(mirrors, s.generateMirrors(pkg.Source)...)
-
-	etag, err := s.cache.ETag(b, pkg.Source, mirrors...)
+	// Schedules are in local time.
+	if pkg.UpdateSchedule != nil && !pkg.UpdateSchedule.Matches(time.Now()) {
+		b.Debugf("Update of %s skipped outside its update schedule %q", name, pkg.UpdateSchedule)
+		return nil
+	}
+	etag, err := s.channelETag(b, pkg)
 	if err != nil {
 		b.Warnf("Could not check updates for %s. Skipping update. Error: %s", name, err)
 	} else if etag == "" {
@@ -536,8 +868,33 @@ func (s *State) UpgradeChannel(b *ui.Task, pkg *manifest.Package) error {
 	return errors.WithStack(s.dao.UpdatePackage(name, dpkg))
 }
 
+// ChannelUpdateAvailable returns true if the source of an installed channel
+// package has changed since it was last fetched, without fetching it.
+func (s *State) ChannelUpdateAvailable(b *ui.Task, pkg *manifest.Package) (bool, error) {
+	if !pkg.Reference.IsChannel() {
+		return false, nil
+	}
+	etag, err := s.channelETag(b, pkg)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return etag != "" && etag != pkg.ETag, nil
+}
+
+func (s *State) channelETag(b *ui.Task, pkg *manifest.Package) (string, error) {
+	mirrors := make([]string, len(pkg.Mirrors))
+	copy(mirrors, pkg.Mirrors)
+	mirrors = append(mirrors, s.generateMirrors(pkg)...)
+	etag, err := s.cache.ETag(b, pkg.Source, mirrors...)
+	return etag, errors.WithStack(err)
+}
+
 func (s *State) removePackage(task *ui.Task, pkg *manifest.Package) error {
-	err := s.removeRecursive(task, filepath.Join(s.binaryDir, pkg.Reference.String()))
+	err := s.index.forgetPackage(pkg.Root, pkg.Reference.String())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = s.removeRecursive(task, filepath.Join(s.binaryDir, pkg.Reference.String()))
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -549,6 +906,19 @@ func (s *State) removePackage(task *ui.Task, pkg *manifest.Package) error {
 	return nil
 }
 
+// EvictCached removes the downloaded artefact of a package from the cache,
+// leaving any extracted package in place.
+func (s *State) EvictCached(b *ui.Task, pkg *manifest.Package) error {
+	release, err := s.acquireLock(b, "evicting cached %s", pkg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release() //nolint:errcheck
+
+	s.index.forgetCached(s.cache.Path(pkg.SHA256, pkg.Source))
+	return errors.WithStack(s.cache.Evict(b, pkg.SHA256, pkg.Source))
+}
+
 func (s *State) evictPackage(b *ui.Task, pkg *manifest.Package) error {
 	release, err := s.acquireLock(b, "evicting package %s", pkg)
 	if err != nil {
@@ -556,6 +926,7 @@ func (s *State) evictPackage(b *ui.Task, pkg *manifest.Package) error {
 	}
 	defer release() //nolint:errcheck
 
+	s.index.forgetCached(s.cache.Path(pkg.SHA256, pkg.Source))
 	if err := s.cache.Evict(b, pkg.SHA256, pkg.Source); err != nil {
 		return errors.WithStack(err)
 	}
@@ -565,10 +936,20 @@ func (s *State) evictPackage(b *ui.Task, pkg *manifest.Package) error {
 	return nil
 }
 
-// Return the generated mirrors that match URL.
-func (s *State) generateMirrors(url string) (mirrors []string) {
+// mirrors returns the package's mirrors followed by its generated mirrors.
+func (s *State) mirrors(p *manifest.Package) []string {
+	mirrors := make([]string, len(p.Mirrors))
+	copy(mirrors, p.Mirrors)
+	return append(mirrors, s.generateMirrors(p)...)
+}
+
+// Return the generated mirrors that match the package's source URL and platform.
+func (s *State) generateMirrors(p *manifest.Package) (mirrors []string) {
 	for _, pam := range s.autoMirrors {
-		matches := pam.re.FindStringSubmatch(url)
+		if !pam.matchesPlatform(p.Platform) {
+			continue
+		}
+		matches := pam.re.FindStringSubmatch(p.Source)
 		if matches == nil {
 			continue
 		}
@@ -579,3 +960,16 @@ func (s *State) generateMirrors(url string) (mirrors []string) {
 	}
 	return
 }
+
+func (pam precompiledAutoMirror) matchesPlatform(p platform.Platform) bool {
+	if p == (platform.Platform{}) {
+		p = platform.Host
+	}
+	osArch := p.OS + "-" + p.Arch
+	for _, re := range pam.platform {
+		if !re.MatchString(p.OS) && !re.MatchString(p.Arch) && !re.MatchString(osArch) {
+			return false
+		}
+	}
+	return true
+}
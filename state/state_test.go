@@ -1,15 +1,27 @@
 package state_test
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/cashapp/hermit/cache"
+	"github.com/cashapp/hermit/errors"
 	"github.com/cashapp/hermit/manifest"
 	"github.com/cashapp/hermit/manifest/manifesttest"
+	"github.com/cashapp/hermit/platform"
+	"github.com/cashapp/hermit/state"
 	"github.com/cashapp/hermit/ui"
 )
 
@@ -41,6 +53,64 @@ func TestCacheAndUnpackDownloadsOnlyWhenNeeded(t *testing.T) {
 	assert.Equal(t, 1, calls)
 }
 
+func TestCacheAllDownloadsConcurrently(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		inflight int
+		peak     int
+		calls    = map[string]int{}
+	)
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			calls[r.URL.Path]++
+			inflight++
+			peak = max(peak, inflight)
+			lock.Unlock()
+			// Give the other downloads a chance to start.
+			for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				lock.Lock()
+				done := peak == 3
+				lock.Unlock()
+				if done {
+					break
+				}
+			}
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+			lock.Lock()
+			inflight--
+			lock.Unlock()
+		}))
+	defer fixture.Clean()
+	state := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	var pkgs []*manifest.Package
+	for _, pkg := range []struct{ name, source string }{
+		{"a", "/shared.tar.gz"},
+		{"b", "/shared.tar.gz"},
+		{"c", "/c.tar.gz"},
+		{"d", "/d.tar.gz"},
+	} {
+		pkgs = append(pkgs, manifesttest.NewPkgBuilder(filepath.Join(state.PkgDir(), pkg.name)).
+			WithName(pkg.name).
+			WithSource(fixture.Server.URL+pkg.source).
+			Result())
+	}
+
+	err := state.CacheAll(log.Task("test"), pkgs)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"/shared.tar.gz": 1, "/c.tar.gz": 1, "/d.tar.gz": 1}, calls)
+	assert.Equal(t, 3, peak)
+	for _, pkg := range pkgs {
+		assert.True(t, state.IsPrepared(pkg), pkg.String())
+	}
+}
+
 func TestCacheAndUnpackHooksRunOnMutablePackage(t *testing.T) {
 	fixture := NewStateTestFixture(t).
 		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -178,3 +248,532 @@ func TestUpdateSymlinks(t *testing.T) {
 	assert.Equal(t, filepath.Join(newPkg.Dest, "linux_exe"), linuxLink)
 
 }
+
+func TestCacheAndUnpackUpdatesIndex(t *testing.T) {
+	fixture := NewStateTestFixture(t).
+		WithIndex().
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+		}))
+	defer fixture.Clean()
+	state := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	pkg := manifesttest.NewPkgBuilder(filepath.Join(state.PkgDir(), "test")).
+		WithBinaries("darwin_exe").
+		WithSource(fixture.Server.URL).Result()
+
+	err := state.CacheAndUnpack(log.Task("test"), pkg)
+	assert.NoError(t, err)
+
+	// A fresh state should find the package in the on-disk index.
+	other := fixture.WithRoot(state.Root()).State()
+	pkg.State = manifest.PackageStateRemote
+	other.ReadPackageState(pkg)
+	assert.Equal(t, manifest.PackageStateInstalled, pkg.State)
+
+	err = other.CleanPackages(log)
+	assert.NoError(t, err)
+
+	pkg.State = manifest.PackageStateRemote
+	state = fixture.State()
+	state.ReadPackageState(pkg)
+	assert.Equal(t, manifest.PackageStateDownloaded, pkg.State)
+}
+
+func TestResetMutablePackageReextractsFromCache(t *testing.T) {
+	calls := 0
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+			calls++
+		}))
+	defer fixture.Clean()
+	state := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	dest := filepath.Join(state.PkgDir(), "test")
+	pkg := manifesttest.NewPkgBuilder(dest).
+		WithBinaries("darwin_exe").
+		WithMutable().
+		WithSource(fixture.Server.URL).
+		Result()
+
+	err := state.CacheAndUnpack(log.Task("test"), pkg)
+	assert.NoError(t, err)
+
+	// The package is writable, so can be mutated.
+	err = os.WriteFile(filepath.Join(dest, "file"), []byte("mutated"), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dest, "new"), []byte("new"), 0600)
+	assert.NoError(t, err)
+
+	// Resetting discards mutations by extracting the cached archive again.
+	err = state.ResetPackage(log.Task("test"), pkg)
+	assert.NoError(t, err)
+	err = state.CacheAndUnpack(log.Task("test"), pkg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	content, err := os.ReadFile(filepath.Join(dest, "file"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, "mutated", string(content))
+	_, err = os.Stat(filepath.Join(dest, "new"))
+	assert.True(t, os.IsNotExist(err))
+	// No pristine copy of the package is kept.
+	_, err = os.Stat(filepath.Join(state.Root(), "base"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestScheduleSerialisesExclusivePackages(t *testing.T) {
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+		}))
+	defer fixture.Clean()
+	state := fixture.State()
+	log, _ := ui.NewForTesting()
+
+	shared := manifesttest.NewPkgBuilder(state.PkgDir()).WithName("shared").Result()
+	exclusive := manifesttest.NewPkgBuilder(state.PkgDir()).WithName("exclusive").WithSource(fixture.Server.URL).Result()
+	exclusive.Concurrency = 1
+
+	// Packages without a concurrency limit may run together.
+	doneA := state.Schedule(shared)
+	doneB := state.Schedule(shared)
+
+	extracted := make(chan struct{})
+	go func() {
+		assert.NoError(t, state.CacheAndUnpack(log.Task("exclusive"), exclusive))
+		close(extracted)
+	}()
+	select {
+	case <-extracted:
+		t.Fatal("exclusive package extracted while others were running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	doneA()
+	doneB()
+	select {
+	case <-extracted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exclusive package was never extracted")
+	}
+}
+
+func TestCacheAllDownloadsExclusivePackagesAlone(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		inflight int
+		overlap  = map[string]int{}
+	)
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			inflight++
+			overlap[r.URL.Path] = max(overlap[r.URL.Path], inflight)
+			lock.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+			lock.Lock()
+			inflight--
+			lock.Unlock()
+		}))
+	defer fixture.Clean()
+	state := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	var pkgs []*manifest.Package
+	for _, name := range []string{"a", "exclusive", "b"} {
+		pkg := manifesttest.NewPkgBuilder(filepath.Join(state.PkgDir(), name)).
+			WithName(name).
+			WithSource(fixture.Server.URL + "/" + name + ".tar.gz").
+			Result()
+		if name == "exclusive" {
+			pkg.Concurrency = 1
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	err := state.CacheAll(log.Task("test"), pkgs)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, overlap["/exclusive.tar.gz"])
+	for _, pkg := range pkgs {
+		assert.True(t, state.IsPrepared(pkg), pkg.String())
+	}
+}
+
+func TestAutoMirrorsMatchPackagePlatform(t *testing.T) {
+	requested := []string{}
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.Path)
+			if !strings.HasPrefix(r.URL.Path, "/arm64/") {
+				http.NotFound(w, r)
+				return
+			}
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+		})).
+		WithAutoMirrors(
+			state.AutoMirror{
+				Origin:   `^(?P<base>.*)/origin/(?P<file>.*)$`,
+				Mirror:   "${base}/amd64/${file}",
+				Platform: []string{"amd64"},
+			},
+			state.AutoMirror{
+				Origin:   `^(?P<base>.*)/origin/(?P<file>.*)$`,
+				Mirror:   "${base}/arm64/${file}",
+				Platform: []string{"linux", "arm64"},
+			},
+		)
+	defer fixture.Clean()
+	sta := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	pkg := manifesttest.NewPkgBuilder(sta.PkgDir()).WithSource(fixture.Server.URL + "/origin/archive.tar.gz").Result()
+	pkg.Platform = platform.Platform{OS: platform.Linux, Arch: platform.Arm64}
+
+	err := sta.CacheAndUnpack(log.Task("test"), pkg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/origin/archive.tar.gz", "/arm64/archive.tar.gz"}, requested)
+}
+
+func TestCacheDirOutsideStateDir(t *testing.T) {
+	calls := 0
+	cacheDir := t.TempDir()
+	fixture := NewStateTestFixture(t).
+		WithCacheDir(cacheDir).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+			calls++
+		}))
+	defer fixture.Clean()
+	sta := fixture.State()
+	assert.Equal(t, cacheDir, sta.CacheDir())
+
+	log, out := ui.NewForTesting()
+	pkg := manifesttest.NewPkgBuilder(sta.PkgDir()).WithSource(fixture.Server.URL).Result()
+	assert.NoError(t, sta.Cache(log.Task("test"), pkg))
+	entries, err := os.ReadDir(cacheDir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, len(entries))
+	_, err = os.Stat(filepath.Join(sta.Root(), "cache"))
+	assert.True(t, os.IsNotExist(err))
+
+	// The archive is found in the relocated cache, and extracted into the state dir.
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	assert.Equal(t, 1, calls)
+	_, err = os.Stat(pkg.Root)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(pkg.Root, sta.PkgDir()))
+
+	// Cleaning only removes, and logs, Hermit's own entries from a shared directory.
+	entries, err = os.ReadDir(cacheDir)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(cacheDir, "unrelated"), []byte("keep"), 0600))
+	out.Reset()
+	assert.NoError(t, sta.CleanCache(log.Task("test")))
+	for _, entry := range entries {
+		assert.Contains(t, out.String(), fmt.Sprintf("rm -rf %q", filepath.Join(cacheDir, entry.Name())))
+	}
+	assert.NotContains(t, out.String(), fmt.Sprintf("rm -rf %q", cacheDir))
+	assert.NotContains(t, out.String(), "unrelated")
+	entries, err = os.ReadDir(cacheDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "unrelated", entries[0].Name())
+}
+
+func TestSingleFileNamedFromContentDisposition(t *testing.T) {
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Disposition", `attachment; filename="../tool"`)
+			_, err := w.Write([]byte("#!/bin/sh\necho hello\n"))
+			assert.NoError(t, err)
+		}))
+	defer fixture.Clean()
+	sta := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	pkg := manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "tool")).
+		WithName("tool").
+		WithBinaries("tool").
+		WithSource(fixture.Server.URL + "/download?id=1").
+		Result()
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	_, err := os.Stat(filepath.Join(pkg.Root, "tool"))
+	assert.NoError(t, err)
+
+	// An explicit filename in the manifest takes precedence.
+	pkg = manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "other")).
+		WithName("other").
+		WithBinaries("other").
+		WithSource(fixture.Server.URL + "/download?id=2").
+		Result()
+	pkg.Filename = "other"
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	_, err = os.Stat(filepath.Join(pkg.Root, "other"))
+	assert.NoError(t, err)
+}
+
+func TestCacheAndUnpackVerifiesIntegrity(t *testing.T) {
+	content := []byte("#!/bin/sh\necho hello\n")
+	calls := 0
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			_, err := w.Write(content)
+			assert.NoError(t, err)
+		}))
+	defer fixture.Clean()
+	sta := fixture.State()
+	sum := sha512.Sum512(content)
+
+	log, _ := ui.NewForTesting()
+	pkg := manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "bad")).
+		WithName("bad").
+		WithBinaries("tool").
+		WithSource(fixture.Server.URL + "/bad").
+		Result()
+	pkg.Filename = "tool"
+	pkg.Integrity = "sha512-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+	err := sta.CacheAndUnpack(log.Task("test"), pkg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "integrity")
+
+	// The mismatched download is evicted rather than reused.
+	assert.Error(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	assert.Equal(t, 2, calls)
+
+	pkg = manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "good")).
+		WithName("good").
+		WithBinaries("tool").
+		WithSource(fixture.Server.URL + "/good").
+		Result()
+	pkg.Filename = "tool"
+	pkg.Integrity = "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	_, err = os.Stat(filepath.Join(pkg.Root, "tool"))
+	assert.NoError(t, err)
+
+	// Any of several space separated digests may match.
+	pkg = manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "multi")).
+		WithName("multi").
+		WithBinaries("tool").
+		WithSource(fixture.Server.URL + "/multi").
+		Result()
+	pkg.Filename = "tool"
+	pkg.Integrity = "sha512-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size)) +
+		" sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	_, err = os.Stat(filepath.Join(pkg.Root, "tool"))
+	assert.NoError(t, err)
+}
+
+func TestCacheAndUnpackVerifiesUnextractedChecksum(t *testing.T) {
+	content := []byte("#!/bin/sh\n")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	fixture := NewStateTestFixture(t).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/good/tool":
+				_, _ = w.Write(content)
+			case "/bad/tool":
+				_, _ = w.Write([]byte("#!/bin/sh\nrm -rf /\n"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	defer fixture.Clean()
+	state := fixture.State()
+	log, _ := ui.NewForTesting()
+	newPkg := func(name, source string) *manifest.Package {
+		pkg := manifesttest.NewPkgBuilder(filepath.Join(state.PkgDir(), name)).
+			WithName(name).
+			WithBinaries("tool").
+			WithSource(fixture.Server.URL + source).
+			Result()
+		pkg.DontExtract = true
+		pkg.SHA256 = checksum
+		return pkg
+	}
+
+	err := state.CacheAndUnpack(log.Task("test"), newPkg("bad", "/bad/tool"))
+	assert.True(t, errors.Is(err, cache.ErrChecksumMismatch), "%v", err)
+
+	err = state.CacheAndUnpack(log.Task("test"), newPkg("missing", "/missing/tool"))
+	assert.False(t, errors.Is(err, cache.ErrChecksumMismatch))
+	assert.Contains(t, err.Error(), "download failed")
+
+	// A corrupted copy in the cache is not used.
+	pkg := newPkg("corrupt", "/good/tool")
+	path := filepath.Join(state.CacheDir(), cache.BasePath(pkg.SHA256, pkg.Source))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, os.WriteFile(path, []byte("corrupt"), 0600))
+	err = state.CacheAndUnpack(log.Task("test"), pkg)
+	assert.True(t, errors.Is(err, cache.ErrChecksumMismatch), "%v", err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	err = state.CacheAndUnpack(log.Task("test"), pkg)
+	assert.NoError(t, err)
+	installed, err := os.ReadFile(filepath.Join(pkg.Root, "tool"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, installed)
+}
+
+func TestCacheAndUnpackWaitsForExtractionMarker(t *testing.T) {
+	fixture := NewStateTestFixture(t).
+		WithLockTimeout(10 * time.Second).
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+		}))
+	defer fixture.Clean()
+	sta := fixture.State()
+
+	log, _ := ui.NewForTesting()
+	pkg := manifesttest.NewPkgBuilder(sta.PkgDir()).WithSource(fixture.Server.URL).Result()
+	marker := pkg.Dest + ".extracting"
+
+	// Another host is extracting the package.
+	assert.NoError(t, os.MkdirAll(filepath.Dir(marker), 0700))
+	assert.NoError(t, os.WriteFile(marker, []byte("other:1\n"), 0600))
+	start := time.Now()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = os.Remove(marker)
+	}()
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "extraction should wait for the marker")
+	_, err := os.Stat(marker)
+	assert.True(t, os.IsNotExist(err), "marker should be removed after extraction")
+
+	// A stale marker left by a dead process is removed.
+	pkg = manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "stale")).WithSource(fixture.Server.URL).Result()
+	marker = pkg.Dest + ".extracting"
+	assert.NoError(t, os.WriteFile(marker, []byte("other:1\n"), 0600))
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(marker, old, old))
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	_, err = os.Stat(filepath.Join(pkg.Root, "darwin_exe"))
+	assert.NoError(t, err)
+
+	// A stale marker another process is already taking over is left to it.
+	pkg = manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "takeover")).WithSource(fixture.Server.URL).Result()
+	marker = pkg.Dest + ".extracting"
+	owner := []byte("other:1:1\n")
+	assert.NoError(t, os.WriteFile(marker, owner, 0600))
+	assert.NoError(t, os.Chtimes(marker, old, old))
+	sum := sha256.Sum256(owner)
+	takeover := fmt.Sprintf("%s.%x.takeover", marker, sum[:8])
+	assert.NoError(t, os.WriteFile(takeover, nil, 0600))
+	start = time.Now()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = os.Remove(marker)
+		_ = os.Remove(takeover)
+	}()
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "extraction should wait for the takeover")
+}
+
+func TestOpenRecreatesCorruptMetadata(t *testing.T) {
+	root := t.TempDir()
+	// A file where the metadata directory should be, eg. after a bad write.
+	err := os.WriteFile(filepath.Join(root, "metadata"), []byte("garbage"), 0600)
+	assert.NoError(t, err)
+
+	fixture := NewStateTestFixture(t).WithRoot(root)
+	defer fixture.Clean()
+	sta := fixture.State()
+	assert.Equal(t, 1, len(sta.Warnings()))
+	assert.Contains(t, sta.Warnings()[0], "state metadata is corrupt, recreating it")
+
+	info, err := os.Stat(filepath.Join(root, "metadata"))
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	corrupt, err := os.ReadFile(filepath.Join(root, "metadata.corrupt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "garbage", string(corrupt))
+
+	pkg := manifesttest.NewPkgBuilder(sta.PkgDir()).WithName("pkg").Result()
+	pkg.ETag = "etag"
+	assert.NoError(t, sta.WritePackageState(pkg))
+}
+
+func TestMigrate(t *testing.T) {
+	fixture := NewStateTestFixture(t).
+		WithIndex().
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fr, err := os.Open("../archive/testdata/archive.tar.gz")
+			assert.NoError(t, err)
+			defer fr.Close() // nolint
+			_, err = io.Copy(w, fr)
+			assert.NoError(t, err)
+		}))
+	defer fixture.Clean()
+	sta := fixture.State()
+	oldRoot := sta.Root()
+
+	log, _ := ui.NewForTesting()
+	pkg := manifesttest.NewPkgBuilder(filepath.Join(sta.PkgDir(), "test")).
+		WithSource(fixture.Server.URL).Result()
+	assert.NoError(t, sta.CacheAndUnpack(log.Task("test"), pkg))
+	// Add an absolute link into the read-only package.
+	assert.NoError(t, os.Chmod(pkg.Root, 0700))
+	assert.NoError(t, os.Symlink(filepath.Join(pkg.Root, "darwin_exe"), filepath.Join(pkg.Root, "exe")))
+	assert.NoError(t, os.Chmod(pkg.Root, 0500))
+
+	newRoot := filepath.Join(t.TempDir(), "state")
+	assert.NoError(t, sta.Migrate(log, newRoot))
+
+	entries, err := os.ReadDir(oldRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries), "only the lock should remain")
+
+	migrated := fixture.WithRoot(newRoot).State()
+	newPkgRoot := filepath.Join(migrated.PkgDir(), "test")
+	link, err := os.Readlink(filepath.Join(newPkgRoot, "exe"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(newPkgRoot, "darwin_exe"), link)
+	link, err = os.Readlink(filepath.Join(migrated.BinaryDir(), pkg.Reference.String(), "darwin_exe"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(newPkgRoot, "darwin_exe"), link)
+
+	// The index and cache are found in the new state.
+	moved := manifesttest.NewPkgBuilder(newPkgRoot).WithSource(fixture.Server.URL).Result()
+	migrated.ReadPackageState(moved)
+	assert.Equal(t, manifest.PackageStateInstalled, moved.State)
+	assert.True(t, migrated.IsPrepared(moved))
+
+	assert.Error(t, migrated.Migrate(log, filepath.Join(newRoot, "pkg")))
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
 	"github.com/cashapp/hermit/cache"
@@ -21,8 +22,12 @@ type StateTestFixture struct {
 
 	ui      *ui.UI
 	root    string
+	cache   string
 	handler http.Handler
 	roots   map[string]bool
+	index   bool
+	mirrors []state.AutoMirror
+	timeout time.Duration
 	t       *testing.T
 }
 
@@ -64,15 +69,47 @@ func (f *StateTestFixture) State() *state.State {
 	}
 	f.roots[root] = true
 	client := f.Server.Client()
-	cache, err := cache.Open(root, nil, client, client)
+	open := func() (*cache.Cache, error) { return cache.Open(root, nil, client, client) }
+	if f.cache != "" {
+		open = func() (*cache.Cache, error) { return cache.OpenDir(f.cache, nil, client, client) }
+	}
+	c, err := open()
 	assert.NoError(f.t, err)
 	sta, err := state.Open(root, state.Config{
-		Builtin: sources.NewBuiltInSource(vfs.InMemoryFS(nil)),
-	}, cache)
+		Builtin:     sources.NewBuiltInSource(vfs.InMemoryFS(nil)),
+		Index:       f.index,
+		AutoMirrors: f.mirrors,
+		LockTimeout: f.timeout,
+	}, c)
 	assert.NoError(f.t, err)
 	return sta
 }
 
+func (f *StateTestFixture) WithIndex() *StateTestFixture {
+	f.index = true
+	return f
+}
+
+func (f *StateTestFixture) WithAutoMirrors(mirrors ...state.AutoMirror) *StateTestFixture {
+	f.mirrors = mirrors
+	return f
+}
+
+func (f *StateTestFixture) WithLockTimeout(timeout time.Duration) *StateTestFixture {
+	f.timeout = timeout
+	return f
+}
+
+func (f *StateTestFixture) WithRoot(root string) *StateTestFixture {
+	f.root = root
+	return f
+}
+
+func (f *StateTestFixture) WithCacheDir(dir string) *StateTestFixture {
+	f.cache = dir
+	return f
+}
+
 func (f *StateTestFixture) WithHTTPHandler(handler http.Handler) *StateTestFixture {
 	f.handler = handler
 	return f
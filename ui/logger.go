@@ -2,6 +2,7 @@ package ui
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"regexp"
@@ -90,14 +91,31 @@ type Logger interface {
 // LogElapsed logs the duration of a function call. Use with defer:
 //
 //	defer LogElapsed(log, "something")()
+//
+// The call is also recorded in the Timeline of the UI, if any.
 func LogElapsed(log Logger, message string, args ...interface{}) func() {
 	start := time.Now()
 	return func() {
-		args = append(args, time.Since(start))
+		end := time.Now()
+		if timeline := timelineOf(log); timeline != nil {
+			timeline.record(fmt.Sprintf(message, args...), start, end)
+		}
+		args = append(args, end.Sub(start))
 		log.Tracef(message+" (%s elapsed)", args...)
 	}
 }
 
+func timelineOf(log Logger) *Timeline {
+	switch log := log.(type) {
+	case *UI:
+		return log.Timeline()
+	case *Task:
+		return log.w.Timeline()
+	default:
+		return nil
+	}
+}
+
 type logWriter struct {
 	lock  sync.Mutex
 	level Level
@@ -0,0 +1,104 @@
+package ui
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// Timeline records the operations timed with LogElapsed, for writing as a
+// Chrome trace.
+type Timeline struct {
+	lock   sync.Mutex
+	start  time.Time
+	events []timelineEvent
+}
+
+type timelineEvent struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// NewTimeline creates a new Timeline starting now.
+func NewTimeline() *Timeline {
+	return &Timeline{start: time.Now()}
+}
+
+func (t *Timeline) record(name string, start, end time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, timelineEvent{name: name, start: start, end: end})
+}
+
+// chromeTraceEvent is a complete ("X") event in the Chrome Trace Event
+// Format, with times in microseconds.
+type chromeTraceEvent struct {
+	Name  string `json:"name"`
+	Phase string `json:"ph"`
+	TS    int64  `json:"ts"`
+	Dur   int64  `json:"dur"`
+	PID   int    `json:"pid"`
+	TID   int    `json:"tid"`
+}
+
+// WriteChromeTrace writes the recorded operations to w in the Chrome trace
+// event format, viewable in chrome://tracing or https://ui.perfetto.dev.
+//
+// Operations that overlap without one containing the other, such as
+// concurrent downloads, are placed on separate threads.
+func (t *Timeline) WriteChromeTrace(w io.Writer) error {
+	t.lock.Lock()
+	events := make([]timelineEvent, len(t.events))
+	copy(events, t.events)
+	t.lock.Unlock()
+	// Outer operations first, so that inner operations nest within them.
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].start.Equal(events[j].start) {
+			return events[i].end.After(events[j].end)
+		}
+		return events[i].start.Before(events[j].start)
+	})
+	var lanes [][]timelineEvent
+	trace := struct {
+		TraceEvents []chromeTraceEvent `json:"traceEvents"`
+	}{TraceEvents: []chromeTraceEvent{}}
+	for _, event := range events {
+		lane := 0
+		for ; lane < len(lanes); lane++ {
+			if fitsLane(lanes[lane], event) {
+				break
+			}
+		}
+		if lane == len(lanes) {
+			lanes = append(lanes, nil)
+		}
+		lanes[lane] = append(lanes[lane], event)
+		trace.TraceEvents = append(trace.TraceEvents, chromeTraceEvent{
+			Name:  event.name,
+			Phase: "X",
+			TS:    event.start.Sub(t.start).Microseconds(),
+			Dur:   event.end.Sub(event.start).Microseconds(),
+			PID:   1,
+			TID:   lane + 1,
+		})
+	}
+	return errors.WithStack(json.NewEncoder(w).Encode(trace))
+}
+
+// fitsLane returns true if event either contains, is contained by, or does
+// not overlap each of the events in lane.
+func fitsLane(lane []timelineEvent, event timelineEvent) bool {
+	for _, other := range lane {
+		disjoint := !event.start.Before(other.end) || !other.start.Before(event.end)
+		nested := !event.start.Before(other.start) && !event.end.After(other.end)
+		if !disjoint && !nested {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestTimelineWriteChromeTrace(t *testing.T) {
+	timeline := NewTimeline()
+	at := func(ms int) time.Time { return timeline.start.Add(time.Duration(ms) * time.Millisecond) }
+	timeline.record("install", at(0), at(100))
+	timeline.record("download a", at(10), at(50))
+	timeline.record("download b", at(20), at(60))
+	timeline.record("link", at(70), at(80))
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, timeline.WriteChromeTrace(buf))
+	var trace struct {
+		TraceEvents []chromeTraceEvent `json:"traceEvents"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &trace))
+	assert.Equal(t, []chromeTraceEvent{
+		{Name: "install", Phase: "X", TS: 0, Dur: 100000, PID: 1, TID: 1},
+		{Name: "download a", Phase: "X", TS: 10000, Dur: 40000, PID: 1, TID: 1},
+		// Overlaps "download a" without nesting, so is on another thread.
+		{Name: "download b", Phase: "X", TS: 20000, Dur: 40000, PID: 1, TID: 2},
+		{Name: "link", Phase: "X", TS: 70000, Dur: 10000, PID: 1, TID: 1},
+	}, trace.TraceEvents)
+}
+
+func TestLogElapsedRecordsToTimeline(t *testing.T) {
+	p, _ := NewForTesting()
+	timeline := NewTimeline()
+	p.SetTimeline(timeline)
+	LogElapsed(p.Task("test"), "Download %s", "pkg")()
+	assert.Equal(t, 1, len(timeline.events))
+	assert.Equal(t, "Download pkg", timeline.events[0].name)
+}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -52,6 +53,8 @@ type UI struct {
 	state              uint64
 	minlevel           Level
 	progressBarEnabled bool
+	stdin              io.Reader // Source of answers to interactive prompts, nil if non-interactive.
+	timeline           *Timeline // Records operations timed with LogElapsed, if not nil.
 }
 
 var _ Logger = &UI{}
@@ -109,6 +112,36 @@ func (w *UI) SetProgressBarEnabled(enabled bool) {
 	w.progressBarEnabled = enabled
 }
 
+// SetStdin sets the reader that interactive prompts such as Choose read from.
+//
+// A nil reader, the default, marks the UI as non-interactive.
+func (w *UI) SetStdin(stdin io.Reader) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stdin = stdin
+}
+
+// SetTimeline sets the Timeline that operations timed with LogElapsed are recorded to.
+func (w *UI) SetTimeline(timeline *Timeline) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.timeline = timeline
+}
+
+// Timeline returns the Timeline set with SetTimeline, or nil.
+func (w *UI) Timeline() *Timeline {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.timeline
+}
+
+// Interactive returns true if the user can be prompted to make a choice.
+func (w *UI) Interactive() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.stdin != nil
+}
+
 // WillLog returns true if "level" will be logged.
 func (w *UI) WillLog(level Level) bool {
 	w.lock.Lock()
@@ -344,6 +377,58 @@ func (w *UI) Confirmation(message string, args ...interface{}) (bool, error) {
 	return s == "y" || s == "yes", nil
 }
 
+// Choose prompts the user to pick one of options from a numbered menu,
+// returning the index of the chosen option.
+//
+// The prompt is repeated until a valid option is chosen. An error is returned
+// if the UI is not interactive.
+func (w *UI) Choose(message string, options []string) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stdin == nil {
+		return 0, errors.Errorf("can't prompt for a choice in a non-interactive session")
+	}
+	fmt.Fprintf(w.stdout, "hermit: %s\n", message)
+	for i, option := range options {
+		fmt.Fprintf(w.stdout, "  %d) %s\n", i+1, option)
+	}
+	for {
+		fmt.Fprintf(w.stdout, "hermit: enter a number [1-%d]: ", len(options))
+		_ = w.stdout.Sync()
+		s, err := readLine(w.stdin)
+		if err != nil {
+			return 0, errors.Wrap(err, "no choice made")
+		}
+		n, err := strconv.Atoi(s)
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		fmt.Fprintf(w.stdout, "hermit: %q is not a valid choice\n", s)
+	}
+}
+
+// readLine reads a single line from r without buffering past its end, so r
+// can be shared with later prompts.
+func readLine(r io.Reader) (string, error) {
+	line := []byte{}
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			break
+		} else if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
 // Sync flushes IO to stdout and stderr.
 func (w *UI) Sync() error {
 	w.lock.Lock()
@@ -0,0 +1,189 @@
+// Package jsonschema converts HCL schemas to JSON Schema, for editors and
+// linters that validate HCL files against their JSON equivalent.
+package jsonschema
+
+import (
+	"strings"
+
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit/errors"
+)
+
+// Draft is the JSON Schema dialect emitted.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document, or a subschema within one.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Default              any                `json:"default,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+}
+
+// FromHCL converts a schema produced by hcl.Schema to JSON Schema.
+//
+// Blocks are objects keyed by block name, with one level of nesting per label.
+// Repeated blocks are arrays of those objects.
+func FromHCL(title string, schema *hcl.AST) (*Schema, error) {
+	out, err := fromEntries(schema.Entries)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	out.Schema = Draft
+	out.Title = title
+	return out, nil
+}
+
+func fromEntries(entries []*hcl.Entry) (*Schema, error) {
+	out := &Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: false,
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.RecursiveSchema:
+			// The recursion is already described by the enclosing block, so
+			// don't constrain it further.
+			if entry.Block != nil {
+				out.Properties[entry.Block.Name] = &Schema{}
+			}
+
+		case entry.Attribute != nil:
+			attr := entry.Attribute
+			prop, err := fromAttribute(attr)
+			if err != nil {
+				return nil, errors.Wrap(err, attr.Key)
+			}
+			out.Properties[attr.Key] = prop
+			if !attr.Optional && attr.Default == nil {
+				out.Required = append(out.Required, attr.Key)
+			}
+
+		case entry.Block != nil:
+			block := entry.Block
+			prop, err := fromBlock(block)
+			if err != nil {
+				return nil, errors.Wrap(err, block.Name)
+			}
+			out.Properties[block.Name] = prop
+		}
+	}
+	return out, nil
+}
+
+func fromBlock(block *hcl.Block) (*Schema, error) {
+	out, err := fromEntries(block.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i := len(block.Labels) - 1; i >= 0; i-- {
+		out = &Schema{
+			Type:                 "object",
+			AdditionalProperties: out,
+		}
+	}
+	if block.Repeated {
+		out = &Schema{Type: "array", Items: out}
+	}
+	out.Description = strings.Join(block.Comments, "\n")
+	return out, nil
+}
+
+func fromAttribute(attr *hcl.Attribute) (*Schema, error) {
+	out, err := fromType(attr.Value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	out.Description = strings.Join(attr.Comments, "\n")
+	if attr.Default != nil {
+		out.Default = fromValue(attr.Default)
+	}
+	for _, value := range attr.Enum {
+		out.Enum = append(out.Enum, fromValue(value))
+	}
+	return out, nil
+}
+
+// fromType converts a type expression from an HCL schema, eg. "[string]".
+func fromType(value *hcl.Value) (*Schema, error) {
+	switch {
+	case value == nil:
+		return &Schema{}, nil
+
+	case value.Type != nil:
+		return &Schema{Type: *value.Type}, nil
+
+	case value.HaveList:
+		if len(value.List) != 1 {
+			return nil, errors.Errorf("expected a single list element type but got %d", len(value.List))
+		}
+		items, err := fromType(value.List[0])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &Schema{Type: "array", Items: items}, nil
+
+	case value.HaveMap:
+		if len(value.Map) != 1 {
+			return nil, errors.Errorf("expected a single map entry type but got %d", len(value.Map))
+		}
+		values, err := fromType(value.Map[0].Value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+
+	default:
+		return nil, errors.Errorf("unsupported schema type %s", value)
+	}
+}
+
+// fromValue converts a literal HCL value, such as a default, to its JSON equivalent.
+func fromValue(value *hcl.Value) any {
+	switch {
+	case value.Bool != nil:
+		return bool(*value.Bool)
+
+	case value.Number != nil:
+		if i, accuracy := value.Number.Int64(); accuracy == 0 {
+			return i
+		}
+		f, _ := value.Number.Float64()
+		return f
+
+	case value.Str != nil:
+		return *value.Str
+
+	case value.Heredoc != nil:
+		return value.GetHeredoc()
+
+	case value.HaveList:
+		out := make([]any, 0, len(value.List))
+		for _, item := range value.List {
+			out = append(out, fromValue(item))
+		}
+		return out
+
+	case value.HaveMap:
+		out := make(map[string]any, len(value.Map))
+		for _, entry := range value.Map {
+			key := entry.Key.String()
+			if entry.Key.Str != nil {
+				key = *entry.Key.Str
+			}
+			out[key] = fromValue(entry.Value)
+		}
+		return out
+
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/hcl"
+
+	"github.com/cashapp/hermit/util/jsonschema"
+)
+
+type testBlock struct {
+	Name  string   `hcl:"name,label" help:"Block name."`
+	Match []string `hcl:"match,optional" help:"Globs to match."`
+}
+
+type testConfig struct {
+	Env     map[string]string `hcl:"env,optional" help:"Extra environment variables."`
+	Level   string            `hcl:"level" default:"info" enum:"info,warn" help:"Log level."`
+	Source  string            `hcl:"source" help:"Source URL."`
+	Retries int               `hcl:"retries,optional" help:"Number of retries."`
+	Blocks  []testBlock       `hcl:"block,block" help:"Repeated blocks."`
+}
+
+func TestFromHCL(t *testing.T) {
+	ast, err := hcl.Schema(&testConfig{})
+	assert.NoError(t, err)
+	schema, err := jsonschema.FromHCL("test", ast)
+	assert.NoError(t, err)
+	data, err := json.MarshalIndent(schema, "", "  ")
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "test",
+  "type": "object",
+  "properties": {
+    "block": {
+      "description": "Repeated blocks.",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "additionalProperties": {
+          "type": "object",
+          "properties": {
+            "match": {
+              "description": "Globs to match.",
+              "type": "array",
+              "items": {
+                "type": "string"
+              }
+            }
+          },
+          "additionalProperties": false
+        }
+      }
+    },
+    "env": {
+      "description": "Extra environment variables.",
+      "type": "object",
+      "additionalProperties": {
+        "type": "string"
+      }
+    },
+    "level": {
+      "description": "Log level.\nenum: info,warn\ndefault: info",
+      "type": "string",
+      "default": "info",
+      "enum": [
+        "info",
+        "warn"
+      ]
+    },
+    "retries": {
+      "description": "Number of retries.",
+      "type": "number"
+    },
+    "source": {
+      "description": "Source URL.",
+      "type": "string"
+    }
+  },
+  "required": [
+    "source"
+  ],
+  "additionalProperties": false
+}`, string(data))
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query parameter names that always carry credentials.
+var sensitiveParams = map[string]bool{
+	"sig":  true,
+	"key":  true,
+	"code": true,
+	"auth": true,
+}
+
+// Substrings of query parameter names that indicate credentials.
+var sensitiveParamSubstrings = []string{"token", "signature", "secret", "password", "credential"}
+
+// RedactURL removes credentials from a URL so that it can be safely logged or
+// included in errors.
+//
+// Userinfo is stripped and the values of sensitive query parameters, such as
+// tokens in pre-signed URLs, are replaced with "REDACTED". Strings that are
+// not URLs are returned unchanged.
+func RedactURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return uri
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			name, _, ok := strings.Cut(param, "=")
+			if !ok {
+				continue
+			}
+			if unescaped, err := url.QueryUnescape(name); err == nil && isSensitiveParam(unescaped) {
+				params[i] = name + "=REDACTED"
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.String()
+}
+
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	if sensitiveParams[name] {
+		return true
+	}
+	for _, sub := range sensitiveParamSubstrings {
+		if strings.Contains(name, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactURLError removes credentials from the URL of a *url.Error, as returned
+// by http.Client.Do, so that it can be safely logged or wrapped. Other errors
+// are returned unchanged.
+func RedactURLError(err error) error {
+	uerr, ok := err.(*url.Error) //nolint:errorlint
+	if !ok {
+		return err
+	}
+	return &url.Error{Op: uerr.Op, URL: RedactURL(uerr.URL), Err: uerr.Err}
+}